@@ -0,0 +1,334 @@
+// Command bench measures throughput of postgresql.Storage import operations
+// against real PostgreSQL database configured via standard PG* environment variables.
+//
+// Every run uses dedicated merchant id and hard deletes its rows before and after measurements, since Delete only
+// marks offers unavailable, so it may be pointed at development database without affecting existing catalogs
+// and every run measures inserts of new rows rather than revival of ones left by previous runs.
+//
+// With -copy-formats Upsert of every size is measured by each listed COPY format, e.g. binary,text,
+// which shows the cost of COPY_FORMAT=text fallback.
+//
+// With -delete-sizes Delete is measured by VALUES list and temporary table strategies for every size
+// together with database round trip, so DELETE_LARGE_THRESHOLD can be chosen where the strategies break even.
+// Given two sizes or more, cost of single offer id of VALUES list is reported too, it is value of DELETE_ID_COST.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"log"
+	"math"
+	"mx/internal/storage/postgresql"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// measurement defines single benchmark result
+type measurement struct {
+	operation string
+	rows      int
+	duration  time.Duration
+}
+
+func main() {
+	sizesFlag := flag.String("sizes", "1000,100000,1000000", "comma separated list of row counts")
+	merchantID := flag.Int64("merchant", 2147483000, "merchant id used for benchmark rows")
+	deleteSizesFlag := flag.String("delete-sizes", "", "comma separated list of row counts Delete strategies are compared for")
+	copyFormatsFlag := flag.String("copy-formats", "", "comma separated list of COPY formats Upsert is compared for, e.g. binary,text")
+	flag.Parse()
+
+	sizes, err := parseSizes(*sizesFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var deleteSizes []int
+	if *deleteSizesFlag != "" {
+		deleteSizes, err = parseSizes(*deleteSizesFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var copyFormats []string
+	if *copyFormatsFlag != "" {
+		for _, field := range strings.Split(*copyFormatsFlag, ",") {
+			format, err := postgresql.ParseCopyFormat(strings.TrimSpace(field))
+			if err != nil {
+				log.Fatal(err)
+			}
+			copyFormats = append(copyFormats, format)
+		}
+	}
+
+	logger, err := zap.NewProduction()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer logger.Sync()
+
+	ctx := context.Background()
+	db, err := postgresql.NewStorage(ctx, logger)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	var results []measurement
+	for _, size := range sizes {
+		m, err := benchSize(ctx, db, *merchantID, size)
+		if err != nil {
+			log.Fatalf("benchmarking %d rows: %v", size, err)
+		}
+		results = append(results, m...)
+	}
+
+	if len(copyFormats) != 0 {
+		m, err := benchCopyFormats(ctx, logger, db, *merchantID, sizes, copyFormats)
+		if err != nil {
+			log.Fatalf("benchmarking copy formats: %v", err)
+		}
+		results = append(results, m...)
+	}
+
+	if len(deleteSizes) != 0 {
+		m, err := benchDeleteStrategies(ctx, logger, db, *merchantID, deleteSizes)
+		if err != nil {
+			log.Fatalf("benchmarking delete strategies: %v", err)
+		}
+		results = append(results, m...)
+	}
+
+	printTable(results)
+}
+
+// benchCopyFormats measures Upsert of new rows for every size by each COPY format,
+// storages using either format share database with db
+func benchCopyFormats(ctx context.Context, logger *zap.Logger, db *postgresql.Storage, merchantID int64, sizes []int, formats []string) ([]measurement, error) {
+	var results []measurement
+	for _, format := range formats {
+		formatted, err := postgresql.NewStorage(ctx, logger, postgresql.WithoutMigrations(), postgresql.WithCopyFormat(format))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, size := range sizes {
+			products := generateProducts(merchantID, size)
+
+			_, err = db.DeleteMerchantProducts(ctx, merchantID)
+			if err != nil {
+				formatted.Close()
+				return nil, err
+			}
+
+			start := time.Now()
+			_, _, err = formatted.Upsert(ctx, products)
+			if err != nil {
+				formatted.Close()
+				return nil, err
+			}
+			results = append(results, measurement{"Upsert (COPY " + format + ")", size, time.Since(start)})
+		}
+
+		formatted.Close()
+	}
+
+	_, err := db.DeleteMerchantProducts(ctx, merchantID)
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// benchDeleteStrategies measures database round trip and Delete of every size by VALUES list
+// and by temporary table, storages forcing either strategy share database with db. Cost of single offer id
+// is derived from VALUES list measurements of the smallest and the largest size.
+func benchDeleteStrategies(ctx context.Context, logger *zap.Logger, db *postgresql.Storage, merchantID int64, sizes []int) ([]measurement, error) {
+	strategies := []struct {
+		operation string
+		threshold int64
+	}{
+		{"Delete (values)", math.MaxInt64},
+		{"Delete (temporary table)", 1},
+	}
+
+	var results []measurement
+
+	// the first ping may open connection, so the second one is measured
+	err := db.Ping(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	err = db.Ping(ctx)
+	if err != nil {
+		return nil, err
+	}
+	results = append(results, measurement{"Round trip", 1, time.Since(start)})
+
+	valuesDurations := make(map[int]time.Duration, len(sizes))
+	minSize, maxSize := sizes[0], sizes[0]
+
+	for _, strategy := range strategies {
+		forced, err := postgresql.NewStorage(ctx, logger, postgresql.WithoutMigrations(), postgresql.WithLargeDeleteThreshold(strategy.threshold))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, size := range sizes {
+			products := generateProducts(merchantID, size)
+			offerIDs := make([]int64, size)
+			for i, p := range products {
+				offerIDs[i] = p.OfferID
+			}
+
+			// rows left unavailable by previous size would be revived rather than inserted
+			_, err = db.DeleteMerchantProducts(ctx, merchantID)
+			if err != nil {
+				forced.Close()
+				return nil, err
+			}
+
+			_, _, err = forced.Upsert(ctx, products)
+			if err != nil {
+				forced.Close()
+				return nil, err
+			}
+
+			start := time.Now()
+			_, err = forced.Delete(ctx, merchantID, offerIDs)
+			if err != nil {
+				forced.Close()
+				return nil, err
+			}
+			duration := time.Since(start)
+			results = append(results, measurement{strategy.operation, size, duration})
+
+			if strategy.threshold == math.MaxInt64 {
+				valuesDurations[size] = duration
+				if size < minSize {
+					minSize = size
+				}
+				if size > maxSize {
+					maxSize = size
+				}
+			}
+		}
+
+		forced.Close()
+	}
+
+	_, err = db.DeleteMerchantProducts(ctx, merchantID)
+	if err != nil {
+		return nil, err
+	}
+
+	if maxSize > minSize {
+		cost := (valuesDurations[maxSize] - valuesDurations[minSize]) / time.Duration(maxSize-minSize)
+		results = append(results, measurement{"Delete per offer id (values)", 1, cost})
+	}
+
+	return results, nil
+}
+
+// benchSize runs Upsert, Delete and UpsertAndDelete on catalog of provided size
+func benchSize(ctx context.Context, db *postgresql.Storage, merchantID int64, size int) ([]measurement, error) {
+	_, err := db.DeleteMerchantProducts(ctx, merchantID)
+	if err != nil {
+		return nil, err
+	}
+
+	products := generateProducts(merchantID, size)
+	offerIDs := make([]int64, size)
+	for i, p := range products {
+		offerIDs[i] = p.OfferID
+	}
+
+	var results []measurement
+
+	start := time.Now()
+	_, _, err = db.Upsert(ctx, products)
+	if err != nil {
+		return nil, err
+	}
+	results = append(results, measurement{"Upsert", size, time.Since(start)})
+
+	start = time.Now()
+	_, err = db.Delete(ctx, merchantID, offerIDs)
+	if err != nil {
+		return nil, err
+	}
+	results = append(results, measurement{"Delete", size, time.Since(start)})
+
+	// half of rows is inserted and another half is deleted within one transaction
+	half := size / 2
+	_, _, err = db.Upsert(ctx, products[half:])
+	if err != nil {
+		return nil, err
+	}
+
+	start = time.Now()
+	_, err = db.UpsertAndDelete(ctx, products[:half], merchantID, offerIDs[half:])
+	if err != nil {
+		return nil, err
+	}
+	results = append(results, measurement{"UpsertAndDelete", size, time.Since(start)})
+
+	_, err = db.DeleteMerchantProducts(ctx, merchantID)
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func generateProducts(merchantID int64, size int) []postgresql.Product {
+	products := make([]postgresql.Product, size)
+	for i := range products {
+		products[i] = postgresql.Product{
+			MerchantID: merchantID,
+			OfferID:    int64(i + 1),
+			Name:       "Benchmark product " + strconv.Itoa(i+1),
+			Price:      decimal.New(int64(i%100000+1), -2),
+			Quantity:   int64(i%1000 + 1),
+		}
+	}
+
+	return products
+}
+
+func parseSizes(s string) ([]int, error) {
+	var sizes []int
+	for _, field := range strings.Split(s, ",") {
+		size, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("bad size %q", field)
+		}
+		sizes = append(sizes, size)
+	}
+
+	return sizes, nil
+}
+
+func printTable(results []measurement) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "operation\trows\tduration\trows/s\t")
+	for _, m := range results {
+		rate := float64(m.rows) / m.duration.Seconds()
+		duration := m.duration.Round(time.Millisecond)
+		// round trip and cost of single offer id are shorter than millisecond
+		if m.duration < time.Millisecond {
+			duration = m.duration.Round(time.Microsecond / 10)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%.0f\t\n", m.operation, m.rows, duration, rate)
+	}
+	w.Flush()
+}
@@ -0,0 +1,18 @@
+// Command parseworker parses single uploaded workbook described by request read from stdin
+// and writes parsing result to stdout. It is spawned by task.Scheduler configured with
+// task.WithParseWorker option.
+package main
+
+import (
+	"fmt"
+	"mx/internal/task"
+	"os"
+)
+
+func main() {
+	err := task.ServeParseWorker(os.Stdin, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,646 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"mx/internal/metrics"
+	"mx/internal/server"
+	"mx/internal/storage"
+	"mx/internal/storage/postgresql"
+	"mx/internal/storage/sqlite"
+	"mx/internal/task"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// environments defines allowed values of APP_ENV
+var environments = map[string]struct{}{
+	"development": {},
+	"staging":     {},
+	"production":  {},
+}
+
+// config defines settings read from environment variables
+type config struct {
+	// environment is read from APP_ENV and defines name of deployment environment
+	environment string
+	// httpPort is read from HTTP_PORT and defines TCP port to listen on, zero picks any free port
+	httpPort int64
+	// tlsCertFile and tlsKeyFile are read from TLS_CERT_FILE and TLS_KEY_FILE and enable HTTPS when both are set
+	tlsCertFile string
+	tlsKeyFile  string
+	// metricsMerchantBuckets is read from METRICS_MERCHANT_BUCKETS and defines number of buckets merchants are hashed
+	// into in per merchant metrics, metricsMerchants is read from METRICS_MERCHANTS and lists merchants labeled individually
+	metricsMerchantBuckets int64
+	metricsMerchants       []int64
+	// taskTTL is read from TASK_TTL and defines how long finished tasks are kept in memory
+	taskTTL time.Duration
+	// taskRetention is read from TASK_RETENTION and defines how long task records are kept in database
+	taskRetention time.Duration
+	// approval is read from TASK_APPROVAL and makes tasks wait for approval after their changes are previewed
+	approval bool
+	// approvalThreshold is read from TASK_APPROVAL_THRESHOLD and defines number of changed offers requiring approval
+	approvalThreshold int64
+	// chunkSize is read from TASK_CHUNK_SIZE and defines number of rows committed per transaction
+	chunkSize int64
+	// queuePollInterval is read from TASK_QUEUE_POLL_INTERVAL, non-zero value enables shared task queue
+	queuePollInterval time.Duration
+	// instanceID is read from INSTANCE_ID and identifies this instance in shared task queue
+	instanceID string
+	// slowQueryThreshold is read from SLOW_QUERY_THRESHOLD, non-zero value enables capturing plans of slow list queries
+	slowQueryThreshold time.Duration
+	// explainSampleRate is read from SLOW_QUERY_EXPLAIN_RATE and defines share of slow queries which plans are captured
+	explainSampleRate float64
+	// maxListRows is read from LIST_MAX_ROWS and caps number of products returned by single /list request
+	maxListRows int64
+	// copyFormat is read from COPY_FORMAT and defines format of COPY used for bulk inserts, either binary or text
+	copyFormat string
+	// largeDeleteThreshold is read from DELETE_LARGE_THRESHOLD and defines number of offer ids deleted by VALUES list
+	// at most, zero selects it by database round trip time
+	largeDeleteThreshold int64
+	// deleteIDCost is read from DELETE_ID_COST and defines server time spent on single offer id of VALUES list,
+	// it is used to select threshold by round trip time
+	deleteIDCost time.Duration
+	// migrate is read from DB_MIGRATE and enables applying embedded schema migrations at startup
+	migrate bool
+	// pool is read from DB_MAX_CONNS, DB_MIN_CONNS, DB_MAX_CONN_LIFETIME, DB_MAX_CONN_IDLE_TIME,
+	// DB_HEALTH_CHECK_PERIOD and DB_CONNECT_TIMEOUT, zero values keep pgxpool defaults
+	pool postgresql.PoolSettings
+	// readTimeout and writeTimeout are read from DB_READ_TIMEOUT and DB_WRITE_TIMEOUT and bound read queries
+	// and catalog writes of storage, zero disables respective timeout
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	// catalogInMemory is read from CATALOG_IN_MEMORY and makes uploads applied to catalog kept in memory for demo
+	catalogInMemory bool
+	// storageDriver is read from STORAGE_DRIVER and selects storage of catalog, either postgres or sqlite,
+	// sqlitePath is read from SQLITE_PATH and defines SQLite database file
+	storageDriver string
+	sqlitePath    string
+	// idempotencyWindow is read from UPLOAD_IDEMPOTENCY_WINDOW and defines how long Idempotency-Key of upload is remembered
+	idempotencyWindow time.Duration
+	// maxUploadBytes is read from MAX_UPLOAD_BYTES and limits size of /upload request body
+	maxUploadBytes int64
+	// remoteUploadTimeout is read from UPLOAD_URL_TIMEOUT and limits time of downloading file for /upload-by-url
+	remoteUploadTimeout time.Duration
+	// remoteUploadMaxSize is read from UPLOAD_URL_MAX_SIZE and limits size in bytes of file downloaded for /upload-by-url
+	remoteUploadMaxSize int64
+	// nameCleanup is read from PRODUCT_NAME_CLEANUP and enables collapsing whitespace in uploaded product names
+	nameCleanup bool
+	// namePolicy is read from PRODUCT_NAME_MAX_LENGTH and PRODUCT_NAME_REJECT_EMOJI and limits uploaded product names
+	namePolicy task.NamePolicy
+	// pricePolicy is read from PRICE_SCALE and PRICE_ROUNDING and defines decimal places of uploaded prices
+	pricePolicy task.PricePolicy
+	// baseCurrency is read from BASE_CURRENCY, non-empty value enables conversion of uploaded prices
+	baseCurrency string
+	// exchangeRatesURL and exchangeRates are read from EXCHANGE_RATES_URL and EXCHANGE_RATES
+	// and define source of exchange rates, the latter is list of fixed rates like EUR=0.92,GBP=0.79
+	exchangeRatesURL string
+	exchangeRates    string
+	// expiryInterval is read from EXPIRY_INTERVAL and defines period between runs applying expiry rules,
+	// zero disables them
+	expiryInterval time.Duration
+	// fileRetention is read from UPLOAD_RETENTION and defines how long uploaded files are kept after their tasks
+	// finish, zero keeps them forever
+	fileRetention time.Duration
+	// archiveFiles is read from UPLOAD_RETENTION_ACTION and makes expired files move to archive instead of deletion
+	archiveFiles bool
+	// sourcePollInterval is read from IMPORT_SOURCE_POLL_INTERVAL and defines period between checks of import sources,
+	// zero disables pulls; sourceTimeout and sourceMaxRows are read from IMPORT_SOURCE_TIMEOUT and IMPORT_SOURCE_MAX_ROWS
+	sourcePollInterval time.Duration
+	sourceTimeout      time.Duration
+	sourceMaxRows      int64
+	// sourceKey is read from IMPORT_SOURCE_KEY, non-empty value makes connection strings of import sources
+	// saved encrypted
+	sourceKey string
+	// kafkaRESTURL and kafkaTopic are read from OUTBOX_KAFKA_REST_URL and OUTBOX_KAFKA_TOPIC, non-empty url enables
+	// saving product changes to outbox and publishing them to the topic
+	kafkaRESTURL string
+	kafkaTopic   string
+	// egressCheckURL is read from EGRESS_CHECK_URL and defines URL requested by egress check of /admin/dependencies
+	egressCheckURL string
+	// outboxPollInterval and outboxBatchSize are read from OUTBOX_POLL_INTERVAL and OUTBOX_BATCH_SIZE
+	outboxPollInterval time.Duration
+	outboxBatchSize    int64
+	// offboardingGracePeriod is read from OFFBOARDING_GRACE_PERIOD and defines how long merchant data is kept
+	// after its offboarding archive is exported
+	offboardingGracePeriod time.Duration
+	// usageFlushInterval is read from USAGE_FLUSH_INTERVAL and defines period of saving API usage counters,
+	// zero disables usage tracking
+	usageFlushInterval time.Duration
+	// adminToken is read from ADMIN_TOKEN and enables admin endpoints
+	adminToken string
+	// linkSigningKey is read from LINK_SIGNING_KEY, non-empty value enables shared links to task resources
+	linkSigningKey string
+	// linkTTL is read from LINK_TTL and defines default validity period of shared links
+	linkTTL time.Duration
+	// blobStorage is read from BLOB_STORAGE and selects storage of uploaded files, either local or s3
+	blobStorage string
+	// uploadDir is read from UPLOAD_DIR and defines directory of uploaded files kept in local storage
+	uploadDir string
+	// s3 is read from S3_* variables and defines bucket of uploaded files kept in s3 storage
+	s3 storage.S3Config
+	// sloSuccessTarget, sloUploadLatency and sloListLatency are read from SLO_SUCCESS_TARGET, SLO_UPLOAD_P95
+	// and SLO_LIST_P95 and define objectives summarized by /slo
+	sloSuccessTarget float64
+	sloUploadLatency time.Duration
+	sloListLatency   time.Duration
+	// jsonStyle is read from JSON_NAMING and JSON_PRICES and defines default style of product JSON
+	jsonStyle postgresql.JSONStyle
+	// searchGuard is read from SEARCH_MIN_QUERY_LENGTH and SEARCH_REQUIRE_MERCHANT and limits expensive name searches
+	searchGuard server.SearchGuard
+	// taskIDs is generator of task ids in format read from TASK_ID_FORMAT
+	taskIDs task.IDGenerator
+}
+
+func readConfig() (config, error) {
+	var cfg config
+	var err error
+
+	cfg.environment = envString("APP_ENV", "development")
+	if _, ok := environments[cfg.environment]; !ok {
+		return config{}, fmt.Errorf("APP_ENV must be one of development, staging or production, got %q", cfg.environment)
+	}
+
+	cfg.httpPort, err = envInt("HTTP_PORT", 8080)
+	if err != nil {
+		return config{}, err
+	}
+
+	if cfg.httpPort < 0 || cfg.httpPort > 65535 {
+		return config{}, fmt.Errorf("HTTP_PORT must be between 0 and 65535, got %d", cfg.httpPort)
+	}
+
+	cfg.tlsCertFile = envString("TLS_CERT_FILE", "")
+	cfg.tlsKeyFile = envString("TLS_KEY_FILE", "")
+	if (cfg.tlsCertFile == "") != (cfg.tlsKeyFile == "") {
+		return config{}, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	cfg.metricsMerchantBuckets, err = envInt("METRICS_MERCHANT_BUCKETS", metrics.DefaultMerchantBuckets)
+	if err != nil {
+		return config{}, err
+	}
+	if cfg.metricsMerchantBuckets <= 0 || cfg.metricsMerchantBuckets > 1000 {
+		return config{}, fmt.Errorf("METRICS_MERCHANT_BUCKETS must be between 1 and 1000")
+	}
+
+	cfg.metricsMerchants, err = envInts("METRICS_MERCHANTS")
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg.taskTTL, err = envDuration("TASK_TTL", time.Hour)
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg.taskRetention, err = envDuration("TASK_RETENTION", 0)
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg.approval, err = envBool("TASK_APPROVAL", false)
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg.approvalThreshold, err = envInt("TASK_APPROVAL_THRESHOLD", 0)
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg.chunkSize, err = envInt("TASK_CHUNK_SIZE", 10000)
+	if err != nil {
+		return config{}, err
+	}
+	// negative size would silently apply whole file in one transaction keeping all its rows in memory
+	if cfg.chunkSize < 0 {
+		return config{}, fmt.Errorf("TASK_CHUNK_SIZE must not be negative, got %d", cfg.chunkSize)
+	}
+
+	cfg.queuePollInterval, err = envDuration("TASK_QUEUE_POLL_INTERVAL", 0)
+	if err != nil {
+		return config{}, err
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return config{}, fmt.Errorf("getting hostname: %w", err)
+	}
+	cfg.instanceID = envString("INSTANCE_ID", hostname)
+
+	cfg.slowQueryThreshold, err = envDuration("SLOW_QUERY_THRESHOLD", 0)
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg.explainSampleRate, err = envFloat("SLOW_QUERY_EXPLAIN_RATE", 0.1)
+	if err != nil {
+		return config{}, err
+	}
+
+	if cfg.explainSampleRate < 0 || cfg.explainSampleRate > 1 {
+		return config{}, fmt.Errorf("SLOW_QUERY_EXPLAIN_RATE must be between 0 and 1, got %v", cfg.explainSampleRate)
+	}
+
+	cfg.maxListRows, err = envInt("LIST_MAX_ROWS", 100000)
+	if err != nil {
+		return config{}, err
+	}
+
+	if cfg.maxListRows < 0 {
+		return config{}, fmt.Errorf("LIST_MAX_ROWS must not be negative, got %d", cfg.maxListRows)
+	}
+
+	cfg.copyFormat, err = postgresql.ParseCopyFormat(envString("COPY_FORMAT", postgresql.CopyFormatBinary))
+	if err != nil {
+		return config{}, fmt.Errorf("COPY_FORMAT: %w", err)
+	}
+
+	cfg.largeDeleteThreshold, err = envInt("DELETE_LARGE_THRESHOLD", postgresql.DefaultLargeDeleteThreshold)
+	if err != nil {
+		return config{}, err
+	}
+
+	if cfg.largeDeleteThreshold < 0 {
+		return config{}, fmt.Errorf("DELETE_LARGE_THRESHOLD must not be negative, got %d", cfg.largeDeleteThreshold)
+	}
+
+	cfg.deleteIDCost, err = envDuration("DELETE_ID_COST", postgresql.DefaultDeleteIDCost)
+	if err != nil {
+		return config{}, err
+	}
+
+	if cfg.deleteIDCost <= 0 {
+		return config{}, fmt.Errorf("DELETE_ID_COST must be positive, got %s", cfg.deleteIDCost)
+	}
+
+	cfg.migrate, err = envBool("DB_MIGRATE", true)
+	if err != nil {
+		return config{}, err
+	}
+
+	maxConns, err := envInt("DB_MAX_CONNS", 0)
+	if err != nil {
+		return config{}, err
+	}
+
+	minConns, err := envInt("DB_MIN_CONNS", 0)
+	if err != nil {
+		return config{}, err
+	}
+
+	if maxConns < 0 || maxConns > math.MaxInt32 || minConns < 0 || minConns > math.MaxInt32 {
+		return config{}, fmt.Errorf("DB_MAX_CONNS and DB_MIN_CONNS must be between 0 and %d", math.MaxInt32)
+	}
+	cfg.pool.MaxConns = int32(maxConns)
+	cfg.pool.MinConns = int32(minConns)
+
+	cfg.pool.MaxConnLifetime, err = envDuration("DB_MAX_CONN_LIFETIME", 0)
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg.pool.MaxConnIdleTime, err = envDuration("DB_MAX_CONN_IDLE_TIME", 0)
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg.pool.HealthCheckPeriod, err = envDuration("DB_HEALTH_CHECK_PERIOD", 0)
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg.pool.ConnectTimeout, err = envDuration("DB_CONNECT_TIMEOUT", 0)
+	if err != nil {
+		return config{}, err
+	}
+
+	err = cfg.pool.Validate()
+	if err != nil {
+		return config{}, fmt.Errorf("DB pool settings: %w", err)
+	}
+
+	cfg.sloSuccessTarget, err = envFloat("SLO_SUCCESS_TARGET", 0.99)
+	if err != nil {
+		return config{}, err
+	}
+
+	if cfg.sloSuccessTarget <= 0 || cfg.sloSuccessTarget >= 1 {
+		return config{}, fmt.Errorf("SLO_SUCCESS_TARGET must be between 0 and 1 exclusive, got %v", cfg.sloSuccessTarget)
+	}
+
+	cfg.sloUploadLatency, err = envDuration("SLO_UPLOAD_P95", 2*time.Second)
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg.sloListLatency, err = envDuration("SLO_LIST_P95", 500*time.Millisecond)
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg.jsonStyle, err = server.ParseJSONStyle(envString("JSON_NAMING", "snake_case"), envString("JSON_PRICES", "string"))
+	if err != nil {
+		return config{}, fmt.Errorf("JSON_NAMING or JSON_PRICES: %w", err)
+	}
+
+	minQueryLength, err := envInt("SEARCH_MIN_QUERY_LENGTH", 3)
+	if err != nil {
+		return config{}, err
+	}
+
+	if minQueryLength < 0 {
+		return config{}, fmt.Errorf("SEARCH_MIN_QUERY_LENGTH must not be negative, got %d", minQueryLength)
+	}
+	cfg.searchGuard.MinQueryLength = int(minQueryLength)
+
+	cfg.searchGuard.RequireMerchant, err = envBool("SEARCH_REQUIRE_MERCHANT", false)
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg.readTimeout, err = envDuration("DB_READ_TIMEOUT", 30*time.Second)
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg.writeTimeout, err = envDuration("DB_WRITE_TIMEOUT", 5*time.Minute)
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg.catalogInMemory, err = envBool("CATALOG_IN_MEMORY", false)
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg.storageDriver = envString("STORAGE_DRIVER", "postgres")
+	cfg.sqlitePath = envString("SQLITE_PATH", "mx.db")
+	switch cfg.storageDriver {
+	case "postgres":
+	case "sqlite":
+		if cfg.catalogInMemory {
+			return config{}, fmt.Errorf("CATALOG_IN_MEMORY can not be enabled together with STORAGE_DRIVER sqlite")
+		}
+		if !sqlite.DriverLinked() {
+			return config{}, fmt.Errorf("STORAGE_DRIVER sqlite requires server built with sqlite tag, see SQLite catalog in README")
+		}
+	default:
+		return config{}, fmt.Errorf("STORAGE_DRIVER must be either postgres or sqlite, got %q", cfg.storageDriver)
+	}
+
+	cfg.idempotencyWindow, err = envDuration("UPLOAD_IDEMPOTENCY_WINDOW", 24*time.Hour)
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg.maxUploadBytes, err = envInt("MAX_UPLOAD_BYTES", 100<<20)
+	if err != nil {
+		return config{}, err
+	}
+
+	if cfg.maxUploadBytes < 0 {
+		return config{}, fmt.Errorf("MAX_UPLOAD_BYTES must not be negative, got %d", cfg.maxUploadBytes)
+	}
+
+	cfg.remoteUploadTimeout, err = envDuration("UPLOAD_URL_TIMEOUT", 30*time.Second)
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg.remoteUploadMaxSize, err = envInt("UPLOAD_URL_MAX_SIZE", 50<<20)
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg.nameCleanup, err = envBool("PRODUCT_NAME_CLEANUP", false)
+	if err != nil {
+		return config{}, err
+	}
+
+	maxNameLength, err := envInt("PRODUCT_NAME_MAX_LENGTH", task.MaxNameLength)
+	if err != nil {
+		return config{}, err
+	}
+
+	if maxNameLength <= 0 || maxNameLength > task.MaxNameLength {
+		return config{}, fmt.Errorf("PRODUCT_NAME_MAX_LENGTH must be between 1 and %d, got %d", task.MaxNameLength, maxNameLength)
+	}
+	cfg.namePolicy.MaxLength = int(maxNameLength)
+
+	cfg.namePolicy.RejectEmoji, err = envBool("PRODUCT_NAME_REJECT_EMOJI", false)
+	if err != nil {
+		return config{}, err
+	}
+
+	priceScale, err := envInt("PRICE_SCALE", task.MaxPriceScale)
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg.pricePolicy = task.PricePolicy{
+		Scale:    int32(priceScale),
+		Rounding: envString("PRICE_ROUNDING", task.PriceRoundingReject),
+	}
+	if priceScale < 0 || priceScale > task.MaxPriceScale {
+		return config{}, fmt.Errorf("PRICE_SCALE must be between 0 and %d, got %d", task.MaxPriceScale, priceScale)
+	}
+
+	err = cfg.pricePolicy.Validate()
+	if err != nil {
+		return config{}, fmt.Errorf("PRICE_ROUNDING: %w", err)
+	}
+
+	cfg.baseCurrency = envString("BASE_CURRENCY", "")
+	cfg.exchangeRatesURL = envString("EXCHANGE_RATES_URL", "")
+	cfg.exchangeRates = envString("EXCHANGE_RATES", "")
+	if cfg.baseCurrency != "" && (cfg.exchangeRatesURL == "") == (cfg.exchangeRates == "") {
+		return config{}, fmt.Errorf("BASE_CURRENCY requires either EXCHANGE_RATES_URL or EXCHANGE_RATES to be set")
+	}
+
+	cfg.expiryInterval, err = envDuration("EXPIRY_INTERVAL", time.Hour)
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg.fileRetention, err = envDuration("UPLOAD_RETENTION", 7*24*time.Hour)
+	if err != nil {
+		return config{}, err
+	}
+
+	switch action := envString("UPLOAD_RETENTION_ACTION", "delete"); action {
+	case "delete":
+	case "archive":
+		cfg.archiveFiles = true
+	default:
+		return config{}, fmt.Errorf("UPLOAD_RETENTION_ACTION must be either delete or archive, got %q", action)
+	}
+
+	cfg.sourcePollInterval, err = envDuration("IMPORT_SOURCE_POLL_INTERVAL", time.Minute)
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg.sourceTimeout, err = envDuration("IMPORT_SOURCE_TIMEOUT", 10*time.Minute)
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg.sourceMaxRows, err = envInt("IMPORT_SOURCE_MAX_ROWS", 5000000)
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg.sourceKey = envString("IMPORT_SOURCE_KEY", "")
+
+	cfg.kafkaRESTURL = envString("OUTBOX_KAFKA_REST_URL", "")
+	cfg.kafkaTopic = envString("OUTBOX_KAFKA_TOPIC", "product-changes")
+	cfg.egressCheckURL = envString("EGRESS_CHECK_URL", "")
+	cfg.outboxPollInterval, err = envDuration("OUTBOX_POLL_INTERVAL", time.Second)
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg.outboxBatchSize, err = envInt("OUTBOX_BATCH_SIZE", 500)
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg.offboardingGracePeriod, err = envDuration("OFFBOARDING_GRACE_PERIOD", 30*24*time.Hour)
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg.usageFlushInterval, err = envDuration("USAGE_FLUSH_INTERVAL", time.Minute)
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg.adminToken = envString("ADMIN_TOKEN", "")
+
+	cfg.linkSigningKey = envString("LINK_SIGNING_KEY", "")
+	cfg.linkTTL, err = envDuration("LINK_TTL", 24*time.Hour)
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg.blobStorage = envString("BLOB_STORAGE", "local")
+	cfg.uploadDir = envString("UPLOAD_DIR", "")
+	switch cfg.blobStorage {
+	case "local":
+	case "s3":
+		cfg.s3 = storage.S3Config{
+			Endpoint:        envString("S3_ENDPOINT", ""),
+			Region:          envString("S3_REGION", "us-east-1"),
+			Bucket:          envString("S3_BUCKET", ""),
+			AccessKeyID:     envString("S3_ACCESS_KEY_ID", ""),
+			SecretAccessKey: envString("S3_SECRET_ACCESS_KEY", ""),
+		}
+		cfg.s3.PathStyle, err = envBool("S3_PATH_STYLE", true)
+		if err != nil {
+			return config{}, err
+		}
+	default:
+		return config{}, fmt.Errorf("BLOB_STORAGE must be either local or s3, got %q", cfg.blobStorage)
+	}
+
+	cfg.taskIDs, err = task.NewIDGenerator(envString("TASK_ID_FORMAT", task.IDFormatXID))
+	if err != nil {
+		return config{}, fmt.Errorf("TASK_ID_FORMAT: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// envString returns value of environment variable or def if variable is not set
+func envString(name string, def string) string {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return def
+	}
+
+	return value
+}
+
+// envDuration parses environment variable as time.Duration returning def if variable is not set
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be duration, e.g. 30m: %w", name, err)
+	}
+
+	return d, nil
+}
+
+// envInt parses environment variable as int64 returning def if variable is not set
+func envInt(name string, def int64) (int64, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return def, nil
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be integer: %w", name, err)
+	}
+
+	return n, nil
+}
+
+// envInts parses environment variable as comma separated list of int64 returning nil if variable is not set
+func envInts(name string) ([]int64, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok || strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+
+	var list []int64
+	for _, item := range strings.Split(value, ",") {
+		n, err := strconv.ParseInt(strings.TrimSpace(item), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be comma separated list of integers: %w", name, err)
+		}
+
+		list = append(list, n)
+	}
+
+	return list, nil
+}
+
+// envBool parses environment variable as bool returning def if variable is not set
+func envBool(name string, def bool) (bool, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return def, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s must be either true or false: %w", name, err)
+	}
+
+	return b, nil
+}
+
+// envFloat parses environment variable as float64 returning def if variable is not set
+func envFloat(name string, def float64) (float64, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return def, nil
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be number: %w", name, err)
+	}
+
+	return f, nil
+}
@@ -0,0 +1,332 @@
+// Command server starts HTTP API processing .xlsx uploads.
+//
+// Database connection is configured via standard PG* environment variables,
+// other settings are read from environment variables described in config.go.
+package main
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"log"
+	"mx/internal/cleanup"
+	"mx/internal/currency"
+	"mx/internal/dbsource"
+	"mx/internal/expiry"
+	"mx/internal/metrics"
+	"mx/internal/offboarding"
+	"mx/internal/outbox"
+	"mx/internal/server"
+	"mx/internal/signedurl"
+	"mx/internal/slo"
+	"mx/internal/storage"
+	"mx/internal/storage/postgresql"
+	"mx/internal/storage/sqlite"
+	"mx/internal/task"
+	"net/http"
+	"time"
+)
+
+func main() {
+	logger, err := zap.NewProduction()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer logger.Sync()
+
+	cfg, err := readConfig()
+	if err != nil {
+		logger.Fatal("Reading config", zap.Error(err))
+	}
+
+	logger = logger.With(zap.String("environment", cfg.environment))
+	logStartup(logger, cfg)
+	metrics.Environment.Set(cfg.environment)
+	err = metrics.SetMerchantLabels(int(cfg.metricsMerchantBuckets), cfg.metricsMerchants)
+	if err != nil {
+		logger.Fatal("Configuring merchant metrics labels", zap.Error(err))
+	}
+
+	storageOpts := []postgresql.StorageOption{
+		postgresql.WithSlowQueryExplain(cfg.slowQueryThreshold, cfg.explainSampleRate),
+		postgresql.WithMaxListRows(cfg.maxListRows),
+		postgresql.WithCopyFormat(cfg.copyFormat),
+		postgresql.WithLargeDeleteThreshold(cfg.largeDeleteThreshold),
+		postgresql.WithDeleteIDCost(cfg.deleteIDCost),
+		postgresql.WithPoolSettings(cfg.pool),
+		postgresql.WithStatementTimeouts(cfg.readTimeout, cfg.writeTimeout),
+	}
+	if cfg.kafkaRESTURL != "" {
+		storageOpts = append(storageOpts, postgresql.WithOutbox())
+	}
+	if !cfg.migrate {
+		storageOpts = append(storageOpts, postgresql.WithoutMigrations())
+	}
+
+	db, err := postgresql.NewStorage(context.Background(), logger, storageOpts...)
+	if err != nil {
+		logger.Fatal("Connecting to database", zap.Error(err))
+	}
+
+	err = db.CheckSchema(context.Background())
+	if err != nil {
+		logger.Fatal("Checking database schema", zap.Error(err))
+	}
+
+	blobs, err := newBlobStore(cfg)
+	if err != nil {
+		logger.Fatal("Creating blob storage", zap.Error(err))
+	}
+
+	schedulerOpts := []task.SchedulerOption{
+		task.WithBlobStore(blobs),
+		task.WithTaskTTL(cfg.taskTTL),
+		task.WithChunkSize(cfg.chunkSize),
+		task.WithIdempotencyWindow(cfg.idempotencyWindow),
+		task.WithTaskRetention(cfg.taskRetention),
+		task.WithNamePolicy(cfg.namePolicy),
+		task.WithPricePolicy(cfg.pricePolicy),
+	}
+	if cfg.approval {
+		schedulerOpts = append(schedulerOpts, task.WithApproval(cfg.approvalThreshold))
+	}
+	if cfg.queuePollInterval > 0 {
+		schedulerOpts = append(schedulerOpts, task.WithSharedQueue(cfg.instanceID, cfg.queuePollInterval))
+	}
+	if cfg.nameCleanup {
+		schedulerOpts = append(schedulerOpts, task.WithProductHooks(task.NameCleanupHook))
+	}
+	if cfg.baseCurrency != "" {
+		converter, err := newPriceConverter(logger, cfg)
+		if err != nil {
+			logger.Fatal("Creating currency converter", zap.Error(err))
+		}
+		schedulerOpts = append(schedulerOpts, task.WithPriceConverter(converter))
+	}
+
+	var catalog server.CatalogStore
+	var sqliteCatalog *sqlite.Store
+	switch {
+	case cfg.catalogInMemory:
+		logger.Warn("Catalog is kept in memory, it is lost on restart")
+		catalog = postgresql.NewMemoryStore()
+	case cfg.storageDriver == "sqlite":
+		sqliteCatalog, err = sqlite.NewStore(context.Background(), logger, cfg.sqlitePath)
+		if err != nil {
+			logger.Fatal("Opening SQLite catalog", zap.Error(err))
+		}
+
+		logger.Info("Catalog is kept in SQLite database", zap.String("path", cfg.sqlitePath))
+		catalog = sqliteCatalog
+	}
+	if catalog != nil {
+		schedulerOpts = append(schedulerOpts, task.WithProductStore(catalog))
+	}
+
+	scheduler, err := task.NewScheduler(logger, db, schedulerOpts...)
+	if err != nil {
+		logger.Fatal("Creating scheduler", zap.Error(err))
+	}
+
+	err = scheduler.Resume(context.Background())
+	if err != nil {
+		logger.Error("Resuming unfinished tasks", zap.Error(err))
+	}
+
+	var dependencies []server.ServerOption
+	if cfg.egressCheckURL != "" {
+		dependencies = append(dependencies, server.WithDependency("egress", egressCheck(cfg.egressCheckURL)))
+	}
+
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	if cfg.expiryInterval > 0 {
+		job, err := expiry.NewJob(logger, db, expiry.WithInterval(cfg.expiryInterval))
+		if err != nil {
+			logger.Fatal("Creating expiry job", zap.Error(err))
+		}
+		go job.Run(jobsCtx)
+	}
+	if cfg.fileRetention > 0 {
+		cleanupOpts := []cleanup.Option{cleanup.WithRetention(cfg.fileRetention)}
+		if cfg.archiveFiles {
+			cleanupOpts = append(cleanupOpts, cleanup.WithArchive())
+		}
+
+		job, err := cleanup.NewJob(logger, db, blobs, cleanupOpts...)
+		if err != nil {
+			logger.Fatal("Creating cleanup job", zap.Error(err))
+		}
+		go job.Run(jobsCtx)
+	}
+
+	if cfg.kafkaRESTURL != "" {
+		sink, err := outbox.NewKafkaREST(cfg.kafkaRESTURL, cfg.kafkaTopic, 30*time.Second)
+		if err != nil {
+			logger.Fatal("Creating outbox sink", zap.Error(err))
+		}
+		dependencies = append(dependencies, server.WithDependency("broker", sink.Ping))
+
+		job, err := outbox.NewJob(logger, db, sink,
+			outbox.WithInterval(cfg.outboxPollInterval),
+			outbox.WithBatchSize(int(cfg.outboxBatchSize)),
+		)
+		if err != nil {
+			logger.Fatal("Creating outbox job", zap.Error(err))
+		}
+		go job.Run(jobsCtx)
+	}
+
+	offboardings, err := offboarding.NewService(logger, db, blobs,
+		offboarding.WithGracePeriod(cfg.offboardingGracePeriod),
+		offboarding.WithIDGenerator(cfg.taskIDs),
+	)
+	if err != nil {
+		logger.Fatal("Creating offboarding service", zap.Error(err))
+	}
+
+	err = offboardings.Resume(context.Background())
+	if err != nil {
+		logger.Error("Resuming offboardings", zap.Error(err))
+	}
+	go offboardings.Run(jobsCtx)
+
+	serverOpts := []server.ServerOption{
+		server.WithEnvironment(cfg.environment),
+		server.WithPort(int(cfg.httpPort)),
+		server.WithTLS(cfg.tlsCertFile, cfg.tlsKeyFile),
+		server.WithBlobStore(blobs),
+		server.WithTaskIDGenerator(cfg.taskIDs),
+		server.WithRemoteUploadLimits(cfg.remoteUploadTimeout, cfg.remoteUploadMaxSize),
+		server.WithBaseCurrency(cfg.baseCurrency),
+		server.WithMaxUploadBytes(cfg.maxUploadBytes),
+		server.WithUsageTracking(cfg.usageFlushInterval),
+		server.WithAdminToken(cfg.adminToken),
+		server.WithJSONStyle(cfg.jsonStyle),
+		server.WithSearchGuard(cfg.searchGuard),
+		server.WithOffboarding(offboardings),
+		server.WithImportSources(cfg.sourcePollInterval, cfg.sourceTimeout, cfg.sourceMaxRows),
+		server.WithObjectives(
+			slo.Objective{Name: server.UploadObjective, SuccessTarget: cfg.sloSuccessTarget, LatencyTarget: cfg.sloUploadLatency},
+			slo.Objective{Name: server.ListObjective, SuccessTarget: cfg.sloSuccessTarget, LatencyTarget: cfg.sloListLatency},
+		),
+	}
+	if cfg.linkSigningKey != "" {
+		signer, err := signedurl.NewSigner([]byte(cfg.linkSigningKey))
+		if err != nil {
+			logger.Fatal("Creating link signer", zap.Error(err))
+		}
+		serverOpts = append(serverOpts, server.WithSharedLinks(signer, cfg.linkTTL))
+	}
+
+	if cfg.sourceKey != "" {
+		sourceCipher, err := dbsource.NewCipher([]byte(cfg.sourceKey))
+		if err != nil {
+			logger.Fatal("Creating import source cipher", zap.Error(err))
+		}
+		serverOpts = append(serverOpts, server.WithImportSourceCipher(sourceCipher))
+	}
+
+	if catalog != nil {
+		serverOpts = append(serverOpts, server.WithProductStore(catalog))
+	}
+	serverOpts = append(serverOpts, dependencies...)
+
+	srv, err := server.NewServer(logger, scheduler, db, serverOpts...)
+	if err != nil {
+		logger.Fatal("Creating server", zap.Error(err))
+	}
+
+	srv.RegisterAfterShutdown(func() error {
+		stopJobs()
+		scheduler.Close()
+		if sqliteCatalog != nil {
+			sqliteCatalog.Close()
+		}
+		db.Close()
+		return nil
+	})
+
+	err = srv.Start()
+	if err != nil {
+		logger.Fatal("Running server", zap.Error(err))
+	}
+}
+
+// logStartup logs single structured record of settings the instance starts with, secrets are reported as set or not
+func logStartup(logger *zap.Logger, cfg config) {
+	logger.Info("Starting service",
+		zap.Int64("http_port", cfg.httpPort),
+		zap.Bool("tls", cfg.tlsCertFile != ""),
+		zap.String("instance_id", cfg.instanceID),
+		zap.Bool("shared_queue", cfg.queuePollInterval > 0),
+		zap.String("storage_driver", cfg.storageDriver),
+		zap.Bool("catalog_in_memory", cfg.catalogInMemory),
+		zap.String("blob_storage", cfg.blobStorage),
+		zap.Bool("migrate", cfg.migrate),
+		zap.Int64("chunk_size", cfg.chunkSize),
+		zap.Int64("delete_large_threshold", cfg.largeDeleteThreshold),
+		zap.Duration("delete_id_cost", cfg.deleteIDCost),
+		zap.Bool("approval", cfg.approval),
+		zap.String("base_currency", cfg.baseCurrency),
+		zap.Bool("outbox", cfg.kafkaRESTURL != ""),
+		zap.Bool("admin_endpoints", cfg.adminToken != ""),
+		zap.Bool("shared_links", cfg.linkSigningKey != ""),
+		zap.Bool("import_source_key", cfg.sourceKey != ""),
+		zap.Bool("egress_check", cfg.egressCheckURL != ""),
+	)
+}
+
+// egressCheck returns check of outbound HTTP requests made by the service, e.g. remote uploads and exchange rates,
+// any response of rawURL proves requests leave the network
+func egressCheck(rawURL string) server.DependencyCheck {
+	client := &http.Client{
+		// redirects are not followed, the first response is enough
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, rawURL, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+
+		return resp.Body.Close()
+	}
+}
+
+// newBlobStore constructs configured storage of uploaded files
+func newBlobStore(cfg config) (storage.Blob, error) {
+	if cfg.blobStorage == "s3" {
+		return storage.NewS3Blob(cfg.s3, time.Minute)
+	}
+
+	local := storage.NewLocalBlob(cfg.uploadDir)
+	err := local.Prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	return local, nil
+}
+
+// newPriceConverter constructs converter into configured base currency using either fixed rates or rates endpoint
+func newPriceConverter(logger *zap.Logger, cfg config) (*currency.Converter, error) {
+	var source currency.Source
+	var err error
+	if cfg.exchangeRates != "" {
+		source, err = currency.ParseStaticSource(cfg.exchangeRates)
+	} else {
+		source, err = currency.NewHTTPSource(cfg.exchangeRatesURL, 10*time.Second)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return currency.NewConverter(logger, cfg.baseCurrency, source)
+}
@@ -0,0 +1,8 @@
+//go:build sqlite
+// +build sqlite
+
+package main
+
+// SQLite driver pinned in go.mod is linked only into binaries built with sqlite tag, so default build does not
+// depend on it, see SQLite catalog in README.
+import _ "modernc.org/sqlite"
@@ -1,15 +1,17 @@
 module mx
 
-go 1.15
+go 1.16
 
 require (
 	github.com/dgraph-io/badger/v3 v3.2011.0
+	github.com/jackc/pgconn v1.8.0
+	github.com/jackc/pgtype v1.6.2
 	github.com/jackc/pgx/v4 v4.10.1
 	github.com/jszwec/csvutil v1.4.0
 	github.com/rs/xid v1.2.1
 	github.com/shopspring/decimal v1.2.0
 	github.com/stretchr/testify v1.6.1 // indirect
-	github.com/tealeg/xlsx/v3 v3.2.3
 	go.uber.org/zap v1.16.0
 	google.golang.org/protobuf v1.25.0 // indirect
+	modernc.org/sqlite v1.14.8
 )
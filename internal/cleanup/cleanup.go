@@ -0,0 +1,242 @@
+// Package cleanup implements background job removing uploaded files of finished tasks after retention period
+package cleanup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"mx/internal/storage"
+	"mx/internal/storage/postgresql"
+	"regexp"
+	"time"
+)
+
+// ArchivePrefix is prepended to keys of files moved to archive instead of deletion
+const ArchivePrefix = "archive/"
+
+// batchSize limits number of files removed per database round trip
+const batchSize = 100
+
+// uploadKey matches keys of uploaded files, i.e. <merchant id>/<name>, so orphan sweep never touches other blobs
+var uploadKey = regexp.MustCompile(`^[0-9]+/[^/]+$`)
+
+// Report defines outcome of single cleanup run
+type Report struct {
+	// Removed is number of files deleted or moved to archive
+	Removed int
+	// Failed is number of files which failed to be removed, they are retried by next run
+	Failed int
+}
+
+// Job defines fields used to remove uploaded files
+type Job struct {
+	logger    *zap.Logger
+	db        *postgresql.Storage
+	blobs     storage.Blob
+	interval  time.Duration
+	retention time.Duration
+	archive   bool
+	now       func() time.Time
+}
+
+// Option type represents function to modify Job struct
+type Option func(j *Job)
+
+// WithInterval applies passed interval as period between cleanup runs
+func WithInterval(d time.Duration) Option {
+	return func(j *Job) {
+		j.interval = d
+	}
+}
+
+// WithRetention applies passed period as time files are kept after their tasks finish
+func WithRetention(d time.Duration) Option {
+	return func(j *Job) {
+		j.retention = d
+	}
+}
+
+// WithArchive makes Job move files under ArchivePrefix instead of deleting them
+func WithArchive() Option {
+	return func(j *Job) {
+		j.archive = true
+	}
+}
+
+// NewJob constructs Job, by default runs happen every hour and files are deleted a week after their tasks finish
+func NewJob(logger *zap.Logger, db *postgresql.Storage, blobs storage.Blob, options ...Option) (*Job, error) {
+	if logger == nil {
+		return nil, errors.New("no logger provided")
+	}
+
+	job := &Job{
+		logger:    logger.With(zap.String("component", "cleanup")),
+		db:        db,
+		blobs:     blobs,
+		interval:  time.Hour,
+		retention: 7 * 24 * time.Hour,
+		now:       time.Now,
+	}
+
+	for _, opt := range options {
+		opt(job)
+	}
+
+	if job.interval <= 0 {
+		return nil, errors.New("cleanup interval must be positive")
+	}
+
+	if job.retention <= 0 {
+		return nil, errors.New("file retention must be positive")
+	}
+
+	return job, nil
+}
+
+// Run sweeps orphaned files once and then removes files of finished tasks every interval until ctx is done
+func (j *Job) Run(ctx context.Context) {
+	_, err := j.SweepOrphans(ctx)
+	if err != nil {
+		j.logger.Error("Orphaned files sweep", zap.Error(err))
+	}
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := j.RunOnce(ctx)
+		if err != nil {
+			j.logger.Error("Cleanup run", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce removes files of tasks finished before retention period. Failure of one file does not prevent
+// removing the others, but stops the run after current batch, so failing files are not fetched again.
+func (j *Job) RunOnce(ctx context.Context) (Report, error) {
+	var report Report
+
+	finishedBefore := j.now().Add(-j.retention)
+	for {
+		files, err := j.db.ExpiredTaskFiles(ctx, finishedBefore, batchSize)
+		if err != nil {
+			return report, err
+		}
+
+		failed := report.Failed
+		for _, f := range files {
+			err = j.remove(ctx, f.Path)
+			if err == nil {
+				err = j.db.MarkTaskFileRemoved(ctx, f.TaskID, j.now())
+			}
+			if err != nil {
+				j.logger.Error("Removing task file", zap.String("task_id", f.TaskID), zap.String("key", f.Path), zap.Error(err))
+				report.Failed++
+				continue
+			}
+
+			report.Removed++
+		}
+
+		if len(files) < batchSize || report.Failed != failed {
+			break
+		}
+	}
+
+	if report.Removed != 0 {
+		j.logger.Info("Task files removed",
+			zap.Int("files", report.Removed),
+			zap.Bool("archived", j.archive),
+			zap.Time("finished_before", finishedBefore),
+		)
+	}
+
+	if report.Failed != 0 {
+		return report, fmt.Errorf("%d task files failed to be removed", report.Failed)
+	}
+
+	return report, nil
+}
+
+// SweepOrphans removes uploaded files older than retention period which are not file of any task,
+// e.g. left by uploads failed between saving file and task. Files are removed like expired ones.
+func (j *Job) SweepOrphans(ctx context.Context) (int, error) {
+	blobs, err := j.blobs.List(ctx, "")
+	if err != nil {
+		return 0, fmt.Errorf("listing uploaded files: %w", err)
+	}
+
+	modifiedBefore := j.now().Add(-j.retention)
+	var candidates []string
+	for _, b := range blobs {
+		if uploadKey.MatchString(b.Key) && b.ModifiedAt.Before(modifiedBefore) {
+			candidates = append(candidates, b.Key)
+		}
+	}
+
+	var removed int
+	for len(candidates) != 0 {
+		n := batchSize
+		if len(candidates) < n {
+			n = len(candidates)
+		}
+
+		orphans, err := j.db.UnreferencedFiles(ctx, candidates[:n])
+		if err != nil {
+			return removed, err
+		}
+		candidates = candidates[n:]
+
+		for _, key := range orphans {
+			err = j.remove(ctx, key)
+			if err != nil {
+				j.logger.Error("Removing orphaned file", zap.String("key", key), zap.Error(err))
+				continue
+			}
+
+			removed++
+		}
+	}
+
+	if removed != 0 {
+		j.logger.Info("Orphaned files removed", zap.Int("files", removed), zap.Bool("archived", j.archive))
+	}
+
+	return removed, nil
+}
+
+// remove deletes blob or moves it under ArchivePrefix, already missing blob is considered removed
+func (j *Job) remove(ctx context.Context, key string) error {
+	if !j.archive {
+		return j.blobs.Delete(ctx, key)
+	}
+
+	r, err := j.blobs.Get(ctx, key)
+	if err != nil {
+		if errors.Is(err, storage.ErrBlobNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return fmt.Errorf("reading blob %s: %w", key, err)
+	}
+
+	err = j.blobs.Put(ctx, ArchivePrefix+key, data)
+	if err != nil {
+		return err
+	}
+
+	return j.blobs.Delete(ctx, key)
+}
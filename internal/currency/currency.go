@@ -0,0 +1,124 @@
+package currency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// ErrUnknownCurrency is returned when there is no exchange rate of requested currency
+var ErrUnknownCurrency = errors.New("unknown currency")
+
+// codePattern matches ISO 4217 alphabetic currency codes
+var codePattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// ValidCode reports whether code looks like ISO 4217 alphabetic currency code, e.g. USD
+func ValidCode(code string) bool {
+	return codePattern.MatchString(code)
+}
+
+// Rates defines exchange rates as amount of each currency per one unit of Base currency
+type Rates struct {
+	Base  string
+	Date  time.Time
+	Rates map[string]decimal.Decimal
+}
+
+// Source represents provider of current exchange rates
+type Source interface {
+	Rates(ctx context.Context, base string) (Rates, error)
+}
+
+// Converter converts prices into base currency using rates of Source cached for a day
+type Converter struct {
+	logger *zap.Logger
+	base   string
+	source Source
+
+	mu sync.Mutex
+	// cached rates are refreshed once UTC date changes, stale ones are used if refresh fails
+	cached    Rates
+	fetchedOn string
+	now       func() time.Time
+}
+
+// NewConverter constructs Converter into base currency using rates of provided source
+func NewConverter(logger *zap.Logger, base string, source Source) (*Converter, error) {
+	if logger == nil {
+		return nil, errors.New("no logger provided")
+	}
+
+	if !ValidCode(base) {
+		return nil, fmt.Errorf("base currency %q is not valid ISO 4217 code", base)
+	}
+
+	if source == nil {
+		return nil, errors.New("no exchange rates source provided")
+	}
+
+	return &Converter{
+		logger: logger,
+		base:   base,
+		source: source,
+		now:    time.Now,
+	}, nil
+}
+
+// BaseCurrency returns code of currency prices are converted into
+func (c *Converter) BaseCurrency() string {
+	return c.base
+}
+
+// Convert returns amount in from currency converted into base currency
+func (c *Converter) Convert(ctx context.Context, amount decimal.Decimal, from string) (decimal.Decimal, error) {
+	if from == c.base {
+		return amount, nil
+	}
+
+	rates, err := c.rates(ctx)
+	if err != nil {
+		return decimal.Decimal{}, err
+	}
+
+	rate, ok := rates.Rates[from]
+	if !ok || !rate.IsPositive() {
+		return decimal.Decimal{}, fmt.Errorf("%w: %s", ErrUnknownCurrency, from)
+	}
+
+	return amount.DivRound(rate, 8), nil
+}
+
+// rates returns cached rates refreshing them once a day
+func (c *Converter) rates(ctx context.Context) (Rates, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	today := c.now().UTC().Format("2006-01-02")
+	if c.fetchedOn == today {
+		return c.cached, nil
+	}
+
+	rates, err := c.source.Rates(ctx, c.base)
+	if err != nil {
+		if c.fetchedOn == "" {
+			return Rates{}, fmt.Errorf("fetching exchange rates: %w", err)
+		}
+
+		c.logger.Warn("Fetching exchange rates, using stale ones", zap.String("fetched_on", c.fetchedOn), zap.Error(err))
+		return c.cached, nil
+	}
+
+	if rates.Base != c.base {
+		return Rates{}, fmt.Errorf("exchange rates source returned rates for %s instead of %s", rates.Base, c.base)
+	}
+
+	c.logger.Info("Exchange rates are refreshed", zap.String("base", rates.Base), zap.Int("currencies", len(rates.Rates)))
+	c.cached = rates
+	c.fetchedOn = today
+	return rates, nil
+}
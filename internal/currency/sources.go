@@ -0,0 +1,110 @@
+package currency
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// StaticSource provides fixed exchange rates, e.g. configured by operator
+type StaticSource struct {
+	rates map[string]decimal.Decimal
+}
+
+// ParseStaticSource reads rates from comma separated list of CODE=rate pairs like "EUR=0.92,GBP=0.79",
+// each rate is amount of the currency per one unit of base currency
+func ParseStaticSource(spec string) (*StaticSource, error) {
+	rates := make(map[string]decimal.Decimal)
+	for _, pair := range strings.Split(spec, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || !ValidCode(parts[0]) {
+			return nil, fmt.Errorf("exchange rate %q must look like EUR=0.92", pair)
+		}
+
+		rate, err := decimal.NewFromString(parts[1])
+		if err != nil || !rate.IsPositive() {
+			return nil, fmt.Errorf("exchange rate of %s must be positive number", parts[0])
+		}
+
+		rates[parts[0]] = rate
+	}
+
+	return &StaticSource{rates: rates}, nil
+}
+
+// Rates returns configured rates as rates of base currency
+func (s *StaticSource) Rates(_ context.Context, base string) (Rates, error) {
+	return Rates{Base: base, Date: time.Now().UTC(), Rates: s.rates}, nil
+}
+
+// HTTPSource fetches rates from HTTP endpoint responding with JSON object
+// like {"base": "USD", "date": "2021-01-31", "rates": {"EUR": 0.82}}, e.g. https://api.frankfurter.app/latest.
+// Base currency is passed to the endpoint in "from" and "base" query parameters.
+type HTTPSource struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPSource constructs HTTPSource fetching rates from rawURL with provided timeout
+func NewHTTPSource(rawURL string, timeout time.Duration) (*HTTPSource, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("exchange rates url %q must be absolute http or https url", rawURL)
+	}
+
+	return &HTTPSource{url: rawURL, client: &http.Client{Timeout: timeout}}, nil
+}
+
+// ratesResponse defines JSON payload returned by rates endpoint
+type ratesResponse struct {
+	Base  string                     `json:"base"`
+	Date  string                     `json:"date"`
+	Rates map[string]decimal.Decimal `json:"rates"`
+}
+
+// Rates fetches current rates of base currency
+func (s *HTTPSource) Rates(ctx context.Context, base string) (Rates, error) {
+	u, err := url.Parse(s.url)
+	if err != nil {
+		return Rates{}, err
+	}
+
+	q := u.Query()
+	q.Set("from", base)
+	q.Set("base", base)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return Rates{}, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return Rates{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Rates{}, fmt.Errorf("exchange rates endpoint responded with %s", resp.Status)
+	}
+
+	var payload ratesResponse
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&payload)
+	if err != nil {
+		return Rates{}, fmt.Errorf("decoding exchange rates: %w", err)
+	}
+
+	rates := Rates{Base: payload.Base, Date: time.Now().UTC(), Rates: payload.Rates}
+	if date, err := time.Parse("2006-01-02", payload.Date); err == nil {
+		rates.Date = date
+	}
+
+	return rates, nil
+}
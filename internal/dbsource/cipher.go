@@ -0,0 +1,84 @@
+package dbsource
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// MinKeyLength defines minimum length of key encrypting connection strings in bytes
+const MinKeyLength = 32
+
+// sealedPrefix marks connection strings encrypted by Cipher, ones saved before key was configured have no prefix
+const sealedPrefix = "sealed:"
+
+// Cipher encrypts connection strings of sources with AES-256-GCM, so credentials can not be read from database alone.
+// Ciphertext is bound to merchant id, so it can not be moved to source of another merchant.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher constructs Cipher using SHA-256 of provided secret key as AES key
+func NewCipher(key []byte) (*Cipher, error) {
+	if len(key) < MinKeyLength {
+		return nil, errors.New("import source key must be at least " + strconv.Itoa(MinKeyLength) + " bytes long")
+	}
+
+	sum := sha256.Sum256(key)
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cipher{aead: aead}, nil
+}
+
+// Seal returns encrypted dsn of the merchant source, nil Cipher returns dsn as is
+func (c *Cipher) Seal(merchantID int64, dsn string) (string, error) {
+	if c == nil {
+		return dsn, nil
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	sealed := c.aead.Seal(nonce, nonce, []byte(dsn), []byte(strconv.FormatInt(merchantID, 10)))
+	return sealedPrefix + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Open returns dsn of the merchant source decrypted, dsn saved without encryption is returned as is
+func (c *Cipher) Open(merchantID int64, dsn string) (string, error) {
+	if !strings.HasPrefix(dsn, sealedPrefix) {
+		return dsn, nil
+	}
+
+	if c == nil {
+		return "", errors.New("dsn is encrypted, but import source key is not configured")
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(dsn, sealedPrefix))
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return "", errors.New("encrypted dsn is malformed")
+	}
+
+	n := c.aead.NonceSize()
+	plain, err := c.aead.Open(nil, sealed[:n], sealed[n:], []byte(strconv.FormatInt(merchantID, 10)))
+	if err != nil {
+		return "", errors.New("dsn can not be decrypted by configured import source key")
+	}
+
+	return string(plain), nil
+}
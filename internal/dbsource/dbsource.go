@@ -0,0 +1,332 @@
+// Package dbsource implements background job pulling products straight from read-only PostgreSQL databases
+// of merchants and importing them like uploaded CSV files
+package dbsource
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+	"io"
+	"mx/internal/storage/postgresql"
+	"mx/internal/task"
+	"mx/internal/upload"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// placeholders of source query replaced by query parameters
+const (
+	// MerchantPlaceholder is replaced by merchant id
+	MerchantPlaceholder = "{merchant_id}"
+	// SincePlaceholder is replaced by start of the previous pull which scheduled a task, NULL for the first pull
+	SincePlaceholder = "{since}"
+)
+
+// Label is attached to tasks scheduled by pulls, so they can be told apart from uploads
+const Label = "db-source"
+
+// columns defines columns source query has to return, they are selected in the order CSV header lists them
+var columns = []string{"offer_id", "name", "price", "quantity", "available"}
+
+// errNotScheduled stops read of source rows which upload does not read to the end
+var errNotScheduled = errors.New("import is not scheduled")
+
+// Uploader is implemented by service scheduling uploaded files
+type Uploader interface {
+	Upload(ctx context.Context, req upload.Request) (upload.Result, error)
+}
+
+// Job defines fields used to pull products of merchant databases
+type Job struct {
+	logger   *zap.Logger
+	db       *postgresql.Storage
+	uploads  Uploader
+	interval time.Duration
+	// timeout limits time of single pull, maxRows limits number of rows returned by source query
+	timeout time.Duration
+	maxRows int64
+	// cipher decrypts connection strings sealed by Cipher, nil means they are saved as is
+	cipher *Cipher
+	now    func() time.Time
+}
+
+// Option type represents function to modify Job struct
+type Option func(j *Job)
+
+// WithInterval applies passed interval as period between checks for sources due to be pulled
+func WithInterval(d time.Duration) Option {
+	return func(j *Job) {
+		j.interval = d
+	}
+}
+
+// WithLimits applies passed time limit of single pull and maximum number of rows source query may return
+func WithLimits(timeout time.Duration, maxRows int64) Option {
+	return func(j *Job) {
+		j.timeout = timeout
+		j.maxRows = maxRows
+	}
+}
+
+// WithCipher applies passed Cipher decrypting connection strings of sources
+func WithCipher(c *Cipher) Option {
+	return func(j *Job) {
+		j.cipher = c
+	}
+}
+
+// NewJob constructs Job, by default sources are checked every minute, pull takes at most 10 minutes
+// and returns at most 5 million rows
+func NewJob(logger *zap.Logger, db *postgresql.Storage, uploads Uploader, options ...Option) (*Job, error) {
+	if logger == nil {
+		return nil, errors.New("no logger provided")
+	}
+
+	job := &Job{
+		logger:   logger.With(zap.String("component", "dbsource")),
+		db:       db,
+		uploads:  uploads,
+		interval: time.Minute,
+		timeout:  10 * time.Minute,
+		maxRows:  5000000,
+		now:      time.Now,
+	}
+
+	for _, opt := range options {
+		opt(job)
+	}
+
+	if job.interval <= 0 {
+		return nil, errors.New("import source poll interval must be positive")
+	}
+
+	if job.timeout <= 0 {
+		return nil, errors.New("import source pull timeout must be positive")
+	}
+
+	if job.maxRows <= 0 {
+		return nil, errors.New("import source row limit must be positive")
+	}
+
+	return job, nil
+}
+
+// Validate checks connection string and query of source without connecting to the database,
+// returned error is safe to be shown to client
+func Validate(src postgresql.ImportSource) error {
+	cfg, err := pgx.ParseConfig(src.DSN)
+	if err != nil {
+		return errors.New("dsn must be valid PostgreSQL connection string")
+	}
+
+	if cfg.Host == "" {
+		return errors.New("dsn must contain host")
+	}
+
+	if strings.TrimSpace(strings.TrimRight(strings.TrimSpace(src.Query), ";")) == "" {
+		return errors.New("query can not be blank")
+	}
+
+	if src.IntervalSeconds <= 0 {
+		return errors.New("interval must be positive")
+	}
+
+	return nil
+}
+
+// Run pulls due sources every interval until ctx is done
+func (j *Job) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := j.RunOnce(ctx)
+		if err != nil {
+			j.logger.Error("Import sources run", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce pulls sources whose interval has passed and returns number of scheduled tasks.
+// Failed pull is saved as last error of the source and retried after its interval.
+func (j *Job) RunOnce(ctx context.Context) (int, error) {
+	runAt := j.now()
+	sources, err := j.db.ClaimDueImportSources(ctx, runAt)
+	if err != nil {
+		return 0, err
+	}
+
+	var scheduled int
+	for _, src := range sources {
+		taskID, err := j.pull(ctx, src)
+		errorText := ""
+		if err != nil {
+			j.logger.Warn("Pulling import source", zap.Int64("merchant_id", src.MerchantID), zap.Error(err))
+			errorText = err.Error()
+		} else {
+			j.logger.Info("Import source pulled", zap.Int64("merchant_id", src.MerchantID), zap.String("task_id", taskID))
+			scheduled++
+		}
+
+		err = j.db.FinishImportSourceRun(ctx, src.MerchantID, runAt, taskID, errorText)
+		if err != nil {
+			return scheduled, err
+		}
+	}
+
+	return scheduled, nil
+}
+
+// pull streams rows of source query as CSV file uploaded on behalf of the merchant while they are read,
+// so pulled rows are never kept in memory. File of failed read is not saved, as file store saves only
+// content read to the end.
+func (j *Job) pull(ctx context.Context, src postgresql.ImportSource) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, j.timeout)
+	defer cancel()
+
+	var err error
+	src.DSN, err = j.cipher.Open(src.MerchantID, src.DSN)
+	if err != nil {
+		return "", err
+	}
+
+	pr, pw := io.Pipe()
+	readErr := make(chan error, 1)
+	go func() {
+		err := j.read(ctx, src, pw)
+		pw.CloseWithError(err)
+		readErr <- err
+	}()
+
+	header := true
+	result, err := j.uploads.Upload(ctx, upload.Request{
+		MerchantID:  src.MerchantID,
+		FileName:    "source.csv",
+		Body:        pr,
+		ContentType: "text/csv",
+		Format:      task.FormatCSV,
+		Header:      &header,
+		Labels:      []string{Label},
+	})
+	// upload may stop reading early, e.g. if quota is exhausted, so blocked read is released
+	pr.CloseWithError(errNotScheduled)
+
+	// failed read explains failed upload better than error of upload it causes
+	rerr := <-readErr
+	switch {
+	case rerr != nil && !errors.Is(rerr, errNotScheduled):
+		return "", rerr
+	case err != nil:
+		return "", fmt.Errorf("scheduling import: %w", err)
+	}
+
+	return result.TaskID.String(), nil
+}
+
+// read runs source query in read-only transaction and writes returned rows to out as CSV with header
+func (j *Job) read(ctx context.Context, src postgresql.ImportSource, out io.Writer) error {
+	cfg, err := pgx.ParseConfig(src.DSN)
+	if err != nil {
+		return errors.New("dsn is not valid PostgreSQL connection string")
+	}
+	cfg.RuntimeParams["application_name"] = "mx-import"
+	cfg.RuntimeParams["default_transaction_read_only"] = "on"
+	cfg.RuntimeParams["statement_timeout"] = strconv.FormatInt(j.timeout.Milliseconds(), 10)
+
+	conn, err := pgx.ConnectConfig(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("connecting to source database: %w", err)
+	}
+	defer conn.Close(context.Background())
+
+	tx, err := conn.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return fmt.Errorf("starting read-only transaction: %w", err)
+	}
+	defer tx.Rollback(context.Background())
+
+	sql, args := query(src, j.maxRows)
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("running source query: %w", err)
+	}
+	defer rows.Close()
+
+	w := csv.NewWriter(out)
+	err = w.Write(columns)
+	if err != nil {
+		return err
+	}
+
+	var n int64
+	values := make([]*string, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	record := make([]string, len(columns))
+	for rows.Next() {
+		n++
+		if n > j.maxRows {
+			return fmt.Errorf("source query returned more than %d rows", j.maxRows)
+		}
+
+		err = rows.Scan(dest...)
+		if err != nil {
+			return fmt.Errorf("reading source row: %w", err)
+		}
+
+		for i, v := range values {
+			record[i] = ""
+			if v != nil {
+				record[i] = *v
+			}
+		}
+
+		err = w.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	if rows.Err() != nil {
+		return fmt.Errorf("running source query: %w", rows.Err())
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+// query wraps source query selecting required columns as text, so values keep exact database representation,
+// and replaces placeholders by parameters. Query is put on its own lines, so its trailing comment does not hide the rest. One row more than maxRows is selected to detect exceeded limit.
+func query(src postgresql.ImportSource, maxRows int64) (string, []interface{}) {
+	q := strings.TrimRight(strings.TrimSpace(src.Query), ";")
+
+	var args []interface{}
+	if strings.Contains(q, MerchantPlaceholder) {
+		args = append(args, src.MerchantID)
+		q = strings.ReplaceAll(q, MerchantPlaceholder, "$"+strconv.Itoa(len(args))+"::bigint")
+	}
+	if strings.Contains(q, SincePlaceholder) {
+		args = append(args, src.LastPulledAt)
+		q = strings.ReplaceAll(q, SincePlaceholder, "$"+strconv.Itoa(len(args))+"::timestamptz")
+	}
+
+	selected := make([]string, len(columns))
+	for i, c := range columns {
+		selected[i] = c + "::text"
+	}
+
+	args = append(args, maxRows+1)
+	return "SELECT " + strings.Join(selected, ", ") + " FROM (\n" + q + "\n) AS source LIMIT $" + strconv.Itoa(len(args)), args
+}
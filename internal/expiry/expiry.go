@@ -0,0 +1,123 @@
+// Package expiry implements background job applying merchant expiry rules to offers missing from imports
+package expiry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"mx/internal/storage/postgresql"
+	"time"
+)
+
+// Report defines outcome of single expiry run
+type Report struct {
+	// Expired maps merchant ids to numbers of their offers expired by the run
+	Expired map[int64]int64
+	// Failed is number of merchants whose rules failed to apply
+	Failed int
+}
+
+// Job defines fields used to apply expiry rules
+type Job struct {
+	logger   *zap.Logger
+	db       *postgresql.Storage
+	interval time.Duration
+	now      func() time.Time
+}
+
+// Option type represents function to modify Job struct
+type Option func(j *Job)
+
+// WithInterval applies passed interval as period between expiry runs
+func WithInterval(d time.Duration) Option {
+	return func(j *Job) {
+		j.interval = d
+	}
+}
+
+// NewJob constructs Job, by default runs happen every hour
+func NewJob(logger *zap.Logger, db *postgresql.Storage, options ...Option) (*Job, error) {
+	if logger == nil {
+		return nil, errors.New("no logger provided")
+	}
+
+	job := &Job{
+		logger:   logger.With(zap.String("component", "expiry")),
+		db:       db,
+		interval: time.Hour,
+		now:      time.Now,
+	}
+
+	for _, opt := range options {
+		opt(job)
+	}
+
+	if job.interval <= 0 {
+		return nil, errors.New("expiry interval must be positive")
+	}
+
+	return job, nil
+}
+
+// Run performs expiry runs every interval until ctx is done
+func (j *Job) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := j.RunOnce(ctx)
+		if err != nil {
+			j.logger.Error("Expiry run", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce applies rule of every merchant once and writes audit log entry for every merchant with expired offers.
+// Failure of one merchant rule does not prevent applying the others.
+func (j *Job) RunOnce(ctx context.Context) (Report, error) {
+	report := Report{Expired: make(map[int64]int64)}
+
+	rules, err := j.db.ExpiryRules(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	now := j.now()
+	for _, rule := range rules {
+		staleBefore := now.Add(-time.Duration(rule.StaleAfterDays) * 24 * time.Hour)
+
+		expired, err := j.db.ExpireOffers(ctx, rule, staleBefore, now)
+		if err != nil {
+			j.logger.Error("Expiring offers", zap.Int64("merchant_id", rule.MerchantID), zap.Error(err))
+			report.Failed++
+			continue
+		}
+
+		if expired == 0 {
+			continue
+		}
+
+		j.logger.Info("Stale offers expired",
+			zap.Int64("merchant_id", rule.MerchantID),
+			zap.String("action", rule.Action),
+			zap.Time("last_seen_before", staleBefore),
+			zap.Int64("offers", expired),
+			zap.Bool("audit", true),
+		)
+
+		report.Expired[rule.MerchantID] = expired
+	}
+
+	if report.Failed != 0 {
+		return report, fmt.Errorf("expiry rules of %d merchants failed", report.Failed)
+	}
+
+	return report, nil
+}
@@ -0,0 +1,25 @@
+// Package logctx carries request or task scoped logger through context, so logs of lower layers
+// are correlated with the request or task they are written for
+package logctx
+
+import (
+	"context"
+	"go.uber.org/zap"
+)
+
+type contextKey struct{}
+
+// NewContext returns copy of ctx carrying provided logger
+func NewContext(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns logger carried by ctx or fallback if there is none
+func FromContext(ctx context.Context, fallback *zap.Logger) *zap.Logger {
+	logger, ok := ctx.Value(contextKey{}).(*zap.Logger)
+	if !ok || logger == nil {
+		return fallback
+	}
+
+	return logger
+}
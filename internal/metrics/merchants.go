@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"encoding/binary"
+	"errors"
+	"expvar"
+	"hash/fnv"
+	"strconv"
+	"sync"
+)
+
+// DefaultMerchantBuckets defines number of buckets merchants are hashed into unless SetMerchantLabels is called
+const DefaultMerchantBuckets = 16
+
+var (
+	// UploadsByMerchant counts scheduled uploads per merchant label, see MerchantLabel
+	UploadsByMerchant = expvar.NewMap("uploads_by_merchant_total")
+	// QuotaRejectionsByMerchant counts uploads rejected due to exhausted quota per merchant label
+	QuotaRejectionsByMerchant = expvar.NewMap("quota_rejections_by_merchant_total")
+	// FailedTasksByMerchant counts tasks aborted by processing error or timed out per merchant label
+	FailedTasksByMerchant = expvar.NewMap("failed_tasks_by_merchant_total")
+)
+
+// merchantLabels defines the way merchant ids are turned into labels
+var merchantLabels = struct {
+	rw      sync.RWMutex
+	buckets uint64
+	// allowlist contains merchants labeled individually
+	allowlist map[int64]struct{}
+}{
+	buckets: DefaultMerchantBuckets,
+}
+
+// SetMerchantLabels makes MerchantLabel hash merchants into provided number of buckets except allowlisted ones,
+// which are labeled individually. Number of distinct labels is at most buckets plus length of allowlist.
+func SetMerchantLabels(buckets int, allowlist []int64) error {
+	if buckets <= 0 {
+		return errors.New("number of merchant buckets must be positive")
+	}
+
+	allowed := make(map[int64]struct{}, len(allowlist))
+	for _, id := range allowlist {
+		allowed[id] = struct{}{}
+	}
+
+	merchantLabels.rw.Lock()
+	merchantLabels.buckets = uint64(buckets)
+	merchantLabels.allowlist = allowed
+	merchantLabels.rw.Unlock()
+
+	return nil
+}
+
+// MerchantLabel returns label of merchant in per merchant metrics, it is "merchant_<id>" for allowlisted merchants
+// and "bucket_<n>" for the rest, so metrics cardinality does not grow with number of merchants
+func MerchantLabel(merchantID int64) string {
+	merchantLabels.rw.RLock()
+	defer merchantLabels.rw.RUnlock()
+
+	_, ok := merchantLabels.allowlist[merchantID]
+	if ok {
+		return "merchant_" + strconv.FormatInt(merchantID, 10)
+	}
+
+	// id is hashed, so merchants are spread over buckets evenly whatever pattern their ids follow
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(merchantID))
+	h := fnv.New64a()
+	_, _ = h.Write(b[:])
+
+	return "bucket_" + strconv.FormatUint(h.Sum64()%merchantLabels.buckets, 10)
+}
+
+// AddMerchant adds delta to m under label of the merchant
+func AddMerchant(m *expvar.Map, merchantID int64, delta int64) {
+	m.Add(MerchantLabel(merchantID), delta)
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+// restoreMerchantLabels brings default labeling back once test finishes
+func restoreMerchantLabels(t *testing.T) {
+	t.Cleanup(func() {
+		err := SetMerchantLabels(DefaultMerchantBuckets, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestMerchantLabel(t *testing.T) {
+	restoreMerchantLabels(t)
+
+	err := SetMerchantLabels(4, []int64{7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := MerchantLabel(7); got != "merchant_7" {
+		t.Fatalf("expected allowlisted merchant to be labeled merchant_7, got %s", got)
+	}
+
+	labels := make(map[string]struct{})
+	for id := int64(1); id <= 1000; id++ {
+		if id == 7 {
+			continue
+		}
+
+		label := MerchantLabel(id)
+		if !strings.HasPrefix(label, "bucket_") {
+			t.Fatalf("expected merchant %d to be labeled by bucket, got %s", id, label)
+		}
+		if MerchantLabel(id) != label {
+			t.Fatalf("label of merchant %d changes between calls", id)
+		}
+		labels[label] = struct{}{}
+	}
+
+	// consecutive ids are spread over every bucket rather than few of them
+	if len(labels) != 4 {
+		t.Fatalf("expected 4 bucket labels, got %v", labels)
+	}
+}
+
+func TestMerchantLabelDefault(t *testing.T) {
+	restoreMerchantLabels(t)
+
+	err := SetMerchantLabels(DefaultMerchantBuckets, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := MerchantLabel(7); !strings.HasPrefix(got, "bucket_") {
+		t.Fatalf("expected merchant without allowlist to be labeled by bucket, got %s", got)
+	}
+}
+
+func TestSetMerchantLabelsBuckets(t *testing.T) {
+	restoreMerchantLabels(t)
+
+	for _, buckets := range []int{0, -1} {
+		err := SetMerchantLabels(buckets, nil)
+		if err == nil {
+			t.Fatalf("expected %d buckets to be refused", buckets)
+		}
+	}
+}
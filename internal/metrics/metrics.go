@@ -0,0 +1,13 @@
+// Package metrics defines application metrics published via expvar
+package metrics
+
+import "expvar"
+
+var (
+	// Environment contains name of deployment environment metrics are collected in
+	Environment = expvar.NewString("environment")
+	// QuotaWarnings counts responses carrying quota warning per quota name
+	QuotaWarnings = expvar.NewMap("quota_warnings_total")
+	// QuotaRejections counts requests rejected due to exhausted quota per quota name
+	QuotaRejections = expvar.NewMap("quota_rejections_total")
+)
@@ -0,0 +1,546 @@
+// Package offboarding exports complete archive of merchant data and deletes the data after grace period
+package offboarding
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"mx/internal/cleanup"
+	"mx/internal/storage"
+	"mx/internal/storage/postgresql"
+	"mx/internal/task"
+	"strconv"
+	"time"
+)
+
+// states of offboarding
+const (
+	// StateExporting means archive of merchant data is being built
+	StateExporting = "Exporting"
+	// StateScheduled means archive is saved and merchant data is deleted once grace period is over
+	StateScheduled = "Scheduled"
+	// StateDeleted means merchant data is deleted, archive is still available
+	StateDeleted = "Deleted"
+	// StateCanceled means deletion was canceled during grace period
+	StateCanceled = "Canceled"
+	// StateFailed means export failed, offboarding may be started again
+	StateFailed = "Failed"
+)
+
+// phases of export reported while offboarding is in StateExporting
+const (
+	PhaseCatalog = "catalog"
+	PhaseTasks   = "tasks"
+	PhaseHistory = "history"
+	PhaseFiles   = "files"
+	PhaseSaving  = "saving"
+)
+
+// ArchivePrefix is prepended to keys of offboarding archives, they do not look like uploads,
+// so cleanup never removes them
+const ArchivePrefix = "offboarding/"
+
+// activeStates defines states merchant may have only one offboarding in
+var activeStates = []string{StateExporting, StateScheduled}
+
+// ErrCanNotCancel is returned when offboarding deletion can not be canceled since it is not scheduled
+var ErrCanNotCancel = errors.New("offboarding is not scheduled for deletion")
+
+// ErrNoArchive is returned when archive of offboarding is not exported yet
+var ErrNoArchive = errors.New("offboarding archive is not exported")
+
+// manifest defines manifest.json of archive
+type manifest struct {
+	OffboardingID string    `json:"offboarding_id"`
+	MerchantID    int64     `json:"merchant_id"`
+	ExportedAt    time.Time `json:"exported_at"`
+	Products      int64     `json:"products"`
+	Archived      int64     `json:"archived_products"`
+	Tasks         int64     `json:"tasks"`
+	ExpiredOffers int64     `json:"expired_offers"`
+	UsageRecords  int64     `json:"usage_records"`
+	Files         int64     `json:"files"`
+}
+
+// Service defines fields used to export and delete merchant data
+type Service struct {
+	logger      *zap.Logger
+	db          *postgresql.Storage
+	blobs       storage.Blob
+	ids         task.IDGenerator
+	interval    time.Duration
+	gracePeriod time.Duration
+	now         func() time.Time
+}
+
+// Option type represents function to modify Service struct
+type Option func(s *Service)
+
+// WithInterval applies passed interval as period between checks for offboardings with grace period over
+func WithInterval(d time.Duration) Option {
+	return func(s *Service) {
+		s.interval = d
+	}
+}
+
+// WithGracePeriod applies passed period as time merchant data is kept after the archive is exported
+func WithGracePeriod(d time.Duration) Option {
+	return func(s *Service) {
+		s.gracePeriod = d
+	}
+}
+
+// WithIDGenerator applies generator of offboarding ids, they share format with task ids
+func WithIDGenerator(ids task.IDGenerator) Option {
+	return func(s *Service) {
+		s.ids = ids
+	}
+}
+
+// NewService constructs Service, by default merchant data is deleted 30 days after export
+// and offboardings are checked every hour
+func NewService(logger *zap.Logger, db *postgresql.Storage, blobs storage.Blob, options ...Option) (*Service, error) {
+	if logger == nil {
+		return nil, errors.New("no logger provided")
+	}
+
+	s := &Service{
+		logger:      logger.With(zap.String("component", "offboarding")),
+		db:          db,
+		blobs:       blobs,
+		ids:         task.XIDGenerator{},
+		interval:    time.Hour,
+		gracePeriod: 30 * 24 * time.Hour,
+		now:         time.Now,
+	}
+
+	for _, opt := range options {
+		opt(s)
+	}
+
+	if s.interval <= 0 {
+		return nil, errors.New("offboarding interval must be positive")
+	}
+
+	if s.gracePeriod < 0 {
+		return nil, errors.New("offboarding grace period must not be negative")
+	}
+
+	return s, nil
+}
+
+// Start creates offboarding of the merchant and exports its data in background.
+// Returns postgresql.ErrOffboardingInProgress if merchant already has exporting or scheduled offboarding.
+func (s *Service) Start(ctx context.Context, merchantID int64) (postgresql.Offboarding, error) {
+	o := postgresql.Offboarding{
+		ID:         s.ids.NewTaskID().String(),
+		MerchantID: merchantID,
+		State:      StateExporting,
+		CreatedAt:  s.now(),
+		UpdatedAt:  s.now(),
+	}
+
+	err := s.db.CreateOffboarding(ctx, o, activeStates)
+	if err != nil {
+		return postgresql.Offboarding{}, err
+	}
+
+	s.logger.Info("Offboarding started", zap.String("offboarding_id", o.ID), zap.Int64("merchant_id", merchantID))
+	go s.export(context.Background(), o)
+
+	return o, nil
+}
+
+// Resume restarts exports interrupted by shutdown, export is started from scratch
+func (s *Service) Resume(ctx context.Context) error {
+	offboardings, err := s.db.Offboardings(ctx, StateExporting, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, o := range offboardings {
+		s.logger.Info("Resuming offboarding", zap.String("offboarding_id", o.ID), zap.Int64("merchant_id", o.MerchantID))
+		go s.export(context.Background(), o)
+	}
+
+	return nil
+}
+
+// Status returns offboarding with provided id or postgresql.ErrOffboardingNotFound
+func (s *Service) Status(ctx context.Context, id string) (postgresql.Offboarding, error) {
+	return s.db.ReadOffboarding(ctx, id)
+}
+
+// Cancel cancels deletion of merchant data during grace period, the archive is kept
+func (s *Service) Cancel(ctx context.Context, id string) (postgresql.Offboarding, error) {
+	o, err := s.db.SetOffboardingState(ctx, id, StateScheduled, StateCanceled)
+	if err != nil {
+		if errors.Is(err, postgresql.ErrConflict) {
+			return postgresql.Offboarding{}, ErrCanNotCancel
+		}
+		return postgresql.Offboarding{}, err
+	}
+
+	s.logger.Info("Offboarding deletion canceled", zap.String("offboarding_id", o.ID), zap.Int64("merchant_id", o.MerchantID))
+	return o, nil
+}
+
+// Archive returns reader of exported archive, reader has to be closed by caller
+func (s *Service) Archive(ctx context.Context, id string) (io.ReadCloser, postgresql.Offboarding, error) {
+	o, err := s.db.ReadOffboarding(ctx, id)
+	if err != nil {
+		return nil, postgresql.Offboarding{}, err
+	}
+
+	if o.ExportedAt == nil || o.ArchiveKey == "" {
+		return nil, o, ErrNoArchive
+	}
+
+	r, err := s.blobs.Get(ctx, o.ArchiveKey)
+	if err != nil {
+		return nil, o, err
+	}
+
+	return r, o, nil
+}
+
+// Run deletes data of merchants whose grace period is over every interval until ctx is done
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := s.RunOnce(ctx)
+		if err != nil {
+			s.logger.Error("Offboarding run", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce deletes data of merchants whose grace period is over and returns number of offboarded merchants.
+// Failure of one merchant does not prevent deleting the others, failed ones are retried by the next run.
+func (s *Service) RunOnce(ctx context.Context) (int, error) {
+	now := s.now()
+	offboardings, err := s.db.Offboardings(ctx, StateScheduled, &now)
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted, failed int
+	for _, o := range offboardings {
+		err = s.deleteData(ctx, o)
+		if err != nil {
+			s.logger.Error("Deleting merchant data", zap.String("offboarding_id", o.ID), zap.Int64("merchant_id", o.MerchantID),
+				zap.Error(err))
+			failed++
+			continue
+		}
+
+		deleted++
+	}
+
+	if failed != 0 {
+		return deleted, fmt.Errorf("%d merchants failed to be offboarded", failed)
+	}
+
+	return deleted, nil
+}
+
+// deleteData removes stored files and database records of the merchant and marks offboarding as deleted
+func (s *Service) deleteData(ctx context.Context, o postgresql.Offboarding) error {
+	keys, err := s.fileKeys(ctx, o.MerchantID)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		err = s.blobs.Delete(ctx, key)
+		if err != nil {
+			return fmt.Errorf("deleting file %s: %w", key, err)
+		}
+	}
+
+	err = s.db.DeleteMerchantData(ctx, o.MerchantID)
+	if err != nil {
+		return err
+	}
+
+	deletedAt := s.now()
+	o.State = StateDeleted
+	o.DeletedAt = &deletedAt
+	err = s.db.UpdateOffboarding(ctx, o)
+	if err != nil {
+		return err
+	}
+
+	s.logger.Warn("Merchant data deleted", zap.String("offboarding_id", o.ID), zap.Int64("merchant_id", o.MerchantID),
+		zap.Int("files", len(keys)), zap.Bool("audit", true))
+	return nil
+}
+
+// fileKeys returns keys of uploaded files of the merchant including ones moved to archive by cleanup
+func (s *Service) fileKeys(ctx context.Context, merchantID int64) ([]string, error) {
+	prefix := strconv.FormatInt(merchantID, 10) + "/"
+
+	var keys []string
+	for _, p := range []string{prefix, cleanup.ArchivePrefix + prefix} {
+		blobs, err := s.blobs.List(ctx, p)
+		if err != nil {
+			return nil, fmt.Errorf("listing files: %w", err)
+		}
+
+		for _, b := range blobs {
+			keys = append(keys, b.Key)
+		}
+	}
+
+	return keys, nil
+}
+
+// export builds archive of merchant data saving progress after every phase and schedules deletion of the data
+func (s *Service) export(ctx context.Context, o postgresql.Offboarding) {
+	log := s.logger.With(zap.String("offboarding_id", o.ID), zap.Int64("merchant_id", o.MerchantID))
+
+	err := s.buildArchive(ctx, &o)
+	if err != nil {
+		log.Error("Exporting merchant data", zap.String("phase", o.Phase), zap.Error(err))
+		o.State = StateFailed
+		o.Error = err.Error()
+		err = s.db.UpdateOffboarding(ctx, o)
+		if err != nil {
+			log.Error("Saving failed offboarding", zap.Error(err))
+		}
+		return
+	}
+
+	exportedAt := s.now()
+	deleteAfter := exportedAt.Add(s.gracePeriod)
+	o.State = StateScheduled
+	o.Phase = ""
+	o.ExportedAt = &exportedAt
+	o.DeleteAfter = &deleteAfter
+	err = s.db.UpdateOffboarding(ctx, o)
+	if err != nil {
+		log.Error("Saving exported offboarding", zap.Error(err))
+		return
+	}
+
+	log.Info("Merchant data exported", zap.String("key", o.ArchiveKey), zap.Int64("size", o.ArchiveSize),
+		zap.Time("delete_after", deleteAfter))
+}
+
+// buildArchive writes zip archive of merchant data and saves it to blob storage
+func (s *Service) buildArchive(ctx context.Context, o *postgresql.Offboarding) error {
+	o.Products, o.Tasks, o.Files = 0, 0, 0
+	m := manifest{OffboardingID: o.ID, MerchantID: o.MerchantID}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	err := s.setPhase(ctx, o, PhaseCatalog)
+	if err != nil {
+		return err
+	}
+
+	products, err := s.db.MerchantProducts(ctx, o.MerchantID)
+	if err != nil {
+		return err
+	}
+	archived, err := s.db.ArchivedProducts(ctx, o.MerchantID)
+	if err != nil {
+		return err
+	}
+	err = writeNDJSON(zw, "catalog.ndjson", len(products), func(i int) interface{} { return products[i] })
+	if err != nil {
+		return err
+	}
+	err = writeNDJSON(zw, "catalog_archive.ndjson", len(archived), func(i int) interface{} { return archived[i] })
+	if err != nil {
+		return err
+	}
+	o.Products = int64(len(products) + len(archived))
+	m.Products, m.Archived = int64(len(products)), int64(len(archived))
+
+	err = s.setPhase(ctx, o, PhaseTasks)
+	if err != nil {
+		return err
+	}
+
+	tasks, err := s.db.MerchantTasks(ctx, o.MerchantID)
+	if err != nil {
+		return err
+	}
+	err = writeNDJSON(zw, "tasks.ndjson", len(tasks), func(i int) interface{} { return tasks[i] })
+	if err != nil {
+		return err
+	}
+	o.Tasks = int64(len(tasks))
+	m.Tasks = o.Tasks
+
+	err = s.setPhase(ctx, o, PhaseHistory)
+	if err != nil {
+		return err
+	}
+
+	err = s.writeHistory(ctx, zw, o.MerchantID, &m)
+	if err != nil {
+		return err
+	}
+
+	err = s.setPhase(ctx, o, PhaseFiles)
+	if err != nil {
+		return err
+	}
+
+	keys, err := s.fileKeys(ctx, o.MerchantID)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		err = s.copyFile(ctx, zw, key)
+		if err != nil {
+			return err
+		}
+		o.Files++
+	}
+	m.Files = o.Files
+
+	err = s.setPhase(ctx, o, PhaseSaving)
+	if err != nil {
+		return err
+	}
+
+	m.ExportedAt = s.now()
+	err = writeJSON(zw, "manifest.json", m)
+	if err != nil {
+		return err
+	}
+
+	err = zw.Close()
+	if err != nil {
+		return fmt.Errorf("closing archive: %w", err)
+	}
+
+	key := ArchivePrefix + strconv.FormatInt(o.MerchantID, 10) + "/" + o.ID + ".zip"
+	err = s.blobs.Put(ctx, key, buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("saving archive: %w", err)
+	}
+
+	o.ArchiveKey = key
+	o.ArchiveSize = int64(buf.Len())
+	return nil
+}
+
+// writeHistory writes expiry rule, expired offers and API usage of the merchant,
+// they are audit records kept in database
+func (s *Service) writeHistory(ctx context.Context, zw *zip.Writer, merchantID int64, m *manifest) error {
+	rule, err := s.db.ReadExpiryRule(ctx, merchantID)
+	switch {
+	case err == nil:
+		err = writeJSON(zw, "expiry_rule.json", rule)
+		if err != nil {
+			return err
+		}
+	case !errors.Is(err, postgresql.ErrNoExpiryRule):
+		return err
+	}
+
+	offers, err := s.db.ExpiredOffers(ctx, merchantID, time.Time{})
+	if err != nil {
+		return err
+	}
+	err = writeNDJSON(zw, "expired_offers.ndjson", len(offers), func(i int) interface{} { return offers[i] })
+	if err != nil {
+		return err
+	}
+	m.ExpiredOffers = int64(len(offers))
+
+	usage, err := s.db.MerchantUsage(ctx, merchantID, time.Time{})
+	if err != nil {
+		return err
+	}
+	err = writeNDJSON(zw, "api_usage.ndjson", len(usage), func(i int) interface{} { return usage[i] })
+	if err != nil {
+		return err
+	}
+	m.UsageRecords = int64(len(usage))
+
+	return nil
+}
+
+// setPhase saves progress of export before the next phase starts
+func (s *Service) setPhase(ctx context.Context, o *postgresql.Offboarding, phase string) error {
+	o.Phase = phase
+	return s.db.UpdateOffboarding(ctx, *o)
+}
+
+// copyFile writes stored file into files directory of archive, file removed meanwhile is skipped
+func (s *Service) copyFile(ctx context.Context, zw *zip.Writer, key string) error {
+	r, err := s.blobs.Get(ctx, key)
+	if err != nil {
+		if errors.Is(err, storage.ErrBlobNotFound) {
+			return nil
+		}
+		return fmt.Errorf("reading file %s: %w", key, err)
+	}
+	defer r.Close()
+
+	w, err := zw.Create("files/" + key)
+	if err != nil {
+		return fmt.Errorf("adding file %s: %w", key, err)
+	}
+
+	_, err = io.Copy(w, r)
+	if err != nil {
+		return fmt.Errorf("copying file %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// writeNDJSON writes n items returned by item into archive entry as newline delimited JSON
+func writeNDJSON(zw *zip.Writer, name string, n int, item func(i int) interface{}) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return fmt.Errorf("adding %s: %w", name, err)
+	}
+
+	enc := json.NewEncoder(w)
+	for i := 0; i < n; i++ {
+		err = enc.Encode(item(i))
+		if err != nil {
+			return fmt.Errorf("writing %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// writeJSON writes v into archive entry as indented JSON
+func writeJSON(zw *zip.Writer, name string, v interface{}) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return fmt.Errorf("adding %s: %w", name, err)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(v)
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+
+	return nil
+}
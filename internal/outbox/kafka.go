@@ -0,0 +1,139 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mx/internal/storage/postgresql"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// kafkaContentType is content type of JSON records accepted by v2 API of Kafka REST Proxy
+const kafkaContentType = "application/vnd.kafka.json.v2+json"
+
+// KafkaREST publishes events to Kafka topic through Kafka REST Proxy, so no Kafka client is linked.
+// Every event is sent as JSON record keyed by "merchant_id:offer_id", so changes of the offer keep their order
+// within partition.
+type KafkaREST struct {
+	url    string
+	client *http.Client
+}
+
+// NewKafkaREST constructs KafkaREST posting records to topic of REST Proxy at rawURL with provided timeout
+func NewKafkaREST(rawURL, topic string, timeout time.Duration) (*KafkaREST, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("kafka rest proxy url %q must be absolute http or https url", rawURL)
+	}
+
+	if topic == "" {
+		return nil, fmt.Errorf("kafka topic can not be blank")
+	}
+
+	return &KafkaREST{
+		url:    strings.TrimRight(rawURL, "/") + "/topics/" + url.PathEscape(topic),
+		client: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// kafkaRecord defines single record of produce request
+type kafkaRecord struct {
+	Key   string                  `json:"key"`
+	Value postgresql.ProductEvent `json:"value"`
+}
+
+// kafkaProduceResponse defines JSON payload returned by produce endpoint, every offset matches record of the request
+type kafkaProduceResponse struct {
+	Offsets []struct {
+		Offset    *int64  `json:"offset"`
+		ErrorCode *int64  `json:"error_code"`
+		Error     *string `json:"error"`
+	} `json:"offsets"`
+}
+
+// Ping reads metadata of the topic, so it fails if REST Proxy is unreachable or the topic does not exist
+func (k *KafkaREST) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("kafka rest proxy responded with %s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+
+	return nil
+}
+
+// Publish produces events as records of the topic, it fails unless every record is written
+func (k *KafkaREST) Publish(ctx context.Context, events []postgresql.ProductEvent) error {
+	records := make([]kafkaRecord, len(events))
+	for i, e := range events {
+		records[i] = kafkaRecord{
+			Key:   strconv.FormatInt(e.MerchantID, 10) + ":" + strconv.FormatInt(e.OfferID, 10),
+			Value: e,
+		}
+	}
+
+	body, err := json.Marshal(struct {
+		Records []kafkaRecord `json:"records"`
+	}{records})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", kafkaContentType)
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("kafka rest proxy responded with %s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+
+	var payload kafkaProduceResponse
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&payload)
+	if err != nil {
+		return fmt.Errorf("decoding kafka rest proxy response: %w", err)
+	}
+
+	if len(payload.Offsets) != len(records) {
+		return fmt.Errorf("kafka rest proxy acknowledged %d of %d records", len(payload.Offsets), len(records))
+	}
+
+	for _, o := range payload.Offsets {
+		if o.Error != nil || o.ErrorCode != nil || o.Offset == nil {
+			message := "no offset"
+			if o.Error != nil {
+				message = *o.Error
+			}
+			return fmt.Errorf("kafka rest proxy failed to write record: %s", message)
+		}
+	}
+
+	return nil
+}
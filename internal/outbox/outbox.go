@@ -0,0 +1,116 @@
+// Package outbox implements background job publishing product changes saved to outbox by import transactions
+package outbox
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"mx/internal/storage/postgresql"
+	"time"
+)
+
+// Sink is implemented by message brokers events are published to. Publish returns nil only once every event
+// is acknowledged by the broker, events of failed call are published again, so consumers have to tolerate duplicates.
+type Sink interface {
+	Publish(ctx context.Context, events []postgresql.ProductEvent) error
+}
+
+// Job defines fields used to publish outbox events
+type Job struct {
+	logger    *zap.Logger
+	db        *postgresql.Storage
+	sink      Sink
+	interval  time.Duration
+	batchSize int
+}
+
+// Option type represents function to modify Job struct
+type Option func(j *Job)
+
+// WithInterval applies passed interval as period between checks of empty outbox
+func WithInterval(d time.Duration) Option {
+	return func(j *Job) {
+		j.interval = d
+	}
+}
+
+// WithBatchSize applies passed maximum number of events published by single Publish call
+func WithBatchSize(n int) Option {
+	return func(j *Job) {
+		j.batchSize = n
+	}
+}
+
+// NewJob constructs Job, by default outbox is checked every second and events are published by 500
+func NewJob(logger *zap.Logger, db *postgresql.Storage, sink Sink, options ...Option) (*Job, error) {
+	if logger == nil {
+		return nil, errors.New("no logger provided")
+	}
+
+	if sink == nil {
+		return nil, errors.New("no sink provided")
+	}
+
+	job := &Job{
+		logger:    logger.With(zap.String("component", "outbox")),
+		db:        db,
+		sink:      sink,
+		interval:  time.Second,
+		batchSize: 500,
+	}
+
+	for _, opt := range options {
+		opt(job)
+	}
+
+	if job.interval <= 0 {
+		return nil, errors.New("outbox poll interval must be positive")
+	}
+
+	if job.batchSize <= 0 {
+		return nil, errors.New("outbox batch size must be positive")
+	}
+
+	return job, nil
+}
+
+// Run publishes events every interval until ctx is done
+func (j *Job) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := j.RunOnce(ctx)
+		if err != nil {
+			j.logger.Error("Publishing product events", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce publishes batches of events until outbox has no unlocked events left and returns number of published events
+func (j *Job) RunOnce(ctx context.Context) (int, error) {
+	var total int
+	for ctx.Err() == nil {
+		n, err := j.db.PublishProductEvents(ctx, j.batchSize, j.sink.Publish)
+		total += n
+		if err != nil {
+			return total, err
+		}
+
+		if n < j.batchSize {
+			break
+		}
+	}
+
+	if total != 0 {
+		j.logger.Debug("Product events published", zap.Int("events", total))
+	}
+
+	return total, nil
+}
@@ -0,0 +1,17 @@
+// Package requestid carries id of HTTP request through context, so it can be attached to logs of lower layers
+package requestid
+
+import "context"
+
+type contextKey struct{}
+
+// NewContext returns copy of ctx carrying provided request id
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns request id carried by ctx or empty string if there is none
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
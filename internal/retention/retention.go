@@ -0,0 +1,188 @@
+// Package retention implements background job enforcing data retention policies
+package retention
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"mx/internal/storage/postgresql"
+	"time"
+)
+
+// Report defines outcome of single retention run
+type Report struct {
+	DryRun          bool
+	PurgedMerchants []postgresql.StaleMerchant
+	PurgedProducts  int64
+	ArchivedTasks   int64
+	PurgedSandbox   int64
+}
+
+// Engine defines fields used to enforce retention policies
+type Engine struct {
+	logger *zap.Logger
+	db     *postgresql.Storage
+	// productRetention defines period without successful import after which merchant products are deleted,
+	// zero disables the policy
+	productRetention time.Duration
+	// taskRetention defines age after which finished task records are archived, zero disables the policy
+	taskRetention time.Duration
+	// sandboxRetention defines period of sandbox merchant inactivity after which its catalog is purged,
+	// zero disables the policy
+	sandboxRetention time.Duration
+	interval         time.Duration
+	dryRun           bool
+}
+
+// Option type represents function to modify Engine struct
+type Option func(e *Engine)
+
+// WithProductRetention makes Engine delete products of merchants with no successful import during period d
+func WithProductRetention(d time.Duration) Option {
+	return func(e *Engine) {
+		e.productRetention = d
+	}
+}
+
+// WithTaskRetention makes Engine archive finished tasks older than d
+func WithTaskRetention(d time.Duration) Option {
+	return func(e *Engine) {
+		e.taskRetention = d
+	}
+}
+
+// WithSandboxRetention makes Engine purge catalogs of sandbox merchants which had no uploads during period d
+func WithSandboxRetention(d time.Duration) Option {
+	return func(e *Engine) {
+		e.sandboxRetention = d
+	}
+}
+
+// WithInterval applies passed interval as period between retention runs
+func WithInterval(d time.Duration) Option {
+	return func(e *Engine) {
+		e.interval = d
+	}
+}
+
+// WithDryRun makes Engine only report what would be deleted or archived
+func WithDryRun() Option {
+	return func(e *Engine) {
+		e.dryRun = true
+	}
+}
+
+// NewEngine constructs Engine, by default all policies are disabled and runs happen every hour
+func NewEngine(logger *zap.Logger, db *postgresql.Storage, options ...Option) (*Engine, error) {
+	if logger == nil {
+		return nil, errors.New("no logger provided")
+	}
+
+	engine := &Engine{
+		logger:   logger.With(zap.String("component", "retention")),
+		db:       db,
+		interval: time.Hour,
+	}
+
+	for _, opt := range options {
+		opt(engine)
+	}
+
+	if engine.interval <= 0 {
+		return nil, errors.New("retention interval must be positive")
+	}
+
+	return engine, nil
+}
+
+// Run performs retention runs every interval until ctx is done
+func (e *Engine) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := e.RunOnce(ctx)
+		if err != nil {
+			e.logger.Error("Retention run", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce applies all enabled policies once and writes audit log entry for every affected entity
+func (e *Engine) RunOnce(ctx context.Context) (Report, error) {
+	report := Report{DryRun: e.dryRun}
+	now := time.Now()
+
+	if e.productRetention > 0 {
+		merchants, err := e.db.StaleMerchants(ctx, now.Add(-e.productRetention))
+		if err != nil {
+			return report, err
+		}
+
+		for _, m := range merchants {
+			deleted := m.ProductCount
+			if !e.dryRun {
+				deleted, err = e.db.DeleteMerchantProducts(ctx, m.MerchantID)
+				if err != nil {
+					return report, err
+				}
+			}
+
+			e.audit("Merchant products purged",
+				zap.Int64("merchant_id", m.MerchantID),
+				zap.Int64("products", deleted),
+			)
+
+			report.PurgedMerchants = append(report.PurgedMerchants, m)
+			report.PurgedProducts += deleted
+		}
+	}
+
+	if e.taskRetention > 0 {
+		before := now.Add(-e.taskRetention)
+
+		var archived int64
+		var err error
+		if e.dryRun {
+			archived, err = e.db.CountTasksToArchive(ctx, before)
+		} else {
+			archived, err = e.db.ArchiveTasks(ctx, before)
+		}
+		if err != nil {
+			return report, err
+		}
+
+		e.audit("Tasks archived",
+			zap.Time("created_before", before),
+			zap.Int64("tasks", archived),
+		)
+
+		report.ArchivedTasks = archived
+	}
+
+	// sandbox catalogs never contain production data, so dry run simply skips them
+	if e.sandboxRetention > 0 && !e.dryRun {
+		purged, err := e.db.PurgeSandbox(ctx, now.Add(-e.sandboxRetention))
+		if err != nil {
+			return report, err
+		}
+
+		e.audit("Sandbox catalogs purged", zap.Int64("products", purged))
+
+		report.PurgedSandbox = purged
+	}
+
+	return report, nil
+}
+
+// audit writes audit log entry marking whether action was actually performed
+func (e *Engine) audit(msg string, fields ...zap.Field) {
+	fields = append(fields, zap.Bool("audit", true), zap.Bool("dry_run", e.dryRun))
+	e.logger.Info(msg, fields...)
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"mx/internal/task"
+	"net/http"
+	"strings"
+)
+
+// forceAbortRequest defines optional body of /admin/tasks/{id}/abort
+type forceAbortRequest struct {
+	// Reason is written to audit log
+	Reason string `json:"reason" validate:"max=1000"`
+}
+
+// handleAdminTask serves POST /admin/tasks/{id}/abort force aborting Processing task, it is admin endpoint.
+// Every attempt is written to audit log whatever its outcome is.
+func (h *handler) handleAdminTask(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/admin/tasks/"), "/")
+	if len(parts) != 2 || parts[1] != "abort" {
+		http.NotFound(w, r)
+		return
+	}
+	taskID := parts[0]
+
+	if !h.isAdmin(r) {
+		h.log(r).Warn("Task force abort is refused", zap.String("task_id", taskID), zap.String("remote_addr", r.RemoteAddr),
+			zap.Bool("audit", true))
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	var req forceAbortRequest
+	if !h.decodeRequest(w, r, &req, true) {
+		return
+	}
+
+	result, err := h.scheduler.ForceAbortTask(r.Context(), taskID)
+	h.log(r).Warn("Task force abort", zap.String("task_id", taskID), zap.String("reason", req.Reason),
+		zap.String("remote_addr", r.RemoteAddr), zap.Bool("local", result.Local), zap.Bool("aborted", err == nil),
+		zap.NamedError("failure", err), zap.Bool("audit", true))
+	if err != nil {
+		switch {
+		case errors.Is(err, task.ErrBadTaskID):
+			http.Error(w, "Bad task id", http.StatusBadRequest)
+			return
+		case errors.Is(err, task.ErrCanNotAbort):
+			http.Error(w, "Task can not be aborted due to its current state", http.StatusConflict)
+			return
+		default:
+			h.writeStorageError(w, r, err)
+			return
+		}
+	}
+
+	h.writeJSON(w, r, http.StatusOK, result)
+}
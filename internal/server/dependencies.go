@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"mx/internal/storage"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// dependencyTimeout limits time of every check performed by /admin/dependencies
+const dependencyTimeout = 2 * time.Second
+
+// dependencyProbeKey is blob key read by filestore check, the blob is not expected to exist
+const dependencyProbeKey = ".dependency-probe"
+
+// DependencyCheck reports whether external dependency of the service is reachable
+type DependencyCheck func(ctx context.Context) error
+
+// dependency defines named check reported by /admin/dependencies
+type dependency struct {
+	name  string
+	check DependencyCheck
+}
+
+// WithDependency adds check of external dependency, e.g. message broker, to ones reported by /admin/dependencies,
+// database and filestore are always checked
+func WithDependency(name string, check DependencyCheck) ServerOption {
+	return func(p *serverParameters) {
+		p.dependencies = append(p.dependencies, dependency{name: name, check: check})
+	}
+}
+
+// dependencyStatus defines result of single dependency check
+type dependencyStatus struct {
+	Name string `json:"name"`
+	// Status is either up or down
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// dependenciesResponse defines payload of /admin/dependencies, Status is ok if every dependency is up and degraded otherwise
+type dependenciesResponse struct {
+	Status       string             `json:"status"`
+	CheckedAt    time.Time          `json:"checked_at"`
+	Dependencies []dependencyStatus `json:"dependencies"`
+}
+
+// dependencyChecks returns checks of database and filestore followed by configured ones
+func (h *handler) dependencyChecks() []dependency {
+	return append([]dependency{
+		{name: "database", check: h.health.Ping},
+		{name: "filestore", check: blobCheck(h.blobs)},
+	}, h.dependencies...)
+}
+
+// blobCheck reads missing probe blob, so reachable store reports storage.ErrBlobNotFound
+func blobCheck(blobs storage.Blob) DependencyCheck {
+	return func(ctx context.Context) error {
+		rc, err := blobs.Get(ctx, dependencyProbeKey)
+		if err != nil {
+			if errors.Is(err, storage.ErrBlobNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		return rc.Close()
+	}
+}
+
+// checkDependencies serves GET /admin/dependencies reporting status and latency of database, filestore and configured
+// dependencies checked concurrently, it responds with 503 if any of them is down and requires X-Admin-Token header
+func (h *handler) checkDependencies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !h.isAdmin(r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	dependencies := h.dependencyChecks()
+	statuses := make([]dependencyStatus, len(dependencies))
+
+	var wg sync.WaitGroup
+	for i, d := range dependencies {
+		wg.Add(1)
+		go func(i int, d dependency) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(r.Context(), dependencyTimeout)
+			defer cancel()
+
+			started := time.Now()
+			err := d.check(ctx)
+			statuses[i] = dependencyStatus{
+				Name:      d.name,
+				Status:    "up",
+				LatencyMS: float64(time.Since(started).Microseconds()) / 1000,
+			}
+			if err != nil {
+				statuses[i].Status = "down"
+				statuses[i].Error = err.Error()
+			}
+		}(i, d)
+	}
+	wg.Wait()
+
+	response := dependenciesResponse{
+		Status:       "ok",
+		CheckedAt:    time.Now().UTC(),
+		Dependencies: statuses,
+	}
+
+	status := http.StatusOK
+	for _, s := range statuses {
+		if s.Status != "up" {
+			h.log(r).Warn("Dependency is down", zap.String("dependency", s.Name), zap.String("error", s.Error))
+			response.Status = "degraded"
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	h.writeJSON(w, r, status, response)
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"go.uber.org/zap"
+	"mx/internal/task"
+	"mx/internal/upload"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// drainingResponse is sent to uploads refused by draining instance, client is expected to retry on another one
+var drainingResponse = errorResponse{"Instance is draining and accepts no uploads", "DRAINING"}
+
+// drainer stops instance from taking new work while reads are served and accepted tasks are finished
+type drainer struct {
+	logger    *zap.Logger
+	uploads   *upload.Service
+	scheduler *task.Scheduler
+	once      sync.Once
+	// done is closed once draining starts
+	done chan struct{}
+}
+
+func newDrainer(logger *zap.Logger, uploads *upload.Service, scheduler *task.Scheduler) *drainer {
+	return &drainer{
+		logger:    logger,
+		uploads:   uploads,
+		scheduler: scheduler,
+		done:      make(chan struct{}),
+	}
+}
+
+// drain refuses following uploads, stops claiming tasks from shared queue and stops import source pulls,
+// repeated calls have no effect
+func (d *drainer) drain() {
+	d.once.Do(func() {
+		d.logger.Info("Draining instance, uploads are refused")
+		d.uploads.Drain()
+		d.scheduler.Drain()
+		close(d.done)
+	})
+}
+
+func (d *drainer) draining() bool {
+	select {
+	case <-d.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// drainStatus defines payload of /admin/drain
+type drainStatus struct {
+	Draining bool `json:"draining"`
+	// ActiveTasks is number of accepted tasks which are not finished yet, instance can be stopped once it is zero
+	ActiveTasks int64 `json:"active_tasks"`
+}
+
+// handleDrain serves GET /admin/drain reporting whether instance is draining and POST /admin/drain starting drain,
+// which equals to SIGUSR1, it requires X-Admin-Token header
+func (h *handler) handleDrain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !h.isAdmin(r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		h.log(r).Info("Drain is requested", zap.Bool("audit", true))
+		h.drainer.drain()
+	}
+
+	h.writeJSON(w, r, http.StatusOK, drainStatus{
+		Draining:    h.drainer.draining(),
+		ActiveTasks: h.scheduler.ActiveTasks(),
+	})
+}
+
+// refuseDraining responds with 503 to upload received while instance is draining before its body is read,
+// it reports whether the upload is refused
+func (h *handler) refuseDraining(w http.ResponseWriter, r *http.Request) bool {
+	if !h.drainer.draining() {
+		return false
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds))
+	h.writeErrorResponse(w, r, http.StatusServiceUnavailable, drainingResponse)
+	return true
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"errors"
+	"mx/internal/storage/postgresql"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// handleExpiryRule serves GET, PUT and DELETE /expiry/rules?merchant_id=... reading, setting and deleting
+// merchant expiry rule, PUT requires stale_after_days and action parameters
+func (h *handler) handleExpiryRule(w http.ResponseWriter, r *http.Request) {
+	q, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		http.Error(w, "Request query can not be parsed", http.StatusBadRequest)
+		return
+	}
+
+	var rule postgresql.ExpiryRule
+	switch r.Method {
+	case http.MethodGet:
+		merchantID, ok := requireMerchantID(w, q)
+		if !ok {
+			return
+		}
+
+		rule, err = h.db.ReadExpiryRule(r.Context(), merchantID)
+	case http.MethodPut:
+		merchantID, ok := requireMerchantID(w, q)
+		if !ok {
+			return
+		}
+
+		days, parseErr := strconv.ParseInt(q.Get("stale_after_days"), 10, 32)
+		if parseErr != nil || days <= 0 {
+			http.Error(w, "Query value for stale_after_days parameter must be positive integer", http.StatusBadRequest)
+			return
+		}
+
+		action := q.Get("action")
+		if !postgresql.ValidExpiryAction(action) {
+			http.Error(w, "Query value for action parameter must be one of zero-quantity, archive", http.StatusBadRequest)
+			return
+		}
+
+		rule, err = h.db.SetExpiryRule(r.Context(), postgresql.ExpiryRule{MerchantID: merchantID, StaleAfterDays: days, Action: action})
+	case http.MethodDelete:
+		merchantID, ok := requireMerchantID(w, q)
+		if !ok {
+			return
+		}
+
+		err = h.db.DeleteExpiryRule(r.Context(), merchantID)
+		if err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, postgresql.ErrNoExpiryRule):
+			http.Error(w, "Merchant has no expiry rule", http.StatusNotFound)
+			return
+		default:
+			h.writeStorageError(w, r, err)
+			return
+		}
+	}
+
+	h.writeJSON(w, r, http.StatusOK, rule)
+}
+
+// expiredOffers serves GET /expiry/report?merchant_id=... listing offers expired by merchant rule
+// since moment passed in RFC 3339 since parameter or during last 30 days
+func (h *handler) expiredOffers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	q, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		http.Error(w, "Request query can not be parsed", http.StatusBadRequest)
+		return
+	}
+
+	merchantID, ok := requireMerchantID(w, q)
+	if !ok {
+		return
+	}
+
+	since, ok := sinceParameter(w, q, 30*24*time.Hour)
+	if !ok {
+		return
+	}
+
+	offers, err := h.db.ExpiredOffers(r.Context(), merchantID, since)
+	if err != nil {
+		h.writeStorageError(w, r, err)
+		return
+	}
+
+	h.writeJSON(w, r, http.StatusOK, offers)
+}
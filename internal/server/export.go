@@ -0,0 +1,85 @@
+package server
+
+import (
+	"go.uber.org/zap"
+	"io"
+	"mime"
+	"mx/internal/storage/postgresql"
+	"mx/internal/task"
+	"mx/internal/xlsxstream"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// exportColumns defines header row of exported workbook, it is the column layout accepted by /upload
+var exportColumns = []string{"offer_id", "name", "price", "quantity", "available"}
+
+// exportCatalog serves GET /export streaming current products of merchant_id as .xlsx workbook,
+// which can be edited and uploaded back as is
+func (h *handler) exportCatalog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	q, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		http.Error(w, "Request query can not be parsed", http.StatusBadRequest)
+		return
+	}
+
+	merchantID, ok := requireMerchantID(w, q)
+	if !ok {
+		return
+	}
+
+	products, err := h.db.MerchantProducts(r.Context(), merchantID)
+	if err != nil {
+		h.writeStorageError(w, r, err)
+		return
+	}
+
+	fileName := "catalog-" + strconv.FormatInt(merchantID, 10) + ".xlsx"
+	w.Header().Set("Content-Type", fileContentTypes[task.FormatXLSX])
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+	w.WriteHeader(http.StatusOK)
+
+	err = writeCatalogXLSX(w, products)
+	if err != nil {
+		h.log(r).Error("Writing response", zap.Int64("merchant_id", merchantID), zap.Error(err))
+	}
+}
+
+// writeCatalogXLSX writes products as rows of single worksheet following exportColumns header row,
+// every exported product is available
+func writeCatalogXLSX(w io.Writer, products []postgresql.Product) error {
+	xw, err := xlsxstream.NewWriter(w, "Products", xlsxstream.WithDimension(int64(len(products))+1, len(exportColumns)))
+	if err != nil {
+		return err
+	}
+
+	header := make([]xlsxstream.Cell, len(exportColumns))
+	for i, name := range exportColumns {
+		header[i] = xlsxstream.Cell{Value: name, Type: xlsxstream.CellTypeString}
+	}
+	err = xw.WriteRow(header...)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range products {
+		err = xw.WriteRow(
+			xlsxstream.Cell{Value: strconv.FormatInt(p.OfferID, 10), Type: xlsxstream.CellTypeNumeric},
+			xlsxstream.Cell{Value: p.Name, Type: xlsxstream.CellTypeString},
+			xlsxstream.Cell{Value: p.Price.String(), Type: xlsxstream.CellTypeNumeric},
+			xlsxstream.Cell{Value: strconv.FormatInt(p.Quantity, 10), Type: xlsxstream.CellTypeNumeric},
+			xlsxstream.Cell{Value: "1", Type: xlsxstream.CellTypeBool},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return xw.Close()
+}
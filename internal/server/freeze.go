@@ -0,0 +1,74 @@
+package server
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"mx/internal/storage/postgresql"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// freezeRequest defines JSON body of PUT /admin/freezes
+type freezeRequest struct {
+	Reason string `json:"reason" validate:"required,max=1000"`
+}
+
+// handleMerchantFreeze serves GET, PUT and DELETE /admin/freezes?merchant_id=... managing freeze blocking writes
+// to merchant catalog, it is admin endpoint
+func (h *handler) handleMerchantFreeze(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	q, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		http.Error(w, "Request query can not be parsed", http.StatusBadRequest)
+		return
+	}
+
+	merchantID, ok := requireMerchantID(w, q)
+	if !ok {
+		return
+	}
+
+	var freeze postgresql.MerchantFreeze
+	switch r.Method {
+	case http.MethodGet:
+		freeze, err = h.db.ReadMerchantFreeze(r.Context(), merchantID)
+	case http.MethodPut:
+		var req freezeRequest
+		if !h.decodeRequest(w, r, &req, false) {
+			return
+		}
+
+		freeze, err = h.db.FreezeMerchant(r.Context(), merchantID, strings.TrimSpace(req.Reason))
+		h.log(r).Warn("Merchant frozen", zap.Int64("merchant_id", merchantID), zap.String("reason", req.Reason),
+			zap.String("remote_addr", r.RemoteAddr), zap.NamedError("failure", err), zap.Bool("audit", true))
+	case http.MethodDelete:
+		err = h.db.UnfreezeMerchant(r.Context(), merchantID)
+		if err == nil {
+			h.log(r).Warn("Merchant unfrozen", zap.Int64("merchant_id", merchantID), zap.String("remote_addr", r.RemoteAddr),
+				zap.Bool("audit", true))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, postgresql.ErrNotFrozen):
+			http.Error(w, "Merchant is not frozen", http.StatusNotFound)
+			return
+		default:
+			h.writeStorageError(w, r, err)
+			return
+		}
+	}
+
+	h.writeJSON(w, r, http.StatusOK, freeze)
+}
@@ -1,151 +1,415 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
-	"github.com/rs/xid"
+	"fmt"
+	"github.com/jszwec/csvutil"
+	"github.com/shopspring/decimal"
 	"go.uber.org/zap"
 	"io/ioutil"
+	"mime"
+	"mx/internal/dbsource"
+	"mx/internal/logctx"
+	"mx/internal/offboarding"
+	"mx/internal/signedurl"
+	"mx/internal/slo"
+	"mx/internal/storage"
 	"mx/internal/storage/postgresql"
 	"mx/internal/task"
+	"mx/internal/upload"
 	"net"
 	"net/http"
 	"net/url"
-	"os"
-	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type productLister interface {
 	List(context.Context, ...postgresql.ListOption) ([]postgresql.Product, error)
+	ListEach(context.Context, func(postgresql.Product) error, ...postgresql.ListOption) error
+	Count(context.Context, ...postgresql.ListOption) (int64, error)
+	Suggest(ctx context.Context, merchantID int64, prefix string, limit int) ([]string, error)
+	Sample(ctx context.Context, merchantID int64, n int) ([]postgresql.Product, error)
+	Get(ctx context.Context, merchantID, offerID int64) (postgresql.Product, error)
+	CreateProduct(ctx context.Context, p postgresql.Product) (postgresql.Product, error)
+	ProductHistory(ctx context.Context, merchantID, offerID int64, taskID string, beforeID int64, limit int) ([]postgresql.ProductHistoryEntry, error)
+	MerchantProducts(ctx context.Context, merchantID int64) ([]postgresql.Product, error)
+	ListRowCap(...postgresql.ListOption) int64
+	FindDuplicates(ctx context.Context, merchantID int64) ([]postgresql.DuplicateGroup, error)
+	MerchantStats(ctx context.Context, merchantID int64) (postgresql.MerchantStats, error)
+	ListMerchants(ctx context.Context) ([]postgresql.CatalogStats, error)
+	MerchantQuality(ctx context.Context, merchantID int64, from, to time.Time) ([]postgresql.DailyQuality, error)
+	ImportSummaries(ctx context.Context, from, to time.Time) ([]postgresql.ImportSummary, error)
+	ReadExpiryRule(ctx context.Context, merchantID int64) (postgresql.ExpiryRule, error)
+	SetExpiryRule(ctx context.Context, rule postgresql.ExpiryRule) (postgresql.ExpiryRule, error)
+	DeleteExpiryRule(ctx context.Context, merchantID int64) error
+	ExpiredOffers(ctx context.Context, merchantID int64, since time.Time) ([]postgresql.ExpiredOffer, error)
+	MerchantUsage(ctx context.Context, merchantID int64, since time.Time) ([]postgresql.HourlyUsage, error)
+	UsageSummary(ctx context.Context, since time.Time) ([]postgresql.UsageTotal, error)
+	ReadImportSource(ctx context.Context, merchantID int64) (postgresql.ImportSource, error)
+	SetImportSource(ctx context.Context, src postgresql.ImportSource) (postgresql.ImportSource, error)
+	DeleteImportSource(ctx context.Context, merchantID int64) error
+	ReadMerchantFreeze(ctx context.Context, merchantID int64) (postgresql.MerchantFreeze, error)
+	FreezeMerchant(ctx context.Context, merchantID int64, reason string) (postgresql.MerchantFreeze, error)
+	UnfreezeMerchant(ctx context.Context, merchantID int64) error
+}
+
+const (
+	// defaultHistoryLimit defines number of changes returned by /products/history if limit parameter is omitted
+	defaultHistoryLimit = 100
+	// maxHistoryLimit defines maximum value of limit parameter for /products/history
+	maxHistoryLimit = 1000
+	// qualityPeriod defines period covered by /merchants/{id}/quality if from parameter is omitted
+	qualityPeriod = 30 * 24 * time.Hour
+	// importsReportPeriod defines period covered by /reports/imports.csv if from parameter is omitted
+	importsReportPeriod = 7 * 24 * time.Hour
+	// defaultSuggestLimit defines number of names returned by /suggest if limit parameter is omitted
+	defaultSuggestLimit = 10
+	// maxSuggestLimit defines maximum value of limit parameter for /suggest
+	maxSuggestLimit = 100
+	// defaultSampleSize defines number of products returned by /list/sample if n parameter is omitted
+	defaultSampleSize = 100
+	// maxSampleSize defines maximum value of n parameter for /list/sample
+	maxSampleSize = 1000
+	// defaultDiffLimit defines number of changes returned by /tasks/{id}/diff if limit parameter is omitted
+	defaultDiffLimit = 1000
+	// maxDiffLimit defines maximum value of limit parameter for /tasks/{id}/diff
+	maxDiffLimit = 10000
+	// defaultListLimit defines number of products returned by /list if limit parameter is omitted
+	defaultListLimit = 1000
+	// maxListLimit defines maximum value of limit parameter for /list
+	maxListLimit = 10000
+	// defaultTaskListLimit defines number of tasks returned by /tasks/list if limit parameter is omitted
+	defaultTaskListLimit = 100
+	// maxTaskListLimit defines maximum value of limit parameter for /tasks/list
+	maxTaskListLimit = 1000
+	// readinessTimeout limits time of database check performed by readiness probe
+	readinessTimeout = time.Second
+	// retryAfterSeconds defines Retry-After header value of responses sent while database is unavailable
+	retryAfterSeconds = 5
+)
+
+// pinger is implemented by storage which reachability is checked by readiness probe
+type pinger interface {
+	Ping(ctx context.Context) error
 }
 
 type handler struct {
-	logger    *zap.Logger
-	host      net.IP
-	scheduler *task.Scheduler
-	db        productLister
+	logger     *zap.Logger
+	scheduler  *task.Scheduler
+	uploads    *upload.Service
+	downloader *upload.Downloader
+	db         productLister
+	health     pinger
+	quota      *quotaChecker
+	// maxUploadBytes limits size of /upload request body, zero means no limit
+	maxUploadBytes int64
+	// adminToken is expected in X-Admin-Token header of admin endpoints, empty value disables them
+	adminToken string
+	// links signs shared links to task reports and files valid for linkTTL by default, nil disables them
+	links   *signedurl.Signer
+	linkTTL time.Duration
+	// blobs stores uploaded files served by shared links
+	blobs storage.Blob
+	// jsonDefaults defines style of product JSON unless Accept header requests another one
+	jsonDefaults postgresql.JSONStyle
+	// slo tracks objectives of upload and list endpoints
+	slo *slo.Tracker
+	// searchGuard rejects or downgrades expensive name searches of /list
+	searchGuard SearchGuard
+	// offboarding exports and deletes merchant data, nil disables offboarding endpoints
+	offboarding *offboarding.Service
+	// offerIDs allocates offer ids of products created without one
+	offerIDs OfferIDAllocator
+	// products serves products of /list and /products
+	products CatalogStore
+	// dependencies are checked by /admin/dependencies in addition to database and filestore
+	dependencies []dependency
+	// drainer refuses uploads once instance starts draining
+	drainer *drainer
+	// sourceCipher encrypts connection strings of import sources before they are saved, nil saves them as is
+	sourceCipher *dbsource.Cipher
+}
+
+// log returns logger of the request carrying its id
+func (h *handler) log(r *http.Request) *zap.Logger {
+	return logctx.FromContext(r.Context(), h.logger)
 }
 
 func (h *handler) handleUpload(w http.ResponseWriter, r *http.Request) {
-	taskID := xid.New()
-	logger := h.logger.With(zap.String("task_id", taskID.String()))
-	logger.Info("Upload handler invocation")
+	h.log(r).Info("Upload handler invocation")
 
-	q, err := url.ParseQuery(r.URL.RawQuery)
-	if err != nil {
-		http.Error(w, "Request query can not be parsed", http.StatusBadRequest)
+	if h.refuseDraining(w, r) {
 		return
 	}
 
-	merchantIDString := q.Get("merchant_id")
-	if merchantIDString == "" {
-		http.Error(w, "Query value for merchant_id parameter can not be blank", http.StatusBadRequest)
+	req, ok := uploadRequest(w, r)
+	if !ok {
 		return
 	}
 
-	merchantID, err := strconv.ParseInt(merchantIDString, 10, 64)
-	if err != nil {
-		http.Error(w, "Query value for merchant_id parameter must represent integer", http.StatusBadRequest)
+	if h.maxUploadBytes > 0 && r.ContentLength > h.maxUploadBytes {
+		h.writeUploadTooLarge(w, r)
 		return
 	}
 
-	if merchantID <= 0 {
-		http.Error(w, "Query value for merchant_id parameter must be positive integer greater than zero", http.StatusBadRequest)
-		return
-	}
+	// body is streamed into file store, so limit is enforced by the reader rather than by Content-Length
+	body := &uploadBody{r: r.Body, limit: h.maxUploadBytes}
+	r.Body = ioutil.NopCloser(body)
 
-	err = os.MkdirAll(merchantIDString, 0750)
+	file, err := readUploadedFile(r)
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		if body.tooLarge() {
+			h.writeUploadTooLarge(w, r)
+			return
+		}
+
+		h.log(r).Error("Reading uploaded file", zap.Error(err))
+
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	filePath := filepath.Join(merchantIDString, taskID.String()+".xlsx")
-	file, err := os.Create(filePath)
-	if err != nil {
-		h.logger.Error("Creating file", zap.Error(err))
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	h.log(r).Info("File info: ", zap.String("name", file.name), zap.Int64("content_length", r.ContentLength))
+
+	req.FileName = file.name
+	req.ContentType = file.contentType
+	req.Body = file.content
+
+	h.upload(w, r, req)
+}
+
+// handleUploadByURL downloads file located at url query parameter and schedules it like uploaded one
+func (h *handler) handleUploadByURL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 		return
 	}
-	defer file.Close()
-
-	f, fh, err := r.FormFile("workbook")
-	if err != nil {
-		h.logger.Error("Retrieving multipart file", zap.Error(err))
 
-		w.WriteHeader(http.StatusBadRequest)
+	if h.refuseDraining(w, r) {
 		return
 	}
-	defer f.Close()
 
-	logger.Info("File info: ", zap.String("name", fh.Filename), zap.Int64("size", fh.Size))
+	req, ok := uploadRequest(w, r)
+	if !ok {
+		return
+	}
 
-	data, err := ioutil.ReadAll(f)
-	if err != nil {
-		h.logger.Error("Reading file data", zap.Error(err))
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	remoteURL := r.URL.Query().Get("url")
+	if remoteURL == "" {
+		http.Error(w, "Query value for url parameter can not be blank", http.StatusBadRequest)
 		return
 	}
 
-	_, err = file.Write(data)
+	file, err := h.downloader.Download(r.Context(), remoteURL)
 	if err != nil {
-		h.logger.Error("Writing file data on disk", zap.Error(err))
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		var validationErr *upload.ValidationError
+		var remoteErr *upload.RemoteError
+		switch {
+		case errors.As(err, &validationErr):
+			http.Error(w, "Upload is invalid: "+validationErr.Error(), http.StatusBadRequest)
+		case errors.As(err, &remoteErr):
+			h.log(r).Warn("Downloading remote file", zap.String("url", remoteURL), zap.Error(err))
+			http.Error(w, "Remote file can not be downloaded: "+remoteErr.Error(), http.StatusBadGateway)
+		default:
+			h.log(r).Error("Downloading remote file", zap.String("url", remoteURL), zap.Error(err))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
 		return
 	}
 
-	h.scheduler.NewTask(taskID, merchantID, filePath)
+	h.log(r).Info("Remote file info: ", zap.String("url", remoteURL), zap.String("name", file.Name), zap.Int("size", len(file.Data)))
 
-	var locationHost string
-	dnsNames, err := net.LookupAddr(h.host.String())
-	if err != nil {
-		h.logger.Warn("Can not lookup DNS name", zap.String("IP address", h.host.String()))
-		locationHost = h.host.String()
-	} else {
-		locationHost = dnsNames[0]
+	req.FileName = file.Name
+	req.Body = bytes.NewReader(file.Data)
+	if req.Format == "" {
+		req.Format = file.Format
 	}
 
-	location := net.JoinHostPort(locationHost, "8080")
-
-	w.Header().Set("Location", "http://"+location+"/tasks?id="+taskID.String())
-	w.WriteHeader(http.StatusOK)
-	return
+	h.upload(w, r, req)
 }
 
-func (h *handler) handleTaskStatus(w http.ResponseWriter, r *http.Request) {
+// uploadRequest reads upload parameters common for all upload endpoints from request query and headers.
+// If parameters are invalid error response is written and false is returned.
+func uploadRequest(w http.ResponseWriter, r *http.Request) (upload.Request, bool) {
 	q, err := url.ParseQuery(r.URL.RawQuery)
 	if err != nil {
 		http.Error(w, "Request query can not be parsed", http.StatusBadRequest)
-		return
+		return upload.Request{}, false
 	}
 
-	taskID := q.Get("id")
-	if taskID == "" {
-		http.Error(w, "Query value for id parameter can not be blank", http.StatusBadRequest)
-		return
+	merchantIDString := q.Get("merchant_id")
+	if merchantIDString == "" {
+		http.Error(w, "Query value for merchant_id parameter can not be blank", http.StatusBadRequest)
+		return upload.Request{}, false
+	}
+
+	merchantID, err := strconv.ParseInt(merchantIDString, 10, 64)
+	if err != nil {
+		http.Error(w, "Query value for merchant_id parameter must represent integer", http.StatusBadRequest)
+		return upload.Request{}, false
+	}
+
+	req := upload.Request{
+		MerchantID:     merchantID,
+		IdempotencyKey: r.Header.Get("Idempotency-Key"),
+		Format:         q.Get("format"),
+		Mode:           q.Get("mode"),
+		Duplicates:     q.Get("duplicates"),
+		Currency:       strings.ToUpper(q.Get("currency")),
+		Labels:         q["label"],
+	}
+
+	deadline := r.Header.Get("X-Process-Deadline")
+	if deadline != "" {
+		req.Deadline, err = time.Parse(time.RFC3339, deadline)
+		if err != nil {
+			http.Error(w, "Header value for X-Process-Deadline must be RFC 3339 time like 2020-01-02T15:04:05Z", http.StatusBadRequest)
+			return upload.Request{}, false
+		}
+	}
+
+	delimiterValues, ok := q["delimiter"]
+	if ok {
+		delimiter := []rune(delimiterValues[0])
+		if len(delimiter) != 1 {
+			http.Error(w, "Query value for delimiter parameter must be single character", http.StatusBadRequest)
+			return upload.Request{}, false
+		}
+		req.Delimiter = delimiter[0]
+	}
+
+	headerValues, ok := q["header"]
+	if ok {
+		header, err := strconv.ParseBool(headerValues[0])
+		if err != nil {
+			http.Error(w, "Query value for header parameter must be either true or false", http.StatusBadRequest)
+			return upload.Request{}, false
+		}
+		req.Header = &header
+	}
+
+	mappingValues, ok := q["mapping"]
+	if ok {
+		err = json.Unmarshal([]byte(mappingValues[0]), &req.Mapping)
+		if err != nil {
+			http.Error(w, `Query value for mapping parameter must be JSON object like {"offer_id":"A","price":"D"}`, http.StatusBadRequest)
+			return upload.Request{}, false
+		}
+	}
+
+	updateValues, ok := q["update"]
+	if ok {
+		if updateValues[0] == "" {
+			http.Error(w, "Query value for update parameter can not be blank", http.StatusBadRequest)
+			return upload.Request{}, false
+		}
+		req.UpdateColumns = strings.Split(updateValues[0], ",")
+	}
+
+	req.Sheet = q.Get("sheet")
+	allSheetsValues, ok := q["all_sheets"]
+	if ok {
+		req.AllSheets, err = strconv.ParseBool(allSheetsValues[0])
+		if err != nil {
+			http.Error(w, "Query value for all_sheets parameter must be either true or false", http.StatusBadRequest)
+			return upload.Request{}, false
+		}
 	}
 
-	taskStatus, err := h.scheduler.ReadTaskStatus(taskID)
+	return req, true
+}
+
+// upload passes request to upload service and writes response with Location of created task
+func (h *handler) upload(w http.ResponseWriter, r *http.Request, req upload.Request) {
+	result, err := h.uploads.Upload(r.Context(), req)
 	if err != nil {
+		var validationErr *upload.ValidationError
+		var contentErr *upload.ContentError
 		switch {
-		case errors.Is(err, task.ErrBadTaskID):
-			http.Error(w, "Bad task id", http.StatusBadRequest)
-			return
+		case errors.As(err, &validationErr):
+			http.Error(w, "Upload is invalid: "+validationErr.Error(), http.StatusBadRequest)
+		case errors.As(err, &contentErr):
+			h.log(r).Info("Rejecting upload content", zap.String("content_type", req.ContentType), zap.Error(err))
+			http.Error(w, "Upload content is invalid: "+contentErr.Error(), http.StatusUnprocessableEntity)
+		case errors.Is(err, upload.ErrQuotaExhausted):
+			http.Error(w, "Daily upload quota is exhausted", http.StatusTooManyRequests)
+		case errors.Is(err, upload.ErrDraining):
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds))
+			h.writeErrorResponse(w, r, http.StatusServiceUnavailable, drainingResponse)
+		case errors.Is(err, postgresql.ErrMerchantFrozen):
+			h.writeStorageError(w, r, err)
+		case errors.Is(err, errUploadTooLarge):
+			h.writeUploadTooLarge(w, r)
 		default:
-			h.logger.Error("Reading task status", zap.Error(err))
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			return
 		}
+		return
+	}
+
+	w.Header().Set("Location", result.Location)
+	w.WriteHeader(http.StatusOK)
+}
+
+// taskLocation returns function building absolute URL of task status served by current host
+// with provided scheme on port of bound listener
+func taskLocation(logger *zap.Logger, host net.IP, scheme string, port *listenerPort) func(taskID task.TaskID) string {
+	return func(taskID task.TaskID) string {
+		var locationHost string
+		dnsNames, err := net.LookupAddr(host.String())
+		if err != nil {
+			logger.Warn("Can not lookup DNS name", zap.String("IP address", host.String()))
+			locationHost = host.String()
+		} else {
+			locationHost = dnsNames[0]
+		}
+
+		location := net.JoinHostPort(locationHost, strconv.Itoa(port.get()))
+
+		return scheme + "://" + location + "/tasks?id=" + taskID.String()
+	}
+}
+
+// listResponse defines JSON page of /list with number of all products matching filters
+type listResponse struct {
+	Items []postgresql.StyledProduct `json:"items"`
+	Total int64                      `json:"total"`
+	// Limit is maximum number of items of the page, zero means page is not limited
+	Limit  int64 `json:"limit"`
+	Offset int64 `json:"offset"`
+	// Truncated reports whether requested limit is lowered to RowCap of storage,
+	// RowCap is omitted if storage does not cap rows
+	Truncated bool  `json:"truncated"`
+	RowCap    int64 `json:"row_cap,omitempty"`
+}
+
+// priceBound reads optional non-negative decimal price parameter of /list.
+// If its value is invalid error response is written and false is returned.
+func priceBound(w http.ResponseWriter, q url.Values, name string) (*decimal.Decimal, bool) {
+	values, ok := q[name]
+	if !ok {
+		return nil, true
 	}
 
-	_, err = w.Write([]byte(taskStatus))
+	price, err := decimal.NewFromString(values[0])
 	if err != nil {
-		h.logger.Error("Writing response", zap.Error(err))
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		http.Error(w, "Query value for "+name+" parameter must represent decimal number", http.StatusBadRequest)
+		return nil, false
+	}
+
+	if price.IsNegative() {
+		http.Error(w, "Query value for "+name+" parameter must not be negative", http.StatusBadRequest)
+		return nil, false
 	}
-	return
+
+	return &price, true
 }
 
 func (h *handler) listProducts(w http.ResponseWriter, r *http.Request) {
@@ -200,24 +464,896 @@ func (h *handler) listProducts(w http.ResponseWriter, r *http.Request) {
 		listOpts = append(listOpts, postgresql.WithNameQuery(nameQuery))
 	}
 
-	products, err := h.db.List(r.Context(), listOpts...)
+	match := q.Get("match")
+	switch match {
+	case "", postgresql.NameMatchPrefix, postgresql.NameMatchContains, postgresql.NameMatchFuzzy:
+	default:
+		http.Error(w, "Query value for match parameter must be either prefix, contains or fuzzy", http.StatusBadRequest)
+		return
+	}
+
+	_, merchantSet := q["merchant_id"]
+	guardedMatch, guidance := h.searchGuard.check(match, q.Get("name"), merchantSet)
+	if guidance != "" {
+		h.log(r).Info("Expensive search is rejected", zap.String("match", match), zap.String("name", q.Get("name")))
+		h.writeErrorResponse(w, r, http.StatusUnprocessableEntity, errorResponse{guidance, "QUERY_TOO_EXPENSIVE"})
+		return
+	}
+	if guardedMatch != match {
+		w.Header().Set("X-Search-Downgraded", guardedMatch)
+	}
+	listOpts = append(listOpts, postgresql.WithNameMatch(guardedMatch))
+
+	priceMin, ok := priceBound(w, q, "price_min")
+	if !ok {
+		return
+	}
+
+	priceMax, ok := priceBound(w, q, "price_max")
+	if !ok {
+		return
+	}
+
+	if priceMin != nil && priceMax != nil && priceMin.GreaterThan(*priceMax) {
+		http.Error(w, "Query value for price_min parameter must not be greater than price_max", http.StatusBadRequest)
+		return
+	}
+
+	if priceMin != nil || priceMax != nil {
+		listOpts = append(listOpts, postgresql.WithPriceRange(priceMin, priceMax))
+	}
+
+	var minQuantity int64
+	minQuantityValues, ok := q["min_quantity"]
+	if ok {
+		minQuantity, err = strconv.ParseInt(minQuantityValues[0], 10, 32)
+		if err != nil {
+			http.Error(w, "Query value for min_quantity parameter must represent integer", http.StatusBadRequest)
+			return
+		}
+
+		if minQuantity < 0 {
+			http.Error(w, "Query value for min_quantity parameter must not be negative", http.StatusBadRequest)
+			return
+		}
+	}
+
+	inStockValues, ok := q["in_stock"]
+	if ok {
+		inStock, err := strconv.ParseBool(inStockValues[0])
+		if err != nil {
+			http.Error(w, "Query value for in_stock parameter must be either true or false", http.StatusBadRequest)
+			return
+		}
+
+		// in_stock=true is shorthand for min_quantity=1 and does not loosen greater min_quantity
+		if inStock && minQuantity < 1 {
+			minQuantity = 1
+		}
+	}
+
+	if minQuantity > 0 {
+		listOpts = append(listOpts, postgresql.WithMinQuantity(minQuantity))
+	}
+
+	availableValues, ok := q["available"]
+	if ok {
+		availability := postgresql.AvailabilityAny
+		if availableValues[0] != "any" {
+			available, err := strconv.ParseBool(availableValues[0])
+			if err != nil {
+				http.Error(w, "Query value for available parameter must be either true, false or any", http.StatusBadRequest)
+				return
+			}
+
+			availability = postgresql.AvailabilityAvailable
+			if !available {
+				availability = postgresql.AvailabilityUnavailable
+			}
+		}
+		listOpts = append(listOpts, postgresql.WithAvailability(availability))
+	}
+
+	// CSV export streams every matching product unless limit is set explicitly
+	var limit int64
+	if !wantsCSV(r, q) {
+		limit = defaultListLimit
+	}
+	limitValues, ok := q["limit"]
+	if ok {
+		limit, err = strconv.ParseInt(limitValues[0], 10, 64)
+		if err != nil {
+			http.Error(w, "Query value for limit parameter must represent integer", http.StatusBadRequest)
+			return
+		}
+
+		if limit <= 0 || limit > maxListLimit {
+			http.Error(w, "Query value for limit parameter must be between 1 and "+strconv.Itoa(maxListLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var offset int64
+	offsetValues, ok := q["offset"]
+	if ok {
+		offset, err = strconv.ParseInt(offsetValues[0], 10, 64)
+		if err != nil {
+			http.Error(w, "Query value for offset parameter must represent integer", http.StatusBadRequest)
+			return
+		}
+
+		if offset < 0 {
+			http.Error(w, "Query value for offset parameter must not be negative", http.StatusBadRequest)
+			return
+		}
+	}
+
+	cursorValues, ok := q["cursor"]
+	if ok {
+		if offset > 0 {
+			http.Error(w, "Query parameters cursor and offset can not be used together", http.StatusBadRequest)
+			return
+		}
+
+		merchantID, offerID, err := decodeListCursor(cursorValues[0])
+		if err != nil {
+			http.Error(w, "Query value for cursor parameter must be next_cursor of previous page", http.StatusBadRequest)
+			return
+		}
+
+		listOpts = append(listOpts, postgresql.WithAfter(merchantID, offerID))
+	}
+
+	// storage caps rows of single query, so larger page is truncated to the cap
+	var truncated bool
+	rowCap := h.db.ListRowCap(listOpts...)
+	if rowCap > 0 && (limit == 0 || limit > rowCap) {
+		limit = rowCap
+		truncated = true
+	}
+
+	if limit > 0 {
+		// one more product is read to find out whether there is next page
+		listOpts = append(listOpts, postgresql.WithLimit(limit+1))
+	}
+	listOpts = append(listOpts, postgresql.WithOffset(offset))
+
+	page := listPage{limit: limit, offset: offset, byCursor: len(cursorValues) > 0, truncated: truncated, rowCap: rowCap}
+	page.setTruncationHeaders(w.Header())
+	if wantsCSV(r, q) {
+		h.streamProductsCSV(w, r, listOpts, page)
+		return
+	}
+
+	style, ok := h.jsonStyle(w, r)
+	if !ok {
+		return
+	}
+
+	products, err := h.products.List(r.Context(), listOpts...)
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		h.writeStorageError(w, r, err)
+		return
+	}
+
+	if limit > 0 && int64(len(products)) > limit {
+		products = products[:limit]
+		page.setNextHeaders(w.Header(), products[len(products)-1])
+	}
+
+	total, err := h.products.Count(r.Context(), listOpts...)
+	if err != nil {
+		h.writeStorageError(w, r, err)
 		return
 	}
 
-	payload, err := json.Marshal(products)
+	h.writeJSON(w, r, http.StatusOK, listResponse{
+		Items:     postgresql.StyleProducts(products, style),
+		Total:     total,
+		Limit:     limit,
+		Offset:    offset,
+		Truncated: page.truncated,
+		RowCap:    page.rowCap,
+	})
+}
+
+func (h *handler) suggestNames(w http.ResponseWriter, r *http.Request) {
+	q, err := url.ParseQuery(r.URL.RawQuery)
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		http.Error(w, "Request query can not be parsed", http.StatusBadRequest)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_, err = w.Write(payload)
+	merchantID, ok := requireMerchantID(w, q)
+	if !ok {
+		return
+	}
+
+	prefix := q.Get("prefix")
+	if prefix == "" {
+		http.Error(w, "Query value for prefix parameter can not be blank", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultSuggestLimit
+	limitValues, ok := q["limit"]
+	if ok {
+		limit, err = strconv.Atoi(limitValues[0])
+		if err != nil {
+			http.Error(w, "Query value for limit parameter must represent integer", http.StatusBadRequest)
+			return
+		}
+
+		if limit <= 0 || limit > maxSuggestLimit {
+			http.Error(w, "Query value for limit parameter must be between 1 and "+strconv.Itoa(maxSuggestLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	names, err := h.db.Suggest(r.Context(), merchantID, prefix, limit)
 	if err != nil {
-		h.logger.Error("Writing response", zap.Error(err))
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		h.writeStorageError(w, r, err)
+		return
+	}
+
+	h.writeJSON(w, r, http.StatusOK, names)
+}
+
+func (h *handler) sampleProducts(w http.ResponseWriter, r *http.Request) {
+	q, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		http.Error(w, "Request query can not be parsed", http.StatusBadRequest)
+		return
+	}
+
+	merchantID, ok := requireMerchantID(w, q)
+	if !ok {
+		return
+	}
+
+	n := defaultSampleSize
+	nValues, ok := q["n"]
+	if ok {
+		n, err = strconv.Atoi(nValues[0])
+		if err != nil {
+			http.Error(w, "Query value for n parameter must represent integer", http.StatusBadRequest)
+			return
+		}
+
+		if n <= 0 || n > maxSampleSize {
+			http.Error(w, "Query value for n parameter must be between 1 and "+strconv.Itoa(maxSampleSize), http.StatusBadRequest)
+			return
+		}
+	}
+
+	style, ok := h.jsonStyle(w, r)
+	if !ok {
+		return
+	}
+
+	products, err := h.db.Sample(r.Context(), merchantID, n)
+	if err != nil {
+		h.writeStorageError(w, r, err)
+		return
+	}
+
+	h.writeJSON(w, r, http.StatusOK, postgresql.StyleProducts(products, style))
+}
+
+// getProduct serves GET /products returning single product of merchant_id with offer_id
+func (h *handler) getProduct(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	q, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		http.Error(w, "Request query can not be parsed", http.StatusBadRequest)
+		return
+	}
+
+	merchantID, ok := requireMerchantID(w, q)
+	if !ok {
+		return
+	}
+
+	offerID, ok := requireOfferID(w, q)
+	if !ok {
+		return
+	}
+
+	style, ok := h.jsonStyle(w, r)
+	if !ok {
+		return
+	}
+
+	product, err := h.products.Get(r.Context(), merchantID, offerID)
+	if err != nil {
+		switch {
+		case errors.Is(err, postgresql.ErrProductNotFound):
+			http.Error(w, "Merchant has no product with such offer_id", http.StatusNotFound)
+			return
+		default:
+			h.writeStorageError(w, r, err)
+			return
+		}
+	}
+
+	h.writeJSON(w, r, http.StatusOK, postgresql.StyledProduct{Product: product, Style: style})
+}
+
+// productsByMethod routes POST /products to createProduct and other requests to get
+func (h *handler) productsByMethod(get http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			h.createProduct(w, r)
+			return
+		}
+
+		get.ServeHTTP(w, r)
+	})
+}
+
+// productRequest defines JSON body of POST /products, omitted offer_id is allocated by the server
+type productRequest struct {
+	OfferID  *int64          `json:"offer_id" validate:"positive"`
+	Name     string          `json:"name" validate:"required"`
+	Price    decimal.Decimal `json:"price" validate:"positive"`
+	Quantity int64           `json:"quantity" validate:"positive"`
+}
+
+// createProduct serves POST /products?merchant_id=... creating available product described by JSON body,
+// so merchants without ERP export may manage catalog via API. Created product is returned with its offer id.
+func (h *handler) createProduct(w http.ResponseWriter, r *http.Request) {
+	q, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		http.Error(w, "Request query can not be parsed", http.StatusBadRequest)
+		return
+	}
+
+	merchantID, ok := requireMerchantID(w, q)
+	if !ok {
+		return
+	}
+
+	style, ok := h.jsonStyle(w, r)
+	if !ok {
+		return
+	}
+
+	var req productRequest
+	if !h.decodeRequest(w, r, &req, false) {
+		return
+	}
+
+	// names and prices policies are configured at runtime, so they are checked apart from validate tags
+	name := strings.TrimSpace(req.Name)
+	err = h.scheduler.ValidateName(name)
+	if err != nil {
+		h.writeValidationError(w, r, fieldError{"name", "is invalid: " + err.Error()})
+		return
+	}
+
+	price, err := h.scheduler.NormalizePrice(req.Price)
+	if err != nil {
+		h.writeValidationError(w, r, fieldError{"price", "is invalid: " + err.Error()})
+		return
+	}
+
+	product := postgresql.Product{
+		MerchantID: merchantID,
+		Name:       name,
+		Price:      price,
+		Quantity:   req.Quantity,
+		Available:  true,
+	}
+	if req.OfferID != nil {
+		product.OfferID = *req.OfferID
+	} else {
+		product.OfferID, err = h.offerIDs.AllocateOfferID(r.Context(), merchantID)
+		if err != nil {
+			h.writeStorageError(w, r, err)
+			return
+		}
+	}
+
+	product, err = h.db.CreateProduct(r.Context(), product)
+	if err != nil {
+		var limitErr *postgresql.CatalogLimitError
+		switch {
+		case errors.Is(err, postgresql.ErrProductExists):
+			h.writeErrorResponse(w, r, http.StatusConflict, errorResponse{"Merchant already has product with such offer_id", "PRODUCT_EXISTS"})
+			return
+		case errors.As(err, &limitErr):
+			h.writeErrorResponse(w, r, http.StatusUnprocessableEntity, errorResponse{limitErr.Error(), "CATALOG_LIMIT_EXCEEDED"})
+			return
+		default:
+			h.writeStorageError(w, r, err)
+			return
+		}
+	}
+
+	h.log(r).Info("Product created", zap.Int64("merchant_id", merchantID), zap.Int64("offer_id", product.OfferID),
+		zap.Bool("allocated", req.OfferID == nil))
+
+	w.Header().Set("Location", "/products?merchant_id="+strconv.FormatInt(merchantID, 10)+"&offer_id="+strconv.FormatInt(product.OfferID, 10))
+	h.writeJSON(w, r, http.StatusCreated, postgresql.StyledProduct{Product: product, Style: style})
+}
+
+// productHistory serves GET /products/history?merchant_id=...&offer_id=... listing changes of the offer made by imports,
+// most recent first, optional task_id limits them to changes of the task and before pages them by change id
+func (h *handler) productHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	q, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		http.Error(w, "Request query can not be parsed", http.StatusBadRequest)
+		return
+	}
+
+	merchantID, ok := requireMerchantID(w, q)
+	if !ok {
+		return
+	}
+
+	offerID, ok := requireOfferID(w, q)
+	if !ok {
+		return
+	}
+
+	var before int64
+	beforeValues, ok := q["before"]
+	if ok {
+		before, err = strconv.ParseInt(beforeValues[0], 10, 64)
+		if err != nil || before <= 0 {
+			http.Error(w, "Query value for before parameter must represent positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit := defaultHistoryLimit
+	limitValues, ok := q["limit"]
+	if ok {
+		limit, err = strconv.Atoi(limitValues[0])
+		if err != nil {
+			http.Error(w, "Query value for limit parameter must represent integer", http.StatusBadRequest)
+			return
+		}
+
+		if limit <= 0 || limit > maxHistoryLimit {
+			http.Error(w, "Query value for limit parameter must be between 1 and "+strconv.Itoa(maxHistoryLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	history, err := h.db.ProductHistory(r.Context(), merchantID, offerID, q.Get("task_id"), before, limit)
+	if err != nil {
+		h.writeStorageError(w, r, err)
+		return
+	}
+
+	h.writeJSON(w, r, http.StatusOK, history)
+}
+
+func (h *handler) findDuplicates(w http.ResponseWriter, r *http.Request) {
+	q, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		http.Error(w, "Request query can not be parsed", http.StatusBadRequest)
+		return
+	}
+
+	merchantID, ok := requireMerchantID(w, q)
+	if !ok {
+		return
+	}
+
+	groups, err := h.db.FindDuplicates(r.Context(), merchantID)
+	if err != nil {
+		h.writeStorageError(w, r, err)
+		return
+	}
+
+	h.writeJSON(w, r, http.StatusOK, groups)
+}
+
+// isAdmin reports whether request carries configured admin token
+func (h *handler) isAdmin(r *http.Request) bool {
+	if h.adminToken == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Admin-Token")), []byte(h.adminToken)) == 1
+}
+
+// liveness reports process is running regardless of its dependencies
+func (h *handler) liveness(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// readiness reports whether instance can serve requests, i.e. database is reachable
+func (h *handler) readiness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	err := h.health.Ping(ctx)
+	if err != nil {
+		h.log(r).Warn("Readiness check failed", zap.Error(err))
+		http.Error(w, "Database is unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// errorResponse defines machine-readable error payload
+type errorResponse struct {
+	Error     string `json:"error"`
+	ErrorCode string `json:"error_code"`
+}
+
+// storageErrorResponses defines responses to storage errors of known kinds, retryable ones are sent with Retry-After
+var storageErrorResponses = []struct {
+	kind      error
+	status    int
+	retryable bool
+	response  errorResponse
+}{
+	{postgresql.ErrUnavailable, http.StatusServiceUnavailable, true, errorResponse{"Database is temporarily unavailable", "DATABASE_UNAVAILABLE"}},
+	{postgresql.ErrConflict, http.StatusConflict, true, errorResponse{"Request conflicted with concurrent operation", "CONFLICT"}},
+	{postgresql.ErrTooLarge, http.StatusRequestEntityTooLarge, false, errorResponse{"Request value exceeds database limits", "TOO_LARGE"}},
+	{postgresql.ErrConstraintViolation, http.StatusUnprocessableEntity, false, errorResponse{"Request violates data constraints", "CONSTRAINT_VIOLATION"}},
+	{postgresql.ErrMerchantFrozen, http.StatusLocked, false, errorResponse{"Merchant catalog is frozen", "MERCHANT_FROZEN"}},
+}
+
+// writeStorageError responds with structured error if storage error is of known kind and with 500 otherwise
+func (h *handler) writeStorageError(w http.ResponseWriter, r *http.Request, err error) {
+	for _, e := range storageErrorResponses {
+		if !errors.Is(err, e.kind) {
+			continue
+		}
+
+		h.log(r).Warn("Storage error", zap.String("error_code", e.response.ErrorCode), zap.Error(err))
+
+		if e.retryable {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds))
+		}
+		h.writeErrorResponse(w, r, e.status, e.response)
+		return
+	}
+
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// writeErrorResponse responds with provided status and JSON error payload
+func (h *handler) writeErrorResponse(w http.ResponseWriter, r *http.Request, status int, response errorResponse) {
+	h.writeJSON(w, r, status, response)
+}
+
+// writeJSON responds with provided status and v encoded as JSON, headers have to be set before it is called
+func (h *handler) writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		h.log(r).Error("Encoding response", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(payload)
+	if err != nil {
+		h.log(r).Error("Writing response", zap.Error(err))
+	}
+}
+
+// writeUploadTooLarge responds with 413 to upload exceeding maxUploadBytes
+func (h *handler) writeUploadTooLarge(w http.ResponseWriter, r *http.Request) {
+	h.log(r).Info("Upload exceeds size limit", zap.Int64("limit", h.maxUploadBytes), zap.Int64("content_length", r.ContentLength))
+
+	// the rest of the body is not read, so connection can not be reused
+	w.Header().Set("Connection", "close")
+	h.writeErrorResponse(w, r, http.StatusRequestEntityTooLarge, errorResponse{
+		Error:     "Upload exceeds maximum size of " + strconv.FormatInt(h.maxUploadBytes, 10) + " bytes",
+		ErrorCode: "UPLOAD_TOO_LARGE",
+	})
+}
+
+// requireMerchantID parses mandatory merchant_id query parameter.
+// If parameter is invalid error response is written and false is returned.
+func requireMerchantID(w http.ResponseWriter, q url.Values) (int64, bool) {
+	merchantIDString := q.Get("merchant_id")
+	if merchantIDString == "" {
+		http.Error(w, "Query value for merchant_id parameter can not be blank", http.StatusBadRequest)
+		return 0, false
+	}
+
+	merchantID, err := strconv.ParseInt(merchantIDString, 10, 64)
+	if err != nil {
+		http.Error(w, "Query value for merchant_id parameter must represent integer", http.StatusBadRequest)
+		return 0, false
+	}
+
+	if merchantID <= 0 {
+		http.Error(w, "Query value for merchant_id parameter must be positive integer greater than zero", http.StatusBadRequest)
+		return 0, false
+	}
+
+	return merchantID, true
+}
+
+// requireOfferID parses mandatory offer_id query parameter.
+// If parameter is invalid error response is written and false is returned.
+func requireOfferID(w http.ResponseWriter, q url.Values) (int64, bool) {
+	offerIDString := q.Get("offer_id")
+	if offerIDString == "" {
+		http.Error(w, "Query value for offer_id parameter can not be blank", http.StatusBadRequest)
+		return 0, false
+	}
+
+	offerID, err := strconv.ParseInt(offerIDString, 10, 64)
+	if err != nil {
+		http.Error(w, "Query value for offer_id parameter must represent integer", http.StatusBadRequest)
+		return 0, false
+	}
+
+	if offerID <= 0 {
+		http.Error(w, "Query value for offer_id parameter must be positive integer greater than zero", http.StatusBadRequest)
+		return 0, false
+	}
+
+	return offerID, true
+}
+
+// sinceParameter parses optional since query parameter as RFC 3339 timestamp, defaulting to moment period ago.
+// If parameter is invalid error response is written and false is returned.
+func sinceParameter(w http.ResponseWriter, q url.Values, period time.Duration) (time.Time, bool) {
+	sinceString := q.Get("since")
+	if sinceString == "" {
+		return time.Now().Add(-period), true
+	}
+
+	since, err := time.Parse(time.RFC3339, sinceString)
+	if err != nil {
+		http.Error(w, "Query value for since parameter must be RFC 3339 timestamp", http.StatusBadRequest)
+		return time.Time{}, false
+	}
+
+	return since, true
+}
+
+// encodeListCursor returns opaque token of /list page following provided product
+func encodeListCursor(p postgresql.Product) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(p.MerchantID, 10) + ":" + strconv.FormatInt(p.OfferID, 10)))
+}
+
+// decodeListCursor returns merchant_id and offer_id of the last product of previous /list page
+func decodeListCursor(cursor string) (int64, int64, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	parts := strings.Split(string(b), ":")
+	if len(parts) != 2 {
+		return 0, 0, errors.New("cursor must contain merchant and offer ids")
+	}
+
+	merchantID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	offerID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return merchantID, offerID, nil
+}
+
+// wantsCSV reports whether client asked for CSV representation either via format query parameter or Accept header
+func wantsCSV(r *http.Request, q url.Values) bool {
+	if q.Get("format") == "csv" {
+		return true
+	}
+
+	return strings.Contains(r.Header.Get("Accept"), "text/csv")
+}
+
+// ParseJSONStyle returns style of product JSON with naming either snake_case or camelCase
+// and prices either string or number
+func ParseJSONStyle(naming, prices string) (postgresql.JSONStyle, error) {
+	var style postgresql.JSONStyle
+	switch naming {
+	case "snake_case":
+	case "camelCase":
+		style.CamelCase = true
+	default:
+		return postgresql.JSONStyle{}, fmt.Errorf("naming must be either snake_case or camelCase, got %q", naming)
+	}
+
+	switch prices {
+	case "string":
+	case "number":
+		style.NumericPrices = true
+	default:
+		return postgresql.JSONStyle{}, fmt.Errorf("prices must be either string or number, got %q", prices)
+	}
+
+	return style, nil
+}
+
+// jsonStyle returns style of product JSON requested by naming and prices parameters of application/json media range
+// in Accept header, e.g. "application/json; naming=camelCase; prices=number", omitted parameters keep server defaults
+func (h *handler) jsonStyle(w http.ResponseWriter, r *http.Request) (postgresql.JSONStyle, bool) {
+	naming, prices := "snake_case", "string"
+	if h.jsonDefaults.CamelCase {
+		naming = "camelCase"
+	}
+	if h.jsonDefaults.NumericPrices {
+		prices = "number"
+	}
+
+	for _, mediaRange := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(mediaRange)
+		if err != nil || mediaType != "application/json" {
+			continue
+		}
+
+		if v, ok := params["naming"]; ok {
+			naming = v
+		}
+		if v, ok := params["prices"]; ok {
+			prices = v
+		}
+		break
+	}
+
+	style, err := ParseJSONStyle(naming, prices)
+	if err != nil {
+		http.Error(w, "Accept header parameter "+err.Error(), http.StatusNotAcceptable)
+		return postgresql.JSONStyle{}, false
+	}
+
+	return style, true
+}
+
+// writeReportCSV writes rejected rows as CSV document with header row
+func (h *handler) writeReportCSV(w http.ResponseWriter, r *http.Request, report []postgresql.RejectedRow) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.WriteHeader(http.StatusOK)
+
+	csvWriter := csv.NewWriter(w)
+	enc := csvutil.NewEncoder(csvWriter)
+
+	err := enc.EncodeHeader(postgresql.RejectedRow{})
+	if err != nil {
+		h.log(r).Error("Writing CSV header", zap.Error(err))
+		return
+	}
+
+	for _, row := range report {
+		err = enc.Encode(row)
+		if err != nil {
+			h.log(r).Error("Writing CSV row", zap.Error(err))
+			return
+		}
+	}
+
+	csvWriter.Flush()
+	err = csvWriter.Error()
+	if err != nil {
+		h.log(r).Error("Writing response", zap.Error(err))
+	}
+}
+
+// listPage defines pagination of /list response needed to announce the next page
+type listPage struct {
+	limit  int64
+	offset int64
+	// byCursor reports whether page is read by keyset, such pages have no next offset
+	byCursor bool
+	// truncated reports whether limit is lowered to rowCap of storage
+	truncated bool
+	rowCap    int64
+}
+
+// nextPageHeaders defines headers announcing the next page, CSV export sends them as trailers
+var nextPageHeaders = []string{"X-Next-Cursor", "X-Next-Offset"}
+
+// setNextHeaders sets headers announcing page following the one which last product is provided
+func (p listPage) setNextHeaders(header http.Header, last postgresql.Product) {
+	header.Set("X-Next-Cursor", encodeListCursor(last))
+	if !p.byCursor {
+		header.Set("X-Next-Offset", strconv.FormatInt(p.offset+p.limit, 10))
+	}
+}
+
+// setTruncationHeaders sets headers reporting page limit lowered to row cap of storage whether or not next page exists,
+// it is known before products are read, so CSV export sends them as headers rather than trailers
+func (p listPage) setTruncationHeaders(header http.Header) {
+	if p.truncated {
+		header.Set("X-Result-Truncated", "true")
+		header.Set("X-Row-Cap", strconv.FormatInt(p.rowCap, 10))
+	}
+}
+
+// streamProductsCSV writes products listed with listOpts as CSV document with header row while they are read
+// from database. Next page headers are known only after the page is written, so they are sent as trailers.
+// Response starts with the first product, so failure of the query itself is still reported with error status.
+func (h *handler) streamProductsCSV(w http.ResponseWriter, r *http.Request, listOpts []postgresql.ListOption, page listPage) {
+	csvWriter := csv.NewWriter(w)
+	enc := csvutil.NewEncoder(csvWriter)
+
+	var written int64
+	var last postgresql.Product
+	var started, hasNext bool
+	start := func() error {
+		started = true
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Trailer", strings.Join(nextPageHeaders, ", "))
+		w.WriteHeader(http.StatusOK)
+		return enc.EncodeHeader(postgresql.Product{})
+	}
+
+	err := h.products.ListEach(r.Context(), func(p postgresql.Product) error {
+		// one more product than limit is read to find out whether there is next page
+		if page.limit > 0 && written == page.limit {
+			hasNext = true
+			return nil
+		}
+
+		if !started {
+			err := start()
+			if err != nil {
+				return err
+			}
+		}
+
+		err := enc.Encode(p)
+		if err != nil {
+			return err
+		}
+		written++
+		last = p
+
+		// flush periodically so client receives data while the rest is read
+		if written%1000 == 0 {
+			csvWriter.Flush()
+		}
+
+		return csvWriter.Error()
+	}, listOpts...)
+	if err != nil {
+		if !started {
+			h.writeStorageError(w, r, err)
+			return
+		}
+
+		h.log(r).Error("Streaming CSV rows", zap.Int64("written", written), zap.Error(err))
+		return
+	}
+
+	if !started {
+		err = start()
+		if err != nil {
+			h.log(r).Error("Writing CSV header", zap.Error(err))
+			return
+		}
+	}
+
+	csvWriter.Flush()
+	err = csvWriter.Error()
+	if err != nil {
+		h.log(r).Error("Writing response", zap.Error(err))
+		return
+	}
+
+	if hasNext {
+		page.setNextHeaders(w.Header(), last)
 	}
-	return
 }
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"mx/internal/storage/postgresql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// cappedLister reports rowCap as ListRowCap of every listing, other methods are not expected to be called
+type cappedLister struct {
+	productLister
+	rowCap int64
+}
+
+func (c cappedLister) ListRowCap(...postgresql.ListOption) int64 {
+	return c.rowCap
+}
+
+// newListHandler constructs handler serving /list from memory catalog of merchant 1 with n products
+func newListHandler(t *testing.T, rowCap int64, n int) *handler {
+	t.Helper()
+
+	catalog := postgresql.NewMemoryStore()
+	products := make([]postgresql.Product, n)
+	for i := range products {
+		products[i] = postgresql.Product{MerchantID: 1, OfferID: int64(i + 1), Name: "Tea", Price: decimal.New(1, 0), Quantity: 1, Available: true}
+	}
+
+	_, _, err := catalog.Upsert(context.Background(), products)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &handler{logger: zap.NewNop(), db: cappedLister{rowCap: rowCap}, products: catalog, searchGuard: defaultSearchGuard}
+}
+
+func TestListTruncation(t *testing.T) {
+	tests := []struct {
+		name      string
+		rowCap    int64
+		products  int
+		query     string
+		items     int
+		truncated bool
+		hasNext   bool
+	}{
+		{name: "capped with next page", rowCap: 2, products: 3, query: "limit=5", items: 2, truncated: true, hasNext: true},
+		{name: "capped without next page", rowCap: 2, products: 2, query: "limit=5", items: 2, truncated: true},
+		{name: "limit within cap", rowCap: 2, products: 3, query: "limit=1", items: 1, hasNext: true},
+		{name: "no cap", products: 3, query: "limit=5", items: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newListHandler(t, tt.rowCap, tt.products)
+
+			w := httptest.NewRecorder()
+			h.listProducts(w, httptest.NewRequest(http.MethodGet, "/list?merchant_id=1&"+tt.query, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			var resp struct {
+				Items     []json.RawMessage `json:"items"`
+				Truncated bool              `json:"truncated"`
+				RowCap    *int64            `json:"row_cap"`
+			}
+			err := json.Unmarshal(w.Body.Bytes(), &resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(resp.Items) != tt.items {
+				t.Fatalf("expected %d items, got %d", tt.items, len(resp.Items))
+			}
+			if resp.Truncated != tt.truncated {
+				t.Fatalf("expected truncated %v, got %v", tt.truncated, resp.Truncated)
+			}
+
+			switch {
+			case tt.rowCap == 0 && resp.RowCap != nil:
+				t.Fatalf("expected row_cap to be omitted, got %d", *resp.RowCap)
+			case tt.rowCap != 0 && (resp.RowCap == nil || *resp.RowCap != tt.rowCap):
+				t.Fatalf("expected row_cap %d, got %v", tt.rowCap, resp.RowCap)
+			}
+
+			header := w.Header()
+			if got := header.Get("X-Result-Truncated") == "true"; got != tt.truncated {
+				t.Fatalf("expected X-Result-Truncated %v, got %q", tt.truncated, header.Get("X-Result-Truncated"))
+			}
+			if tt.truncated && header.Get("X-Row-Cap") != "2" {
+				t.Fatalf("expected X-Row-Cap 2, got %q", header.Get("X-Row-Cap"))
+			}
+			if got := header.Get("X-Next-Cursor") != ""; got != tt.hasNext {
+				t.Fatalf("expected next page %v, got X-Next-Cursor %q", tt.hasNext, header.Get("X-Next-Cursor"))
+			}
+		})
+	}
+}
+
+func TestListTruncationCSV(t *testing.T) {
+	h := newListHandler(t, 2, 2)
+
+	w := httptest.NewRecorder()
+	h.listProducts(w, httptest.NewRequest(http.MethodGet, "/list?merchant_id=1&format=csv", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// recorder keeps headers as they were when status was written, so trailers would not be seen here
+	header := w.Result().Header
+	if header.Get("X-Result-Truncated") != "true" || header.Get("X-Row-Cap") != "2" {
+		t.Fatalf("expected truncation headers before body, got X-Result-Truncated %q and X-Row-Cap %q",
+			header.Get("X-Result-Truncated"), header.Get("X-Row-Cap"))
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"mx/internal/dbsource"
+	"mx/internal/storage/postgresql"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// importSourceRequest defines body of PUT /admin/import-sources, interval is Go duration like 15m
+type importSourceRequest struct {
+	DSN      string `json:"dsn" validate:"required"`
+	Query    string `json:"query" validate:"required"`
+	Interval string `json:"interval" validate:"minduration=1s"`
+	// Enabled is true if omitted
+	Enabled *bool `json:"enabled"`
+}
+
+// handleImportSource serves GET, PUT and DELETE /admin/import-sources?merchant_id=... managing database
+// products of the merchant are pulled from, it is admin endpoint and responses never contain connection string
+func (h *handler) handleImportSource(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	q, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		http.Error(w, "Request query can not be parsed", http.StatusBadRequest)
+		return
+	}
+
+	var src postgresql.ImportSource
+	switch r.Method {
+	case http.MethodGet:
+		merchantID, ok := requireMerchantID(w, q)
+		if !ok {
+			return
+		}
+
+		src, err = h.db.ReadImportSource(r.Context(), merchantID)
+	case http.MethodPut:
+		merchantID, ok := requireMerchantID(w, q)
+		if !ok {
+			return
+		}
+
+		var req importSourceRequest
+		if !h.decodeRequest(w, r, &req, false) {
+			return
+		}
+
+		// interval is checked by validate tag already
+		interval, _ := time.ParseDuration(req.Interval)
+
+		src = postgresql.ImportSource{
+			MerchantID:      merchantID,
+			DSN:             req.DSN,
+			Query:           req.Query,
+			IntervalSeconds: int64(interval / time.Second),
+			Enabled:         req.Enabled == nil || *req.Enabled,
+		}
+		err = dbsource.Validate(src)
+		if err != nil {
+			h.writeErrorResponse(w, r, http.StatusBadRequest, errorResponse{"Import source is invalid: " + err.Error(), "INVALID_REQUEST"})
+			return
+		}
+
+		src.DSN, err = h.sourceCipher.Seal(merchantID, src.DSN)
+		if err != nil {
+			h.log(r).Error("Encrypting import source dsn", zap.Error(err))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		src, err = h.db.SetImportSource(r.Context(), src)
+		h.log(r).Warn("Import source set", zap.Int64("merchant_id", merchantID), zap.String("remote_addr", r.RemoteAddr),
+			zap.NamedError("failure", err), zap.Bool("audit", true))
+	case http.MethodDelete:
+		merchantID, ok := requireMerchantID(w, q)
+		if !ok {
+			return
+		}
+
+		err = h.db.DeleteImportSource(r.Context(), merchantID)
+		if err == nil {
+			h.log(r).Warn("Import source deleted", zap.Int64("merchant_id", merchantID), zap.String("remote_addr", r.RemoteAddr),
+				zap.Bool("audit", true))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, postgresql.ErrNoImportSource):
+			http.Error(w, "Merchant has no import source", http.StatusNotFound)
+			return
+		default:
+			h.writeStorageError(w, r, err)
+			return
+		}
+	}
+
+	// connection string contains credentials
+	src.DSN = ""
+	h.writeJSON(w, r, http.StatusOK, src)
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"io"
+	"mime"
+	"mx/internal/signedurl"
+	"mx/internal/storage"
+	"mx/internal/task"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+)
+
+// maxLinkTTL limits validity period of shared links requested via ttl parameter
+const maxLinkTTL = 7 * 24 * time.Hour
+
+// paths of shared task resources, links to them are valid only when signed
+const (
+	sharedReportPath = "/shared/tasks/report"
+	sharedFilePath   = "/shared/tasks/file"
+)
+
+// fileContentTypes maps formats of uploaded files to content types they are shared with
+var fileContentTypes = map[string]string{
+	task.FormatXLSX:   "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	task.FormatCSV:    "text/csv",
+	task.FormatNDJSON: "application/x-ndjson",
+}
+
+// taskLinks defines signed links to task resources
+type taskLinks struct {
+	ReportURL    string    `json:"report_url"`
+	ReportCSVURL string    `json:"report_csv_url"`
+	FileURL      string    `json:"file_url"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// handleTaskLinks serves POST /tasks/links?id=... creating signed links to the task report and uploaded file,
+// which are valid without other credentials for ttl parameter duration or linkTTL
+func (h *handler) handleTaskLinks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.links == nil {
+		http.Error(w, "Shared links are not configured", http.StatusNotFound)
+		return
+	}
+
+	q, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		http.Error(w, "Request query can not be parsed", http.StatusBadRequest)
+		return
+	}
+
+	taskID := q.Get("id")
+	if taskID == "" {
+		http.Error(w, "Query value for id parameter can not be blank", http.StatusBadRequest)
+		return
+	}
+
+	ttl := h.linkTTL
+	if ttlString := q.Get("ttl"); ttlString != "" {
+		ttl, err = time.ParseDuration(ttlString)
+		if err != nil || ttl <= 0 || ttl > maxLinkTTL {
+			http.Error(w, "Query value for ttl parameter must be positive duration not longer than "+maxLinkTTL.String(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	_, err = h.scheduler.ReadTaskFile(r.Context(), taskID)
+	if err != nil {
+		switch {
+		case errors.Is(err, task.ErrBadTaskID):
+			http.Error(w, "Bad task id", http.StatusBadRequest)
+			return
+		default:
+			h.log(r).Error("Reading task file", zap.Error(err))
+			h.writeStorageError(w, r, err)
+			return
+		}
+	}
+
+	base := "http://" + r.Host
+	if r.TLS != nil {
+		base = "https://" + r.Host
+	}
+
+	links := taskLinks{ExpiresAt: time.Now().Add(ttl).Truncate(time.Second)}
+	params := url.Values{"id": {taskID}}
+	links.ReportURL = base + h.links.Sign(sharedReportPath, params, links.ExpiresAt)
+	links.FileURL = base + h.links.Sign(sharedFilePath, params, links.ExpiresAt)
+	links.ReportCSVURL = base + h.links.Sign(sharedReportPath, url.Values{"id": {taskID}, "format": {"csv"}}, links.ExpiresAt)
+
+	h.log(r).Info("Shared task links created", zap.String("task_id", taskID), zap.Time("expires_at", links.ExpiresAt))
+
+	h.writeJSON(w, r, http.StatusOK, links)
+}
+
+// verifyLink checks signature and expiry of shared link and returns its query.
+// If link is invalid error response is written and false is returned.
+func (h *handler) verifyLink(w http.ResponseWriter, r *http.Request) (url.Values, bool) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return nil, false
+	}
+
+	if h.links == nil {
+		http.NotFound(w, r)
+		return nil, false
+	}
+
+	q, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		http.Error(w, "Request query can not be parsed", http.StatusBadRequest)
+		return nil, false
+	}
+
+	err = h.links.Verify(r.URL.Path, q)
+	switch {
+	case errors.Is(err, signedurl.ErrExpired):
+		http.Error(w, "Link is expired", http.StatusGone)
+		return nil, false
+	case err != nil:
+		h.log(r).Info("Rejecting shared link", zap.Error(err))
+		http.Error(w, "Link signature is invalid", http.StatusForbidden)
+		return nil, false
+	}
+
+	return q, true
+}
+
+// sharedTaskReport serves signed GET /shared/tasks/report links to task report
+func (h *handler) sharedTaskReport(w http.ResponseWriter, r *http.Request) {
+	q, ok := h.verifyLink(w, r)
+	if !ok {
+		return
+	}
+
+	h.writeTaskReport(w, r, q, q.Get("id"))
+}
+
+// sharedTaskFile serves signed GET /shared/tasks/file links to file uploaded for the task
+func (h *handler) sharedTaskFile(w http.ResponseWriter, r *http.Request) {
+	q, ok := h.verifyLink(w, r)
+	if !ok {
+		return
+	}
+
+	file, err := h.scheduler.ReadTaskFile(r.Context(), q.Get("id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, task.ErrBadTaskID):
+			http.Error(w, "Bad task id", http.StatusBadRequest)
+			return
+		default:
+			h.log(r).Error("Reading task file", zap.Error(err))
+			h.writeStorageError(w, r, err)
+			return
+		}
+	}
+
+	blob, err := h.blobs.Get(r.Context(), file.Path)
+	if err != nil {
+		if errors.Is(err, storage.ErrBlobNotFound) {
+			h.log(r).Warn("Uploaded file is missing", zap.String("key", file.Path))
+			http.Error(w, "Uploaded file is no longer available", http.StatusNotFound)
+			return
+		}
+
+		h.log(r).Error("Reading uploaded file", zap.String("key", file.Path), zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", fileContentTypes[file.Format])
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(file.Path)}))
+	w.WriteHeader(http.StatusOK)
+	_, err = io.Copy(w, blob)
+	if err != nil {
+		h.log(r).Error("Writing response", zap.Error(err))
+	}
+}
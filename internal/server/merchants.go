@@ -0,0 +1,111 @@
+package server
+
+import (
+	"errors"
+	"mx/internal/storage/postgresql"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (h *handler) merchantStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	q, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		http.Error(w, "Request query can not be parsed", http.StatusBadRequest)
+		return
+	}
+
+	merchantID, ok := requireMerchantID(w, q)
+	if !ok {
+		return
+	}
+
+	stats, err := h.db.MerchantStats(r.Context(), merchantID)
+	if err != nil {
+		switch {
+		case errors.Is(err, postgresql.ErrNoCatalogStats):
+			http.Error(w, "Merchant has no products", http.StatusNotFound)
+			return
+		default:
+			h.writeStorageError(w, r, err)
+			return
+		}
+	}
+
+	h.writeJSON(w, r, http.StatusOK, stats)
+}
+
+func (h *handler) listMerchants(w http.ResponseWriter, r *http.Request) {
+	merchants, err := h.db.ListMerchants(r.Context())
+	if err != nil {
+		h.writeStorageError(w, r, err)
+		return
+	}
+
+	h.writeJSON(w, r, http.StatusOK, merchants)
+}
+
+// merchantQuality serves GET /merchants/{id}/quality listing daily data quality of tasks of the merchant
+// done within [from, to), last 30 days by default
+func (h *handler) merchantQuality(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/merchants/"), "/")
+	if len(parts) != 2 || parts[1] != "quality" {
+		http.NotFound(w, r)
+		return
+	}
+
+	merchantID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || merchantID <= 0 {
+		http.Error(w, "Merchant id in path must be positive integer greater than zero", http.StatusBadRequest)
+		return
+	}
+
+	q, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		http.Error(w, "Request query can not be parsed", http.StatusBadRequest)
+		return
+	}
+
+	to := time.Now()
+	if toString := q.Get("to"); toString != "" {
+		to, err = time.Parse(time.RFC3339, toString)
+		if err != nil {
+			http.Error(w, "Query value for to parameter must be RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+
+	from := to.Add(-qualityPeriod)
+	if fromString := q.Get("from"); fromString != "" {
+		from, err = time.Parse(time.RFC3339, fromString)
+		if err != nil {
+			http.Error(w, "Query value for from parameter must be RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if !from.Before(to) {
+		http.Error(w, "Query value for from parameter must be earlier than to", http.StatusBadRequest)
+		return
+	}
+
+	quality, err := h.db.MerchantQuality(r.Context(), merchantID, from, to)
+	if err != nil {
+		h.writeStorageError(w, r, err)
+		return
+	}
+
+	h.writeJSON(w, r, http.StatusOK, quality)
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"github.com/rs/xid"
+	"go.uber.org/zap"
+	"mx/internal/logctx"
+	"mx/internal/requestid"
+	"net/http"
+	"strconv"
+)
+
+// environmentMiddleware adds X-Environment header to every response
+func environmentMiddleware(environment string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Environment", environment)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestIDMiddleware passes X-Request-ID header value or newly generated id through request context
+// and reports it in X-Request-ID response header
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" {
+			id = xid.New().String()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(requestid.NewContext(r.Context(), id)))
+	})
+}
+
+// loggerMiddleware passes logger carrying request id and merchant id from merchant_id query parameter
+// through request context, so it must be wrapped by requestIDMiddleware
+func loggerMiddleware(logger *zap.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestLogger := logger.With(zap.String("request_id", requestid.FromContext(r.Context())))
+
+		merchantID, err := strconv.ParseInt(r.URL.Query().Get("merchant_id"), 10, 64)
+		if err == nil && merchantID > 0 {
+			requestLogger = requestLogger.With(zap.Int64("merchant_id", merchantID))
+		}
+
+		next.ServeHTTP(w, r.WithContext(logctx.NewContext(r.Context(), requestLogger)))
+	})
+}
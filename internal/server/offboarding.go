@@ -0,0 +1,151 @@
+package server
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"io"
+	"mime"
+	"mx/internal/offboarding"
+	"mx/internal/storage"
+	"mx/internal/storage/postgresql"
+	"net/http"
+	"net/url"
+	"path"
+)
+
+// offboardingPath is path of offboarding endpoints, archive is served under offboardingPath + "/archive"
+const offboardingPath = "/admin/offboardings"
+
+// handleOffboarding serves admin endpoint of merchant offboarding:
+// POST starts export of merchant_id data, GET returns progress of offboarding id
+// and DELETE cancels deletion of offboarding id during grace period.
+// Starting and canceling are written to audit log.
+func (h *handler) handleOffboarding(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.offboarding == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !h.isAdmin(r) {
+		if r.Method != http.MethodGet {
+			h.log(r).Warn("Offboarding is refused", zap.String("method", r.Method), zap.String("remote_addr", r.RemoteAddr),
+				zap.Bool("audit", true))
+		}
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	q, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		http.Error(w, "Request query can not be parsed", http.StatusBadRequest)
+		return
+	}
+
+	var o postgresql.Offboarding
+	status := http.StatusOK
+	switch r.Method {
+	case http.MethodPost:
+		merchantID, ok := requireMerchantID(w, q)
+		if !ok {
+			return
+		}
+
+		o, err = h.offboarding.Start(r.Context(), merchantID)
+		h.log(r).Warn("Merchant offboarding", zap.Int64("merchant_id", merchantID), zap.String("offboarding_id", o.ID),
+			zap.String("remote_addr", r.RemoteAddr), zap.Bool("started", err == nil), zap.NamedError("failure", err),
+			zap.Bool("audit", true))
+		if errors.Is(err, postgresql.ErrOffboardingInProgress) {
+			http.Error(w, "Merchant offboarding is already in progress", http.StatusConflict)
+			return
+		}
+
+		if err == nil {
+			w.Header().Set("Location", offboardingPath+"?id="+url.QueryEscape(o.ID))
+			status = http.StatusAccepted
+		}
+	case http.MethodGet:
+		o, err = h.offboarding.Status(r.Context(), q.Get("id"))
+	case http.MethodDelete:
+		o, err = h.offboarding.Cancel(r.Context(), q.Get("id"))
+		h.log(r).Warn("Merchant offboarding cancel", zap.String("offboarding_id", q.Get("id")),
+			zap.String("remote_addr", r.RemoteAddr), zap.Bool("canceled", err == nil), zap.NamedError("failure", err),
+			zap.Bool("audit", true))
+		if errors.Is(err, offboarding.ErrCanNotCancel) {
+			http.Error(w, "Offboarding deletion can be canceled only while it is scheduled", http.StatusConflict)
+			return
+		}
+	}
+	if err != nil {
+		if errors.Is(err, postgresql.ErrOffboardingNotFound) {
+			http.Error(w, "Offboarding with such id does not exist", http.StatusNotFound)
+			return
+		}
+
+		h.writeStorageError(w, r, err)
+		return
+	}
+
+	h.writeJSON(w, r, status, o)
+}
+
+// offboardingArchive serves GET /admin/offboardings/archive downloading exported archive of offboarding id,
+// it is admin endpoint and every download is written to audit log
+func (h *handler) offboardingArchive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.offboarding == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !h.isAdmin(r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	q, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		http.Error(w, "Request query can not be parsed", http.StatusBadRequest)
+		return
+	}
+
+	archive, o, err := h.offboarding.Archive(r.Context(), q.Get("id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, postgresql.ErrOffboardingNotFound):
+			http.Error(w, "Offboarding with such id does not exist", http.StatusNotFound)
+			return
+		case errors.Is(err, offboarding.ErrNoArchive):
+			http.Error(w, "Offboarding archive is not exported yet", http.StatusConflict)
+			return
+		case errors.Is(err, storage.ErrBlobNotFound):
+			h.log(r).Warn("Offboarding archive is missing", zap.String("key", o.ArchiveKey))
+			http.Error(w, "Offboarding archive is no longer available", http.StatusNotFound)
+			return
+		default:
+			h.log(r).Error("Reading offboarding archive", zap.Error(err))
+			h.writeStorageError(w, r, err)
+			return
+		}
+	}
+	defer archive.Close()
+
+	h.log(r).Warn("Offboarding archive download", zap.String("offboarding_id", o.ID), zap.Int64("merchant_id", o.MerchantID),
+		zap.String("remote_addr", r.RemoteAddr), zap.Bool("audit", true))
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(o.ArchiveKey)}))
+	w.WriteHeader(http.StatusOK)
+	_, err = io.Copy(w, archive)
+	if err != nil {
+		h.log(r).Error("Writing response", zap.Error(err))
+	}
+}
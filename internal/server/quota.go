@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"mx/internal/logctx"
+	"mx/internal/metrics"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// quotaWarningThreshold defines share of quota after which responses carry X-Quota-Warning header
+	quotaWarningThreshold = 0.8
+	// quotaUsageTTL defines how long merchant quota usage is cached between database lookups,
+	// entries older than that are evicted, so cache holds merchants seen recently only
+	quotaUsageTTL = time.Minute
+
+	quotaUploadsPerDay = "uploads_per_day"
+	quotaCatalogSize   = "catalog_size"
+)
+
+type quotaCounter interface {
+	CountTasksSince(ctx context.Context, merchantID int64, since time.Time) (int64, error)
+	CountProducts(ctx context.Context, merchantID int64) (int64, error)
+	CatalogLimit() int64
+}
+
+// quotaUsage defines cached usage of merchant quotas
+type quotaUsage struct {
+	uploads   int64
+	products  int64
+	checkedAt time.Time
+}
+
+// quotaChecker defines fields used to enforce upload quota and warn about approaching any quota
+type quotaChecker struct {
+	logger        *zap.Logger
+	db            quotaCounter
+	uploadsPerDay int64
+
+	mu    sync.Mutex
+	usage map[int64]quotaUsage
+	// evictedAt is time expired entries were last removed from usage
+	evictedAt time.Time
+}
+
+func newQuotaChecker(logger *zap.Logger, db quotaCounter, uploadsPerDay int64) *quotaChecker {
+	return &quotaChecker{
+		logger:        logger,
+		db:            db,
+		uploadsPerDay: uploadsPerDay,
+		usage:         make(map[int64]quotaUsage),
+	}
+}
+
+// readUsage returns merchant quota usage using cached value if it is fresh enough or fresh is false
+func (q *quotaChecker) readUsage(ctx context.Context, merchantID int64, fresh bool) (quotaUsage, error) {
+	q.mu.Lock()
+	usage, ok := q.usage[merchantID]
+	q.mu.Unlock()
+
+	if ok && !fresh && time.Since(usage.checkedAt) < quotaUsageTTL {
+		return usage, nil
+	}
+
+	var err error
+	usage = quotaUsage{checkedAt: time.Now()}
+
+	if q.uploadsPerDay > 0 {
+		usage.uploads, err = q.db.CountTasksSince(ctx, merchantID, usage.checkedAt.Add(-24*time.Hour))
+		if err != nil {
+			return quotaUsage{}, err
+		}
+	}
+
+	if q.db.CatalogLimit() > 0 {
+		usage.products, err = q.db.CountProducts(ctx, merchantID)
+		if err != nil {
+			return quotaUsage{}, err
+		}
+	}
+
+	q.mu.Lock()
+	q.usage[merchantID] = usage
+	q.evictExpired(usage.checkedAt)
+	q.mu.Unlock()
+
+	return usage, nil
+}
+
+// evictExpired removes usage entries older than quotaUsageTTL at most once per quotaUsageTTL, q.mu has to be locked
+func (q *quotaChecker) evictExpired(now time.Time) {
+	if now.Sub(q.evictedAt) < quotaUsageTTL {
+		return
+	}
+
+	for merchantID, usage := range q.usage {
+		if now.Sub(usage.checkedAt) >= quotaUsageTTL {
+			delete(q.usage, merchantID)
+		}
+	}
+	q.evictedAt = now
+}
+
+// UploadAllowed reports whether merchant has not exhausted uploads per day quota
+func (q *quotaChecker) UploadAllowed(ctx context.Context, merchantID int64) (bool, error) {
+	if q.uploadsPerDay <= 0 {
+		return true, nil
+	}
+
+	usage, err := q.readUsage(ctx, merchantID, true)
+	if err != nil {
+		return false, err
+	}
+
+	if usage.uploads >= q.uploadsPerDay {
+		metrics.QuotaRejections.Add(quotaUploadsPerDay, 1)
+		metrics.AddMerchant(metrics.QuotaRejectionsByMerchant, merchantID, 1)
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// middleware adds X-Quota-Warning header for every quota merchant from merchant_id query parameter
+// used more than quotaWarningThreshold of. It wraps routes serving merchant catalog only, and usage is not read
+// unless any quota is configured.
+func (q *quotaChecker) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if q.uploadsPerDay <= 0 && q.db.CatalogLimit() <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		merchantID, err := strconv.ParseInt(r.URL.Query().Get("merchant_id"), 10, 64)
+		if err != nil || merchantID <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		usage, err := q.readUsage(r.Context(), merchantID, false)
+		if err != nil {
+			logctx.FromContext(r.Context(), q.logger).Warn("Can not read quota usage", zap.Error(err))
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		q.warn(w, quotaUploadsPerDay, usage.uploads, q.uploadsPerDay)
+		q.warn(w, quotaCatalogSize, usage.products, q.db.CatalogLimit())
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (q *quotaChecker) warn(w http.ResponseWriter, name string, used, limit int64) {
+	if limit <= 0 || float64(used) < float64(limit)*quotaWarningThreshold {
+		return
+	}
+
+	w.Header().Add("X-Quota-Warning", fmt.Sprintf("%s; used=%d; limit=%d", name, used, limit))
+	metrics.QuotaWarnings.Add(name, 1)
+}
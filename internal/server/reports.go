@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/csv"
+	"github.com/jszwec/csvutil"
+	"go.uber.org/zap"
+	"mime"
+	"mx/internal/storage/postgresql"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// importsReport serves GET /reports/imports.csv listing summaries of tasks of every merchant created within [from, to),
+// last 7 days by default, as CSV document, it requires X-Admin-Token header
+func (h *handler) importsReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !h.isAdmin(r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	q, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		http.Error(w, "Request query can not be parsed", http.StatusBadRequest)
+		return
+	}
+
+	to := time.Now()
+	if toString := q.Get("to"); toString != "" {
+		to, err = time.Parse(time.RFC3339, toString)
+		if err != nil {
+			http.Error(w, "Query value for to parameter must be RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+
+	from := to.Add(-importsReportPeriod)
+	if fromString := q.Get("from"); fromString != "" {
+		from, err = time.Parse(time.RFC3339, fromString)
+		if err != nil {
+			http.Error(w, "Query value for from parameter must be RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if !from.Before(to) {
+		http.Error(w, "Query value for from parameter must be earlier than to", http.StatusBadRequest)
+		return
+	}
+
+	summaries, err := h.db.ImportSummaries(r.Context(), from, to)
+	if err != nil {
+		h.writeStorageError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": "imports.csv"}))
+	w.WriteHeader(http.StatusOK)
+
+	csvWriter := csv.NewWriter(w)
+	enc := csvutil.NewEncoder(csvWriter)
+
+	err = enc.EncodeHeader(postgresql.ImportSummary{})
+	if err != nil {
+		h.log(r).Error("Writing CSV header", zap.Error(err))
+		return
+	}
+
+	for _, summary := range summaries {
+		err = enc.Encode(summary)
+		if err != nil {
+			h.log(r).Error("Writing CSV row", zap.Error(err))
+			return
+		}
+	}
+
+	csvWriter.Flush()
+	err = csvWriter.Error()
+	if err != nil {
+		h.log(r).Error("Writing response", zap.Error(err))
+	}
+}
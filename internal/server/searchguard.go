@@ -0,0 +1,43 @@
+package server
+
+import (
+	"mx/internal/storage/postgresql"
+	"strconv"
+	"unicode/utf8"
+)
+
+// SearchGuard defines heuristics applied to name search of /list before it reaches database,
+// since contains and fuzzy matches of short queries scan most of trigram index
+type SearchGuard struct {
+	// MinQueryLength is minimum number of characters of contains and fuzzy queries, zero disables the check.
+	// Shorter query of single merchant is downgraded to prefix match, which is served by btree index.
+	MinQueryLength int
+	// RequireMerchant makes contains and fuzzy searches across all merchants rejected
+	RequireMerchant bool
+}
+
+// defaultSearchGuard is applied unless WithSearchGuard option is provided, trigrams need 3 characters
+var defaultSearchGuard = SearchGuard{MinQueryLength: 3}
+
+// check returns match mode name query is executed with, which differs from requested one if search is downgraded.
+// Non-empty guidance means search is rejected.
+func (g SearchGuard) check(match, name string, merchantSet bool) (string, string) {
+	if name == "" || (match != postgresql.NameMatchContains && match != postgresql.NameMatchFuzzy) {
+		return match, ""
+	}
+
+	if g.RequireMerchant && !merchantSet {
+		return match, "Search with match=" + match + " requires merchant_id, use match=prefix to search all merchants"
+	}
+
+	if utf8.RuneCountInString(name) >= g.MinQueryLength {
+		return match, ""
+	}
+
+	if merchantSet {
+		return postgresql.NameMatchPrefix, ""
+	}
+
+	return match, "Search with match=" + match + " requires name of at least " + strconv.Itoa(g.MinQueryLength) +
+		" characters, set merchant_id or use match=prefix for shorter names"
+}
@@ -3,64 +3,468 @@ package server
 import (
 	"context"
 	"errors"
+	"expvar"
 	"fmt"
 	"go.uber.org/zap"
+	"mx/internal/dbsource"
+	"mx/internal/offboarding"
+	"mx/internal/signedurl"
+	"mx/internal/slo"
+	"mx/internal/storage"
 	"mx/internal/storage/postgresql"
 	"mx/internal/task"
+	"mx/internal/upload"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"sync/atomic"
 	"syscall"
+	"time"
 )
 
 // Server defines fields used in HTTP processing
 type Server struct {
 	logger        *zap.Logger
 	httpServer    *http.Server
+	usage         *usageRecorder
+	sources       *dbsource.Job
 	afterShutdown func() error
+	// drainer stops instance from taking new work on SIGUSR1 or POST /admin/drain
+	drainer *drainer
+	// port is port of bound listener, tlsCertFile and tlsKeyFile make Server serve HTTPS
+	port        *listenerPort
+	tlsCertFile string
+	tlsKeyFile  string
+}
+
+// listenerPort keeps port Server is bound to, it is read by upload handlers while Start binds the listener
+type listenerPort struct {
+	port int64
+}
+
+func (p *listenerPort) set(port int) {
+	atomic.StoreInt64(&p.port, int64(port))
+}
+
+func (p *listenerPort) get() int {
+	return int(atomic.LoadInt64(&p.port))
+}
+
+// serverParameters defines fields that affect Server construction
+type serverParameters struct {
+	uploadsPerDay int64
+	environment   string
+	taskIDs       task.IDGenerator
+	// remoteTimeout and remoteMaxSize limit downloads of /upload-by-url
+	remoteTimeout time.Duration
+	remoteMaxSize int64
+	// baseCurrency enables conversion of uploaded prices, see upload.WithBaseCurrency
+	baseCurrency string
+	// maxUploadBytes limits size of /upload request body, zero means no limit
+	maxUploadBytes int64
+	// usageInterval defines period of saving API usage, zero disables usage tracking
+	usageInterval time.Duration
+	adminToken    string
+	// links signs shared links to task resources valid for linkTTL by default
+	links   *signedurl.Signer
+	linkTTL time.Duration
+	// blobs stores uploaded files, by default they are saved to working directory
+	blobs storage.Blob
+	// jsonStyle defines default style of product JSON
+	jsonStyle postgresql.JSONStyle
+	// objectives are tracked for /upload and /list endpoint groups and summarized by /slo
+	objectives []slo.Objective
+	// searchGuard rejects or downgrades expensive name searches of /list
+	searchGuard SearchGuard
+	// sourceCipher encrypts connection strings of import sources, nil keeps them as is
+	sourceCipher *dbsource.Cipher
+	// offboarding exports and deletes merchant data, nil disables offboarding endpoints
+	offboarding *offboarding.Service
+	// offerIDs allocates offer ids of products created without one, by default they are taken from the database
+	offerIDs OfferIDAllocator
+	// products serves products listed by /list and read by /products, by default they are read from the database
+	products CatalogStore
+	// dependencies are checked by /admin/dependencies together with database and filestore
+	dependencies []dependency
+	// sourceInterval defines period between checks of import sources due to be pulled, zero disables pulls,
+	// sourceTimeout and sourceMaxRows limit single pull
+	sourceInterval time.Duration
+	sourceTimeout  time.Duration
+	sourceMaxRows  int64
+	// port is TCP port to listen on, zero picks any free port
+	port int
+	// tlsCertFile and tlsKeyFile enable HTTPS when both are set
+	tlsCertFile string
+	tlsKeyFile  string
+}
+
+// ServerOption type represents function to modify serverParameters struct
+type ServerOption func(p *serverParameters)
+
+// WithUploadsPerDay limits number of uploads per merchant during last 24 hours, zero means no limit
+func WithUploadsPerDay(n int64) ServerOption {
+	return func(p *serverParameters) {
+		p.uploadsPerDay = n
+	}
+}
+
+// WithEnvironment applies passed name of deployment environment, e.g. staging or production,
+// which is reported in X-Environment header of every response
+func WithEnvironment(environment string) ServerOption {
+	return func(p *serverParameters) {
+		p.environment = environment
+	}
+}
+
+// WithTaskIDGenerator applies passed generator of ids of uploaded tasks
+func WithTaskIDGenerator(ids task.IDGenerator) ServerOption {
+	return func(p *serverParameters) {
+		p.taskIDs = ids
+	}
+}
+
+// WithRemoteUploadLimits limits time of download and size of file uploaded via /upload-by-url
+func WithRemoteUploadLimits(timeout time.Duration, maxSize int64) ServerOption {
+	return func(p *serverParameters) {
+		p.remoteTimeout = timeout
+		p.remoteMaxSize = maxSize
+	}
+}
+
+// WithBaseCurrency allows uploads to set currency of prices converted into provided base currency
+func WithBaseCurrency(base string) ServerOption {
+	return func(p *serverParameters) {
+		p.baseCurrency = base
+	}
+}
+
+// WithMaxUploadBytes limits size of /upload request body, larger uploads are rejected with 413
+func WithMaxUploadBytes(n int64) ServerOption {
+	return func(p *serverParameters) {
+		p.maxUploadBytes = n
+	}
+}
+
+// WithUsageTracking makes Server count requests, errors and transferred bytes per API key and merchant,
+// counters are saved to the database every interval
+func WithUsageTracking(interval time.Duration) ServerOption {
+	return func(p *serverParameters) {
+		p.usageInterval = interval
+	}
+}
+
+// WithAdminToken enables admin endpoints, e.g. /usage/summary, for requests carrying token in X-Admin-Token header
+func WithAdminToken(token string) ServerOption {
+	return func(p *serverParameters) {
+		p.adminToken = token
+	}
+}
+
+// WithSharedLinks enables signed links to task reports and uploaded files, which are valid for ttl by default
+func WithSharedLinks(signer *signedurl.Signer, ttl time.Duration) ServerOption {
+	return func(p *serverParameters) {
+		p.links = signer
+		p.linkTTL = ttl
+	}
+}
+
+// WithBlobStore makes Server save uploaded files to provided blob storage,
+// scheduler has to read them from the same one
+func WithBlobStore(blobs storage.Blob) ServerOption {
+	return func(p *serverParameters) {
+		p.blobs = blobs
+	}
+}
+
+// WithJSONStyle defines field naming and price encoding of product JSON used unless client requests another one
+func WithJSONStyle(style postgresql.JSONStyle) ServerOption {
+	return func(p *serverParameters) {
+		p.jsonStyle = style
+	}
+}
+
+// WithObjectives replaces default objectives of "upload" and "list" endpoint groups summarized by /slo
+func WithObjectives(objectives ...slo.Objective) ServerOption {
+	return func(p *serverParameters) {
+		p.objectives = objectives
+	}
+}
+
+// WithSearchGuard replaces default heuristics applied to contains and fuzzy name searches of /list
+func WithSearchGuard(guard SearchGuard) ServerOption {
+	return func(p *serverParameters) {
+		p.searchGuard = guard
+	}
+}
+
+// WithImportSources enables pulls of merchant databases configured via /admin/import-sources checked every interval,
+// single pull takes at most timeout and reads at most maxRows rows
+func WithImportSources(interval, timeout time.Duration, maxRows int64) ServerOption {
+	return func(p *serverParameters) {
+		p.sourceInterval = interval
+		p.sourceTimeout = timeout
+		p.sourceMaxRows = maxRows
+	}
+}
+
+// WithImportSourceCipher makes connection strings of import sources saved encrypted by provided Cipher
+func WithImportSourceCipher(c *dbsource.Cipher) ServerOption {
+	return func(p *serverParameters) {
+		p.sourceCipher = c
+	}
+}
+
+// WithPort applies passed TCP port to listen on, zero makes Server pick any free port reported by Port
+func WithPort(port int) ServerOption {
+	return func(p *serverParameters) {
+		p.port = port
+	}
+}
+
+// WithTLS makes Server serve HTTPS using provided certificate and key files
+func WithTLS(certFile, keyFile string) ServerOption {
+	return func(p *serverParameters) {
+		p.tlsCertFile = certFile
+		p.tlsKeyFile = keyFile
+	}
+}
+
+// WithOffboarding enables admin endpoints exporting and deleting merchant data via provided service
+func WithOffboarding(service *offboarding.Service) ServerOption {
+	return func(p *serverParameters) {
+		p.offboarding = service
+	}
+}
+
+// OfferIDAllocator is implemented by sources of offer ids assigned to products created via POST /products
+// without offer_id, allocated id must not be used by the merchant yet
+type OfferIDAllocator interface {
+	AllocateOfferID(ctx context.Context, merchantID int64) (int64, error)
+}
+
+// WithOfferIDAllocator replaces per-merchant sequence of the database allocating offer ids of created products
+func WithOfferIDAllocator(allocator OfferIDAllocator) ServerOption {
+	return func(p *serverParameters) {
+		p.offerIDs = allocator
+	}
+}
+
+// CatalogStore is catalog storage serving /list and /products, see WithProductStore
+type CatalogStore interface {
+	storage.ProductStore
+	ListEach(ctx context.Context, fn func(postgresql.Product) error, options ...postgresql.ListOption) error
+	Count(ctx context.Context, options ...postgresql.ListOption) (int64, error)
+}
+
+// WithProductStore makes /list and /products serve products of provided catalog storage instead of the database
+func WithProductStore(products CatalogStore) ServerOption {
+	return func(p *serverParameters) {
+		p.products = products
+	}
 }
 
 // NewServer constructs a Server
-func NewServer(logger *zap.Logger, scheduler *task.Scheduler, db *postgresql.Storage) (*Server, error) {
+func NewServer(logger *zap.Logger, scheduler *task.Scheduler, db *postgresql.Storage, options ...ServerOption) (*Server, error) {
 	if logger == nil {
 		return nil, errors.New("no logger provided")
 	}
 
+	parameters := &serverParameters{
+		environment:   "development",
+		taskIDs:       task.XIDGenerator{},
+		remoteTimeout: 30 * time.Second,
+		remoteMaxSize: 50 << 20,
+		blobs:         storage.NewLocalBlob(""),
+		objectives:    defaultObjectives,
+		searchGuard:   defaultSearchGuard,
+		port:          8080,
+	}
+	for _, opt := range options {
+		opt(parameters)
+	}
+
+	if parameters.offerIDs == nil {
+		parameters.offerIDs = db
+	}
+
+	if parameters.products == nil {
+		parameters.products = db
+	}
+
+	if parameters.port < 0 || parameters.port > 65535 {
+		return nil, fmt.Errorf("port must be between 0 and 65535, got %d", parameters.port)
+	}
+
+	if (parameters.tlsCertFile == "") != (parameters.tlsKeyFile == "") {
+		return nil, errors.New("both TLS certificate and key files have to be provided")
+	}
+
+	scheme := "http"
+	if parameters.tlsCertFile != "" {
+		scheme = "https"
+	}
+
+	port := &listenerPort{}
+	port.set(parameters.port)
+
 	currentAddr, err := currentHost(logger)
 	if err != nil {
 		logger.Error("Can not retrieve current address")
 		return nil, err
 	}
 
+	tracker, err := slo.NewTracker(parameters.objectives...)
+	if err != nil {
+		return nil, err
+	}
+
+	quota := newQuotaChecker(logger, db, parameters.uploadsPerDay)
+
+	uploads, err := upload.NewService(logger, upload.NewBlobFileStore(parameters.blobs), scheduler, quota,
+		upload.WithLocation(taskLocation(logger, currentAddr, scheme, port)),
+		upload.WithIDGenerator(parameters.taskIDs),
+		upload.WithBaseCurrency(parameters.baseCurrency),
+		upload.WithFreezeCheck(db),
+	)
+	if err != nil {
+		return nil, err
+	}
+
 	h := handler{
-		logger:    logger,
-		host:      currentAddr,
-		scheduler: scheduler,
-		db:        db,
+		logger:     logger,
+		scheduler:  scheduler,
+		uploads:    uploads,
+		downloader: upload.NewDownloader(parameters.remoteTimeout, parameters.remoteMaxSize),
+		db:         db,
+		health:     db,
+		quota:      quota,
+
+		maxUploadBytes: parameters.maxUploadBytes,
+		adminToken:     parameters.adminToken,
+		links:          parameters.links,
+		linkTTL:        parameters.linkTTL,
+		blobs:          parameters.blobs,
+		slo:            tracker,
+		jsonDefaults:   parameters.jsonStyle,
+		searchGuard:    parameters.searchGuard,
+		offboarding:    parameters.offboarding,
+		offerIDs:       parameters.offerIDs,
+		products:       parameters.products,
+		dependencies:   parameters.dependencies,
+		drainer:        newDrainer(logger, uploads, scheduler),
+		sourceCipher:   parameters.sourceCipher,
+	}
+
+	usage := newUsageRecorder(logger, db, parameters.usageInterval)
+
+	var sources *dbsource.Job
+	if parameters.sourceInterval > 0 {
+		sources, err = dbsource.NewJob(logger, db, uploads,
+			dbsource.WithInterval(parameters.sourceInterval),
+			dbsource.WithLimits(parameters.sourceTimeout, parameters.sourceMaxRows),
+			dbsource.WithCipher(parameters.sourceCipher),
+		)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	mux := http.NewServeMux()
-	mux.Handle("/upload", http.HandlerFunc(h.handleUpload))
+	// quota warnings are sent by routes serving merchant catalog
+	mux.Handle("/upload", h.quota.middleware(sloMiddleware(tracker, UploadObjective, http.HandlerFunc(h.handleUpload))))
+	mux.Handle("/upload-by-url", h.quota.middleware(sloMiddleware(tracker, UploadObjective, http.HandlerFunc(h.handleUploadByURL))))
 	mux.Handle("/tasks", http.HandlerFunc(h.handleTaskStatus))
-	mux.Handle("/list", http.HandlerFunc(h.listProducts))
+	mux.Handle("/tasks/", http.HandlerFunc(h.handleTaskChunks))
+	mux.Handle("/tasks/list", http.HandlerFunc(h.listTasks))
+	mux.Handle("/tasks/report", http.HandlerFunc(h.handleTaskReport))
+	mux.Handle("/tasks/links", http.HandlerFunc(h.handleTaskLinks))
+	mux.Handle("/tasks/cancel-batch", http.HandlerFunc(h.cancelTasks))
+	mux.Handle("/admin/tasks/", http.HandlerFunc(h.handleAdminTask))
+	mux.Handle("/admin/import-sources", http.HandlerFunc(h.handleImportSource))
+	mux.Handle("/admin/freezes", http.HandlerFunc(h.handleMerchantFreeze))
+	mux.Handle("/admin/dependencies", http.HandlerFunc(h.checkDependencies))
+	mux.Handle("/admin/drain", http.HandlerFunc(h.handleDrain))
+	mux.Handle(offboardingPath, http.HandlerFunc(h.handleOffboarding))
+	mux.Handle(offboardingPath+"/archive", http.HandlerFunc(h.offboardingArchive))
+	mux.Handle(sharedReportPath, http.HandlerFunc(h.sharedTaskReport))
+	mux.Handle(sharedFilePath, http.HandlerFunc(h.sharedTaskFile))
+	mux.Handle("/list", h.quota.middleware(sloMiddleware(tracker, ListObjective, http.HandlerFunc(h.listProducts))))
+	mux.Handle("/list/sample", sloMiddleware(tracker, ListObjective, http.HandlerFunc(h.sampleProducts)))
+	mux.Handle("/products", h.quota.middleware(h.productsByMethod(sloMiddleware(tracker, ListObjective, http.HandlerFunc(h.getProduct)))))
+	mux.Handle("/products/history", http.HandlerFunc(h.productHistory))
+	mux.Handle("/export", http.HandlerFunc(h.exportCatalog))
+	mux.Handle("/suggest", http.HandlerFunc(h.suggestNames))
+	mux.Handle("/analytics/duplicates", http.HandlerFunc(h.findDuplicates))
+	mux.Handle("/stats", h.quota.middleware(http.HandlerFunc(h.merchantStats)))
+	mux.Handle("/merchants", http.HandlerFunc(h.listMerchants))
+	mux.Handle("/merchants/", http.HandlerFunc(h.merchantQuality))
+	mux.Handle("/expiry/rules", http.HandlerFunc(h.handleExpiryRule))
+	mux.Handle("/expiry/report", http.HandlerFunc(h.expiredOffers))
+	mux.Handle("/usage", http.HandlerFunc(h.merchantUsage))
+	mux.Handle("/usage/summary", http.HandlerFunc(h.usageSummary))
+	mux.Handle("/reports/imports.csv", http.HandlerFunc(h.importsReport))
+	mux.Handle("/slo", http.HandlerFunc(h.sloSummary))
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.Handle("/health/live", http.HandlerFunc(h.liveness))
+	mux.Handle("/health/ready", http.HandlerFunc(h.readiness))
 
 	httpServer := &http.Server{
-		Addr:    ":8080",
-		Handler: mux,
+		Addr:    ":" + strconv.Itoa(parameters.port),
+		Handler: requestIDMiddleware(usage.middleware(loggerMiddleware(logger, environmentMiddleware(parameters.environment, mux)))),
 	}
 
 	return &Server{
 		logger:     logger,
 		httpServer: httpServer,
+		usage:      usage,
+		sources:    sources,
+		drainer:    h.drainer,
+
+		port:        port,
+		tlsCertFile: parameters.tlsCertFile,
+		tlsKeyFile:  parameters.tlsKeyFile,
 	}, nil
 }
 
-// Start calls ListenAndServe on http.Server instance inside Server struct
+// Port returns port Server listens on, with zero WithPort it is known only after Start binds the listener
+func (s *Server) Port() int {
+	return s.port.get()
+}
+
+// Start binds listener and serves http.Server instance inside Server struct on it
 // and implements graceful shutdown via goroutine waiting for signals
 func (s *Server) Start() error {
 	idleConnsClosed := make(chan struct{})
 
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	go s.usage.run(jobsCtx)
+	if s.sources != nil {
+		// draining instance stops pulling import sources, pulls scheduled earlier are finished by scheduler
+		sourcesCtx, stopSources := context.WithCancel(jobsCtx)
+		go func() {
+			select {
+			case <-s.drainer.done:
+				stopSources()
+			case <-sourcesCtx.Done():
+			}
+		}()
+		go s.sources.Run(sourcesCtx)
+	}
+
+	go func() {
+		sigusr1 := make(chan os.Signal, 1)
+		signal.Notify(sigusr1, syscall.SIGUSR1)
+		select {
+		case <-sigusr1:
+			s.Drain()
+		case <-jobsCtx.Done():
+		}
+		signal.Stop(sigusr1)
+	}()
+
 	go func() {
 		sigint := make(chan os.Signal, 1)
 		signal.Notify(sigint, syscall.SIGINT, syscall.SIGTERM)
@@ -76,16 +480,38 @@ func (s *Server) Start() error {
 		close(idleConnsClosed)
 	}()
 
-	s.logger.Info("Starting HTTP server")
-	if err := s.httpServer.ListenAndServe(); err != http.ErrServerClosed {
-		return fmt.Errorf("s.httpServer.ListenAndServe: %v", err)
+	listener, err := net.Listen("tcp", s.httpServer.Addr)
+	if err != nil {
+		return fmt.Errorf("net.Listen: %v", err)
+	}
+	// Location of uploaded tasks carries port actually bound even if any free one was requested
+	s.port.set(listener.Addr().(*net.TCPAddr).Port)
+
+	s.logger.Info("Starting HTTP server", zap.Int("port", s.port.get()), zap.Bool("tls", s.tlsCertFile != ""))
+	if s.tlsCertFile != "" {
+		err = s.httpServer.ServeTLS(listener, s.tlsCertFile, s.tlsKeyFile)
+	} else {
+		err = s.httpServer.Serve(listener)
+	}
+	if err != http.ErrServerClosed {
+		return fmt.Errorf("s.httpServer.Serve: %v", err)
 	}
 
 	<-idleConnsClosed
 
+	stopJobs()
+	s.usage.flush()
+
 	return s.afterShutdown()
 }
 
+// Drain makes Server refuse uploads and stop taking tasks from shared queue and import sources, while reads are
+// served and accepted tasks are finished, so instance can be stopped once /admin/drain reports no active tasks.
+// SIGUSR1 drains Server as well, SIGINT and SIGTERM still shut it down.
+func (s *Server) Drain() {
+	s.drainer.drain()
+}
+
 // RegisterAfterShutdown registers provided function to be called after Server shutdown
 func (s *Server) RegisterAfterShutdown(f func() error) {
 	s.afterShutdown = f
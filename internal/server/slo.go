@@ -0,0 +1,44 @@
+package server
+
+import (
+	"mx/internal/slo"
+	"net/http"
+	"time"
+)
+
+// names of objectives tracked for endpoint groups, /upload and /upload-by-url form upload one,
+// /list and /list/sample form list one
+const (
+	UploadObjective = "upload"
+	ListObjective   = "list"
+)
+
+// defaultObjectives are tracked unless WithObjectives option is provided
+var defaultObjectives = []slo.Objective{
+	{Name: UploadObjective, SuccessTarget: 0.99, LatencyTarget: 2 * time.Second},
+	{Name: ListObjective, SuccessTarget: 0.99, LatencyTarget: 500 * time.Millisecond},
+}
+
+// sloMiddleware records latency of request and whether it succeeded under objective,
+// only server errors are counted as failures since client ones do not indicate service health
+func sloMiddleware(tracker *slo.Tracker, objective string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		cw := &countingWriter{ResponseWriter: w}
+
+		next.ServeHTTP(cw, r)
+
+		tracker.Observe(objective, cw.status < http.StatusInternalServerError, time.Since(start))
+	})
+}
+
+// sloSummary serves GET /slo reporting success ratio, 95th percentile latency and remaining error budget
+// of tracked objectives over last hour and day
+func (h *handler) sloSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.writeJSON(w, r, http.StatusOK, h.slo.Summaries())
+}
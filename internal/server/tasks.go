@@ -0,0 +1,361 @@
+package server
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"mx/internal/task"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+func (h *handler) handleTaskStatus(w http.ResponseWriter, r *http.Request) {
+	q, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		http.Error(w, "Request query can not be parsed", http.StatusBadRequest)
+		return
+	}
+
+	taskID := q.Get("id")
+	if taskID == "" {
+		http.Error(w, "Query value for id parameter can not be blank", http.StatusBadRequest)
+		return
+	}
+
+	taskStatus, err := h.scheduler.ReadTaskStatus(r.Context(), taskID)
+	if err != nil {
+		switch {
+		case errors.Is(err, task.ErrBadTaskID):
+			http.Error(w, "Bad task id", http.StatusBadRequest)
+			return
+		// clients polling Location after restart decide whether to upload file again
+		case errors.Is(err, task.ErrTaskNotFound):
+			h.writeErrorResponse(w, r, http.StatusNotFound, errorResponse{"Task with such id never existed", "TASK_NOT_FOUND"})
+			return
+		case errors.Is(err, task.ErrTaskExpired):
+			h.writeErrorResponse(w, r, http.StatusNotFound, errorResponse{"Task has expired and its record is removed", "TASK_EXPIRED"})
+			return
+		default:
+			h.log(r).Error("Reading task status", zap.Error(err))
+			h.writeStorageError(w, r, err)
+			return
+		}
+	}
+
+	h.writeJSON(w, r, http.StatusOK, taskStatus)
+}
+
+// handleTaskChunks serves GET /tasks/{id}/chunks, GET /tasks/{id}/diff, POST /tasks/{id}/approve
+// and POST /tasks/{id}/reject
+func (h *handler) handleTaskChunks(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/tasks/"), "/")
+	if len(parts) != 2 || (parts[1] != "chunks" && parts[1] != "diff" && parts[1] != "approve" && parts[1] != "reject") {
+		http.NotFound(w, r)
+		return
+	}
+
+	if parts[1] == "approve" || parts[1] == "reject" {
+		h.decideTask(w, r, parts[0], parts[1] == "approve")
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if parts[1] == "diff" {
+		h.taskDiff(w, r, parts[0])
+		return
+	}
+
+	chunks, err := h.scheduler.ReadTaskChunks(r.Context(), parts[0])
+	if err != nil {
+		switch {
+		case errors.Is(err, task.ErrBadTaskID):
+			http.Error(w, "Bad task id", http.StatusBadRequest)
+			return
+		default:
+			h.log(r).Error("Reading task chunks", zap.Error(err))
+			h.writeStorageError(w, r, err)
+			return
+		}
+	}
+
+	h.writeJSON(w, r, http.StatusOK, chunks)
+}
+
+// decisionRequest defines optional body of /tasks/{id}/approve and /tasks/{id}/reject
+type decisionRequest struct {
+	// Reason is saved with the decision and written to audit log
+	Reason string `json:"reason" validate:"max=1000"`
+}
+
+// decideTask serves POST /tasks/{id}/approve applying PendingApproval task and POST /tasks/{id}/reject
+// finishing it unapplied, it is admin endpoint. Every attempt is written to audit log whatever its outcome is.
+func (h *handler) decideTask(w http.ResponseWriter, r *http.Request, taskID string, approve bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !h.isAdmin(r) {
+		h.log(r).Warn("Task decision is refused", zap.String("task_id", taskID), zap.Bool("approve", approve),
+			zap.String("remote_addr", r.RemoteAddr), zap.Bool("audit", true))
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	var req decisionRequest
+	if !h.decodeRequest(w, r, &req, true) {
+		return
+	}
+
+	var err error
+	if approve {
+		err = h.scheduler.ApproveTask(r.Context(), taskID, req.Reason)
+	} else {
+		err = h.scheduler.RejectTask(r.Context(), taskID, req.Reason)
+	}
+	h.log(r).Warn("Task decision", zap.String("task_id", taskID), zap.Bool("approve", approve), zap.String("reason", req.Reason),
+		zap.String("remote_addr", r.RemoteAddr), zap.NamedError("failure", err), zap.Bool("audit", true))
+	if err != nil {
+		switch {
+		case errors.Is(err, task.ErrBadTaskID):
+			http.Error(w, "Bad task id", http.StatusBadRequest)
+			return
+		case errors.Is(err, task.ErrNotPendingApproval):
+			http.Error(w, "Task is not pending approval", http.StatusConflict)
+			return
+		default:
+			h.writeStorageError(w, r, err)
+			return
+		}
+	}
+
+	status, err := h.scheduler.ReadTaskStatus(r.Context(), taskID)
+	if err != nil {
+		h.log(r).Error("Reading task status", zap.Error(err))
+		h.writeStorageError(w, r, err)
+		return
+	}
+
+	h.writeJSON(w, r, http.StatusOK, status)
+}
+
+// cancelBatchRequest defines body of /tasks/cancel-batch, exactly one of fields has to be set
+type cancelBatchRequest struct {
+	IDs        []string `json:"ids" validate:"max=1000"`
+	MerchantID int64    `json:"merchant_id" validate:"omitempty,positive"`
+}
+
+// validate checks that exactly one of fields is set
+func (req cancelBatchRequest) validate() []fieldError {
+	switch {
+	case len(req.IDs) == 0 && req.MerchantID == 0:
+		return []fieldError{{"ids", "must be set unless merchant_id is set"}}
+	case len(req.IDs) != 0 && req.MerchantID != 0:
+		return []fieldError{{"ids", "must not be set together with merchant_id"}}
+	}
+
+	return nil
+}
+
+// cancelBatchResponse defines outcomes of /tasks/cancel-batch
+type cancelBatchResponse struct {
+	Canceled int                 `json:"canceled"`
+	Results  []task.CancelResult `json:"results"`
+}
+
+// cancelTasks serves POST /tasks/cancel-batch canceling Processing tasks listed in ids field of JSON body
+// or all Processing tasks of merchant_id, it is admin endpoint
+func (h *handler) cancelTasks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !h.isAdmin(r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	var req cancelBatchRequest
+	if !h.decodeRequest(w, r, &req, false) {
+		return
+	}
+
+	results, err := h.scheduler.CancelTasks(r.Context(), req.IDs, req.MerchantID)
+	if err != nil {
+		h.log(r).Error("Canceling tasks", zap.Error(err))
+		h.writeStorageError(w, r, err)
+		return
+	}
+
+	response := cancelBatchResponse{Results: results}
+	for _, result := range results {
+		if result.Outcome == task.CancelOutcomeCanceled {
+			response.Canceled++
+		}
+	}
+
+	h.log(r).Info("Tasks are canceled in batch", zap.Int64("merchant_id", req.MerchantID),
+		zap.Int("requested", len(req.IDs)), zap.Int("canceled", response.Canceled), zap.Bool("audit", true))
+
+	h.writeJSON(w, r, http.StatusOK, response)
+}
+
+// taskDiff serves GET /tasks/{id}/diff?after=...&limit=... listing offers upserted and deleted by finished or previewed task
+// in pages ordered by offer_id, next page starts after next_after of previous one
+func (h *handler) taskDiff(w http.ResponseWriter, r *http.Request, taskID string) {
+	q, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		http.Error(w, "Request query can not be parsed", http.StatusBadRequest)
+		return
+	}
+
+	var after int64
+	afterValues, ok := q["after"]
+	if ok {
+		after, err = strconv.ParseInt(afterValues[0], 10, 64)
+		if err != nil {
+			http.Error(w, "Query value for after parameter must represent integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit := defaultDiffLimit
+	limitValues, ok := q["limit"]
+	if ok {
+		limit, err = strconv.Atoi(limitValues[0])
+		if err != nil {
+			http.Error(w, "Query value for limit parameter must represent integer", http.StatusBadRequest)
+			return
+		}
+
+		if limit <= 0 || limit > maxDiffLimit {
+			http.Error(w, "Query value for limit parameter must be between 1 and "+strconv.Itoa(maxDiffLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	diff, err := h.scheduler.ReadTaskDiff(r.Context(), taskID, after, limit)
+	if err != nil {
+		switch {
+		case errors.Is(err, task.ErrBadTaskID):
+			http.Error(w, "Bad task id", http.StatusBadRequest)
+			return
+		case errors.Is(err, task.ErrTaskNotFinished):
+			http.Error(w, "Task is not finished yet", http.StatusConflict)
+			return
+		default:
+			h.log(r).Error("Reading task diff", zap.Error(err))
+			h.writeStorageError(w, r, err)
+			return
+		}
+	}
+
+	h.writeJSON(w, r, http.StatusOK, diff)
+}
+
+// listTasks serves GET /tasks/list?merchant_id=...&label=... listing latest tasks having every requested label
+func (h *handler) listTasks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	q, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		http.Error(w, "Request query can not be parsed", http.StatusBadRequest)
+		return
+	}
+
+	var merchantID int64
+	merchantIDValues, ok := q["merchant_id"]
+	if ok {
+		merchantID, err = strconv.ParseInt(merchantIDValues[0], 10, 64)
+		if err != nil || merchantID <= 0 {
+			http.Error(w, "Query value for merchant_id parameter must represent positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	labels := q["label"]
+	for _, label := range labels {
+		if label == "" {
+			http.Error(w, "Query value for label parameter can not be blank", http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit := defaultTaskListLimit
+	limitValues, ok := q["limit"]
+	if ok {
+		limit, err = strconv.Atoi(limitValues[0])
+		if err != nil {
+			http.Error(w, "Query value for limit parameter must represent integer", http.StatusBadRequest)
+			return
+		}
+
+		if limit <= 0 || limit > maxTaskListLimit {
+			http.Error(w, "Query value for limit parameter must be between 1 and "+strconv.Itoa(maxTaskListLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	tasks, err := h.scheduler.ListTasks(r.Context(), merchantID, labels, limit)
+	if err != nil {
+		h.log(r).Error("Listing tasks", zap.Error(err))
+		h.writeStorageError(w, r, err)
+		return
+	}
+
+	h.writeJSON(w, r, http.StatusOK, tasks)
+}
+
+// handleTaskReport serves GET /tasks/report?id=... listing rows of the task ignored as invalid with reasons
+func (h *handler) handleTaskReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	q, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		http.Error(w, "Request query can not be parsed", http.StatusBadRequest)
+		return
+	}
+
+	taskID := q.Get("id")
+	if taskID == "" {
+		http.Error(w, "Query value for id parameter can not be blank", http.StatusBadRequest)
+		return
+	}
+
+	h.writeTaskReport(w, r, q, taskID)
+}
+
+// writeTaskReport responds with rejected rows of the task either as JSON or as CSV if client asked for it
+func (h *handler) writeTaskReport(w http.ResponseWriter, r *http.Request, q url.Values, taskID string) {
+	report, err := h.scheduler.ReadTaskReport(r.Context(), taskID)
+	if err != nil {
+		switch {
+		case errors.Is(err, task.ErrBadTaskID):
+			http.Error(w, "Bad task id", http.StatusBadRequest)
+			return
+		default:
+			h.log(r).Error("Reading task report", zap.Error(err))
+			h.writeStorageError(w, r, err)
+			return
+		}
+	}
+
+	if wantsCSV(r, q) {
+		h.writeReportCSV(w, r, report)
+		return
+	}
+
+	h.writeJSON(w, r, http.StatusOK, report)
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+)
+
+// uploadField is name of multipart form field carrying uploaded file
+const uploadField = "workbook"
+
+// errNoUploadField is returned by readUploadedFile when multipart body has no uploadField part
+var errNoUploadField = errors.New("multipart body has no " + uploadField + " part")
+
+// errUploadTooLarge is returned by uploadBody once more bytes than its limit are read
+var errUploadTooLarge = errors.New("upload exceeds size limit")
+
+// uploadBody counts bytes read from request body and fails with errUploadTooLarge once more than limit bytes
+// are read, zero limit means there is no limit
+type uploadBody struct {
+	r     io.Reader
+	limit int64
+	n     int64
+}
+
+// Read reads from request body at most one byte more than limit allows,
+// so body of exactly maximum size is told from larger one
+func (b *uploadBody) Read(p []byte) (int, error) {
+	if b.tooLarge() {
+		return 0, errUploadTooLarge
+	}
+
+	if b.limit > 0 && int64(len(p)) > b.limit-b.n+1 {
+		p = p[:b.limit-b.n+1]
+	}
+
+	n, err := b.r.Read(p)
+	b.n += int64(n)
+	if b.tooLarge() {
+		return n, errUploadTooLarge
+	}
+
+	return n, err
+}
+
+// tooLarge reports whether limit is exceeded, multipart reader does not keep error of underlying reader
+// when part headers are read, so it is checked instead of the error returned by it
+func (b *uploadBody) tooLarge() bool {
+	return b.limit > 0 && b.n > b.limit
+}
+
+// uploadedFile defines file of /upload request body, its content is streamed from the body
+type uploadedFile struct {
+	name        string
+	contentType string
+	content     io.Reader
+}
+
+// readUploadedFile finds file in multipart/form-data body's uploadField part or, for any other content type,
+// takes the whole body. Content of the file is not read, it is streamed from body by whoever saves it.
+// Body is read as a stream whatever its transfer encoding is, so chunked requests without Content-Length
+// are accepted, and no other form parts are buffered or spooled to temporary files.
+func readUploadedFile(r *http.Request) (uploadedFile, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return uploadedFile{name: bodyFileName(r), contentType: r.Header.Get("Content-Type"), content: r.Body}, nil
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return uploadedFile{}, err
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return uploadedFile{}, errNoUploadField
+		}
+		if err != nil {
+			return uploadedFile{}, err
+		}
+
+		if part.FormName() != uploadField {
+			_, err = io.Copy(ioutil.Discard, part)
+			part.Close()
+			if err != nil {
+				return uploadedFile{}, err
+			}
+			continue
+		}
+
+		return uploadedFile{name: part.FileName(), contentType: part.Header.Get("Content-Type"), content: part}, nil
+	}
+}
+
+// bodyFileName returns file name of non-multipart upload taken from filename parameter of Content-Disposition header
+// or filename query parameter, empty name makes format be detected from content
+func bodyFileName(r *http.Request) string {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Disposition"))
+	if err == nil && params["filename"] != "" {
+		return params["filename"]
+	}
+
+	return r.URL.Query().Get("filename")
+}
@@ -0,0 +1,275 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"go.uber.org/zap"
+	"io"
+	"mx/internal/storage/postgresql"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// usageSaveTimeout limits time of saving usage collected since previous flush
+const usageSaveTimeout = 10 * time.Second
+
+type usageSaver interface {
+	SaveUsage(ctx context.Context, records []postgresql.HourlyUsage) error
+}
+
+// usageKey identifies hourly usage counters
+type usageKey struct {
+	hour       time.Time
+	apiKey     string
+	merchantID int64
+}
+
+// usageRecorder defines fields used to count requests per API key and merchant and to save counters hourly aggregated
+type usageRecorder struct {
+	logger *zap.Logger
+	db     usageSaver
+	// interval defines period between saves, zero disables usage tracking
+	interval time.Duration
+
+	mu       sync.Mutex
+	counters map[usageKey]*postgresql.UsageCounters
+}
+
+func newUsageRecorder(logger *zap.Logger, db usageSaver, interval time.Duration) *usageRecorder {
+	return &usageRecorder{
+		logger:   logger,
+		db:       db,
+		interval: interval,
+		counters: make(map[usageKey]*postgresql.UsageCounters),
+	}
+}
+
+// apiKeyFingerprint identifies API key from X-API-Key header without keeping the key itself,
+// requests without the header are counted under empty key
+func apiKeyFingerprint(r *http.Request) string {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// countingBody counts bytes read from request body
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// countingWriter captures status and counts bytes of response body
+type countingWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (w *countingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// middleware counts request, its status and transferred bytes under API key and merchant from merchant_id
+// query parameter
+func (u *usageRecorder) middleware(next http.Handler) http.Handler {
+	if u.interval <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := &countingBody{ReadCloser: r.Body}
+		r.Body = body
+		cw := &countingWriter{ResponseWriter: w}
+
+		next.ServeHTTP(cw, r)
+
+		merchantID, err := strconv.ParseInt(r.URL.Query().Get("merchant_id"), 10, 64)
+		if err != nil || merchantID <= 0 {
+			merchantID = 0
+		}
+
+		u.record(usageKey{
+			hour:       time.Now().UTC().Truncate(time.Hour),
+			apiKey:     apiKeyFingerprint(r),
+			merchantID: merchantID,
+		}, cw.status, body.n, cw.n)
+	})
+}
+
+// record adds single request to counters of the key
+func (u *usageRecorder) record(key usageKey, status int, bytesIn, bytesOut int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	c, ok := u.counters[key]
+	if !ok {
+		c = &postgresql.UsageCounters{}
+		u.counters[key] = c
+	}
+
+	c.Requests++
+	switch {
+	case status >= 500:
+		c.ServerErrors++
+	case status >= 400:
+		c.ClientErrors++
+	}
+	c.BytesIn += bytesIn
+	c.BytesOut += bytesOut
+}
+
+// run saves collected usage every interval until ctx is done
+func (u *usageRecorder) run(ctx context.Context) {
+	if u.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(u.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			u.flush()
+		}
+	}
+}
+
+// flush saves usage collected since previous flush, counters failed to be saved are kept for the next one
+func (u *usageRecorder) flush() {
+	u.mu.Lock()
+	counters := u.counters
+	u.counters = make(map[usageKey]*postgresql.UsageCounters)
+	u.mu.Unlock()
+
+	if len(counters) == 0 {
+		return
+	}
+
+	records := make([]postgresql.HourlyUsage, 0, len(counters))
+	for k, c := range counters {
+		records = append(records, postgresql.HourlyUsage{
+			Hour:          k.hour,
+			APIKey:        k.apiKey,
+			MerchantID:    k.merchantID,
+			UsageCounters: *c,
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), usageSaveTimeout)
+	defer cancel()
+
+	err := u.db.SaveUsage(ctx, records)
+	if err == nil {
+		return
+	}
+
+	u.logger.Warn("Can not save API usage, keeping it for next attempt", zap.Int("records", len(records)), zap.Error(err))
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for k, c := range counters {
+		if current, ok := u.counters[k]; ok {
+			c.Requests += current.Requests
+			c.ClientErrors += current.ClientErrors
+			c.ServerErrors += current.ServerErrors
+			c.BytesIn += current.BytesIn
+			c.BytesOut += current.BytesOut
+		}
+		u.counters[k] = c
+	}
+}
+
+// merchantUsage serves GET /usage?merchant_id=... listing hourly API usage of the merchant per API key
+// since moment passed in RFC 3339 since parameter or during last 24 hours
+func (h *handler) merchantUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	q, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		http.Error(w, "Request query can not be parsed", http.StatusBadRequest)
+		return
+	}
+
+	merchantID, ok := requireMerchantID(w, q)
+	if !ok {
+		return
+	}
+
+	since, ok := sinceParameter(w, q, 24*time.Hour)
+	if !ok {
+		return
+	}
+
+	usage, err := h.db.MerchantUsage(r.Context(), merchantID, since)
+	if err != nil {
+		h.writeStorageError(w, r, err)
+		return
+	}
+
+	h.writeJSON(w, r, http.StatusOK, usage)
+}
+
+// usageSummary serves GET /usage/summary listing API usage totals of every API key and merchant
+// since moment passed in since parameter or during last 24 hours, it requires X-Admin-Token header
+func (h *handler) usageSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !h.isAdmin(r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	q, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		http.Error(w, "Request query can not be parsed", http.StatusBadRequest)
+		return
+	}
+
+	since, ok := sinceParameter(w, q, 24*time.Hour)
+	if !ok {
+		return
+	}
+
+	totals, err := h.db.UsageSummary(r.Context(), since)
+	if err != nil {
+		h.writeStorageError(w, r, err)
+		return
+	}
+
+	h.writeJSON(w, r, http.StatusOK, totals)
+}
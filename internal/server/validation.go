@@ -0,0 +1,215 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/shopspring/decimal"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRequestBodyBytes limits size of JSON bodies read by decodeRequest
+const maxRequestBodyBytes = 1 << 20
+
+// fieldError describes invalid field of JSON request body
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validationErrorResponse is errorResponse listing every invalid field of JSON request body
+type validationErrorResponse struct {
+	errorResponse
+	Details []fieldError `json:"details,omitempty"`
+}
+
+// requestValidator is implemented by request bodies which have checks involving several fields,
+// they are run once validate tags of every field pass
+type requestValidator interface {
+	validate() []fieldError
+}
+
+var decimalType = reflect.TypeOf(decimal.Decimal{})
+
+// validateRequest checks fields of struct pointed by v against comma separated rules of their validate tags:
+// required means string is not blank and other value is not zero, omitempty skips following rules for zero value,
+// positive means integer or decimal.Decimal is greater than zero, max=N limits characters of string or items of slice,
+// minduration=D means string is duration of at least D. Nil pointer fails required and skips other rules, otherwise
+// rules are applied to the value it points to. Fields are named in errors by their json tags.
+func validateRequest(v interface{}) []fieldError {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	rt := rv.Type()
+
+	var errs []fieldError
+	for i := 0; i < rt.NumField(); i++ {
+		tag, ok := rt.Field(i).Tag.Lookup("validate")
+		if !ok {
+			continue
+		}
+
+		name := strings.Split(rt.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = rt.Field(i).Name
+		}
+
+		message := validateField(rv.Field(i), strings.Split(tag, ","))
+		if message != "" {
+			errs = append(errs, fieldError{Field: name, Message: message})
+		}
+	}
+
+	if len(errs) != 0 {
+		return errs
+	}
+
+	if custom, ok := v.(requestValidator); ok {
+		return custom.validate()
+	}
+
+	return nil
+}
+
+// validateField applies rules to field value and returns message of the first failed one or empty string
+func validateField(field reflect.Value, rules []string) string {
+	for _, rule := range rules {
+		if field.Kind() == reflect.Ptr {
+			if field.IsNil() {
+				if rule == "required" {
+					return "must be set"
+				}
+				return ""
+			}
+			field = field.Elem()
+		}
+
+		name, arg := rule, ""
+		if i := strings.IndexByte(rule, '='); i >= 0 {
+			name, arg = rule[:i], rule[i+1:]
+		}
+
+		switch name {
+		case "required":
+			if field.Kind() == reflect.String && strings.TrimSpace(field.String()) == "" {
+				return "must not be blank"
+			}
+			if field.IsZero() {
+				return "must be set"
+			}
+		case "omitempty":
+			if field.IsZero() {
+				return ""
+			}
+		case "positive":
+			if !positive(field) {
+				return "must be positive"
+			}
+		case "max":
+			n, err := strconv.Atoi(arg)
+			if err != nil {
+				panic("validate: bad max rule " + rule)
+			}
+			if field.Kind() == reflect.String {
+				if len([]rune(field.String())) > n {
+					return "must be at most " + arg + " characters long"
+				}
+			} else if field.Len() > n {
+				return "must contain at most " + arg + " items"
+			}
+		case "minduration":
+			min, err := time.ParseDuration(arg)
+			if err != nil {
+				panic("validate: bad minduration rule " + rule)
+			}
+			d, err := time.ParseDuration(field.String())
+			if err != nil || d < min {
+				return "must be duration of at least " + arg
+			}
+		default:
+			panic("validate: unknown rule " + rule)
+		}
+	}
+
+	return ""
+}
+
+// positive reports whether integer or decimal.Decimal value is greater than zero
+func positive(v reflect.Value) bool {
+	if v.Type() == decimalType {
+		return v.Interface().(decimal.Decimal).IsPositive()
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() > 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() > 0
+	}
+
+	panic("validate: positive rule is applied to " + v.Type().String())
+}
+
+// decodeRequest reads JSON body into struct pointed by v and validates it, empty body is accepted if optional is set.
+// Invalid body is responded with 400 and false is returned.
+func (h *handler) decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}, optional bool) bool {
+	err := json.NewDecoder(io.LimitReader(r.Body, maxRequestBodyBytes)).Decode(v)
+	if err != nil && !(optional && errors.Is(err, io.EOF)) {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			h.writeValidationError(w, r, fieldError{Field: typeErr.Field, Message: "must be " + jsonKind(typeErr.Type)})
+			return false
+		}
+
+		h.writeErrorResponse(w, r, http.StatusBadRequest, errorResponse{"Request body must be JSON object", "INVALID_REQUEST"})
+		return false
+	}
+
+	errs := validateRequest(v)
+	if len(errs) != 0 {
+		h.writeValidationError(w, r, errs...)
+		return false
+	}
+
+	return true
+}
+
+// writeValidationError responds with 400 listing invalid fields, the first one is described by error field as well
+func (h *handler) writeValidationError(w http.ResponseWriter, r *http.Request, errs ...fieldError) {
+	message := "Request body is invalid"
+	if len(errs) != 0 {
+		message = "Value of " + errs[0].Field + " field " + errs[0].Message
+	}
+
+	h.writeJSON(w, r, http.StatusBadRequest, validationErrorResponse{
+		errorResponse: errorResponse{message, "INVALID_REQUEST"},
+		Details:       errs,
+	})
+}
+
+// jsonKind describes JSON value expected for Go type in validation errors
+func jsonKind(t reflect.Type) string {
+	if t == decimalType {
+		return "number"
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonKind(t.Elem())
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	default:
+		return "object"
+	}
+}
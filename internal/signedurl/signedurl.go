@@ -0,0 +1,93 @@
+// Package signedurl implements links granting access to single resource until they expire,
+// so they can be shared with people holding no other credentials
+package signedurl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// MinKeyLength defines minimum length of signing key in bytes
+const MinKeyLength = 32
+
+// query parameters added to signed links
+const (
+	expiresParameter   = "expires"
+	signatureParameter = "signature"
+)
+
+var (
+	// ErrBadSignature is returned when link is not signed or was modified after signing
+	ErrBadSignature = errors.New("link signature is invalid")
+	// ErrExpired is returned when link is used after its expiry
+	ErrExpired = errors.New("link is expired")
+)
+
+// Signer signs and verifies links with HMAC-SHA256 of their path and query
+type Signer struct {
+	key []byte
+	now func() time.Time
+}
+
+// NewSigner constructs Signer using provided secret key
+func NewSigner(key []byte) (*Signer, error) {
+	if len(key) < MinKeyLength {
+		return nil, errors.New("signing key must be at least " + strconv.Itoa(MinKeyLength) + " bytes long")
+	}
+
+	return &Signer{key: key, now: time.Now}, nil
+}
+
+// Sign returns path with query consisting of provided parameters, expiry moment and signature
+func (s *Signer) Sign(path string, params url.Values, expiresAt time.Time) string {
+	q := url.Values{}
+	for k, v := range params {
+		q[k] = v
+	}
+	q.Set(expiresParameter, strconv.FormatInt(expiresAt.Unix(), 10))
+	q.Del(signatureParameter)
+
+	q.Set(signatureParameter, s.signature(path, q))
+	return path + "?" + q.Encode()
+}
+
+// Verify checks that query of link to path carries valid signature and the link is not expired
+func (s *Signer) Verify(path string, query url.Values) error {
+	signature := query.Get(signatureParameter)
+	if signature == "" {
+		return ErrBadSignature
+	}
+
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Del(signatureParameter)
+
+	if !hmac.Equal([]byte(signature), []byte(s.signature(path, q))) {
+		return ErrBadSignature
+	}
+
+	expires, err := strconv.ParseInt(q.Get(expiresParameter), 10, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+
+	if !s.now().Before(time.Unix(expires, 0)) {
+		return ErrExpired
+	}
+
+	return nil
+}
+
+// signature returns HMAC of path and query encoded with sorted keys
+func (s *Signer) signature(path string, q url.Values) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(path + "?" + q.Encode()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
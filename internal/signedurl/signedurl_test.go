@@ -0,0 +1,93 @@
+package signedurl
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSigner(t *testing.T, key string, now time.Time) *Signer {
+	t.Helper()
+
+	s, err := NewSigner([]byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return now }
+
+	return s
+}
+
+func TestSigner(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	key := strings.Repeat("k", MinKeyLength)
+	s := newTestSigner(t, key, now)
+
+	link := s.Sign("/shared/tasks/report", url.Values{"id": {"task"}}, now.Add(time.Hour))
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tampered returns query of the link modified by fn
+	tampered := func(fn func(q url.Values)) url.Values {
+		q := u.Query()
+		fn(q)
+		return q
+	}
+
+	tests := []struct {
+		name   string
+		signer *Signer
+		path   string
+		query  url.Values
+		err    error
+	}{
+		{name: "valid", signer: s, path: u.Path, query: u.Query()},
+		{name: "another path", signer: s, path: "/shared/tasks/file", query: u.Query(), err: ErrBadSignature},
+		{name: "modified parameter", signer: s, path: u.Path, query: tampered(func(q url.Values) { q.Set("id", "other") }), err: ErrBadSignature},
+		{name: "added parameter", signer: s, path: u.Path, query: tampered(func(q url.Values) { q.Set("format", "csv") }), err: ErrBadSignature},
+		{name: "extended expiry", signer: s, path: u.Path, query: tampered(func(q url.Values) { q.Set(expiresParameter, "4102444800") }), err: ErrBadSignature},
+		{name: "no signature", signer: s, path: u.Path, query: tampered(func(q url.Values) { q.Del(signatureParameter) }), err: ErrBadSignature},
+		{name: "another key", signer: newTestSigner(t, strings.Repeat("x", MinKeyLength), now), path: u.Path, query: u.Query(), err: ErrBadSignature},
+		{name: "expired", signer: newTestSigner(t, key, now.Add(time.Hour)), path: u.Path, query: u.Query(), err: ErrExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.signer.Verify(tt.path, tt.query)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestSignReplacesSignature(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := newTestSigner(t, strings.Repeat("k", MinKeyLength), now)
+
+	link := s.Sign("/shared/tasks/file", url.Values{"id": {"task"}, signatureParameter: {"forged"}}, now.Add(time.Minute))
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := u.Query()[signatureParameter]; len(got) != 1 || got[0] == "forged" {
+		t.Fatalf("expected single signature replacing passed one, got %v", got)
+	}
+
+	err = s.Verify(u.Path, u.Query())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewSignerShortKey(t *testing.T) {
+	_, err := NewSigner([]byte(strings.Repeat("k", MinKeyLength-1)))
+	if err == nil {
+		t.Fatal("expected short key to be refused")
+	}
+}
@@ -0,0 +1,212 @@
+// Package slo tracks success ratio and latency of endpoints in memory and summarizes their error budgets,
+// so deployments without monitoring stack still get health insight
+package slo
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyBounds defines upper bounds of latency histogram buckets, slower requests fall into the last bucket
+var latencyBounds = []time.Duration{
+	5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second, 30 * time.Second, time.Minute,
+}
+
+// windows defines periods summaries are computed over, the longest one defines how long observations are kept
+var windows = []struct {
+	name   string
+	length time.Duration
+}{
+	{"1h", time.Hour},
+	{"24h", 24 * time.Hour},
+}
+
+// bucketLength defines resolution of rolling windows
+const bucketLength = time.Minute
+
+// Objective defines target success ratio and 95th percentile latency of endpoint
+type Objective struct {
+	Name string
+	// SuccessTarget is required share of successful requests, e.g. 0.99
+	SuccessTarget float64
+	// LatencyTarget is required 95th percentile latency
+	LatencyTarget time.Duration
+}
+
+// bucket contains observations of single minute
+type bucket struct {
+	minute  int64
+	total   int64
+	errors  int64
+	latency []int64
+}
+
+// series contains rolling minute buckets of objective observations
+type series struct {
+	objective Objective
+	buckets   []bucket
+}
+
+// Tracker records observations of endpoints and summarizes them against objectives
+type Tracker struct {
+	mu     sync.Mutex
+	series map[string]*series
+	names  []string
+	now    func() time.Time
+}
+
+// NewTracker constructs Tracker of provided objectives
+func NewTracker(objectives ...Objective) (*Tracker, error) {
+	t := &Tracker{series: make(map[string]*series, len(objectives)), now: time.Now}
+
+	longest := windows[len(windows)-1].length
+	for _, o := range objectives {
+		if o.SuccessTarget <= 0 || o.SuccessTarget >= 1 {
+			return nil, errors.New("success target of " + o.Name + " objective must be between 0 and 1")
+		}
+		if o.LatencyTarget <= 0 {
+			return nil, errors.New("latency target of " + o.Name + " objective must be positive")
+		}
+
+		buckets := make([]bucket, longest/bucketLength)
+		for i := range buckets {
+			buckets[i].minute = -1
+			buckets[i].latency = make([]int64, len(latencyBounds)+1)
+		}
+
+		t.series[o.Name] = &series{objective: o, buckets: buckets}
+		t.names = append(t.names, o.Name)
+	}
+
+	sort.Strings(t.names)
+	return t, nil
+}
+
+// Observe records request of objective endpoint, observations of unknown objectives are dropped
+func (t *Tracker) Observe(name string, success bool, latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.series[name]
+	if !ok {
+		return
+	}
+
+	minute := t.now().Unix() / int64(bucketLength/time.Second)
+	b := &s.buckets[minute%int64(len(s.buckets))]
+	if b.minute != minute {
+		b.minute = minute
+		b.total = 0
+		b.errors = 0
+		for i := range b.latency {
+			b.latency[i] = 0
+		}
+	}
+
+	b.total++
+	if !success {
+		b.errors++
+	}
+	b.latency[sort.Search(len(latencyBounds), func(i int) bool { return latency <= latencyBounds[i] })]++
+}
+
+// WindowSummary defines indicators of objective over single window
+type WindowSummary struct {
+	Window   string `json:"window"`
+	Requests int64  `json:"requests"`
+	Errors   int64  `json:"errors"`
+	// SuccessRatio is 1 if there were no requests
+	SuccessRatio float64 `json:"success_ratio"`
+	// P95LatencyMS is upper bound of histogram bucket containing 95th percentile latency
+	P95LatencyMS int64 `json:"p95_latency_ms"`
+	// ErrorBudgetRemaining is share of allowed errors not spent yet, negative once budget is exhausted
+	ErrorBudgetRemaining float64 `json:"error_budget_remaining"`
+	SuccessMet           bool    `json:"success_met"`
+	LatencyMet           bool    `json:"latency_met"`
+}
+
+// Summary defines indicators of objective over every window
+type Summary struct {
+	Objective       string          `json:"objective"`
+	SuccessTarget   float64         `json:"success_target"`
+	LatencyTargetMS int64           `json:"latency_target_ms"`
+	Windows         []WindowSummary `json:"windows"`
+}
+
+// Summaries returns summaries of all objectives ordered by name
+func (t *Tracker) Summaries() []Summary {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	current := t.now().Unix() / int64(bucketLength/time.Second)
+	summaries := make([]Summary, 0, len(t.names))
+	for _, name := range t.names {
+		s := t.series[name]
+		summary := Summary{
+			Objective:       name,
+			SuccessTarget:   s.objective.SuccessTarget,
+			LatencyTargetMS: s.objective.LatencyTarget.Milliseconds(),
+		}
+
+		for _, w := range windows {
+			summary.Windows = append(summary.Windows, s.summarize(w.name, current, int64(w.length/bucketLength)))
+		}
+
+		summaries = append(summaries, summary)
+	}
+
+	return summaries
+}
+
+// summarize computes indicators over minutes buckets ending at current one
+func (s *series) summarize(window string, current, minutes int64) WindowSummary {
+	ws := WindowSummary{Window: window}
+	latency := make([]int64, len(latencyBounds)+1)
+	for _, b := range s.buckets {
+		if b.minute < 0 || b.minute <= current-minutes || b.minute > current {
+			continue
+		}
+
+		ws.Requests += b.total
+		ws.Errors += b.errors
+		for i, n := range b.latency {
+			latency[i] += n
+		}
+	}
+
+	ws.SuccessRatio = 1
+	ws.ErrorBudgetRemaining = 1
+	if ws.Requests != 0 {
+		ws.SuccessRatio = float64(ws.Requests-ws.Errors) / float64(ws.Requests)
+		budget := (1 - s.objective.SuccessTarget) * float64(ws.Requests)
+		ws.ErrorBudgetRemaining = 1 - float64(ws.Errors)/budget
+	}
+	ws.SuccessMet = ws.SuccessRatio >= s.objective.SuccessTarget
+
+	// 95th percentile falls into the first bucket covering 95% of requests
+	threshold := (ws.Requests*95 + 99) / 100
+	var seen int64
+	for i, n := range latency {
+		seen += n
+		if seen < threshold || n == 0 {
+			continue
+		}
+
+		bound := latencyBounds[len(latencyBounds)-1]
+		if i < len(latencyBounds) {
+			bound = latencyBounds[i]
+		}
+		ws.P95LatencyMS = bound.Milliseconds()
+		ws.LatencyMet = i < len(latencyBounds) && bound <= s.objective.LatencyTarget
+		break
+	}
+	if ws.Requests == 0 {
+		ws.LatencyMet = true
+	}
+
+	return ws
+}
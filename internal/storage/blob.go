@@ -0,0 +1,195 @@
+// Package storage defines storage of uploaded files, catalog and tasks are stored by postgresql subpackage
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrBlobNotFound is returned when there is no blob with requested key
+var ErrBlobNotFound = errors.New("blob not found")
+
+// Blob is implemented by storage of uploaded files addressed by slash separated keys
+// like "<merchant id>/<task id>.xlsx"
+type Blob interface {
+	// Put writes data under key replacing existing blob
+	Put(ctx context.Context, key string, data []byte) error
+	// PutFrom writes content read from r to the end under key replacing existing blob,
+	// blob is not replaced if reading fails
+	PutFrom(ctx context.Context, key string, r io.Reader) error
+	// Get returns reader of blob content or ErrBlobNotFound, reader has to be closed by caller
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes blob, removing missing blob is not an error
+	Delete(ctx context.Context, key string) error
+	// List returns all blobs with keys starting with prefix
+	List(ctx context.Context, prefix string) ([]BlobInfo, error)
+}
+
+// BlobInfo defines stored blob
+type BlobInfo struct {
+	Key        string
+	ModifiedAt time.Time
+}
+
+// LocalBlob stores blobs as files under root directory, so it works only if all replicas share the directory
+type LocalBlob struct {
+	root string
+}
+
+// NewLocalBlob constructs LocalBlob storing files under root directory, empty root means working directory
+func NewLocalBlob(root string) *LocalBlob {
+	return &LocalBlob{root: root}
+}
+
+// Prepare creates root directory if it is missing and checks that files can be created in it,
+// so misconfigured directory is reported at startup rather than by the first upload
+func (l *LocalBlob) Prepare() error {
+	root := l.root
+	if root == "" {
+		root = "."
+	}
+
+	err := os.MkdirAll(root, 0750)
+	if err != nil {
+		return fmt.Errorf("creating upload directory: %w", err)
+	}
+
+	info, err := os.Stat(root)
+	if err != nil {
+		return fmt.Errorf("checking upload directory: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("upload directory %s is not a directory", root)
+	}
+
+	f, err := ioutil.TempFile(root, ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("upload directory %s is not writable: %w", root, err)
+	}
+	f.Close()
+
+	return os.Remove(f.Name())
+}
+
+// Path returns path of file containing blob with provided key
+func (l *LocalBlob) Path(key string) string {
+	return filepath.Join(l.root, filepath.FromSlash(key))
+}
+
+// Put writes data to file of the key creating missing directories
+func (l *LocalBlob) Put(_ context.Context, key string, data []byte) error {
+	path := l.Path(key)
+	err := os.MkdirAll(filepath.Dir(path), 0750)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0640)
+}
+
+// PutFrom copies r to temporary file next to file of the key and renames it once copying succeeds,
+// so blob being written is never read partially
+func (l *LocalBlob) PutFrom(_ context.Context, key string, r io.Reader) error {
+	path := l.Path(key)
+	err := os.MkdirAll(filepath.Dir(path), 0750)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), ".put-*")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Chmod(0640)
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return nil
+}
+
+// Get opens file of the key
+func (l *LocalBlob) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	f, err := os.Open(l.Path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrBlobNotFound
+		}
+
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Delete removes file of the key
+func (l *LocalBlob) Delete(_ context.Context, key string) error {
+	err := os.Remove(l.Path(key))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// List walks root directory collecting regular files with keys starting with prefix
+func (l *LocalBlob) List(_ context.Context, prefix string) ([]BlobInfo, error) {
+	root := l.root
+	if root == "" {
+		root = "."
+	}
+
+	var blobs []BlobInfo
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+
+		if info.IsDir() {
+			// directories which can not contain matching keys are skipped entirely
+			if key != "." && !strings.HasPrefix(key+"/", prefix) && !strings.HasPrefix(prefix, key+"/") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.Mode().IsRegular() && strings.HasPrefix(key, prefix) {
+			blobs = append(blobs, BlobInfo{Key: key, ModifiedAt: info.ModTime()})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return blobs, nil
+}
@@ -0,0 +1,180 @@
+package postgresql
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+	"time"
+)
+
+// SaveTaskPreview sets state and result stats of task previewed with WithPreview, the task stays unfinished
+func (s *Storage) SaveTaskPreview(ctx context.Context, id string, state string, added, updated, removed, ignored, skipped, duplicates int64, reasonCounts map[string]int64) error {
+	sql := `UPDATE tasks
+               SET state = $2,
+                   added = $3,
+                   updated = $4,
+                   removed = $5,
+                   ignored = $6,
+                   skipped = $7,
+                   duplicates = $8,
+                   reason_counts = $9,
+                   updated_at = now()
+             WHERE id = $1`
+
+	if reasonCounts == nil {
+		reasonCounts = map[string]int64{}
+	}
+
+	tag, err := s.db.Exec(ctx, sql, id, state, added, updated, removed, ignored, skipped, duplicates, reasonCounts)
+	if err != nil {
+		s.log(ctx).Error("Saving task preview", zap.String("task_id", id), zap.Error(err))
+		return classify(err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrTaskNotFound
+	}
+
+	return nil
+}
+
+// TaskApproved reports whether task with provided id was approved to be applied
+func (s *Storage) TaskApproved(ctx context.Context, id string) (bool, error) {
+	var approved bool
+	err := s.db.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM task_approvals WHERE task_id = $1 AND approved)", id).Scan(&approved)
+	if err != nil {
+		s.log(ctx).Error("Checking task approval", zap.String("task_id", id), zap.Error(err))
+		return false, classify(err)
+	}
+
+	return approved, nil
+}
+
+// ApproveTask moves task from state to state saving approval with reason. Stats and checkpoint of the task are reset
+// and its preview changes are deleted, so the task is applied from scratch. Claim of task staying in the same state
+// is kept, so instance processing it goes on, otherwise the claim is reset and the task is queued again.
+// Returns updated record, ErrTaskNotFound if there is no such task and ErrConflict if the task is in other state.
+func (s *Storage) ApproveTask(ctx context.Context, id string, from, to string, reason string) (Task, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.log(ctx).Error("Starting transaction", zap.Error(err))
+		return Task{}, classify(err)
+	}
+	defer tx.Rollback(context.Background())
+
+	sql := `UPDATE tasks
+               SET state = $3,
+                   added = 0,
+                   updated = 0,
+                   removed = 0,
+                   ignored = 0,
+                   skipped = 0,
+                   duplicates = 0,
+                   reason_counts = '{}'::jsonb,
+                   checkpoint = 0,
+                   claimed_by = CASE WHEN $2 = $3 THEN claimed_by ELSE '' END,
+                   claimed_at = CASE WHEN $2 = $3 THEN claimed_at END,
+                   updated_at = now()
+             WHERE id = $1
+               AND state = $2
+         RETURNING ` + taskColumns
+
+	t, err := scanTask(tx.QueryRow(ctx, sql, id, from, to))
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			s.log(ctx).Error("Approving task", zap.String("task_id", id), zap.Error(err))
+			return Task{}, classify(err)
+		}
+
+		return Task{}, s.decisionConflict(ctx, id)
+	}
+
+	_, err = tx.Exec(ctx, "DELETE FROM task_changes WHERE task_id = $1", id)
+	if err != nil {
+		s.log(ctx).Error("Deleting preview changes", zap.String("task_id", id), zap.Error(err))
+		return Task{}, classify(err)
+	}
+
+	err = s.saveDecision(ctx, tx, id, true, reason)
+	if err != nil {
+		return Task{}, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.log(ctx).Error("Committing transaction", zap.Error(err))
+		return Task{}, classify(err)
+	}
+
+	return t, nil
+}
+
+// RejectTask sets final state of task which is in from state saving rejection with reason, preview changes
+// of the task are kept. Returns ErrTaskNotFound if there is no such task and ErrConflict if the task is in other state.
+func (s *Storage) RejectTask(ctx context.Context, id string, from, to string, finishedAt time.Time, reason string) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.log(ctx).Error("Starting transaction", zap.Error(err))
+		return classify(err)
+	}
+	defer tx.Rollback(context.Background())
+
+	sql := `UPDATE tasks
+               SET state = $3,
+                   updated_at = now(),
+                   finished_at = $4
+             WHERE id = $1
+               AND state = $2`
+
+	tag, err := tx.Exec(ctx, sql, id, from, to, finishedAt)
+	if err != nil {
+		s.log(ctx).Error("Rejecting task", zap.String("task_id", id), zap.Error(err))
+		return classify(err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return s.decisionConflict(ctx, id)
+	}
+
+	err = s.saveDecision(ctx, tx, id, false, reason)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.log(ctx).Error("Committing transaction", zap.Error(err))
+		return classify(err)
+	}
+
+	return nil
+}
+
+// saveDecision saves approval or rejection of the task within tx replacing earlier one
+func (s *Storage) saveDecision(ctx context.Context, tx pgx.Tx, id string, approved bool, reason string) error {
+	sql := `INSERT INTO task_approvals (task_id, approved, reason, decided_at)
+            VALUES ($1, $2, $3, now())
+                ON CONFLICT (task_id) DO UPDATE
+               SET approved = excluded.approved,
+                   reason = excluded.reason,
+                   decided_at = excluded.decided_at`
+
+	_, err := tx.Exec(ctx, sql, id, approved, reason)
+	if err != nil {
+		s.log(ctx).Error("Saving task decision", zap.String("task_id", id), zap.Error(err))
+		return classify(err)
+	}
+
+	return nil
+}
+
+// decisionConflict tells task which does not exist from task in unexpected state
+func (s *Storage) decisionConflict(ctx context.Context, id string) error {
+	_, err := s.ReadTask(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return ErrConflict
+}
@@ -0,0 +1,199 @@
+package postgresql
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+// kinds of TaskChange
+const (
+	ChangeUpserted = "upserted"
+	ChangeDeleted  = "deleted"
+)
+
+// TaskChange defines offer added, updated or deleted by the task. Product values are the ones written by the task,
+// they are omitted for deleted offers.
+type TaskChange struct {
+	OfferID  int64            `json:"offer_id"`
+	Change   string           `json:"change"`
+	Name     *string          `json:"name,omitempty"`
+	Price    *decimal.Decimal `json:"price,omitempty"`
+	Quantity *int64           `json:"quantity,omitempty"`
+}
+
+// recordingChanges makes Upsert and Delete save changed offers as changes of the task within their transaction,
+// offer changed several times by the task keeps the last change only. Every change is added to product history as well
+// and to outbox if it is enabled by WithOutbox.
+func recordingChanges(taskID string) TxOption {
+	return txOptionFunc(func(opts *txOptions) {
+		opts.changesTaskID = taskID
+	})
+}
+
+// upsertedChanges returns CTE saving rows returned by source CTE as upserted offers of the task passed as parameter
+func upsertedChanges(source string, parameter string) string {
+	return `changes AS
+                    (INSERT INTO task_changes (task_id, offer_id, change, name, price, quantity)
+                     SELECT ` + parameter + `, offer_id, '` + ChangeUpserted + `', name, price, quantity
+                       FROM ` + source + `
+                         ON CONFLICT (task_id, offer_id) DO UPDATE
+                        SET change = excluded.change,
+                            name = excluded.name,
+                            price = excluded.price,
+                            quantity = excluded.quantity)`
+}
+
+// recordDeleted returns statement saving offerID column of rows made unavailable by provided statement as deleted offers
+// of the task passed as parameter and to product history, events adds them to outbox as well.
+// Its rows affected count equals count of changed rows.
+func recordDeleted(deleting string, offerID string, parameter string, events bool) string {
+	sql := `WITH deleted AS
+                    (` + deleting + `
+                  RETURNING merchant_id, ` + offerID + ` AS offer_id, price, quantity),
+                 history AS
+                    (INSERT INTO product_history (merchant_id, offer_id, task_id, change, old_price, old_quantity)
+                     SELECT merchant_id, offer_id, ` + parameter + `, '` + HistoryDeleted + `', price, quantity
+                       FROM deleted)`
+	if events {
+		sql += `,
+                 events AS
+                    (INSERT INTO product_events (merchant_id, offer_id, task_id, change)
+                     SELECT merchant_id, offer_id, ` + parameter + `, '` + ChangeDeleted + `'
+                       FROM deleted)`
+	}
+
+	return sql + `
+            INSERT INTO task_changes (task_id, offer_id, change)
+            SELECT ` + parameter + `, offer_id, '` + ChangeDeleted + `'
+              FROM deleted
+                ON CONFLICT (task_id, offer_id) DO UPDATE
+               SET change = excluded.change,
+                   name = NULL,
+                   price = NULL,
+                   quantity = NULL`
+}
+
+// TaskChanges returns at most limit changes of the task ordered by offer_id starting after provided one
+// or ErrTaskNotFound
+func (s *Storage) TaskChanges(ctx context.Context, taskID string, afterOfferID int64, limit int) ([]TaskChange, error) {
+	var exists bool
+	err := s.db.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM tasks WHERE id = $1)", taskID).Scan(&exists)
+	if err != nil {
+		s.log(ctx).Error("Reading task", zap.String("task_id", taskID), zap.Error(err))
+		return nil, classify(err)
+	}
+
+	if !exists {
+		return nil, ErrTaskNotFound
+	}
+
+	sql := `SELECT offer_id, change, name, price, quantity
+              FROM task_changes
+             WHERE task_id = $1
+               AND offer_id > $2
+             ORDER BY offer_id
+             LIMIT $3`
+
+	rows, err := s.db.Query(ctx, sql, taskID, afterOfferID, limit)
+	if err != nil {
+		s.log(ctx).Error("Selecting task changes", zap.String("task_id", taskID), zap.Error(err))
+		return nil, classify(err)
+	}
+	defer rows.Close()
+
+	changes := []TaskChange{}
+	for rows.Next() {
+		var c TaskChange
+		err = rows.Scan(&c.OfferID, &c.Change, &c.Name, &c.Price, &c.Quantity)
+		if err != nil {
+			s.log(ctx).Error("Scanning row", zap.Error(err))
+			return nil, classify(err)
+		}
+
+		changes = append(changes, c)
+	}
+
+	if rows.Err() != nil {
+		return nil, classify(rows.Err())
+	}
+
+	return changes, nil
+}
+
+// keepPreviewChanges reads changes of provided offers recorded within tx, rolls tx back and saves the changes again,
+// so they outlive the rollback of catalog changes made by preview
+func (s *Storage) keepPreviewChanges(ctx context.Context, tx pgx.Tx, taskID string, toUpsert []Product, toDelete []int64) error {
+	offerIDs := make([]int64, 0, len(toUpsert)+len(toDelete))
+	for _, p := range toUpsert {
+		offerIDs = append(offerIDs, p.OfferID)
+	}
+	offerIDs = append(offerIDs, toDelete...)
+
+	sql := `SELECT offer_id, change, name, price::text, quantity
+              FROM task_changes
+             WHERE task_id = $1
+               AND offer_id = ANY ($2)`
+
+	rows, err := tx.Query(ctx, sql, taskID, offerIDs)
+	if err != nil {
+		s.log(ctx).Error("Selecting preview changes", zap.String("task_id", taskID), zap.Error(err))
+		return err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	var kinds []string
+	var names, prices []*string
+	var quantities []*int64
+	for rows.Next() {
+		var offerID int64
+		var kind string
+		var name, price *string
+		var quantity *int64
+		err = rows.Scan(&offerID, &kind, &name, &price, &quantity)
+		if err != nil {
+			s.log(ctx).Error("Scanning row", zap.Error(err))
+			return err
+		}
+
+		ids = append(ids, offerID)
+		kinds = append(kinds, kind)
+		names = append(names, name)
+		prices = append(prices, price)
+		quantities = append(quantities, quantity)
+	}
+
+	if rows.Err() != nil {
+		return rows.Err()
+	}
+
+	err = tx.Rollback(ctx)
+	if err != nil {
+		s.log(ctx).Error("Rolling back preview", zap.Error(err))
+		return err
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	sql = `INSERT INTO task_changes (task_id, offer_id, change, name, price, quantity)
+           SELECT $1, offer_id, change, name, price::numeric, quantity
+             FROM unnest($2::bigint[], $3::text[], $4::text[], $5::text[], $6::bigint[])
+                  AS previewed (offer_id, change, name, price, quantity)
+               ON CONFLICT (task_id, offer_id) DO UPDATE
+              SET change = excluded.change,
+                  name = excluded.name,
+                  price = excluded.price,
+                  quantity = excluded.quantity`
+
+	_, err = s.db.Exec(ctx, sql, taskID, ids, kinds, names, prices, quantities)
+	if err != nil {
+		s.log(ctx).Error("Saving preview changes", zap.String("task_id", taskID), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package postgresql
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+	"time"
+)
+
+// TaskChunk defines stats of single transaction of chunked import
+type TaskChunk struct {
+	// Number is one-based position of the chunk within the task
+	Number int64 `json:"number"`
+	// StartRow and EndRow define range [StartRow, EndRow) of workbook rows applied by the chunk
+	StartRow   int64     `json:"start_row"`
+	EndRow     int64     `json:"end_row"`
+	Added      int64     `json:"added"`
+	Updated    int64     `json:"updated"`
+	Removed    int64     `json:"removed"`
+	Ignored    int64     `json:"ignored"`
+	Skipped    int64     `json:"skipped"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	DurationMS int64     `json:"duration_ms"`
+}
+
+// saveChunk inserts stats of the chunk being committed by UpsertAndDelete within its transaction
+func (s *Storage) saveChunk(ctx context.Context, tx pgx.Tx, parameters *importParameters, stats ImportStats, startedAt time.Time) error {
+	sql := `INSERT INTO task_chunks (task_id, start_row, end_row, added, updated, removed, ignored, skipped, started_at, finished_at)
+            SELECT id, checkpoint, $2, $3, $4, $5, $6, $7, $8, $9
+              FROM tasks
+             WHERE id = $1`
+
+	_, err := tx.Exec(ctx, sql, parameters.checkpointTaskID, parameters.checkpoint, stats.Added, stats.Updated, stats.Removed, parameters.ignored, stats.Skipped, startedAt, time.Now())
+	if err != nil {
+		s.log(ctx).Error("Saving task chunk", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// TaskChunks returns stats of committed chunks of the task in order they were applied or ErrTaskNotFound
+func (s *Storage) TaskChunks(ctx context.Context, taskID string) ([]TaskChunk, error) {
+	var exists bool
+	err := s.db.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM tasks WHERE id = $1)", taskID).Scan(&exists)
+	if err != nil {
+		s.log(ctx).Error("Reading task", zap.String("task_id", taskID), zap.Error(err))
+		return nil, classify(err)
+	}
+
+	if !exists {
+		return nil, ErrTaskNotFound
+	}
+
+	sql := `SELECT row_number() OVER (ORDER BY end_row),
+                   start_row, end_row, added, updated, removed, ignored, skipped, started_at, finished_at
+              FROM task_chunks
+             WHERE task_id = $1
+             ORDER BY end_row`
+
+	rows, err := s.db.Query(ctx, sql, taskID)
+	if err != nil {
+		s.log(ctx).Error("Selecting task chunks", zap.String("task_id", taskID), zap.Error(err))
+		return nil, classify(err)
+	}
+	defer rows.Close()
+
+	chunks := []TaskChunk{}
+	for rows.Next() {
+		var c TaskChunk
+		err = rows.Scan(&c.Number, &c.StartRow, &c.EndRow, &c.Added, &c.Updated, &c.Removed, &c.Ignored, &c.Skipped, &c.StartedAt, &c.FinishedAt)
+		if err != nil {
+			s.log(ctx).Error("Scanning row", zap.Error(err))
+			return nil, classify(err)
+		}
+
+		c.DurationMS = c.FinishedAt.Sub(c.StartedAt).Milliseconds()
+		chunks = append(chunks, c)
+	}
+
+	if rows.Err() != nil {
+		return nil, classify(rows.Err())
+	}
+
+	return chunks, nil
+}
@@ -0,0 +1,140 @@
+package postgresql
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// formats of COPY used for bulk inserts
+const (
+	// CopyFormatBinary sends typed values in binary format, it is the default and the fastest one
+	CopyFormatBinary = "binary"
+	// CopyFormatText sends values as text, it is fallback for proxies and servers failing binary COPY
+	CopyFormatText = "text"
+)
+
+// ParseCopyFormat validates name of COPY format, empty name means CopyFormatBinary
+func ParseCopyFormat(name string) (string, error) {
+	switch name {
+	case "", CopyFormatBinary:
+		return CopyFormatBinary, nil
+	case CopyFormatText:
+		return CopyFormatText, nil
+	default:
+		return "", fmt.Errorf("copy format must be either %s or %s, got %q", CopyFormatBinary, CopyFormatText, name)
+	}
+}
+
+// WithCopyFormat applies passed format to COPY of upserted products, deleted offer ids and rejected rows
+func WithCopyFormat(format string) StorageOption {
+	return func(s *Storage) {
+		s.copyFormat = format
+	}
+}
+
+// copyFrom inserts rows of src into table within tx using COPY in configured format and returns number of copied rows
+func (s *Storage) copyFrom(ctx context.Context, tx pgx.Tx, table string, columns []string, src pgx.CopyFromSource) (int64, error) {
+	if s.copyFormat != CopyFormatText {
+		return tx.CopyFrom(ctx, pgx.Identifier{table}, columns, src)
+	}
+
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = pgx.Identifier{c}.Sanitize()
+	}
+	sql := "COPY " + pgx.Identifier{table}.Sanitize() + " (" + strings.Join(quoted, ", ") + ") FROM STDIN"
+
+	// rows are encoded while previous ones are being sent, so the whole text is never kept in memory
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(encodeCopyText(w, src))
+	}()
+
+	tag, err := tx.Conn().PgConn().CopyFrom(ctx, r, sql)
+	// unblocks encoding if COPY has failed before reading all rows
+	r.CloseWithError(io.ErrClosedPipe)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// encodeCopyText writes rows of src to w in text format of COPY
+func encodeCopyText(w io.Writer, src pgx.CopyFromSource) error {
+	var buf []byte
+	for src.Next() {
+		values, err := src.Values()
+		if err != nil {
+			return err
+		}
+
+		buf = buf[:0]
+		for i, v := range values {
+			if i > 0 {
+				buf = append(buf, '\t')
+			}
+
+			buf, err = appendCopyText(buf, v)
+			if err != nil {
+				return err
+			}
+		}
+		buf = append(buf, '\n')
+
+		_, err = w.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+
+	return src.Err()
+}
+
+// appendCopyText appends value in text format of COPY to buf, nil and NULL values are written as \N
+func appendCopyText(buf []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(buf, `\N`...), nil
+	case int64:
+		return strconv.AppendInt(buf, v, 10), nil
+	case string:
+		return appendCopyEscaped(buf, v), nil
+	case pgtype.TextEncoder:
+		text, err := v.EncodeText(nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		if text == nil {
+			return append(buf, `\N`...), nil
+		}
+		return appendCopyEscaped(buf, string(text)), nil
+	default:
+		return nil, fmt.Errorf("value of type %T can not be copied as text", v)
+	}
+}
+
+// appendCopyEscaped appends s to buf escaping characters special for text format of COPY
+func appendCopyEscaped(buf []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			buf = append(buf, `\\`...)
+		case '\t':
+			buf = append(buf, `\t`...)
+		case '\n':
+			buf = append(buf, `\n`...)
+		case '\r':
+			buf = append(buf, `\r`...)
+		default:
+			buf = append(buf, c)
+		}
+	}
+
+	return buf
+}
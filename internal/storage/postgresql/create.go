@@ -0,0 +1,111 @@
+package postgresql
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+)
+
+// ErrProductExists is returned when merchant already has available product with offer id of created one
+var ErrProductExists = errors.New("product already exists")
+
+// AllocateOfferID returns offer id greater than any offer id the merchant has used so far, including unavailable
+// and archived offers. Ids are taken from per-merchant sequence in offer_id_sequences, so concurrent calls never
+// return the same id, while imports may still upload it later like any other offer id.
+func (s *Storage) AllocateOfferID(ctx context.Context, merchantID int64) (int64, error) {
+	sql := `INSERT INTO offer_id_sequences AS sequences (merchant_id, last_offer_id)
+            SELECT $1, GREATEST((SELECT max(offer_id) FROM ` + s.productsTable(merchantID) + ` WHERE merchant_id = $1),
+                                (SELECT max(offer_id) FROM ` + s.archiveTable(merchantID) + ` WHERE merchant_id = $1),
+                                0) + 1
+                ON CONFLICT (merchant_id) DO UPDATE
+               SET last_offer_id = GREATEST(sequences.last_offer_id + 1, excluded.last_offer_id)
+         RETURNING last_offer_id::bigint`
+
+	var offerID int64
+	err := s.db.QueryRow(ctx, sql, merchantID).Scan(&offerID)
+	if err != nil {
+		s.log(ctx).Error("Allocating offer id", zap.Int64("merchant_id", merchantID), zap.Error(err))
+		return 0, classify(err)
+	}
+
+	return offerID, nil
+}
+
+// CreateProduct inserts available product with name, price and quantity of p and returns saved one.
+// Unavailable offer with the same id is made available again, while available one makes ErrProductExists returned.
+// Catalog which has reached limit of WithCatalogLimit makes CatalogLimitError returned, frozen one ErrMerchantFrozen.
+func (s *Storage) CreateProduct(ctx context.Context, p Product) (Product, error) {
+	ctx, cancel := s.writeContext(ctx)
+	defer cancel()
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.log(ctx).Error("Starting transaction", zap.Error(err))
+		return Product{}, classify(err)
+	}
+	defer tx.Rollback(context.Background())
+
+	err = checkNotFrozen(ctx, tx, p.MerchantID)
+	if err != nil {
+		return Product{}, classify(err)
+	}
+
+	table := s.productsTable(p.MerchantID)
+	if s.maxCatalogSize > 0 {
+		// imports of the merchant hold the same lock while checking catalog size
+		_, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", p.MerchantID)
+		if err != nil {
+			s.log(ctx).Error("Acquiring merchant lock", zap.Error(err))
+			return Product{}, classify(err)
+		}
+
+		var count int64
+		sql := "SELECT count(*) FROM " + table + " WHERE merchant_id = $1 AND is_available"
+		err = tx.QueryRow(ctx, sql, p.MerchantID).Scan(&count)
+		if err != nil {
+			s.log(ctx).Error("Counting merchant products", zap.Error(err))
+			return Product{}, classify(err)
+		}
+
+		if count >= s.maxCatalogSize {
+			return Product{}, &CatalogLimitError{
+				MerchantID:   p.MerchantID,
+				Limit:        s.maxCatalogSize,
+				CurrentCount: count,
+				ResultCount:  count + 1,
+			}
+		}
+	}
+
+	sql := `INSERT INTO ` + table + ` AS products (merchant_id, offer_id, name, price, quantity)
+            VALUES ($1, $2, $3, $4, $5)
+                ON CONFLICT (merchant_id, offer_id) DO UPDATE
+               SET name = excluded.name,
+                   price = excluded.price,
+                   quantity = excluded.quantity,
+                   original_price = NULL,
+                   original_currency = NULL,
+                   last_seen_at = now(),
+                   is_available = true
+             WHERE NOT products.is_available
+         RETURNING ` + productSelectColumns
+
+	created, err := scanProduct(tx.QueryRow(ctx, sql, p.MerchantID, p.OfferID, p.Name, p.Price, p.Quantity))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Product{}, ErrProductExists
+		}
+
+		s.log(ctx).Error("Inserting product", zap.Int64("merchant_id", p.MerchantID), zap.Int64("offer_id", p.OfferID), zap.Error(err))
+		return Product{}, classify(err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.log(ctx).Error("Committing transaction", zap.Error(err))
+		return Product{}, classify(err)
+	}
+
+	return created, nil
+}
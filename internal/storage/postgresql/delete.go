@@ -4,54 +4,66 @@ import (
 	"context"
 	"errors"
 	"github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
 	"strconv"
 	"strings"
 )
 
-// Delete performs variable-step transaction in order to delete provided products.
-// A. Transaction will have one step if Product slice length is relatively small.
-// B. Transaction will have three steps if Product slice length is relatively big.
-// The actual values of "small" and "big" should be found by tests, but for now let's
-// state that less than 500 is small.
+// Delete performs variable-step transaction in order to mark provided products as unavailable.
+// Rows are kept with is_available set to false instead of being deleted, so flapping availability
+// does not churn the table, and already unavailable ones are not touched again.
+// A. Transaction will have one step if offerIDs slice length does not exceed threshold.
+// B. Transaction will have three steps if offerIDs slice length exceeds threshold.
+// Threshold is DefaultLargeDeleteThreshold unless WithLargeDeleteThreshold is passed, in particular
+// it may be selected by round trip time measured within the transaction, see deleteThreshold.
 //
 // Transaction B has following steps:
 // 1. create temporary table
 // 2. fill it via bulkProducts insert with incoming data
-// 3. perform delete using temporary table
+// 3. perform update using temporary table
 //
 // Delete will run as nested transaction providing asNestedTo option. By default it runs as stand-alone one.
+// With recordingChanges option deleted offers are saved as changes of the task and to product history.
 //
-// Returns deleted rows and an error.
-func (s *Storage) Delete(ctx context.Context, merchantID int64, offerIDs []int64, options ...txOption) (int64, error) {
-	// TODO: set "large" definition as external parameter, e.g. field in Storage
-	isLarge := len(offerIDs) > 500
+// Returns number of products made unavailable and an error.
+func (s *Storage) Delete(ctx context.Context, merchantID int64, offerIDs []int64, options ...TxOption) (int64, error) {
 	var deleted int64
 
 	txOptions := buildOptions(options...)
 	var tx pgx.Tx
 	var err error
 	if txOptions.runAsChild {
-		s.logger.Debug("Running delete as nested transaction")
+		s.log(ctx).Debug("Running delete as nested transaction")
 		tx, err = txOptions.parentTx.Begin(ctx)
 	} else {
-		s.logger.Debug("Running delete as stand-alone transaction")
+		s.log(ctx).Debug("Running delete as stand-alone transaction")
 		tx, err = s.db.Begin(ctx)
 	}
 
 	if err != nil {
-		s.logger.Error("Begin delete transaction")
-		return 0, err
+		s.log(ctx).Error("Begin delete transaction")
+		return 0, classify(err)
 	}
 	// error handling can be omitted for rollback according to docs
 	// see https://pkg.go.dev/github.com/jackc/pgx/v4?tab=doc#hdr-Transactions or any source comment on Rollback
 	// TODO: define timeout for transaction rollback
 	defer tx.Rollback(context.Background())
 
+	threshold, err := s.deleteThreshold(ctx, tx)
+	if err != nil {
+		s.log(ctx).Error("Measuring round trip time")
+		return 0, classify(err)
+	}
+	isLarge := int64(len(offerIDs)) > threshold
+	s.log(ctx).Debug("Selecting delete strategy", zap.Int("offers", len(offerIDs)), zap.Int64("threshold", threshold))
+
 	if !isLarge {
-		s.logger.Debug("Performing 'values based' delete")
+		s.log(ctx).Debug("Performing 'values based' delete")
 
-		sql := `DELETE FROM products
+		sql := `UPDATE ` + s.productsTable(merchantID) + `
+                   SET is_available = false
                  WHERE merchant_id = $1
+                   AND is_available
                    AND offer_id IN (VALUES `
 
 		builder := new(strings.Builder)
@@ -66,50 +78,65 @@ func (s *Storage) Delete(ctx context.Context, merchantID int64, offerIDs []int64
 		builder.WriteString(strconv.FormatInt(offerIDs[i], 10))
 		builder.WriteString("))")
 
-		tag, err := tx.Exec(ctx, builder.String(), merchantID)
+		sql = builder.String()
+		args := []interface{}{merchantID}
+		if txOptions.changesTaskID != "" {
+			sql = recordDeleted(sql, "offer_id", "$2", s.outbox)
+			args = append(args, txOptions.changesTaskID)
+		}
+
+		tag, err := tx.Exec(ctx, sql, args...)
 		if err != nil {
-			s.logger.Error("Performing 'values based' delete")
-			return 0, err
+			s.log(ctx).Error("Performing 'values based' delete")
+			return 0, classify(err)
 		}
 
 		deleted = tag.RowsAffected()
 	} else {
-		s.logger.Debug("Performing 'temporary table based' delete")
+		s.log(ctx).Debug("Performing 'temporary table based' delete")
 
-		s.logger.Debug("Creating temporary table")
+		s.log(ctx).Debug("Creating temporary table")
 
 		sql := `CREATE TEMPORARY TABLE offer_ids_temporary (offer_id offer_id)
                     ON COMMIT DROP`
 
 		_, err = tx.Exec(ctx, sql)
 		if err != nil {
-			s.logger.Error("Create temporary table")
-			return 0, err
+			s.log(ctx).Error("Create temporary table")
+			return 0, classify(err)
 		}
 
-		s.logger.Debug("Performing bulk insert on temporary table")
+		s.log(ctx).Debug("Performing bulk insert on temporary table")
 
 		bulkData := &bulkOfferIDs{
 			rows: offerIDs,
 			idx:  -1,
 		}
-		_, err = tx.CopyFrom(ctx, pgx.Identifier{"offer_ids_temporary"}, []string{"offer_id"}, bulkData)
+		_, err = s.copyFrom(ctx, tx, "offer_ids_temporary", []string{"offer_id"}, bulkData)
 		if err != nil {
-			s.logger.Error("Bulk insert")
-			return 0, err
+			s.log(ctx).Error("Bulk insert")
+			return 0, classify(err)
 		}
 
-		s.logger.Debug("Performing delete using temporary table")
+		s.log(ctx).Debug("Performing delete using temporary table")
 
-		sql = `DELETE FROM products
-                USING offer_ids_temporary
+		sql = `UPDATE ` + s.productsTable(merchantID) + ` AS products
+                  SET is_available = false
+                 FROM offer_ids_temporary
                 WHERE merchant_id = $1
+                  AND products.is_available
                   AND products.offer_id = offer_ids_temporary.offer_id`
 
-		tag, err := tx.Exec(ctx, sql, merchantID)
+		args := []interface{}{merchantID}
+		if txOptions.changesTaskID != "" {
+			sql = recordDeleted(sql, "products.offer_id", "$2", s.outbox)
+			args = append(args, txOptions.changesTaskID)
+		}
+
+		tag, err := tx.Exec(ctx, sql, args...)
 		if err != nil {
-			s.logger.Error("Delete using temporary table")
-			return 0, err
+			s.log(ctx).Error("Delete using temporary table")
+			return 0, classify(err)
 		}
 
 		deleted = tag.RowsAffected()
@@ -119,25 +146,25 @@ func (s *Storage) Delete(ctx context.Context, merchantID int64, offerIDs []int64
 	if ctxErr != nil {
 		switch {
 		case errors.Is(ctxErr, context.DeadlineExceeded):
-			s.logger.Info("Task deadline exceeded")
+			s.log(ctx).Info("Task deadline exceeded")
 			return 0, ctxErr
 
 		case errors.Is(ctxErr, context.Canceled):
-			s.logger.Info("Task is canceled")
+			s.log(ctx).Info("Task is canceled")
 			return 0, ctxErr
 		}
 	}
 
 	if txOptions.runAsChild {
-		s.logger.Debug("Committing nested delete transaction")
+		s.log(ctx).Debug("Committing nested delete transaction")
 	} else {
-		s.logger.Debug("Committing stand-alone delete transaction")
+		s.log(ctx).Debug("Committing stand-alone delete transaction")
 	}
 
 	err = tx.Commit(ctx)
 	if err != nil {
-		s.logger.Error("Commit nested delete transaction")
-		return 0, err
+		s.log(ctx).Error("Commit nested delete transaction")
+		return 0, classify(err)
 	}
 
 	return deleted, nil
@@ -0,0 +1,93 @@
+package postgresql
+
+import (
+	"context"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// benchMerchantID is merchant whose rows are written by benchmarks, it differs from one of cmd/bench
+const benchMerchantID = 2147483001
+
+// benchStorage connects to database configured via PG* environment variables or skips benchmark
+// if it is not available
+func benchStorage(b *testing.B, options ...StorageOption) *Storage {
+	b.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s, err := NewStorage(ctx, zap.NewNop(), options...)
+	if err != nil {
+		b.Skipf("database is not available: %v", err)
+	}
+
+	err = s.Ping(ctx)
+	if err != nil {
+		s.Close()
+		b.Skipf("database is not available: %v", err)
+	}
+
+	return s
+}
+
+// benchmarkDelete measures Delete of catalogs of different sizes with provided threshold,
+// catalog is upserted again before every Delete outside of measured time
+func benchmarkDelete(b *testing.B, threshold int64) {
+	s := benchStorage(b, WithLargeDeleteThreshold(threshold))
+	defer s.Close()
+
+	ctx := context.Background()
+	defer s.DeleteMerchantProducts(ctx, benchMerchantID)
+
+	for _, size := range []int{100, 1000, 10000} {
+		products := make([]Product, size)
+		offerIDs := make([]int64, size)
+		for i := range products {
+			products[i] = Product{
+				MerchantID: benchMerchantID,
+				OfferID:    int64(i + 1),
+				Name:       "Benchmark product " + strconv.Itoa(i+1),
+				Price:      decimal.New(int64(i%100000+1), -2),
+				Quantity:   int64(i%1000 + 1),
+			}
+			offerIDs[i] = int64(i + 1)
+		}
+
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			_, err := s.DeleteMerchantProducts(ctx, benchMerchantID)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				_, _, err := s.Upsert(ctx, products)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+
+				deleted, err := s.Delete(ctx, benchMerchantID, offerIDs)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if deleted != int64(size) {
+					b.Fatalf("expected %d products deleted, got %d", size, deleted)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDeleteValues(b *testing.B) {
+	benchmarkDelete(b, math.MaxInt64)
+}
+
+func BenchmarkDeleteTemporaryTable(b *testing.B) {
+	benchmarkDelete(b, 1)
+}
@@ -0,0 +1,80 @@
+package postgresql
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultLargeDeleteThreshold is number of offer ids Delete applies by VALUES list at most unless
+	// WithLargeDeleteThreshold is passed, larger slices are applied via temporary table
+	DefaultLargeDeleteThreshold = 500
+	// DefaultDeleteIDCost is server time assumed to be spent on single offer id of VALUES list unless WithDeleteIDCost
+	// is passed. It is a rough guess, cmd/bench -delete-sizes reports the cost measured against actual database.
+	DefaultDeleteIDCost = time.Microsecond
+	// temporaryTableRoundTrips is number of statements temporary table path sends in addition to VALUES one
+	temporaryTableRoundTrips = 2
+	// minAutoDeleteThreshold and maxAutoDeleteThreshold bound threshold selected automatically
+	minAutoDeleteThreshold = 100
+	maxAutoDeleteThreshold = 50000
+	// rttSampleInterval is number of Delete calls per round trip measurement, so selecting threshold
+	// does not add a round trip to every Delete
+	rttSampleInterval = 16
+)
+
+// WithLargeDeleteThreshold makes Delete apply slices of more than n offer ids via temporary table
+// and smaller ones by VALUES list. Zero n selects threshold automatically by measured round trip time, see deleteThreshold.
+func WithLargeDeleteThreshold(n int64) StorageOption {
+	return func(s *Storage) {
+		s.largeDeleteThreshold = n
+	}
+}
+
+// WithDeleteIDCost applies passed server time spent on single offer id of VALUES list,
+// it is used to select threshold automatically, see deleteThreshold
+func WithDeleteIDCost(d time.Duration) StorageOption {
+	return func(s *Storage) {
+		s.deleteIDCost = d
+	}
+}
+
+// deleteThreshold returns number of offer ids Delete applies by VALUES list at most. Unless threshold is configured
+// it is selected by average round trip time of SELECT 1 sent within tx, so time of acquiring pooled connection
+// is not counted: temporary table path takes temporaryTableRoundTrips more round trips, while VALUES list costs
+// deleteIDCost per offer id, so the latter pays off while it is shorter than rtt times temporaryTableRoundTrips
+// over deleteIDCost. Round trip is measured by the first and then every rttSampleInterval call.
+func (s *Storage) deleteThreshold(ctx context.Context, tx pgx.Tx) (int64, error) {
+	if s.largeDeleteThreshold > 0 {
+		return s.largeDeleteThreshold, nil
+	}
+
+	average := atomic.LoadInt64(&s.deleteRTT)
+	if atomic.AddInt64(&s.deleteCalls, 1)%rttSampleInterval == 1 {
+		started := time.Now()
+		_, err := tx.Exec(ctx, "SELECT 1")
+		if err != nil {
+			return 0, err
+		}
+		rtt := int64(time.Since(started))
+
+		// exponential moving average smooths out single slow round trips, the first one is taken as is
+		if average == 0 {
+			average = rtt
+		} else {
+			average += (rtt - average) / 8
+		}
+		atomic.StoreInt64(&s.deleteRTT, average)
+	}
+
+	threshold := average * temporaryTableRoundTrips / int64(s.deleteIDCost)
+	switch {
+	case threshold < minAutoDeleteThreshold:
+		return minAutoDeleteThreshold, nil
+	case threshold > maxAutoDeleteThreshold:
+		return maxAutoDeleteThreshold, nil
+	default:
+		return threshold, nil
+	}
+}
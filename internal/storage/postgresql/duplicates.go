@@ -0,0 +1,53 @@
+package postgresql
+
+import (
+	"context"
+	"go.uber.org/zap"
+)
+
+// DuplicateGroup defines offers of the same merchant sharing normalized product name
+type DuplicateGroup struct {
+	NormalizedName string  `json:"normalized_name"`
+	OfferIDs       []int64 `json:"offer_ids"`
+}
+
+// FindDuplicates returns groups of merchant offers which names are equal after normalization,
+// i.e. trimming, collapsing whitespaces and lowering case. Biggest groups go first.
+func (s *Storage) FindDuplicates(ctx context.Context, merchantID int64) ([]DuplicateGroup, error) {
+	ctx, cancel := s.readContext(ctx)
+	defer cancel()
+
+	sql := `SELECT lower(regexp_replace(btrim(name), '\s+', ' ', 'g'))::text AS normalized_name,
+                   array_agg(offer_id::bigint ORDER BY offer_id) AS offer_ids
+              FROM ` + s.productsTable(merchantID) + `
+             WHERE merchant_id = $1
+               AND is_available
+             GROUP BY normalized_name
+            HAVING count(*) > 1
+             ORDER BY count(*) DESC, normalized_name`
+
+	rows, err := s.db.Query(ctx, sql, merchantID)
+	if err != nil {
+		s.log(ctx).Error("Selecting duplicates", zap.Error(err))
+		return nil, classify(err)
+	}
+	defer rows.Close()
+
+	groups := []DuplicateGroup{}
+	for rows.Next() {
+		var g DuplicateGroup
+		err = rows.Scan(&g.NormalizedName, &g.OfferIDs)
+		if err != nil {
+			s.log(ctx).Error("Scanning row", zap.Error(err))
+			return nil, classify(err)
+		}
+
+		groups = append(groups, g)
+	}
+
+	if rows.Err() != nil {
+		return nil, classify(rows.Err())
+	}
+
+	return groups, nil
+}
@@ -1,34 +1,62 @@
 package postgresql
 
 import (
-	"errors"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
 	"github.com/shopspring/decimal"
 )
 
-var floatErr = errors.New("decimal value can not be presented as float64")
-
 type Product struct {
-	MerchantID int64           `json:"merchant_id"`
-	OfferID    int64           `json:"offer_id"`
-	Name       string          `json:"name"`
-	Price      decimal.Decimal `json:"price"`
-	Quantity   int64           `json:"quantity"`
+	MerchantID int64           `json:"merchant_id" csv:"merchant_id"`
+	OfferID    int64           `json:"offer_id" csv:"offer_id"`
+	Name       string          `json:"name" csv:"name"`
+	Price      decimal.Decimal `json:"price" csv:"price"`
+	Quantity   int64           `json:"quantity" csv:"quantity"`
+	// Available is false for offers uploaded as unavailable, they are kept in catalog but not listed by default
+	Available bool `json:"available" csv:"available"`
+	// OriginalPrice and OriginalCurrency keep uploaded price if Price is converted to base currency
+	OriginalPrice    *decimal.Decimal `json:"original_price,omitempty" csv:"original_price,omitempty"`
+	OriginalCurrency string           `json:"original_currency,omitempty" csv:"original_currency,omitempty"`
+}
+
+// productSelectColumns defines columns scanned by scanProduct in the same order
+const productSelectColumns = `merchant_id, offer_id, name, price, quantity, is_available, original_price,
+                              COALESCE(original_currency, '')`
+
+func scanProduct(row pgx.Row) (Product, error) {
+	var p Product
+	err := row.Scan(&p.MerchantID, &p.OfferID, &p.Name, &p.Price, &p.Quantity, &p.Available, &p.OriginalPrice, &p.OriginalCurrency)
+	return p, err
+}
+
+// numeric converts decimal into exact numeric value of COPY row reusing its coefficient and exponent,
+// so price is neither rounded through float64 nor formatted and parsed back
+func numeric(d decimal.Decimal) (*pgtype.Numeric, error) {
+	return &pgtype.Numeric{Int: d.Coefficient(), Exp: d.Exponent(), Status: pgtype.Present}, nil
 }
 
 func (p Product) interfaceSlice() ([]interface{}, error) {
-	floatPrice, ok := p.Price.Float64()
-	if !ok {
-		// the magnitude of underlying value is too big
-		// see comments for https://github.com/shopspring/decimal/blob/v1.2.0/decimal.go#L729
-		// and https://golang.org/src/math/big/rat.go?s=8033:8080#L279
-		return nil, floatErr
+	price, err := numeric(p.Price)
+	if err != nil {
+		return nil, err
+	}
+
+	var originalPrice, originalCurrency interface{}
+	if p.OriginalPrice != nil {
+		originalPrice, err = numeric(*p.OriginalPrice)
+		if err != nil {
+			return nil, err
+		}
+		originalCurrency = p.OriginalCurrency
 	}
 
 	return []interface{}{
 		p.MerchantID,
 		p.OfferID,
 		p.Name,
-		floatPrice,
+		price,
 		p.Quantity,
+		originalPrice,
+		originalCurrency,
 	}, nil
 }
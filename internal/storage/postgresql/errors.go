@@ -0,0 +1,112 @@
+package postgresql
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgconn"
+	"net"
+	"strings"
+)
+
+// errors returned by Storage methods wrap pgconn errors into one of these kinds, so callers can tell them apart
+// via errors.Is while original error is still available via errors.As
+var (
+	// ErrConflict means operation conflicted with concurrent one or existing row and may succeed if repeated
+	ErrConflict = errors.New("conflicting operation")
+	// ErrTooLarge means value or statement exceeds database limits
+	ErrTooLarge = errors.New("value is too large")
+	// ErrConstraintViolation means data violates table or domain constraint
+	ErrConstraintViolation = errors.New("constraint violation")
+	// ErrUnavailable means database can not be reached at the moment
+	ErrUnavailable = errors.New("database is unavailable")
+)
+
+// errorKinds maps SQLSTATE codes to error kinds, codes missing here are classified by their class
+// see https://www.postgresql.org/docs/current/errcodes-appendix.html
+var errorKinds = map[string]error{
+	"40001": ErrConflict,    // serialization_failure
+	"40P01": ErrConflict,    // deadlock_detected
+	"55P03": ErrConflict,    // lock_not_available
+	"23505": ErrConflict,    // unique_violation
+	"22001": ErrTooLarge,    // string_data_right_truncation
+	"22003": ErrTooLarge,    // numeric_value_out_of_range
+	"53300": ErrUnavailable, // too_many_connections
+	"57P01": ErrUnavailable, // admin_shutdown
+	"57P02": ErrUnavailable, // crash_shutdown
+	"57P03": ErrUnavailable, // cannot_connect_now
+}
+
+// errorClasses maps SQLSTATE classes to error kinds
+var errorClasses = map[string]error{
+	"08": ErrUnavailable,         // connection_exception
+	"23": ErrConstraintViolation, // integrity_constraint_violation
+	"54": ErrTooLarge,            // program_limit_exceeded
+}
+
+// classifiedError attaches kind to error returned by database driver
+type classifiedError struct {
+	kind error
+	err  error
+}
+
+func (e *classifiedError) Error() string {
+	return e.kind.Error() + ": " + e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() error {
+	return e.err
+}
+
+func (e *classifiedError) Is(target error) bool {
+	return target == e.kind
+}
+
+// classify wraps err into its kind, errors which are not database failures are returned as is
+func classify(err error) error {
+	kind := errorKind(err)
+	if kind == nil {
+		return err
+	}
+
+	var classified *classifiedError
+	if errors.As(err, &classified) {
+		return err
+	}
+
+	return &classifiedError{kind: kind, err: err}
+}
+
+// errorKind returns kind of err or nil if it has none
+func errorKind(err error) error {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return nil
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		kind, ok := errorKinds[pgErr.Code]
+		if ok {
+			return kind
+		}
+
+		if len(pgErr.Code) >= 2 {
+			return errorClasses[pgErr.Code[:2]]
+		}
+		return nil
+	}
+
+	var netErr net.Error
+	// pgconn does not export its connect error type
+	if errors.As(err, &netErr) || strings.HasPrefix(err.Error(), "failed to connect") {
+		return ErrUnavailable
+	}
+
+	return nil
+}
+
+// IsUnavailable reports whether err means database can not be reached at the moment,
+// e.g. connection is refused or server is shutting down during failover.
+// Unlike errors.Is(err, ErrUnavailable) it also recognizes errors not returned by Storage methods.
+func IsUnavailable(err error) bool {
+	return errors.Is(err, ErrUnavailable) || errorKind(err) == ErrUnavailable
+}
@@ -0,0 +1,107 @@
+package postgresql
+
+import (
+	"context"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"time"
+)
+
+// ProductEvent defines change of the offer made by import task and saved to product_events outbox by the same
+// transaction. Change is either ChangeUpserted or ChangeDeleted, product values are omitted for deleted offers.
+type ProductEvent struct {
+	ID         int64            `json:"id"`
+	MerchantID int64            `json:"merchant_id"`
+	OfferID    int64            `json:"offer_id"`
+	TaskID     string           `json:"task_id"`
+	Change     string           `json:"change"`
+	Name       *string          `json:"name,omitempty"`
+	Price      *decimal.Decimal `json:"price,omitempty"`
+	Quantity   *int64           `json:"quantity,omitempty"`
+	CreatedAt  time.Time        `json:"created_at"`
+}
+
+// WithOutbox makes Upsert and Delete recording changes of tasks save every change to product_events outbox as well,
+// see PublishProductEvents
+func WithOutbox() StorageOption {
+	return func(s *Storage) {
+		s.outbox = true
+	}
+}
+
+// upsertedEvents returns CTE saving rows returned by source CTE to outbox as upserted offers of the task passed as parameter
+func upsertedEvents(source string, parameter string) string {
+	return `events AS
+                    (INSERT INTO product_events (merchant_id, offer_id, task_id, change, name, price, quantity)
+                     SELECT merchant_id, offer_id, ` + parameter + `, '` + ChangeUpserted + `', name, price, quantity
+                       FROM ` + source + `)`
+}
+
+// PublishProductEvents locks at most limit oldest events of outbox skipping ones locked by other instances
+// and passes them to publish. Events are deleted only if publish succeeds, so every event is published at least once.
+// Returns number of published events, zero means outbox is empty.
+func (s *Storage) PublishProductEvents(ctx context.Context, limit int, publish func(ctx context.Context, events []ProductEvent) error) (int, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.log(ctx).Error("Starting transaction", zap.Error(err))
+		return 0, classify(err)
+	}
+	defer tx.Rollback(context.Background())
+
+	sql := `SELECT id, merchant_id::bigint, offer_id::bigint, task_id, change, name, price, quantity, created_at
+              FROM product_events
+             ORDER BY id
+             LIMIT $1
+               FOR UPDATE SKIP LOCKED`
+
+	rows, err := tx.Query(ctx, sql, limit)
+	if err != nil {
+		s.log(ctx).Error("Selecting product events", zap.Error(err))
+		return 0, classify(err)
+	}
+	defer rows.Close()
+
+	var events []ProductEvent
+	for rows.Next() {
+		var e ProductEvent
+		err = rows.Scan(&e.ID, &e.MerchantID, &e.OfferID, &e.TaskID, &e.Change, &e.Name, &e.Price, &e.Quantity, &e.CreatedAt)
+		if err != nil {
+			s.log(ctx).Error("Scanning row", zap.Error(err))
+			return 0, classify(err)
+		}
+
+		events = append(events, e)
+	}
+
+	if rows.Err() != nil {
+		return 0, classify(rows.Err())
+	}
+
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	err = publish(ctx, events)
+	if err != nil {
+		return 0, err
+	}
+
+	ids := make([]int64, len(events))
+	for i, e := range events {
+		ids[i] = e.ID
+	}
+
+	_, err = tx.Exec(ctx, "DELETE FROM product_events WHERE id = ANY ($1)", ids)
+	if err != nil {
+		s.log(ctx).Error("Deleting published product events", zap.Error(err))
+		return 0, classify(err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.log(ctx).Error("Committing transaction", zap.Error(err))
+		return 0, classify(err)
+	}
+
+	return len(events), nil
+}
@@ -0,0 +1,221 @@
+package postgresql
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+	"time"
+)
+
+// actions applied by expiry rules to offers missing from imports
+const (
+	// ExpiryZeroQuantity sets quantity of stale offers to zero keeping them in the catalog
+	ExpiryZeroQuantity = "zero-quantity"
+	// ExpiryArchive moves stale offers from the catalog to products_archive table
+	ExpiryArchive = "archive"
+)
+
+// ErrNoExpiryRule is returned when merchant has no expiry rule
+var ErrNoExpiryRule = errors.New("no expiry rule")
+
+// ValidExpiryAction reports whether action is one of ExpiryZeroQuantity and ExpiryArchive
+func ValidExpiryAction(action string) bool {
+	return action == ExpiryZeroQuantity || action == ExpiryArchive
+}
+
+// ExpiryRule defines action applied to merchant offers not seen in any import for StaleAfterDays days
+type ExpiryRule struct {
+	MerchantID     int64     `json:"merchant_id"`
+	StaleAfterDays int64     `json:"stale_after_days"`
+	Action         string    `json:"action"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// ExpiredOffer defines offer expired by ExpiryRule
+type ExpiredOffer struct {
+	OfferID    int64     `json:"offer_id"`
+	Name       string    `json:"name"`
+	Action     string    `json:"action"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiredAt  time.Time `json:"expired_at"`
+}
+
+// archiveTable returns name of the table archived offers of the merchant are moved to
+func (s *Storage) archiveTable(merchantID int64) string {
+	if s.IsSandbox(merchantID) {
+		return "sandbox.products_archive"
+	}
+
+	return "products_archive"
+}
+
+// SetExpiryRule creates or replaces expiry rule of the merchant and returns saved one
+func (s *Storage) SetExpiryRule(ctx context.Context, rule ExpiryRule) (ExpiryRule, error) {
+	sql := `INSERT INTO expiry_rules (merchant_id, stale_after_days, action, updated_at)
+            VALUES ($1, $2, $3, now())
+                ON CONFLICT (merchant_id) DO UPDATE
+               SET stale_after_days = excluded.stale_after_days,
+                   action = excluded.action,
+                   updated_at = excluded.updated_at
+         RETURNING updated_at`
+
+	err := s.db.QueryRow(ctx, sql, rule.MerchantID, rule.StaleAfterDays, rule.Action).Scan(&rule.UpdatedAt)
+	if err != nil {
+		s.log(ctx).Error("Saving expiry rule", zap.Int64("merchant_id", rule.MerchantID), zap.Error(err))
+		return ExpiryRule{}, classify(err)
+	}
+
+	return rule, nil
+}
+
+// ReadExpiryRule returns expiry rule of the merchant or ErrNoExpiryRule
+func (s *Storage) ReadExpiryRule(ctx context.Context, merchantID int64) (ExpiryRule, error) {
+	sql := `SELECT merchant_id::bigint, stale_after_days::bigint, action, updated_at
+              FROM expiry_rules
+             WHERE merchant_id = $1`
+
+	var rule ExpiryRule
+	err := s.db.QueryRow(ctx, sql, merchantID).Scan(&rule.MerchantID, &rule.StaleAfterDays, &rule.Action, &rule.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ExpiryRule{}, ErrNoExpiryRule
+		}
+
+		s.log(ctx).Error("Reading expiry rule", zap.Int64("merchant_id", merchantID), zap.Error(err))
+		return ExpiryRule{}, classify(err)
+	}
+
+	return rule, nil
+}
+
+// DeleteExpiryRule deletes expiry rule of the merchant or returns ErrNoExpiryRule
+func (s *Storage) DeleteExpiryRule(ctx context.Context, merchantID int64) error {
+	tag, err := s.db.Exec(ctx, "DELETE FROM expiry_rules WHERE merchant_id = $1", merchantID)
+	if err != nil {
+		s.log(ctx).Error("Deleting expiry rule", zap.Int64("merchant_id", merchantID), zap.Error(err))
+		return classify(err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrNoExpiryRule
+	}
+
+	return nil
+}
+
+// ExpiryRules returns expiry rules of all merchants except frozen ones ordered by merchant id
+func (s *Storage) ExpiryRules(ctx context.Context) ([]ExpiryRule, error) {
+	sql := `SELECT merchant_id::bigint, stale_after_days::bigint, action, updated_at
+              FROM expiry_rules r
+             WHERE NOT EXISTS (SELECT 1 FROM merchant_freezes f WHERE f.merchant_id = r.merchant_id)
+             ORDER BY merchant_id`
+
+	rows, err := s.db.Query(ctx, sql)
+	if err != nil {
+		s.log(ctx).Error("Selecting expiry rules", zap.Error(err))
+		return nil, classify(err)
+	}
+	defer rows.Close()
+
+	var rules []ExpiryRule
+	for rows.Next() {
+		var rule ExpiryRule
+		err = rows.Scan(&rule.MerchantID, &rule.StaleAfterDays, &rule.Action, &rule.UpdatedAt)
+		if err != nil {
+			s.log(ctx).Error("Scanning row", zap.Error(err))
+			return nil, classify(err)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	if rows.Err() != nil {
+		return nil, classify(rows.Err())
+	}
+
+	return rules, nil
+}
+
+// archivedColumns defines product columns copied to products_archive, they are listed explicitly,
+// so archive tables migrated by adding columns after archived_at are filled correctly
+const archivedColumns = `merchant_id, offer_id, name, price, quantity, original_price, original_currency, last_seen_at, is_available`
+
+// ExpireOffers applies rule to merchant offers last seen in imports before staleBefore
+// and records them in expired_offers with provided expiry moment. Returns number of expired offers.
+// Offers which already have zero quantity are not expired by ExpiryZeroQuantity again until seen in import.
+func (s *Storage) ExpireOffers(ctx context.Context, rule ExpiryRule, staleBefore time.Time, expiredAt time.Time) (int64, error) {
+	table := s.productsTable(rule.MerchantID)
+
+	// data-modifying statements can not be nested, so each action defines top level expired CTE
+	var expired string
+	switch rule.Action {
+	case ExpiryZeroQuantity:
+		expired = `expired AS
+                    (UPDATE ` + table + `
+                        SET quantity = 0
+                      WHERE merchant_id = $1
+                        AND last_seen_at < $2
+                        AND quantity > 0
+                  RETURNING offer_id, name, last_seen_at)`
+	case ExpiryArchive:
+		expired = `archived AS
+                    (DELETE FROM ` + table + `
+                      WHERE merchant_id = $1
+                        AND last_seen_at < $2
+                  RETURNING *),
+                 expired AS
+                    (INSERT INTO ` + s.archiveTable(rule.MerchantID) + `
+                            (` + archivedColumns + `, archived_at)
+                     SELECT ` + archivedColumns + `, $4::timestamptz FROM archived
+                  RETURNING offer_id, name, last_seen_at)`
+	default:
+		return 0, errors.New("unknown expiry action " + rule.Action)
+	}
+
+	sql := `WITH ` + expired + `
+            INSERT INTO expired_offers (merchant_id, offer_id, name, action, last_seen_at, expired_at)
+            SELECT $1, offer_id, name, $3::text, last_seen_at, $4 FROM expired`
+
+	tag, err := s.db.Exec(ctx, sql, rule.MerchantID, staleBefore, rule.Action, expiredAt)
+	if err != nil {
+		s.log(ctx).Error("Expiring offers", zap.Int64("merchant_id", rule.MerchantID), zap.String("action", rule.Action), zap.Error(err))
+		return 0, classify(err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// ExpiredOffers returns offers of the merchant expired after since, most recently expired first
+func (s *Storage) ExpiredOffers(ctx context.Context, merchantID int64, since time.Time) ([]ExpiredOffer, error) {
+	sql := `SELECT offer_id::bigint, name::text, action, last_seen_at, expired_at
+              FROM expired_offers
+             WHERE merchant_id = $1
+               AND expired_at >= $2
+             ORDER BY expired_at DESC, offer_id`
+
+	rows, err := s.db.Query(ctx, sql, merchantID, since)
+	if err != nil {
+		s.log(ctx).Error("Selecting expired offers", zap.Int64("merchant_id", merchantID), zap.Error(err))
+		return nil, classify(err)
+	}
+	defer rows.Close()
+
+	offers := []ExpiredOffer{}
+	for rows.Next() {
+		var o ExpiredOffer
+		err = rows.Scan(&o.OfferID, &o.Name, &o.Action, &o.LastSeenAt, &o.ExpiredAt)
+		if err != nil {
+			s.log(ctx).Error("Scanning row", zap.Error(err))
+			return nil, classify(err)
+		}
+
+		offers = append(offers, o)
+	}
+
+	if rows.Err() != nil {
+		return nil, classify(rows.Err())
+	}
+
+	return offers, nil
+}
@@ -0,0 +1,88 @@
+package postgresql
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// explainTimeout limits time spent on capturing plan of single slow query
+const explainTimeout = 10 * time.Second
+
+// WithSlowQueryExplain makes Storage log EXPLAIN (ANALYZE, BUFFERS) output for list and search queries
+// taking longer than threshold. Only sampleRate share of slow queries is explained, since the query is executed again.
+func WithSlowQueryExplain(threshold time.Duration, sampleRate float64) StorageOption {
+	return func(s *Storage) {
+		s.slowQueryThreshold = threshold
+		s.explainSampleRate = sampleRate
+	}
+}
+
+// observeQuery checks duration of query started at provided moment and captures its plan in background if it is slow
+func (s *Storage) observeQuery(ctx context.Context, name string, started time.Time, sql string, args ...interface{}) {
+	elapsed := time.Since(started)
+	if s.slowQueryThreshold <= 0 || elapsed < s.slowQueryThreshold {
+		return
+	}
+
+	// logger of the request carries its id
+	logger := s.log(ctx).With(
+		zap.String("query", name),
+		zap.Duration("duration", elapsed),
+		zap.String("sql", sql),
+	)
+
+	if rand.Float64() >= s.explainSampleRate {
+		logger.Info("Slow query")
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), explainTimeout)
+		defer cancel()
+
+		plan, err := s.explain(ctx, sql, args...)
+		if err != nil {
+			logger.Error("Explaining slow query", zap.Error(err))
+			return
+		}
+
+		logger.Info("Slow query", zap.String("plan", plan))
+	}()
+}
+
+// explain returns text representation of the actual query plan
+func (s *Storage) explain(ctx context.Context, sql string, args ...interface{}) (string, error) {
+	// EXPLAIN ANALYZE really executes the statement, read only transaction guarantees it has no side effects
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(context.Background())
+
+	rows, err := tx.Query(ctx, "EXPLAIN (ANALYZE, BUFFERS) "+sql, args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var line string
+		err = rows.Scan(&line)
+		if err != nil {
+			return "", err
+		}
+
+		lines = append(lines, line)
+	}
+
+	if rows.Err() != nil {
+		return "", rows.Err()
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
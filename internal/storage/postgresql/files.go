@@ -0,0 +1,109 @@
+package postgresql
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"time"
+)
+
+// TaskFile defines uploaded file of finished task
+type TaskFile struct {
+	TaskID string
+	// Path is key of the file in blob storage
+	Path string
+}
+
+// ExpiredTaskFiles returns at most limit not yet removed files of tasks finished before provided moment,
+// archived tasks included
+func (s *Storage) ExpiredTaskFiles(ctx context.Context, finishedBefore time.Time, limit int) ([]TaskFile, error) {
+	sql := `(SELECT id, file_path
+               FROM tasks
+              WHERE finished_at < $1
+                AND file_removed_at IS NULL
+                AND file_path <> ''
+              ORDER BY finished_at)
+             UNION ALL
+            (SELECT id, file_path
+               FROM tasks_archive
+              WHERE finished_at < $1
+                AND file_removed_at IS NULL
+                AND file_path <> '')
+             LIMIT $2`
+
+	rows, err := s.db.Query(ctx, sql, finishedBefore, limit)
+	if err != nil {
+		s.log(ctx).Error("Selecting expired task files", zap.Error(err))
+		return nil, classify(err)
+	}
+	defer rows.Close()
+
+	var files []TaskFile
+	for rows.Next() {
+		var f TaskFile
+		err = rows.Scan(&f.TaskID, &f.Path)
+		if err != nil {
+			s.log(ctx).Error("Scanning row", zap.Error(err))
+			return nil, classify(err)
+		}
+
+		files = append(files, f)
+	}
+
+	if rows.Err() != nil {
+		return nil, classify(rows.Err())
+	}
+
+	return files, nil
+}
+
+// MarkTaskFileRemoved records moment uploaded file of the task was removed, whether the task is archived or not
+func (s *Storage) MarkTaskFileRemoved(ctx context.Context, taskID string, removedAt time.Time) error {
+	sql := `WITH active AS
+                    (UPDATE tasks
+                        SET file_removed_at = $2
+                      WHERE id = $1)
+            UPDATE tasks_archive
+               SET file_removed_at = $2
+             WHERE id = $1`
+
+	_, err := s.db.Exec(ctx, sql, taskID, removedAt)
+	if err != nil {
+		s.log(ctx).Error("Marking task file removed", zap.String("task_id", taskID), zap.Error(err))
+		return classify(err)
+	}
+
+	return nil
+}
+
+// UnreferencedFiles returns those of provided blob keys which are not file of any task, archived ones included
+func (s *Storage) UnreferencedFiles(ctx context.Context, paths []string) ([]string, error) {
+	sql := `SELECT p.path
+              FROM unnest($1::text[]) AS p(path)
+             WHERE NOT EXISTS (SELECT 1 FROM tasks t WHERE t.file_path = p.path)
+               AND NOT EXISTS (SELECT 1 FROM tasks_archive a WHERE a.file_path = p.path)`
+
+	rows, err := s.db.Query(ctx, sql, paths)
+	if err != nil {
+		s.log(ctx).Error("Selecting unreferenced files", zap.Error(err))
+		return nil, classify(err)
+	}
+	defer rows.Close()
+
+	var unreferenced []string
+	for rows.Next() {
+		var path string
+		err = rows.Scan(&path)
+		if err != nil {
+			s.log(ctx).Error("Scanning row", zap.Error(err))
+			return nil, classify(err)
+		}
+
+		unreferenced = append(unreferenced, path)
+	}
+
+	if rows.Err() != nil {
+		return nil, classify(rows.Err())
+	}
+
+	return unreferenced, nil
+}
@@ -0,0 +1,104 @@
+package postgresql
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+	"time"
+)
+
+// ErrMerchantFrozen is returned by writes to catalog of frozen merchant
+var ErrMerchantFrozen = errors.New("merchant catalog is frozen")
+
+// ErrNotFrozen is returned when merchant has no freeze
+var ErrNotFrozen = errors.New("merchant is not frozen")
+
+// MerchantFreeze defines freeze blocking imports, product creation and expiry of merchant catalog,
+// e.g. during dispute or investigation. Reads are served as usual.
+type MerchantFreeze struct {
+	MerchantID int64     `json:"merchant_id"`
+	Reason     string    `json:"reason"`
+	FrozenAt   time.Time `json:"frozen_at"`
+}
+
+// FreezeMerchant freezes catalog of the merchant, freezing frozen merchant replaces reason only.
+// Writes which have checked the freeze before it is saved may still complete.
+func (s *Storage) FreezeMerchant(ctx context.Context, merchantID int64, reason string) (MerchantFreeze, error) {
+	sql := `INSERT INTO merchant_freezes (merchant_id, reason)
+            VALUES ($1, $2)
+                ON CONFLICT (merchant_id) DO UPDATE
+               SET reason = excluded.reason
+         RETURNING merchant_id::bigint, reason, frozen_at`
+
+	var f MerchantFreeze
+	err := s.db.QueryRow(ctx, sql, merchantID, reason).Scan(&f.MerchantID, &f.Reason, &f.FrozenAt)
+	if err != nil {
+		s.log(ctx).Error("Freezing merchant", zap.Int64("merchant_id", merchantID), zap.Error(err))
+		return MerchantFreeze{}, classify(err)
+	}
+
+	return f, nil
+}
+
+// ReadMerchantFreeze returns freeze of the merchant or ErrNotFrozen
+func (s *Storage) ReadMerchantFreeze(ctx context.Context, merchantID int64) (MerchantFreeze, error) {
+	sql := `SELECT merchant_id::bigint, reason, frozen_at
+              FROM merchant_freezes
+             WHERE merchant_id = $1`
+
+	var f MerchantFreeze
+	err := s.db.QueryRow(ctx, sql, merchantID).Scan(&f.MerchantID, &f.Reason, &f.FrozenAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return MerchantFreeze{}, ErrNotFrozen
+		}
+
+		s.log(ctx).Error("Reading merchant freeze", zap.Int64("merchant_id", merchantID), zap.Error(err))
+		return MerchantFreeze{}, classify(err)
+	}
+
+	return f, nil
+}
+
+// UnfreezeMerchant removes freeze of the merchant or returns ErrNotFrozen
+func (s *Storage) UnfreezeMerchant(ctx context.Context, merchantID int64) error {
+	tag, err := s.db.Exec(ctx, "DELETE FROM merchant_freezes WHERE merchant_id = $1", merchantID)
+	if err != nil {
+		s.log(ctx).Error("Unfreezing merchant", zap.Int64("merchant_id", merchantID), zap.Error(err))
+		return classify(err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrNotFrozen
+	}
+
+	return nil
+}
+
+// MerchantFrozen reports whether catalog of the merchant is frozen
+func (s *Storage) MerchantFrozen(ctx context.Context, merchantID int64) (bool, error) {
+	var frozen bool
+	err := s.db.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM merchant_freezes WHERE merchant_id = $1)", merchantID).Scan(&frozen)
+	if err != nil {
+		s.log(ctx).Error("Checking merchant freeze", zap.Int64("merchant_id", merchantID), zap.Error(err))
+		return false, classify(err)
+	}
+
+	return frozen, nil
+}
+
+// checkNotFrozen returns ErrMerchantFrozen if catalog of the merchant is frozen, it is called by write transactions
+func checkNotFrozen(ctx context.Context, tx pgx.Tx, merchantID int64) error {
+	var frozen bool
+	err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM merchant_freezes WHERE merchant_id = $1)", merchantID).Scan(&frozen)
+	if err != nil {
+		return err
+	}
+
+	if frozen {
+		return ErrMerchantFrozen
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package postgresql
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+)
+
+// ErrProductNotFound is returned when merchant has no product with requested offer id
+var ErrProductNotFound = errors.New("product not found")
+
+// Get returns available product of the merchant with provided offer id or ErrProductNotFound
+func (s *Storage) Get(ctx context.Context, merchantID, offerID int64) (Product, error) {
+	ctx, cancel := s.readContext(ctx)
+	defer cancel()
+
+	sql := `SELECT ` + productSelectColumns + `
+              FROM ` + s.productsTable(merchantID) + `
+             WHERE merchant_id = $1
+               AND offer_id = $2
+               AND is_available`
+
+	p, err := scanProduct(s.db.QueryRow(ctx, sql, merchantID, offerID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Product{}, ErrProductNotFound
+		}
+
+		s.log(ctx).Error("Reading product", zap.Int64("merchant_id", merchantID), zap.Int64("offer_id", offerID), zap.Error(err))
+		return Product{}, classify(err)
+	}
+
+	return p, nil
+}
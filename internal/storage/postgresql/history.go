@@ -0,0 +1,102 @@
+package postgresql
+
+import (
+	"context"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"time"
+)
+
+// kinds of ProductHistoryEntry
+const (
+	HistoryInserted = "inserted"
+	HistoryUpdated  = "updated"
+	HistoryDeleted  = "deleted"
+)
+
+// ProductHistoryEntry defines single change of the offer made by import task. Old values are omitted for inserted
+// offers and new ones for deleted offers.
+type ProductHistoryEntry struct {
+	ID          int64            `json:"id"`
+	TaskID      string           `json:"task_id"`
+	Change      string           `json:"change"`
+	OldPrice    *decimal.Decimal `json:"old_price,omitempty"`
+	NewPrice    *decimal.Decimal `json:"new_price,omitempty"`
+	OldQuantity *int64           `json:"old_quantity,omitempty"`
+	NewQuantity *int64           `json:"new_quantity,omitempty"`
+	ChangedAt   time.Time        `json:"changed_at"`
+}
+
+// previousValues returns CTE selecting current price and quantity of offers of products_temporary in table,
+// data-modifying CTEs of the same statement do not affect it, so it keeps values prior to the statement
+func previousValues(table string) string {
+	return `previous AS
+                    (SELECT p.offer_id, p.price, p.quantity
+                       FROM ` + table + ` p
+                       JOIN products_temporary t
+                         ON t.merchant_id = p.merchant_id
+                        AND t.offer_id = p.offer_id)`
+}
+
+// upsertedHistory returns CTE saving rows returned by source CTE to product history of the task passed as parameter.
+// Old values are taken from previous CTE if it is not empty, offers missing from it are inserted ones.
+func upsertedHistory(source string, previous string, parameter string) string {
+	if previous == "" {
+		return `history AS
+                    (INSERT INTO product_history (merchant_id, offer_id, task_id, change, new_price, new_quantity)
+                     SELECT merchant_id, offer_id, ` + parameter + `, '` + HistoryInserted + `', price, quantity
+                       FROM ` + source + `)`
+	}
+
+	return `history AS
+                    (INSERT INTO product_history (merchant_id, offer_id, task_id, change, old_price, new_price,
+                                                  old_quantity, new_quantity)
+                     SELECT s.merchant_id, s.offer_id, ` + parameter + `,
+                            CASE WHEN o.offer_id IS NULL THEN '` + HistoryInserted + `' ELSE '` + HistoryUpdated + `' END,
+                            o.price, s.price, o.quantity, s.quantity
+                       FROM ` + source + ` s
+                       LEFT JOIN ` + previous + ` o
+                         ON o.offer_id = s.offer_id)`
+}
+
+// ProductHistory returns at most limit changes of the offer made by imports, most recent first,
+// starting before change with provided id, zero beforeID starts from the latest change.
+// Non-empty taskID limits changes to the ones made by the task.
+func (s *Storage) ProductHistory(ctx context.Context, merchantID, offerID int64, taskID string, beforeID int64, limit int) ([]ProductHistoryEntry, error) {
+	ctx, cancel := s.readContext(ctx)
+	defer cancel()
+
+	sql := `SELECT id, task_id, change, old_price, new_price, old_quantity, new_quantity, changed_at
+              FROM product_history
+             WHERE merchant_id = $1
+               AND offer_id = $2
+               AND ($3::text = '' OR task_id = $3)
+               AND ($4::bigint = 0 OR id < $4)
+             ORDER BY id DESC
+             LIMIT $5`
+
+	rows, err := s.db.Query(ctx, sql, merchantID, offerID, taskID, beforeID, limit)
+	if err != nil {
+		s.log(ctx).Error("Selecting product history", zap.Int64("merchant_id", merchantID), zap.Int64("offer_id", offerID), zap.Error(err))
+		return nil, classify(err)
+	}
+	defer rows.Close()
+
+	history := []ProductHistoryEntry{}
+	for rows.Next() {
+		var e ProductHistoryEntry
+		err = rows.Scan(&e.ID, &e.TaskID, &e.Change, &e.OldPrice, &e.NewPrice, &e.OldQuantity, &e.NewQuantity, &e.ChangedAt)
+		if err != nil {
+			s.log(ctx).Error("Scanning row", zap.Error(err))
+			return nil, classify(err)
+		}
+
+		history = append(history, e)
+	}
+
+	if rows.Err() != nil {
+		return nil, classify(rows.Err())
+	}
+
+	return history, nil
+}
@@ -0,0 +1,81 @@
+package postgresql
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"time"
+)
+
+// ImportSummary defines result of single task in imports report
+type ImportSummary struct {
+	TaskID     string `json:"task_id" csv:"task_id"`
+	MerchantID int64  `json:"merchant_id" csv:"merchant_id"`
+	// File is path of uploaded file, Format is the way it was read
+	File   string `json:"file" csv:"file"`
+	Format string `json:"format" csv:"format"`
+	// Rows is number of rows read from the file including ignored, skipped and duplicate ones
+	Rows       int64      `json:"rows" csv:"rows"`
+	Added      int64      `json:"added" csv:"added"`
+	Updated    int64      `json:"updated" csv:"updated"`
+	Removed    int64      `json:"removed" csv:"removed"`
+	Ignored    int64      `json:"ignored" csv:"ignored"`
+	Skipped    int64      `json:"skipped" csv:"skipped"`
+	Duplicates int64      `json:"duplicates" csv:"duplicates"`
+	State      string     `json:"state" csv:"state"`
+	ErrorCode  string     `json:"error_code" csv:"error_code"`
+	CreatedAt  time.Time  `json:"created_at" csv:"created_at"`
+	FinishedAt *time.Time `json:"finished_at" csv:"finished_at"`
+	// DurationSeconds is time from upload to finish of the task, nil for unfinished tasks
+	DurationSeconds *float64 `json:"duration_seconds" csv:"duration_seconds"`
+}
+
+// ImportSummaries returns summaries of tasks of every merchant, archived included, created within [from, to)
+// in creation order
+func (s *Storage) ImportSummaries(ctx context.Context, from, to time.Time) ([]ImportSummary, error) {
+	ctx, cancel := s.readContext(ctx)
+	defer cancel()
+
+	sql := `WITH created AS (
+                SELECT id, merchant_id, file_path, file_format, added, updated, removed, ignored, skipped, duplicates,
+                       state, error_code, created_at, finished_at
+                  FROM tasks
+                 WHERE created_at >= $1 AND created_at < $2
+                 UNION ALL
+                SELECT id, merchant_id, file_path, file_format, added, updated, removed, ignored, skipped, duplicates,
+                       state, error_code, created_at, finished_at
+                  FROM tasks_archive
+                 WHERE created_at >= $1 AND created_at < $2
+            )
+            SELECT id, merchant_id, file_path, file_format,
+                   added + updated + removed + ignored + skipped + duplicates,
+                   added, updated, removed, ignored, skipped, duplicates, state, error_code, created_at, finished_at,
+                   extract(epoch FROM finished_at - created_at)::float8
+              FROM created
+             ORDER BY created_at, id`
+
+	rows, err := s.db.Query(ctx, sql, from, to)
+	if err != nil {
+		s.log(ctx).Error("Selecting import summaries", zap.Time("from", from), zap.Time("to", to), zap.Error(err))
+		return nil, classify(err)
+	}
+	defer rows.Close()
+
+	summaries := []ImportSummary{}
+	for rows.Next() {
+		var i ImportSummary
+		err = rows.Scan(&i.TaskID, &i.MerchantID, &i.File, &i.Format, &i.Rows, &i.Added, &i.Updated, &i.Removed,
+			&i.Ignored, &i.Skipped, &i.Duplicates, &i.State, &i.ErrorCode, &i.CreatedAt, &i.FinishedAt, &i.DurationSeconds)
+		if err != nil {
+			s.log(ctx).Error("Scanning row", zap.Error(err))
+			return nil, classify(err)
+		}
+
+		summaries = append(summaries, i)
+	}
+
+	if rows.Err() != nil {
+		return nil, classify(rows.Err())
+	}
+
+	return summaries, nil
+}
@@ -0,0 +1,114 @@
+package postgresql
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/shopspring/decimal"
+	"strconv"
+)
+
+// JSONStyle defines field naming and price encoding of Product JSON, zero value matches Product json tags
+type JSONStyle struct {
+	// CamelCase names fields like offerId instead of offer_id
+	CamelCase bool
+	// NumericPrices encodes prices as JSON numbers instead of strings,
+	// consumers parsing numbers as floats may lose precision of big prices
+	NumericPrices bool
+}
+
+// productFieldNames defines snake_case and camelCase names of Product fields in encoding order
+var productFieldNames = [...][2]string{
+	{"merchant_id", "merchantId"},
+	{"offer_id", "offerId"},
+	{"name", "name"},
+	{"price", "price"},
+	{"quantity", "quantity"},
+	{"available", "available"},
+	{"original_price", "originalPrice"},
+	{"original_currency", "originalCurrency"},
+}
+
+// StyledProduct encodes Product to JSON in provided style
+type StyledProduct struct {
+	Product
+	Style JSONStyle
+}
+
+// StyleProducts wraps products to be encoded in provided style
+func StyleProducts(products []Product, style JSONStyle) []StyledProduct {
+	styled := make([]StyledProduct, 0, len(products))
+	for _, p := range products {
+		styled = append(styled, StyledProduct{Product: p, Style: style})
+	}
+
+	return styled
+}
+
+// MarshalJSON encodes product with snake_case field names and string prices
+func (p Product) MarshalJSON() ([]byte, error) {
+	return p.marshalJSON(JSONStyle{})
+}
+
+// MarshalJSON encodes product in its style
+func (p StyledProduct) MarshalJSON() ([]byte, error) {
+	return p.Product.marshalJSON(p.Style)
+}
+
+func (p Product) marshalJSON(style JSONStyle) ([]byte, error) {
+	name, err := json.Marshal(p.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	values := [len(productFieldNames)][]byte{
+		strconv.AppendInt(nil, p.MerchantID, 10),
+		strconv.AppendInt(nil, p.OfferID, 10),
+		name,
+		style.price(p.Price),
+		strconv.AppendInt(nil, p.Quantity, 10),
+		strconv.AppendBool(nil, p.Available),
+	}
+	if p.OriginalPrice != nil {
+		values[6] = style.price(*p.OriginalPrice)
+	}
+	if p.OriginalCurrency != "" {
+		values[7], err = json.Marshal(p.OriginalCurrency)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	naming := 0
+	if style.CamelCase {
+		naming = 1
+	}
+
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, v := range values {
+		// original price and currency are omitted for unconverted prices
+		if v == nil {
+			continue
+		}
+
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		b.WriteByte('"')
+		b.WriteString(productFieldNames[i][naming])
+		b.WriteString(`":`)
+		b.Write(v)
+	}
+	b.WriteByte('}')
+
+	return b.Bytes(), nil
+}
+
+// price encodes price as JSON string or number
+func (s JSONStyle) price(d decimal.Decimal) []byte {
+	if s.NumericPrices {
+		return []byte(d.String())
+	}
+
+	return []byte(`"` + d.String() + `"`)
+}
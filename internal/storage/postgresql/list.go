@@ -2,10 +2,11 @@ package postgresql
 
 import (
 	"context"
-	"github.com/jackc/pgx/v4"
+	"github.com/shopspring/decimal"
 	"go.uber.org/zap"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // listParameters defines fields that affect SELECT SQL query in List method
@@ -13,6 +14,22 @@ type listParameters struct {
 	merchantID int64
 	offerID    int64
 	nameQuery  string
+	// nameMatch is either NameMatchPrefix, NameMatchContains or NameMatchFuzzy, empty means NameMatchPrefix
+	nameMatch string
+	// limit and offset define page of products ordered by merchant_id and offer_id, zero limit means no limit
+	limit  int64
+	offset int64
+	// afterMerchantID and afterOfferID define key products are listed after, see WithAfter
+	afterMerchantID int64
+	afterOfferID    int64
+	afterSet        bool
+	// priceMin and priceMax bound price of listed products inclusively, nil means the bound is not set
+	priceMin *decimal.Decimal
+	priceMax *decimal.Decimal
+	// minQuantity excludes products with smaller quantity, zero means quantity is not filtered
+	minQuantity int64
+	// availability is one of Availability constants, empty means AvailabilityAvailable
+	availability string
 }
 
 const (
@@ -24,9 +41,59 @@ const (
 	defaultNameQuery = ""
 )
 
-// isAnyNonDefault returns true only if all fields in listParameters equal to default values
-func (lp listParameters) isAnyNonDefault() bool {
-	return lp.merchantID == defaultMerchantID || lp.offerID == defaultOfferID || lp.nameQuery == defaultNameQuery
+// name match modes of WithNameMatch
+const (
+	// NameMatchPrefix matches names starting with query
+	NameMatchPrefix = "prefix"
+	// NameMatchContains matches names containing query ignoring case
+	NameMatchContains = "contains"
+	// NameMatchFuzzy matches names containing words similar to query by trigrams, so typos are tolerated
+	NameMatchFuzzy = "fuzzy"
+)
+
+// availability filters of WithAvailability
+const (
+	// AvailabilityAvailable lists available products only, it is the default
+	AvailabilityAvailable = "available"
+	// AvailabilityUnavailable lists products uploaded as unavailable only
+	AvailabilityUnavailable = "unavailable"
+	// AvailabilityAny lists products whatever their availability is
+	AvailabilityAny = "any"
+)
+
+// UnfilteredListRows caps number of products read by List without any filter whatever limit is,
+// so listing of every catalog stays bounded even if WithMaxListRows cap is disabled or larger
+const UnfilteredListRows = 10000
+
+// hasFilters reports whether any filter narrowing listed products is set, pagination options are not filters.
+// Availability is not counted as most products of every catalog share it.
+func (lp listParameters) hasFilters() bool {
+	return lp.merchantID != defaultMerchantID || lp.offerID != defaultOfferID || lp.nameQuery != defaultNameQuery ||
+		lp.priceMin != nil || lp.priceMax != nil || lp.minQuantity > 0
+}
+
+// rowCap returns maximum number of products returned by List with lp, zero means there is no cap
+func (s *Storage) rowCap(lp listParameters) int64 {
+	if !lp.hasFilters() && (s.maxListRows <= 0 || s.maxListRows > UnfilteredListRows) {
+		return UnfilteredListRows
+	}
+
+	return s.maxListRows
+}
+
+// ListRowCap returns maximum number of products returned by List with provided options, zero means there is no cap
+func (s *Storage) ListRowCap(options ...ListOption) int64 {
+	parameters := listParameters{
+		merchantID: defaultMerchantID,
+		offerID:    defaultOfferID,
+		nameQuery:  defaultNameQuery,
+	}
+
+	for _, opt := range options {
+		opt(&parameters)
+	}
+
+	return s.rowCap(parameters)
 }
 
 // ListOption type represents function to modify listParameters struct
@@ -53,8 +120,158 @@ func WithNameQuery(q string) ListOption {
 	}
 }
 
+// WithNameMatch applies passed mode as the way nameQuery is matched, contains and fuzzy modes use trigram index
+func WithNameMatch(mode string) ListOption {
+	return func(p *listParameters) {
+		p.nameMatch = mode
+	}
+}
+
+// WithLimit applies passed number as maximum number of returned products
+func WithLimit(n int64) ListOption {
+	return func(p *listParameters) {
+		p.limit = n
+	}
+}
+
+// WithOffset applies passed number as number of matching products skipped before returned ones
+func WithOffset(n int64) ListOption {
+	return func(p *listParameters) {
+		p.offset = n
+	}
+}
+
+// WithAfter makes List return products which (merchant_id, offer_id) key follows provided one,
+// so pages are read by keyset instead of offset, which does not slow down on deep pages
+func WithAfter(merchantID, offerID int64) ListOption {
+	return func(p *listParameters) {
+		p.afterMerchantID = merchantID
+		p.afterOfferID = offerID
+		p.afterSet = true
+	}
+}
+
+// WithPriceRange makes List return products with price between min and max inclusively, nil bound is not applied
+func WithPriceRange(min, max *decimal.Decimal) ListOption {
+	return func(p *listParameters) {
+		p.priceMin = min
+		p.priceMax = max
+	}
+}
+
+// WithMinQuantity makes List return products with quantity of at least n, e.g. 1 for products in stock
+func WithMinQuantity(n int64) ListOption {
+	return func(p *listParameters) {
+		p.minQuantity = n
+	}
+}
+
+// WithAvailability makes List return products of provided availability, see Availability constants
+func WithAvailability(availability string) ListOption {
+	return func(p *listParameters) {
+		p.availability = availability
+	}
+}
+
+// bind appends value to args and returns placeholder referencing it
+func bind(args *[]interface{}, value interface{}) string {
+	*args = append(*args, value)
+	return "$" + strconv.Itoa(len(*args))
+}
+
+// writeFilters writes WHERE clause of filters shared by List and Count to b and returns args with filter values appended,
+// every value is passed as query parameter, so statements differ only by set of filters and their plans are cached
+func (lp listParameters) writeFilters(b *strings.Builder, args []interface{}) []interface{} {
+	b.WriteString(" WHERE 1 = 1")
+
+	switch lp.availability {
+	case AvailabilityAny:
+	case AvailabilityUnavailable:
+		b.WriteString(" AND NOT is_available")
+	default:
+		b.WriteString(" AND is_available")
+	}
+
+	if lp.merchantID != defaultMerchantID {
+		b.WriteString(" AND merchant_id = " + bind(&args, lp.merchantID))
+	}
+
+	if lp.offerID != defaultOfferID {
+		b.WriteString(" AND offer_id = " + bind(&args, lp.offerID))
+	}
+
+	if lp.nameQuery != defaultNameQuery {
+		switch lp.nameMatch {
+		case NameMatchContains:
+			b.WriteString(" AND name ILIKE " + bind(&args, "%"+likeEscaper.Replace(lp.nameQuery)+"%"))
+		case NameMatchFuzzy:
+			b.WriteString(" AND " + bind(&args, lp.nameQuery) + " <% name")
+		default:
+			b.WriteString(" AND name ^@ " + bind(&args, lp.nameQuery))
+		}
+	}
+
+	switch {
+	case lp.priceMin != nil && lp.priceMax != nil:
+		b.WriteString(" AND price BETWEEN " + bind(&args, *lp.priceMin) + " AND " + bind(&args, *lp.priceMax))
+	case lp.priceMin != nil:
+		b.WriteString(" AND price >= " + bind(&args, *lp.priceMin))
+	case lp.priceMax != nil:
+		b.WriteString(" AND price <= " + bind(&args, *lp.priceMax))
+	}
+
+	if lp.minQuantity > 0 {
+		b.WriteString(" AND quantity >= " + bind(&args, lp.minQuantity))
+	}
+
+	return args
+}
+
+// writePage writes keyset, ORDER BY, LIMIT and OFFSET clauses of List to b and returns args with their values appended
+func (lp listParameters) writePage(b *strings.Builder, args []interface{}) []interface{} {
+	if lp.afterSet {
+		b.WriteString(" AND (merchant_id, offer_id) > (" + bind(&args, lp.afterMerchantID) + ", " + bind(&args, lp.afterOfferID) + ")")
+	}
+
+	// pages are stable only if rows are ordered by unique key
+	if lp.limit > 0 || lp.offset > 0 || lp.afterSet {
+		b.WriteString(" ORDER BY merchant_id, offer_id")
+	}
+
+	if lp.limit > 0 {
+		b.WriteString(" LIMIT " + bind(&args, lp.limit))
+	}
+
+	if lp.offset > 0 {
+		b.WriteString(" OFFSET " + bind(&args, lp.offset))
+	}
+
+	return args
+}
+
 // List returns Product slice from database applying ListOptions if presented.
+// At most one product more than ListRowCap is returned whatever limit is requested,
+// so caller can tell truncated result by its length.
 func (s *Storage) List(ctx context.Context, options ...ListOption) ([]Product, error) {
+	var products []Product
+	err := s.ListEach(ctx, func(p Product) error {
+		products = append(products, p)
+		return nil
+	}, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return products, nil
+}
+
+// ListEach calls fn for every product List would return in the same order while rows are being read,
+// so products are never kept in memory together. Error returned by fn stops reading and is returned as is.
+// Read timeout bounds the whole iteration including time spent in fn, e.g. by streaming products to client.
+func (s *Storage) ListEach(ctx context.Context, fn func(p Product) error, options ...ListOption) error {
+	ctx, cancel := s.readContext(ctx)
+	defer cancel()
+
 	parameters := &listParameters{
 		merchantID: defaultMerchantID,
 		offerID:    defaultOfferID,
@@ -65,52 +282,77 @@ func (s *Storage) List(ctx context.Context, options ...ListOption) ([]Product, e
 		opt(parameters)
 	}
 
-	var rows pgx.Rows
-	var err error
+	rowCap := s.rowCap(*parameters)
+	if rowCap > 0 && (parameters.limit <= 0 || parameters.limit > rowCap+1) {
+		parameters.limit = rowCap + 1
+	}
 
+	var args []interface{}
+
+	started := time.Now()
 	b := strings.Builder{}
-	b.WriteString("SELECT * FROM products")
+	b.WriteString("SELECT " + productSelectColumns + " FROM " + s.productsTable(parameters.merchantID))
 
-	if parameters.isAnyNonDefault() {
-		b.WriteString(" WHERE 1 = 1")
+	args = parameters.writeFilters(&b, args)
+	args = parameters.writePage(&b, args)
 
-		if parameters.merchantID != defaultMerchantID {
-			b.WriteString(" AND merchant_id = " + strconv.FormatInt(parameters.merchantID, 10))
-		}
+	rows, err := s.db.Query(ctx, b.String(), args...)
+	if err != nil {
+		s.log(ctx).Error("Selecting rows", zap.Error(err))
+		return classify(err)
+	}
+	defer rows.Close()
 
-		if parameters.offerID != defaultOfferID {
-			b.WriteString(" AND offer_id = " + strconv.FormatInt(parameters.offerID, 10))
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			s.log(ctx).Error("Scanning row", zap.Error(err))
+			return classify(err)
 		}
 
-		if parameters.nameQuery != defaultNameQuery {
-			b.WriteString(" AND name ^@ $1")
-			rows, err = s.db.Query(ctx, b.String(), parameters.nameQuery)
-		} else {
-			sql := b.String()
-			rows, err = s.db.Query(ctx, sql)
+		err = fn(p)
+		if err != nil {
+			return err
 		}
 	}
 
-	if err != nil {
-		s.logger.Error("Selecting rows", zap.Error(err))
-		return nil, err
+	if rows.Err() != nil {
+		return classify(rows.Err())
 	}
 
-	var products []Product
-	for rows.Next() {
-		var p Product
-		err = rows.Scan(&p.MerchantID, &p.OfferID, &p.Name, &p.Price, &p.Quantity)
-		if err != nil {
-			s.logger.Error("Scanning row", zap.Error(err))
-			return nil, err
-		}
+	s.observeQuery(ctx, "list", started, b.String(), args...)
 
-		products = append(products, p)
+	return nil
+}
+
+// Count returns number of products matching filters of ListOptions, pagination options are ignored
+func (s *Storage) Count(ctx context.Context, options ...ListOption) (int64, error) {
+	ctx, cancel := s.readContext(ctx)
+	defer cancel()
+
+	parameters := &listParameters{
+		merchantID: defaultMerchantID,
+		offerID:    defaultOfferID,
+		nameQuery:  defaultNameQuery,
 	}
 
-	if rows.Err() != nil {
-		return nil, err
+	for _, opt := range options {
+		opt(parameters)
 	}
 
-	return products, nil
+	started := time.Now()
+	b := strings.Builder{}
+	b.WriteString("SELECT count(*) FROM " + s.productsTable(parameters.merchantID))
+	args := parameters.writeFilters(&b, nil)
+
+	var count int64
+	err := s.db.QueryRow(ctx, b.String(), args...).Scan(&count)
+	if err != nil {
+		s.log(ctx).Error("Counting rows", zap.Error(err))
+		return 0, classify(err)
+	}
+
+	s.observeQuery(ctx, "count", started, b.String(), args...)
+
+	return count, nil
 }
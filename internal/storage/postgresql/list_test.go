@@ -0,0 +1,241 @@
+package postgresql
+
+import (
+	"github.com/shopspring/decimal"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testParameters returns listParameters built from options the way List and Count build them
+func testParameters(options ...ListOption) listParameters {
+	parameters := listParameters{
+		merchantID: defaultMerchantID,
+		offerID:    defaultOfferID,
+		nameQuery:  defaultNameQuery,
+	}
+
+	for _, opt := range options {
+		opt(&parameters)
+	}
+
+	return parameters
+}
+
+func TestBind(t *testing.T) {
+	var args []interface{}
+	for i, want := range []string{"$1", "$2", "$3"} {
+		got := bind(&args, int64(i))
+		if got != want {
+			t.Fatalf("expected placeholder %s, got %s", want, got)
+		}
+	}
+
+	if want := []interface{}{int64(0), int64(1), int64(2)}; !reflect.DeepEqual(args, want) {
+		t.Fatalf("expected args %v, got %v", want, args)
+	}
+}
+
+func TestWriteFilters(t *testing.T) {
+	min := decimal.RequireFromString("10.50")
+	max := decimal.RequireFromString("99.99")
+
+	tests := []struct {
+		name    string
+		options []ListOption
+		sql     string
+		args    []interface{}
+	}{
+		{
+			name: "no filters",
+			sql:  " WHERE 1 = 1 AND is_available",
+		},
+		{
+			name:    "available",
+			options: []ListOption{WithAvailability(AvailabilityAvailable)},
+			sql:     " WHERE 1 = 1 AND is_available",
+		},
+		{
+			name:    "unavailable",
+			options: []ListOption{WithAvailability(AvailabilityUnavailable)},
+			sql:     " WHERE 1 = 1 AND NOT is_available",
+		},
+		{
+			name:    "any availability",
+			options: []ListOption{WithAvailability(AvailabilityAny)},
+			sql:     " WHERE 1 = 1",
+		},
+		{
+			name:    "merchant id",
+			options: []ListOption{WithMerchantID(7)},
+			sql:     " WHERE 1 = 1 AND is_available AND merchant_id = $1",
+			args:    []interface{}{int64(7)},
+		},
+		{
+			name:    "offer id",
+			options: []ListOption{WithOfferID(42)},
+			sql:     " WHERE 1 = 1 AND is_available AND offer_id = $1",
+			args:    []interface{}{int64(42)},
+		},
+		{
+			name:    "default name match",
+			options: []ListOption{WithNameQuery("Tea")},
+			sql:     " WHERE 1 = 1 AND is_available AND name ^@ $1",
+			args:    []interface{}{"Tea"},
+		},
+		{
+			name:    "prefix name match",
+			options: []ListOption{WithNameQuery("Tea"), WithNameMatch(NameMatchPrefix)},
+			sql:     " WHERE 1 = 1 AND is_available AND name ^@ $1",
+			args:    []interface{}{"Tea"},
+		},
+		{
+			name:    "contains name match",
+			options: []ListOption{WithNameQuery(`50%_off\`), WithNameMatch(NameMatchContains)},
+			sql:     " WHERE 1 = 1 AND is_available AND name ILIKE $1",
+			args:    []interface{}{`%50\%\_off\\%`},
+		},
+		{
+			name:    "fuzzy name match",
+			options: []ListOption{WithNameQuery("teh"), WithNameMatch(NameMatchFuzzy)},
+			sql:     " WHERE 1 = 1 AND is_available AND $1 <% name",
+			args:    []interface{}{"teh"},
+		},
+		{
+			name:    "name match without query",
+			options: []ListOption{WithNameMatch(NameMatchFuzzy)},
+			sql:     " WHERE 1 = 1 AND is_available",
+		},
+		{
+			name:    "price range",
+			options: []ListOption{WithPriceRange(&min, &max)},
+			sql:     " WHERE 1 = 1 AND is_available AND price BETWEEN $1 AND $2",
+			args:    []interface{}{min, max},
+		},
+		{
+			name:    "min price",
+			options: []ListOption{WithPriceRange(&min, nil)},
+			sql:     " WHERE 1 = 1 AND is_available AND price >= $1",
+			args:    []interface{}{min},
+		},
+		{
+			name:    "max price",
+			options: []ListOption{WithPriceRange(nil, &max)},
+			sql:     " WHERE 1 = 1 AND is_available AND price <= $1",
+			args:    []interface{}{max},
+		},
+		{
+			name:    "no price bounds",
+			options: []ListOption{WithPriceRange(nil, nil)},
+			sql:     " WHERE 1 = 1 AND is_available",
+		},
+		{
+			name:    "min quantity",
+			options: []ListOption{WithMinQuantity(3)},
+			sql:     " WHERE 1 = 1 AND is_available AND quantity >= $1",
+			args:    []interface{}{int64(3)},
+		},
+		{
+			name:    "zero min quantity",
+			options: []ListOption{WithMinQuantity(0)},
+			sql:     " WHERE 1 = 1 AND is_available",
+		},
+		{
+			name: "every filter",
+			options: []ListOption{
+				WithAvailability(AvailabilityAny), WithMerchantID(7), WithOfferID(42), WithNameQuery("Tea"),
+				WithNameMatch(NameMatchContains), WithPriceRange(&min, &max), WithMinQuantity(3),
+			},
+			sql: " WHERE 1 = 1 AND merchant_id = $1 AND offer_id = $2 AND name ILIKE $3" +
+				" AND price BETWEEN $4 AND $5 AND quantity >= $6",
+			args: []interface{}{int64(7), int64(42), "%Tea%", min, max, int64(3)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			args := testParameters(tt.options...).writeFilters(&b, nil)
+
+			if b.String() != tt.sql {
+				t.Fatalf("expected SQL %q, got %q", tt.sql, b.String())
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Fatalf("expected args %v, got %v", tt.args, args)
+			}
+		})
+	}
+}
+
+func TestWritePage(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []ListOption
+		// args are already bound by filters, so placeholders of page continue their numbering
+		args []interface{}
+		sql  string
+		want []interface{}
+	}{
+		{
+			name: "no paging",
+			sql:  "",
+		},
+		{
+			name:    "limit",
+			options: []ListOption{WithLimit(20)},
+			sql:     " ORDER BY merchant_id, offer_id LIMIT $1",
+			want:    []interface{}{int64(20)},
+		},
+		{
+			name:    "offset",
+			options: []ListOption{WithOffset(40)},
+			sql:     " ORDER BY merchant_id, offer_id OFFSET $1",
+			want:    []interface{}{int64(40)},
+		},
+		{
+			name:    "limit and offset",
+			options: []ListOption{WithLimit(20), WithOffset(40)},
+			sql:     " ORDER BY merchant_id, offer_id LIMIT $1 OFFSET $2",
+			want:    []interface{}{int64(20), int64(40)},
+		},
+		{
+			name:    "keyset",
+			options: []ListOption{WithAfter(7, 42)},
+			sql:     " AND (merchant_id, offer_id) > ($1, $2) ORDER BY merchant_id, offer_id",
+			want:    []interface{}{int64(7), int64(42)},
+		},
+		{
+			name:    "keyset with limit",
+			options: []ListOption{WithAfter(7, 42), WithLimit(20)},
+			sql:     " AND (merchant_id, offer_id) > ($1, $2) ORDER BY merchant_id, offer_id LIMIT $3",
+			want:    []interface{}{int64(7), int64(42), int64(20)},
+		},
+		{
+			name:    "keyset of zero key",
+			options: []ListOption{WithAfter(0, 0)},
+			sql:     " AND (merchant_id, offer_id) > ($1, $2) ORDER BY merchant_id, offer_id",
+			want:    []interface{}{int64(0), int64(0)},
+		},
+		{
+			name:    "after filters",
+			options: []ListOption{WithAfter(7, 42), WithLimit(20), WithOffset(40)},
+			args:    []interface{}{int64(7)},
+			sql:     " AND (merchant_id, offer_id) > ($2, $3) ORDER BY merchant_id, offer_id LIMIT $4 OFFSET $5",
+			want:    []interface{}{int64(7), int64(7), int64(42), int64(20), int64(40)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			args := testParameters(tt.options...).writePage(&b, tt.args)
+
+			if b.String() != tt.sql {
+				t.Fatalf("expected SQL %q, got %q", tt.sql, b.String())
+			}
+			if !reflect.DeepEqual(args, tt.want) {
+				t.Fatalf("expected args %v, got %v", tt.want, args)
+			}
+		})
+	}
+}
@@ -0,0 +1,360 @@
+package postgresql
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryStore keeps catalogs in memory, so catalog can be served without database by tests and demo mode.
+// It applies import and list options the way Storage does with following exceptions: fuzzy name match falls back
+// to contains one, there is no row cap, and checkpoints, rejected rows and changes of tasks are not saved.
+type MemoryStore struct {
+	rw sync.RWMutex
+	// products contains products of every merchant by offer id, unavailable ones are kept like in Storage
+	products map[int64]map[int64]Product
+}
+
+// NewMemoryStore constructs empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{products: make(map[int64]map[int64]Product)}
+}
+
+// Upsert inserts new products and updates changed columns of existing ones, unavailable products are revived
+// and counted as added. insertOnly and updatingColumns options are applied, other ones are ignored.
+//
+// Returns added and updated products count and error
+func (m *MemoryStore) Upsert(ctx context.Context, products []Product, options ...TxOption) (int64, int64, error) {
+	txOptions := buildOptions(options...)
+
+	m.rw.Lock()
+	defer m.rw.Unlock()
+
+	var inserted, updated int64
+	for _, p := range products {
+		catalog := m.catalog(p.MerchantID)
+		added, changed := upsertProduct(catalog, p, txOptions.insertOnly, txOptions.updateColumns)
+		if added {
+			inserted++
+		}
+		if changed {
+			updated++
+		}
+	}
+
+	return inserted, updated, nil
+}
+
+// Delete makes provided offers of the merchant unavailable, already unavailable ones are not counted.
+//
+// Returns number of products made unavailable and an error.
+func (m *MemoryStore) Delete(ctx context.Context, merchantID int64, offerIDs []int64, options ...TxOption) (int64, error) {
+	m.rw.Lock()
+	defer m.rw.Unlock()
+
+	return deleteProducts(m.catalog(merchantID), offerIDs), nil
+}
+
+// UpsertAndDelete upserts and deletes provided products at once, WithPhaseCallback, WithInsertOnly,
+// WithUpdateColumns and WithPreview options are applied, WithPreview leaves catalog unchanged.
+//
+// Returns stats of applied rows and error.
+func (m *MemoryStore) UpsertAndDelete(ctx context.Context, toUpsert []Product, merchantID int64, toDelete []int64, options ...ImportOption) (ImportStats, error) {
+	parameters := &importParameters{
+		onPhase: func(string) {},
+	}
+
+	for _, opt := range options {
+		opt(parameters)
+	}
+
+	err := ctx.Err()
+	if err != nil {
+		return ImportStats{}, classify(err)
+	}
+
+	m.rw.Lock()
+	defer m.rw.Unlock()
+
+	catalog := m.catalog(merchantID)
+	if parameters.preview {
+		preview := make(map[int64]Product, len(catalog))
+		for id, p := range catalog {
+			preview[id] = p
+		}
+		catalog = preview
+	}
+
+	var inserted, updated, deleted int64
+	if len(toUpsert) != 0 {
+		parameters.onPhase(PhaseUpserting)
+		for _, p := range toUpsert {
+			added, changed := upsertProduct(catalog, p, parameters.insertOnly, parameters.updateColumns)
+			if added {
+				inserted++
+			}
+			if changed {
+				updated++
+			}
+		}
+	}
+
+	if len(toDelete) != 0 && !parameters.insertOnly {
+		parameters.onPhase(PhaseDeleting)
+		deleted = deleteProducts(catalog, toDelete)
+	}
+
+	stats := ImportStats{
+		Added:   inserted,
+		Updated: updated,
+		Removed: deleted,
+		Skipped: int64(len(toUpsert)) - inserted - updated,
+	}
+	if parameters.insertOnly {
+		stats.Skipped += int64(len(toDelete))
+	}
+
+	return stats, nil
+}
+
+// List returns products matching ListOptions ordered by merchant_id and offer_id
+func (m *MemoryStore) List(ctx context.Context, options ...ListOption) ([]Product, error) {
+	parameters := listParameters{
+		merchantID: defaultMerchantID,
+		offerID:    defaultOfferID,
+		nameQuery:  defaultNameQuery,
+	}
+
+	for _, opt := range options {
+		opt(&parameters)
+	}
+
+	products := m.filter(parameters, true)
+	sort.Slice(products, func(i, j int) bool {
+		if products[i].MerchantID != products[j].MerchantID {
+			return products[i].MerchantID < products[j].MerchantID
+		}
+		return products[i].OfferID < products[j].OfferID
+	})
+
+	if parameters.offset > 0 {
+		if parameters.offset >= int64(len(products)) {
+			return []Product{}, nil
+		}
+		products = products[parameters.offset:]
+	}
+
+	if parameters.limit > 0 && parameters.limit < int64(len(products)) {
+		products = products[:parameters.limit]
+	}
+
+	if products == nil {
+		products = []Product{}
+	}
+
+	return products, nil
+}
+
+// ListEach calls fn for every product List would return in the same order.
+// Error returned by fn stops iteration and is returned as is.
+func (m *MemoryStore) ListEach(ctx context.Context, fn func(p Product) error, options ...ListOption) error {
+	products, err := m.List(ctx, options...)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range products {
+		err = fn(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Count returns number of products matching filters of ListOptions, pagination options are ignored
+func (m *MemoryStore) Count(ctx context.Context, options ...ListOption) (int64, error) {
+	parameters := listParameters{
+		merchantID: defaultMerchantID,
+		offerID:    defaultOfferID,
+		nameQuery:  defaultNameQuery,
+	}
+
+	for _, opt := range options {
+		opt(&parameters)
+	}
+
+	return int64(len(m.filter(parameters, false))), nil
+}
+
+// filter returns products matching filters of lp in no particular order, keyed reports whether keyset is applied
+func (m *MemoryStore) filter(lp listParameters, keyed bool) []Product {
+	m.rw.RLock()
+	defer m.rw.RUnlock()
+
+	var products []Product
+	for merchantID, catalog := range m.products {
+		if lp.merchantID != defaultMerchantID && merchantID != lp.merchantID {
+			continue
+		}
+
+		for _, p := range catalog {
+			if lp.matches(p) && (!keyed || lp.follows(p)) {
+				products = append(products, p)
+			}
+		}
+	}
+
+	return products
+}
+
+// Get returns available product of the merchant with provided offer id or ErrProductNotFound
+func (m *MemoryStore) Get(ctx context.Context, merchantID, offerID int64) (Product, error) {
+	m.rw.RLock()
+	defer m.rw.RUnlock()
+
+	p, ok := m.products[merchantID][offerID]
+	if !ok || !p.Available {
+		return Product{}, ErrProductNotFound
+	}
+
+	return p, nil
+}
+
+// catalog returns products of the merchant creating empty catalog if there is none, m has to be locked for writing
+func (m *MemoryStore) catalog(merchantID int64) map[int64]Product {
+	catalog, ok := m.products[merchantID]
+	if !ok {
+		catalog = make(map[int64]Product)
+		m.products[merchantID] = catalog
+	}
+
+	return catalog
+}
+
+// upsertProduct saves p to catalog the way Upsert saves row of products table
+// and reports whether p is added or existing product is changed
+func upsertProduct(catalog map[int64]Product, p Product, insertOnly bool, columns []string) (bool, bool) {
+	existing, ok := catalog[p.OfferID]
+	if !ok || !existing.Available {
+		p.Available = true
+		catalog[p.OfferID] = p
+		return true, false
+	}
+
+	if insertOnly {
+		return false, false
+	}
+
+	if len(columns) == 0 {
+		columns = UpdatableColumns
+	}
+
+	updated := existing
+	for _, c := range columns {
+		switch c {
+		case "name":
+			updated.Name = p.Name
+		case "price":
+			updated.Price = p.Price
+			updated.OriginalPrice = p.OriginalPrice
+			updated.OriginalCurrency = p.OriginalCurrency
+		case "quantity":
+			updated.Quantity = p.Quantity
+		}
+	}
+
+	if sameProduct(existing, updated) {
+		return false, false
+	}
+
+	catalog[p.OfferID] = updated
+	return false, true
+}
+
+// sameProduct reports whether products have equal columns
+func sameProduct(a, b Product) bool {
+	if a.Name != b.Name || !a.Price.Equal(b.Price) || a.Quantity != b.Quantity || a.OriginalCurrency != b.OriginalCurrency {
+		return false
+	}
+
+	if a.OriginalPrice == nil || b.OriginalPrice == nil {
+		return a.OriginalPrice == b.OriginalPrice
+	}
+
+	return a.OriginalPrice.Equal(*b.OriginalPrice)
+}
+
+// deleteProducts makes available offers of catalog unavailable and returns their number
+func deleteProducts(catalog map[int64]Product, offerIDs []int64) int64 {
+	var deleted int64
+	for _, id := range offerIDs {
+		p, ok := catalog[id]
+		if !ok || !p.Available {
+			continue
+		}
+
+		p.Available = false
+		catalog[id] = p
+		deleted++
+	}
+
+	return deleted
+}
+
+// matches reports whether p passes filters of lp, merchant filter is applied by caller
+func (lp listParameters) matches(p Product) bool {
+	switch lp.availability {
+	case AvailabilityAny:
+	case AvailabilityUnavailable:
+		if p.Available {
+			return false
+		}
+	default:
+		if !p.Available {
+			return false
+		}
+	}
+
+	if lp.offerID != defaultOfferID && p.OfferID != lp.offerID {
+		return false
+	}
+
+	if lp.nameQuery != defaultNameQuery {
+		switch lp.nameMatch {
+		case NameMatchContains, NameMatchFuzzy:
+			if !strings.Contains(strings.ToLower(p.Name), strings.ToLower(lp.nameQuery)) {
+				return false
+			}
+		default:
+			if !strings.HasPrefix(p.Name, lp.nameQuery) {
+				return false
+			}
+		}
+	}
+
+	if lp.priceMin != nil && p.Price.LessThan(*lp.priceMin) {
+		return false
+	}
+
+	if lp.priceMax != nil && p.Price.GreaterThan(*lp.priceMax) {
+		return false
+	}
+
+	if lp.minQuantity > 0 && p.Quantity < lp.minQuantity {
+		return false
+	}
+
+	return true
+}
+
+// follows reports whether p follows keyset of lp set by WithAfter
+func (lp listParameters) follows(p Product) bool {
+	if !lp.afterSet {
+		return true
+	}
+
+	return p.MerchantID > lp.afterMerchantID || p.MerchantID == lp.afterMerchantID && p.OfferID > lp.afterOfferID
+}
@@ -0,0 +1,204 @@
+package postgresql
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migrationFiles contains schema migrations named like 0001_schema.sql, number prefix is version of migration
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationsLockID is key of advisory lock held while migrations are applied, so concurrent instances apply them once
+const migrationsLockID = 4242000001
+
+// migration defines single schema migration
+type migration struct {
+	version int64
+	name    string
+	sql     string
+}
+
+// baselineVersion is version of migration creating the whole schema. Migrations following it until
+// databases created before embedded migrations had to be upgraded manually are idempotent,
+// so they upgrade such databases and change nothing on top of the baseline.
+const baselineVersion = 1
+
+// WithoutMigrations makes NewStorage skip applying embedded migrations, e.g. in production where schema changes
+// are reviewed and applied separately; Migrate may still be called explicitly
+func WithoutMigrations() StorageOption {
+	return func(s *Storage) {
+		s.skipMigrations = true
+	}
+}
+
+// loadMigrations returns embedded migrations ordered by version
+func loadMigrations() ([]migration, error) {
+	names, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]migration, 0, len(names))
+	for _, entry := range names {
+		base := strings.TrimSuffix(entry.Name(), ".sql")
+		parts := strings.SplitN(base, "_", 2)
+		version, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil || len(parts) != 2 || version <= 0 {
+			return nil, fmt.Errorf("migration file %s must be named like 0001_name.sql", entry.Name())
+		}
+
+		data, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, migration{version: version, name: parts[1], sql: string(data)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].version == migrations[i-1].version {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", migrations[i-1].name, migrations[i].name)
+		}
+	}
+
+	return migrations, nil
+}
+
+// Migrate applies embedded migrations missing from schema_migrations table in version order, each one in its own
+// transaction. Database which has products table but no applied migrations was created from schema script
+// before migrations were embedded, so baseline is recorded as applied without running it.
+func (s *Storage) Migrate(ctx context.Context) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	conn, err := s.db.Acquire(ctx)
+	if err != nil {
+		s.log(ctx).Error("Acquiring connection", zap.Error(err))
+		return classify(err)
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationsLockID)
+	if err != nil {
+		s.log(ctx).Error("Acquiring migrations lock", zap.Error(err))
+		return classify(err)
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationsLockID)
+
+	sql := `CREATE TABLE IF NOT EXISTS public.schema_migrations
+            (
+                version bigint NOT NULL,
+                name text NOT NULL,
+                applied_at timestamp with time zone NOT NULL DEFAULT now(),
+                CONSTRAINT schema_migrations_pkey PRIMARY KEY (version)
+            )`
+
+	_, err = conn.Exec(ctx, sql)
+	if err != nil {
+		s.log(ctx).Error("Creating migrations table", zap.Error(err))
+		return classify(err)
+	}
+
+	rows, err := conn.Query(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		s.log(ctx).Error("Selecting applied migrations", zap.Error(err))
+		return classify(err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]bool)
+	for rows.Next() {
+		var version int64
+		err = rows.Scan(&version)
+		if err != nil {
+			s.log(ctx).Error("Scanning row", zap.Error(err))
+			return classify(err)
+		}
+
+		applied[version] = true
+	}
+
+	if rows.Err() != nil {
+		return classify(rows.Err())
+	}
+
+	if len(applied) == 0 {
+		var exists bool
+		err = conn.QueryRow(ctx, "SELECT to_regclass('public.products') IS NOT NULL").Scan(&exists)
+		if err != nil {
+			s.log(ctx).Error("Checking existing schema", zap.Error(err))
+			return classify(err)
+		}
+
+		if exists {
+			_, err = conn.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", baselineVersion, migrations[0].name)
+			if err != nil {
+				s.log(ctx).Error("Recording baseline migration", zap.Error(err))
+				return classify(err)
+			}
+
+			s.log(ctx).Info("Existing schema recorded as baseline migration", zap.Int64("version", baselineVersion))
+			applied[baselineVersion] = true
+		}
+	}
+
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+
+		err = s.applyMigration(ctx, conn.Conn(), m)
+		if err != nil {
+			return err
+		}
+
+		s.log(ctx).Info("Migration applied", zap.Int64("version", m.version), zap.String("name", m.name))
+	}
+
+	return nil
+}
+
+// applyMigration runs statements of migration and records it as applied within single transaction
+func (s *Storage) applyMigration(ctx context.Context, conn *pgx.Conn, m migration) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		s.log(ctx).Error("Starting transaction", zap.Error(err))
+		return classify(err)
+	}
+	defer tx.Rollback(context.Background())
+
+	// statements are sent without parameters, so the whole script is run by single simple query
+	_, err = tx.Exec(ctx, m.sql)
+	if err != nil {
+		s.log(ctx).Error("Applying migration", zap.Int64("version", m.version), zap.String("name", m.name), zap.Error(err))
+		return fmt.Errorf("applying migration %d %s: %w", m.version, m.name, classify(err))
+	}
+
+	_, err = tx.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.version, m.name)
+	if err != nil {
+		s.log(ctx).Error("Recording migration", zap.Int64("version", m.version), zap.Error(err))
+		return classify(err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.log(ctx).Error("Committing transaction", zap.Error(err))
+		return classify(err)
+	}
+
+	return nil
+}
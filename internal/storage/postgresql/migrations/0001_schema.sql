@@ -0,0 +1,546 @@
+-- EXTENSION: pg_trgm
+
+-- DROP EXTENSION pg_trgm;
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- DOMAIN: public.merchant_id
+
+-- DROP DOMAIN public.merchant_id;
+
+CREATE DOMAIN public.merchant_id
+    AS integer
+    NOT NULL;
+
+ALTER DOMAIN public.merchant_id
+    ADD CONSTRAINT positive_merchant_id CHECK (VALUE > 0);
+
+-- DOMAIN: public.offer_id
+
+-- DROP DOMAIN public.offer_id;
+
+CREATE DOMAIN public.offer_id
+    AS integer
+    NOT NULL;
+
+ALTER DOMAIN public.offer_id
+    ADD CONSTRAINT positive_offer_id CHECK (VALUE > 0);
+
+-- DOMAIN: public.product_name
+
+-- DROP DOMAIN public.product_name;
+
+CREATE DOMAIN public.product_name
+    AS character varying(200)
+    NOT NULL;
+
+ALTER DOMAIN public.product_name
+    ADD CONSTRAINT "not empty" CHECK (VALUE::text <> ''::text);
+
+ALTER DOMAIN public.product_name
+    ADD CONSTRAINT no_control_characters CHECK (VALUE::text !~ '[[:cntrl:]]'::text);
+
+-- DOMAIN: public.product_price
+
+-- DROP DOMAIN public.product_price;
+
+CREATE DOMAIN public.product_price
+    AS numeric(14,2)
+    NOT NULL;
+
+ALTER DOMAIN public.product_price
+    ADD CONSTRAINT positive_price CHECK (VALUE > 0::numeric);
+
+-- DOMAIN: public.product_quantity
+
+-- DROP DOMAIN public.product_quantity;
+
+CREATE DOMAIN public.product_quantity
+    AS integer
+    NOT NULL;
+
+-- zero quantity is set by expiry rules to offers missing from imports
+ALTER DOMAIN public.product_quantity
+    ADD CONSTRAINT non_negative_quantity CHECK (VALUE >= 0);
+
+-- Table: public.products
+
+-- DROP TABLE public.products;
+
+CREATE TABLE public.products
+(
+    merchant_id merchant_id,
+    offer_id offer_id,
+    name product_name COLLATE pg_catalog."default",
+    price product_price,
+    quantity product_quantity,
+    original_price numeric(14,2),
+    original_currency character(3),
+    last_seen_at timestamp with time zone NOT NULL DEFAULT now(),
+    is_available boolean NOT NULL DEFAULT true,
+    CONSTRAINT unique_ids_pair UNIQUE (merchant_id, offer_id)
+)
+
+    TABLESPACE pg_default;
+
+-- Table: public.products_archive
+
+-- DROP TABLE public.products_archive;
+
+CREATE TABLE public.products_archive
+(
+    LIKE public.products INCLUDING DEFAULTS,
+    archived_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT products_archive_pkey PRIMARY KEY (merchant_id, offer_id, archived_at)
+)
+
+    TABLESPACE pg_default;
+
+-- Table: public.expiry_rules
+
+-- DROP TABLE public.expiry_rules;
+
+CREATE TABLE public.expiry_rules
+(
+    merchant_id merchant_id,
+    stale_after_days integer NOT NULL,
+    action character varying(20) NOT NULL,
+    updated_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT expiry_rules_pkey PRIMARY KEY (merchant_id),
+    CONSTRAINT positive_stale_after_days CHECK (stale_after_days > 0),
+    CONSTRAINT known_action CHECK (action::text = ANY (ARRAY['zero-quantity'::text, 'archive'::text]))
+)
+
+    TABLESPACE pg_default;
+
+-- Table: public.expired_offers
+
+-- DROP TABLE public.expired_offers;
+
+CREATE TABLE public.expired_offers
+(
+    merchant_id merchant_id,
+    offer_id offer_id,
+    name product_name COLLATE pg_catalog."default",
+    action character varying(20) NOT NULL,
+    last_seen_at timestamp with time zone NOT NULL,
+    expired_at timestamp with time zone NOT NULL,
+    CONSTRAINT expired_offers_pkey PRIMARY KEY (merchant_id, offer_id, expired_at)
+)
+
+    TABLESPACE pg_default;
+
+-- Index: public.expired_offers_merchant_id_expired_at_idx
+
+-- DROP INDEX public.expired_offers_merchant_id_expired_at_idx;
+
+CREATE INDEX expired_offers_merchant_id_expired_at_idx
+    ON public.expired_offers USING btree
+    (merchant_id, expired_at)
+    TABLESPACE pg_default;
+
+-- Table: public.import_sources
+
+-- DROP TABLE public.import_sources;
+
+CREATE TABLE public.import_sources
+(
+    merchant_id merchant_id,
+    dsn text NOT NULL,
+    query text NOT NULL,
+    interval_seconds bigint NOT NULL,
+    enabled boolean NOT NULL DEFAULT true,
+    last_run_at timestamp with time zone,
+    last_pulled_at timestamp with time zone,
+    last_task_id character varying(36) NOT NULL DEFAULT '',
+    last_error text NOT NULL DEFAULT '',
+    updated_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT import_sources_pkey PRIMARY KEY (merchant_id),
+    CONSTRAINT positive_interval_seconds CHECK (interval_seconds > 0)
+)
+
+    TABLESPACE pg_default;
+
+-- Table: public.offer_id_sequences
+
+-- DROP TABLE public.offer_id_sequences;
+
+-- last offer ids allocated to products created via API without offer_id
+CREATE TABLE public.offer_id_sequences
+(
+    merchant_id merchant_id,
+    last_offer_id offer_id,
+    CONSTRAINT offer_id_sequences_pkey PRIMARY KEY (merchant_id)
+)
+
+    TABLESPACE pg_default;
+
+-- Table: public.merchant_freezes
+
+-- DROP TABLE public.merchant_freezes;
+
+CREATE TABLE public.merchant_freezes
+(
+    merchant_id merchant_id,
+    reason text NOT NULL DEFAULT '',
+    frozen_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT merchant_freezes_pkey PRIMARY KEY (merchant_id)
+)
+
+    TABLESPACE pg_default;
+
+-- Table: public.tasks
+
+-- DROP TABLE public.tasks;
+
+CREATE TABLE public.tasks
+(
+    id character varying(36) NOT NULL,
+    merchant_id merchant_id,
+    state character varying(20) NOT NULL,
+    added bigint NOT NULL DEFAULT 0,
+    updated bigint NOT NULL DEFAULT 0,
+    removed bigint NOT NULL DEFAULT 0,
+    ignored bigint NOT NULL DEFAULT 0,
+    skipped bigint NOT NULL DEFAULT 0,
+    duplicates bigint NOT NULL DEFAULT 0,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    updated_at timestamp with time zone NOT NULL DEFAULT now(),
+    finished_at timestamp with time zone,
+    error_code character varying(50) NOT NULL DEFAULT '',
+    error_reason text NOT NULL DEFAULT '',
+    file_path text NOT NULL DEFAULT '',
+    checkpoint bigint NOT NULL DEFAULT 0,
+    claimed_by character varying(100) NOT NULL DEFAULT '',
+    claimed_at timestamp with time zone,
+    idempotency_key character varying(255) NOT NULL DEFAULT '',
+    file_format character varying(10) NOT NULL DEFAULT 'xlsx',
+    file_options jsonb NOT NULL DEFAULT '{}'::jsonb,
+    import_mode character varying(20) NOT NULL DEFAULT 'upsert',
+    update_columns text[] NOT NULL DEFAULT '{}'::text[],
+    currency character varying(3) NOT NULL DEFAULT '',
+    file_removed_at timestamp with time zone,
+    deadline timestamp with time zone,
+    labels text[] NOT NULL DEFAULT '{}'::text[],
+    reason_counts jsonb NOT NULL DEFAULT '{}'::jsonb,
+    CONSTRAINT tasks_pkey PRIMARY KEY (id)
+)
+
+    TABLESPACE pg_default;
+
+-- Table: public.task_chunks
+
+-- DROP TABLE public.task_chunks;
+
+CREATE TABLE public.task_chunks
+(
+    task_id character varying(36) NOT NULL,
+    start_row bigint NOT NULL,
+    end_row bigint NOT NULL,
+    added bigint NOT NULL,
+    updated bigint NOT NULL,
+    removed bigint NOT NULL,
+    ignored bigint NOT NULL,
+    skipped bigint NOT NULL DEFAULT 0,
+    started_at timestamp with time zone NOT NULL,
+    finished_at timestamp with time zone NOT NULL,
+    CONSTRAINT task_chunks_pkey PRIMARY KEY (task_id, end_row),
+    CONSTRAINT task_chunks_task_id_fkey FOREIGN KEY (task_id)
+        REFERENCES public.tasks (id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE CASCADE
+)
+
+    TABLESPACE pg_default;
+
+-- Table: public.task_rejected_rows
+
+-- DROP TABLE public.task_rejected_rows;
+
+CREATE TABLE public.task_rejected_rows
+(
+    task_id character varying(36) NOT NULL,
+    row_number bigint NOT NULL,
+    code character varying(30) NOT NULL DEFAULT '',
+    reason text NOT NULL,
+    CONSTRAINT task_rejected_rows_pkey PRIMARY KEY (task_id, row_number),
+    CONSTRAINT task_rejected_rows_task_id_fkey FOREIGN KEY (task_id)
+        REFERENCES public.tasks (id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE CASCADE
+)
+
+    TABLESPACE pg_default;
+
+-- Table: public.task_changes
+
+-- DROP TABLE public.task_changes;
+
+CREATE TABLE public.task_changes
+(
+    task_id character varying(36) NOT NULL,
+    offer_id offer_id,
+    change character varying(10) NOT NULL,
+    name character varying(200),
+    price numeric(14,2),
+    quantity integer,
+    CONSTRAINT task_changes_pkey PRIMARY KEY (task_id, offer_id),
+    CONSTRAINT task_changes_task_id_fkey FOREIGN KEY (task_id)
+        REFERENCES public.tasks (id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE CASCADE
+)
+
+    TABLESPACE pg_default;
+
+-- Table: public.product_history
+
+-- DROP TABLE public.product_history;
+
+-- rows outlive tasks moved to tasks_archive, so task_id does not reference tasks
+CREATE TABLE public.product_history
+(
+    id bigint NOT NULL GENERATED ALWAYS AS IDENTITY,
+    merchant_id merchant_id,
+    offer_id offer_id,
+    task_id character varying(36) NOT NULL,
+    change character varying(10) NOT NULL,
+    old_price numeric(14,2),
+    new_price numeric(14,2),
+    old_quantity integer,
+    new_quantity integer,
+    changed_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT product_history_pkey PRIMARY KEY (id)
+)
+
+    TABLESPACE pg_default;
+
+-- Index: public.product_history_merchant_id_offer_id_idx
+
+-- DROP INDEX public.product_history_merchant_id_offer_id_idx;
+
+CREATE INDEX product_history_merchant_id_offer_id_idx
+    ON public.product_history USING btree
+    (merchant_id, offer_id, id)
+    TABLESPACE pg_default;
+
+-- Table: public.product_events
+
+-- DROP TABLE public.product_events;
+
+-- outbox of product changes, rows are written by import transactions and deleted once published
+CREATE TABLE public.product_events
+(
+    id bigint NOT NULL GENERATED ALWAYS AS IDENTITY,
+    merchant_id merchant_id,
+    offer_id offer_id,
+    task_id character varying(36) NOT NULL,
+    change character varying(10) NOT NULL,
+    name character varying(200),
+    price numeric(14,2),
+    quantity integer,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT product_events_pkey PRIMARY KEY (id)
+)
+
+    TABLESPACE pg_default;
+
+-- Table: public.api_usage
+
+-- DROP TABLE public.api_usage;
+
+CREATE TABLE public.api_usage
+(
+    hour timestamp with time zone NOT NULL,
+    api_key character varying(64) NOT NULL,
+    merchant_id bigint NOT NULL,
+    requests bigint NOT NULL DEFAULT 0,
+    client_errors bigint NOT NULL DEFAULT 0,
+    server_errors bigint NOT NULL DEFAULT 0,
+    bytes_in bigint NOT NULL DEFAULT 0,
+    bytes_out bigint NOT NULL DEFAULT 0,
+    CONSTRAINT api_usage_pkey PRIMARY KEY (hour, api_key, merchant_id)
+)
+
+    TABLESPACE pg_default;
+
+-- Index: public.api_usage_merchant_id_hour_idx
+
+-- DROP INDEX public.api_usage_merchant_id_hour_idx;
+
+CREATE INDEX api_usage_merchant_id_hour_idx
+    ON public.api_usage USING btree
+    (merchant_id, hour)
+    TABLESPACE pg_default;
+
+-- Index: public.tasks_processing_created_at_idx
+
+-- DROP INDEX public.tasks_processing_created_at_idx;
+
+CREATE INDEX tasks_processing_created_at_idx
+    ON public.tasks USING btree
+    (created_at)
+    TABLESPACE pg_default
+    WHERE state::text = 'Processing'::text;
+
+-- Index: public.tasks_file_cleanup_idx
+
+-- DROP INDEX public.tasks_file_cleanup_idx;
+
+CREATE INDEX tasks_file_cleanup_idx
+    ON public.tasks USING btree
+    (finished_at)
+    TABLESPACE pg_default
+    WHERE file_removed_at IS NULL;
+
+-- Index: public.tasks_labels_idx
+
+-- DROP INDEX public.tasks_labels_idx;
+
+CREATE INDEX tasks_labels_idx
+    ON public.tasks USING gin
+    (labels COLLATE pg_catalog."default")
+    TABLESPACE pg_default;
+
+-- Index: public.tasks_merchant_id_idempotency_key_idx
+
+-- DROP INDEX public.tasks_merchant_id_idempotency_key_idx;
+
+CREATE INDEX tasks_merchant_id_idempotency_key_idx
+    ON public.tasks USING btree
+    (merchant_id, idempotency_key COLLATE pg_catalog."default", created_at)
+    TABLESPACE pg_default
+    WHERE idempotency_key::text <> ''::text;
+
+-- Index: public.tasks_merchant_id_finished_at_idx
+
+-- DROP INDEX public.tasks_merchant_id_finished_at_idx;
+
+CREATE INDEX tasks_merchant_id_finished_at_idx
+    ON public.tasks USING btree
+    (merchant_id, finished_at)
+    TABLESPACE pg_default
+    WHERE state::text = 'Done'::text;
+
+-- Index: public.products_merchant_id_name_idx
+
+-- DROP INDEX public.products_merchant_id_name_idx;
+
+CREATE INDEX products_merchant_id_name_idx
+    ON public.products USING btree
+    (merchant_id, name COLLATE pg_catalog."default" text_pattern_ops)
+    TABLESPACE pg_default;
+
+-- Index: public.products_name_trgm_idx
+
+-- DROP INDEX public.products_name_trgm_idx;
+
+CREATE INDEX products_name_trgm_idx
+    ON public.products USING gin
+    (name COLLATE pg_catalog."default" gin_trgm_ops)
+    TABLESPACE pg_default;
+
+-- Table: public.tasks_archive
+
+-- DROP TABLE public.tasks_archive;
+
+CREATE TABLE public.tasks_archive
+(
+    LIKE public.tasks INCLUDING DEFAULTS,
+    archived_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT tasks_archive_pkey PRIMARY KEY (id)
+)
+
+    TABLESPACE pg_default;
+
+-- Table: public.offboardings
+
+-- DROP TABLE public.offboardings;
+
+CREATE TABLE public.offboardings
+(
+    id character varying(36) NOT NULL,
+    merchant_id merchant_id,
+    state character varying(20) NOT NULL,
+    phase character varying(20) NOT NULL DEFAULT '',
+    products bigint NOT NULL DEFAULT 0,
+    tasks bigint NOT NULL DEFAULT 0,
+    files bigint NOT NULL DEFAULT 0,
+    archive_key text NOT NULL DEFAULT '',
+    archive_size bigint NOT NULL DEFAULT 0,
+    error text NOT NULL DEFAULT '',
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    updated_at timestamp with time zone NOT NULL DEFAULT now(),
+    exported_at timestamp with time zone,
+    delete_after timestamp with time zone,
+    deleted_at timestamp with time zone,
+    CONSTRAINT offboardings_pkey PRIMARY KEY (id)
+)
+
+    TABLESPACE pg_default;
+
+-- Index: public.offboardings_active_merchant_id_idx
+
+-- DROP INDEX public.offboardings_active_merchant_id_idx;
+
+-- merchant may have only one offboarding which is exporting or waiting for deletion
+CREATE UNIQUE INDEX offboardings_active_merchant_id_idx
+    ON public.offboardings USING btree
+    (merchant_id)
+    TABLESPACE pg_default
+    WHERE state::text = ANY (ARRAY['Exporting'::text, 'Scheduled'::text]);
+
+-- SCHEMA: sandbox
+
+-- DROP SCHEMA sandbox;
+
+CREATE SCHEMA sandbox;
+
+-- Table: sandbox.products
+
+-- DROP TABLE sandbox.products;
+
+CREATE TABLE sandbox.products
+(
+    LIKE public.products INCLUDING ALL
+)
+
+    TABLESPACE pg_default;
+
+-- Table: sandbox.products_archive
+
+-- DROP TABLE sandbox.products_archive;
+
+CREATE TABLE sandbox.products_archive
+(
+    LIKE public.products_archive INCLUDING ALL
+)
+
+    TABLESPACE pg_default;
+
+-- View: public.catalog_stats
+
+-- DROP MATERIALIZED VIEW public.catalog_stats;
+
+CREATE MATERIALIZED VIEW public.catalog_stats
+    TABLESPACE pg_default
+AS
+SELECT p.merchant_id::bigint AS merchant_id,
+       count(*) AS product_count,
+       min(p.price)::numeric AS min_price,
+       max(p.price)::numeric AS max_price,
+       round(avg(p.price), 2) AS avg_price,
+       (SELECT max(t.updated_at)
+          FROM public.tasks t
+         WHERE t.merchant_id = p.merchant_id
+           AND t.added + t.updated + t.removed > 0) AS last_update
+  FROM public.products p
+ WHERE p.is_available
+ GROUP BY p.merchant_id
+WITH DATA;
+
+-- unique index is required by REFRESH MATERIALIZED VIEW CONCURRENTLY
+CREATE UNIQUE INDEX catalog_stats_merchant_id_idx
+    ON public.catalog_stats USING btree
+    (merchant_id)
+    TABLESPACE pg_default;
@@ -0,0 +1,42 @@
+-- Migration: products.is_available
+-- Offers uploaded as unavailable are kept with is_available = false instead of being deleted.
+-- Existing rows are available ones, so the default fills them. Archive tables are filled by explicit column list,
+-- so the column may follow archived_at there.
+
+ALTER TABLE public.products
+    ADD COLUMN IF NOT EXISTS is_available boolean NOT NULL DEFAULT true;
+
+ALTER TABLE public.products_archive
+    ADD COLUMN IF NOT EXISTS is_available boolean NOT NULL DEFAULT true;
+
+ALTER TABLE sandbox.products
+    ADD COLUMN IF NOT EXISTS is_available boolean NOT NULL DEFAULT true;
+
+ALTER TABLE sandbox.products_archive
+    ADD COLUMN IF NOT EXISTS is_available boolean NOT NULL DEFAULT true;
+
+-- catalog_stats summarizes available products only
+
+DROP MATERIALIZED VIEW IF EXISTS public.catalog_stats;
+
+CREATE MATERIALIZED VIEW public.catalog_stats
+    TABLESPACE pg_default
+AS
+SELECT p.merchant_id::bigint AS merchant_id,
+       count(*) AS product_count,
+       min(p.price)::numeric AS min_price,
+       max(p.price)::numeric AS max_price,
+       round(avg(p.price), 2) AS avg_price,
+       (SELECT max(t.updated_at)
+          FROM public.tasks t
+         WHERE t.merchant_id = p.merchant_id
+           AND t.added + t.updated + t.removed > 0) AS last_update
+  FROM public.products p
+ WHERE p.is_available
+ GROUP BY p.merchant_id
+WITH DATA;
+
+CREATE UNIQUE INDEX catalog_stats_merchant_id_idx
+    ON public.catalog_stats USING btree
+    (merchant_id)
+    TABLESPACE pg_default;
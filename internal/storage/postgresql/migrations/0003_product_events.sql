@@ -0,0 +1,18 @@
+-- Migration: product_events
+-- Outbox of product changes published to Kafka, see OUTBOX_KAFKA_REST_URL.
+
+CREATE TABLE IF NOT EXISTS public.product_events
+(
+    id bigint NOT NULL GENERATED ALWAYS AS IDENTITY,
+    merchant_id merchant_id,
+    offer_id offer_id,
+    task_id character varying(36) NOT NULL,
+    change character varying(10) NOT NULL,
+    name character varying(200),
+    price numeric(14,2),
+    quantity integer,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT product_events_pkey PRIMARY KEY (id)
+)
+
+    TABLESPACE pg_default;
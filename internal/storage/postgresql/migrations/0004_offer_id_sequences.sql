@@ -0,0 +1,11 @@
+-- Migration: offer_id_sequences
+-- Offer ids allocated to products created via POST /products without offer_id.
+
+CREATE TABLE IF NOT EXISTS public.offer_id_sequences
+(
+    merchant_id merchant_id,
+    last_offer_id offer_id,
+    CONSTRAINT offer_id_sequences_pkey PRIMARY KEY (merchant_id)
+)
+
+    TABLESPACE pg_default;
@@ -0,0 +1,12 @@
+-- Migration: merchant_freezes
+-- Freezes blocking writes to merchant catalogs, see /admin/freezes.
+
+CREATE TABLE IF NOT EXISTS public.merchant_freezes
+(
+    merchant_id merchant_id,
+    reason text NOT NULL DEFAULT '',
+    frozen_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT merchant_freezes_pkey PRIMARY KEY (merchant_id)
+)
+
+    TABLESPACE pg_default;
@@ -0,0 +1,17 @@
+-- Migration: task_approvals
+-- Decisions on tasks waiting in PendingApproval state, see POST /tasks/{id}/approve.
+
+CREATE TABLE IF NOT EXISTS public.task_approvals
+(
+    task_id character varying(36) NOT NULL,
+    approved boolean NOT NULL,
+    reason text NOT NULL DEFAULT '',
+    decided_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT task_approvals_pkey PRIMARY KEY (task_id),
+    CONSTRAINT task_approvals_task_id_fkey FOREIGN KEY (task_id)
+        REFERENCES public.tasks (id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE CASCADE
+)
+
+    TABLESPACE pg_default;
@@ -0,0 +1,310 @@
+package postgresql
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+	"time"
+)
+
+// ErrOffboardingNotFound is returned when there is no offboarding with requested id
+var ErrOffboardingNotFound = errors.New("offboarding not found")
+
+// ErrOffboardingInProgress is returned when merchant has offboarding which is either exporting or scheduled
+var ErrOffboardingInProgress = errors.New("offboarding is already in progress")
+
+// Offboarding defines export of merchant data followed by deletion of the data after grace period
+type Offboarding struct {
+	ID         string `json:"id"`
+	MerchantID int64  `json:"merchant_id"`
+	State      string `json:"state"`
+	// Phase is part of data being exported while State is Exporting
+	Phase string `json:"phase,omitempty"`
+	// Products, Tasks and Files are numbers of catalog rows, task records and stored files exported so far
+	Products int64 `json:"products"`
+	Tasks    int64 `json:"tasks"`
+	Files    int64 `json:"files"`
+	// ArchiveKey is blob key of exported archive, empty until the archive is saved
+	ArchiveKey  string `json:"archive_key,omitempty"`
+	ArchiveSize int64  `json:"archive_size,omitempty"`
+	// Error describes why export or deletion failed
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// ExportedAt is set once archive is saved, DeleteAfter is moment merchant data is deleted after
+	ExportedAt  *time.Time `json:"exported_at,omitempty"`
+	DeleteAfter *time.Time `json:"delete_after,omitempty"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+}
+
+// offboardingColumns defines columns scanned by scanOffboarding in the same order
+const offboardingColumns = `id, merchant_id, state, phase, products, tasks, files, archive_key, archive_size, error,
+                            created_at, updated_at, exported_at, delete_after, deleted_at`
+
+func scanOffboarding(row pgx.Row) (Offboarding, error) {
+	var o Offboarding
+	err := row.Scan(
+		&o.ID,
+		&o.MerchantID,
+		&o.State,
+		&o.Phase,
+		&o.Products,
+		&o.Tasks,
+		&o.Files,
+		&o.ArchiveKey,
+		&o.ArchiveSize,
+		&o.Error,
+		&o.CreatedAt,
+		&o.UpdatedAt,
+		&o.ExportedAt,
+		&o.DeleteAfter,
+		&o.DeletedAt,
+	)
+	return o, err
+}
+
+// CreateOffboarding inserts new offboarding record using ID, MerchantID, State and CreatedAt of o.
+// Returns ErrOffboardingInProgress if merchant already has offboarding in one of activeStates.
+func (s *Storage) CreateOffboarding(ctx context.Context, o Offboarding, activeStates []string) error {
+	sql := `INSERT INTO offboardings (id, merchant_id, state, created_at, updated_at)
+            SELECT $1, $2, $3, $4, $4
+             WHERE NOT EXISTS (SELECT 1
+                                 FROM offboardings
+                                WHERE merchant_id = $2
+                                  AND state = ANY ($5))`
+
+	tag, err := s.db.Exec(ctx, sql, o.ID, o.MerchantID, o.State, o.CreatedAt, activeStates)
+	if err != nil {
+		if errors.Is(classify(err), ErrConflict) {
+			// concurrent request has inserted active offboarding of the merchant first
+			return ErrOffboardingInProgress
+		}
+
+		s.log(ctx).Error("Inserting offboarding", zap.String("offboarding_id", o.ID), zap.Error(err))
+		return classify(err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrOffboardingInProgress
+	}
+
+	return nil
+}
+
+// UpdateOffboarding saves state, progress, archive and timestamps of existing offboarding record
+func (s *Storage) UpdateOffboarding(ctx context.Context, o Offboarding) error {
+	sql := `UPDATE offboardings
+               SET state = $2,
+                   phase = $3,
+                   products = $4,
+                   tasks = $5,
+                   files = $6,
+                   archive_key = $7,
+                   archive_size = $8,
+                   error = $9,
+                   updated_at = now(),
+                   exported_at = $10,
+                   delete_after = $11,
+                   deleted_at = $12
+             WHERE id = $1`
+
+	tag, err := s.db.Exec(ctx, sql, o.ID, o.State, o.Phase, o.Products, o.Tasks, o.Files, o.ArchiveKey, o.ArchiveSize, o.Error,
+		o.ExportedAt, o.DeleteAfter, o.DeletedAt)
+	if err != nil {
+		s.log(ctx).Error("Updating offboarding", zap.String("offboarding_id", o.ID), zap.Error(err))
+		return classify(err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrOffboardingNotFound
+	}
+
+	return nil
+}
+
+// SetOffboardingState changes state of offboarding record only if it is currently in from state.
+// Returns ErrOffboardingNotFound if there is no such record and ErrConflict if the record is in other state.
+func (s *Storage) SetOffboardingState(ctx context.Context, id string, from, to string) (Offboarding, error) {
+	sql := `UPDATE offboardings
+               SET state = $3,
+                   updated_at = now()
+             WHERE id = $1
+               AND state = $2
+         RETURNING ` + offboardingColumns
+
+	o, err := scanOffboarding(s.db.QueryRow(ctx, sql, id, from, to))
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			s.log(ctx).Error("Changing offboarding state", zap.String("offboarding_id", id), zap.Error(err))
+			return Offboarding{}, classify(err)
+		}
+
+		_, err = s.ReadOffboarding(ctx, id)
+		if err != nil {
+			return Offboarding{}, err
+		}
+		return Offboarding{}, ErrConflict
+	}
+
+	return o, nil
+}
+
+// ReadOffboarding returns offboarding record with provided id or ErrOffboardingNotFound
+func (s *Storage) ReadOffboarding(ctx context.Context, id string) (Offboarding, error) {
+	sql := `SELECT ` + offboardingColumns + `
+              FROM offboardings
+             WHERE id = $1`
+
+	o, err := scanOffboarding(s.db.QueryRow(ctx, sql, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Offboarding{}, ErrOffboardingNotFound
+		}
+
+		s.log(ctx).Error("Reading offboarding", zap.String("offboarding_id", id), zap.Error(err))
+		return Offboarding{}, classify(err)
+	}
+
+	return o, nil
+}
+
+// Offboardings returns offboarding records in provided state in creation order,
+// non-nil deleteBefore limits them to ones which have to be deleted before the moment
+func (s *Storage) Offboardings(ctx context.Context, state string, deleteBefore *time.Time) ([]Offboarding, error) {
+	sql := `SELECT ` + offboardingColumns + `
+              FROM offboardings
+             WHERE state = $1
+               AND ($2::timestamptz IS NULL OR delete_after < $2)
+             ORDER BY created_at`
+
+	rows, err := s.db.Query(ctx, sql, state, deleteBefore)
+	if err != nil {
+		s.log(ctx).Error("Selecting offboardings", zap.String("state", state), zap.Error(err))
+		return nil, classify(err)
+	}
+	defer rows.Close()
+
+	var offboardings []Offboarding
+	for rows.Next() {
+		o, err := scanOffboarding(rows)
+		if err != nil {
+			s.log(ctx).Error("Scanning row", zap.Error(err))
+			return nil, classify(err)
+		}
+
+		offboardings = append(offboardings, o)
+	}
+
+	if rows.Err() != nil {
+		return nil, classify(rows.Err())
+	}
+
+	return offboardings, nil
+}
+
+// MerchantProducts returns available products of the merchant ordered by offer id ignoring list row cap
+func (s *Storage) MerchantProducts(ctx context.Context, merchantID int64) ([]Product, error) {
+	sql := `SELECT ` + productSelectColumns + `
+              FROM ` + s.productsTable(merchantID) + `
+             WHERE merchant_id = $1
+               AND is_available
+             ORDER BY offer_id`
+
+	rows, err := s.db.Query(ctx, sql, merchantID)
+	if err != nil {
+		s.log(ctx).Error("Selecting merchant products", zap.Int64("merchant_id", merchantID), zap.Error(err))
+		return nil, classify(err)
+	}
+
+	return s.collectProducts(ctx, rows)
+}
+
+// ArchivedProducts returns offers of the merchant moved to products_archive by expiry rules
+func (s *Storage) ArchivedProducts(ctx context.Context, merchantID int64) ([]Product, error) {
+	sql := `SELECT ` + productSelectColumns + `
+              FROM ` + s.archiveTable(merchantID) + `
+             WHERE merchant_id = $1
+             ORDER BY offer_id, archived_at`
+
+	rows, err := s.db.Query(ctx, sql, merchantID)
+	if err != nil {
+		s.log(ctx).Error("Selecting archived products", zap.Int64("merchant_id", merchantID), zap.Error(err))
+		return nil, classify(err)
+	}
+
+	return s.collectProducts(ctx, rows)
+}
+
+// MerchantTasks returns all task records of the merchant including archived ones in creation order
+func (s *Storage) MerchantTasks(ctx context.Context, merchantID int64) ([]Task, error) {
+	sql := `SELECT ` + taskColumns + ` FROM tasks WHERE merchant_id = $1
+             UNION ALL
+            SELECT ` + taskColumns + ` FROM tasks_archive WHERE merchant_id = $1
+             ORDER BY created_at, id`
+
+	rows, err := s.db.Query(ctx, sql, merchantID)
+	if err != nil {
+		s.log(ctx).Error("Selecting merchant tasks", zap.Int64("merchant_id", merchantID), zap.Error(err))
+		return nil, classify(err)
+	}
+	defer rows.Close()
+
+	var tasks []Task
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			s.log(ctx).Error("Scanning row", zap.Error(err))
+			return nil, classify(err)
+		}
+
+		tasks = append(tasks, t)
+	}
+
+	if rows.Err() != nil {
+		return nil, classify(rows.Err())
+	}
+
+	return tasks, nil
+}
+
+// DeleteMerchantData deletes catalog, archived offers, tasks with their chunks, rejected rows and changes,
+// archived tasks, product history, expiry rule, expired offers, API usage, import source and offer id sequence
+// of the merchant in single transaction
+func (s *Storage) DeleteMerchantData(ctx context.Context, merchantID int64) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.log(ctx).Error("Starting transaction", zap.Error(err))
+		return classify(err)
+	}
+	defer tx.Rollback(context.Background())
+
+	tables := []string{
+		s.productsTable(merchantID),
+		s.archiveTable(merchantID),
+		// task_chunks, task_rejected_rows and task_changes rows are deleted by cascade
+		"tasks",
+		"tasks_archive",
+		"product_history",
+		"expiry_rules",
+		"expired_offers",
+		"api_usage",
+		"import_sources",
+		"offer_id_sequences",
+	}
+	for _, table := range tables {
+		_, err = tx.Exec(ctx, "DELETE FROM "+table+" WHERE merchant_id = $1", merchantID)
+		if err != nil {
+			s.log(ctx).Error("Deleting merchant data", zap.Int64("merchant_id", merchantID), zap.String("table", table), zap.Error(err))
+			return classify(err)
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.log(ctx).Error("Committing transaction", zap.Error(err))
+		return classify(err)
+	}
+
+	return nil
+}
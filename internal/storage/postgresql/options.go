@@ -0,0 +1,86 @@
+package postgresql
+
+import "github.com/shopspring/decimal"
+
+// ListQuery defines products requested by ListOptions, so other catalog storages can apply the options
+type ListQuery struct {
+	// MerchantID and OfferID are zero unless respective filter is set
+	MerchantID int64
+	OfferID    int64
+	// NameQuery is empty unless name filter is set, NameMatch is one of NameMatch constants or empty for prefix match
+	NameQuery string
+	NameMatch string
+	// Limit and Offset define page of products ordered by merchant_id and offer_id, zero Limit means no limit
+	Limit  int64
+	Offset int64
+	// AfterMerchantID and AfterOfferID are key products are listed after if AfterSet is true, see WithAfter
+	AfterMerchantID int64
+	AfterOfferID    int64
+	AfterSet        bool
+	// PriceMin and PriceMax bound price inclusively, nil means the bound is not set
+	PriceMin *decimal.Decimal
+	PriceMax *decimal.Decimal
+	// MinQuantity excludes products with smaller quantity, zero means quantity is not filtered
+	MinQuantity int64
+	// Availability is one of Availability constants, empty means AvailabilityAvailable
+	Availability string
+}
+
+// NewListQuery applies options the way List does
+func NewListQuery(options ...ListOption) ListQuery {
+	parameters := listParameters{
+		merchantID: defaultMerchantID,
+		offerID:    defaultOfferID,
+		nameQuery:  defaultNameQuery,
+	}
+
+	for _, opt := range options {
+		opt(&parameters)
+	}
+
+	return ListQuery{
+		MerchantID:      parameters.merchantID,
+		OfferID:         parameters.offerID,
+		NameQuery:       parameters.nameQuery,
+		NameMatch:       parameters.nameMatch,
+		Limit:           parameters.limit,
+		Offset:          parameters.offset,
+		AfterMerchantID: parameters.afterMerchantID,
+		AfterOfferID:    parameters.afterOfferID,
+		AfterSet:        parameters.afterSet,
+		PriceMin:        parameters.priceMin,
+		PriceMax:        parameters.priceMax,
+		MinQuantity:     parameters.minQuantity,
+		Availability:    parameters.availability,
+	}
+}
+
+// ImportQuery defines behaviour of UpsertAndDelete requested by ImportOptions which does not depend on task records,
+// so other catalog storages can apply the options
+type ImportQuery struct {
+	// OnPhase is called every time import starts new phase, it is never nil
+	OnPhase    func(phase string)
+	InsertOnly bool
+	// UpdateColumns limits columns of existing products set by import, empty means UpdatableColumns
+	UpdateColumns []string
+	// Preview leaves catalog unchanged
+	Preview bool
+}
+
+// NewImportQuery applies options the way UpsertAndDelete does
+func NewImportQuery(options ...ImportOption) ImportQuery {
+	parameters := &importParameters{
+		onPhase: func(string) {},
+	}
+
+	for _, opt := range options {
+		opt(parameters)
+	}
+
+	return ImportQuery{
+		OnPhase:       parameters.onPhase,
+		InsertOnly:    parameters.insertOnly,
+		UpdateColumns: parameters.updateColumns,
+		Preview:       parameters.preview,
+	}
+}
@@ -0,0 +1,68 @@
+package postgresql
+
+import (
+	"errors"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"time"
+)
+
+// PoolSettings defines connection pool of Storage, zero fields keep defaults of pgxpool
+// or values of PG* environment variables, e.g. PGCONNECT_TIMEOUT
+type PoolSettings struct {
+	// MaxConns and MinConns limit number of open connections
+	MaxConns int32
+	MinConns int32
+	// MaxConnLifetime and MaxConnIdleTime define how long connection may be open and stay idle before it is closed
+	MaxConnLifetime time.Duration
+	MaxConnIdleTime time.Duration
+	// HealthCheckPeriod is period between checks closing connections which are broken, idle or too old
+	HealthCheckPeriod time.Duration
+	// ConnectTimeout limits time of establishing single connection
+	ConnectTimeout time.Duration
+}
+
+// Validate checks settings are not negative and MinConns does not exceed MaxConns
+func (p PoolSettings) Validate() error {
+	if p.MaxConns < 0 || p.MinConns < 0 {
+		return errors.New("pool connection limits must not be negative")
+	}
+
+	if p.MaxConns > 0 && p.MinConns > p.MaxConns {
+		return errors.New("minimum number of pool connections must not exceed maximum one")
+	}
+
+	if p.MaxConnLifetime < 0 || p.MaxConnIdleTime < 0 || p.HealthCheckPeriod < 0 || p.ConnectTimeout < 0 {
+		return errors.New("pool durations must not be negative")
+	}
+
+	return nil
+}
+
+// WithPoolSettings applies passed settings to connection pool opened by NewStorage
+func WithPoolSettings(settings PoolSettings) StorageOption {
+	return func(s *Storage) {
+		s.pool = settings
+	}
+}
+
+// apply copies non-zero settings to config
+func (p PoolSettings) apply(config *pgxpool.Config) {
+	if p.MaxConns > 0 {
+		config.MaxConns = p.MaxConns
+	}
+	if p.MinConns > 0 {
+		config.MinConns = p.MinConns
+	}
+	if p.MaxConnLifetime > 0 {
+		config.MaxConnLifetime = p.MaxConnLifetime
+	}
+	if p.MaxConnIdleTime > 0 {
+		config.MaxConnIdleTime = p.MaxConnIdleTime
+	}
+	if p.HealthCheckPeriod > 0 {
+		config.HealthCheckPeriod = p.HealthCheckPeriod
+	}
+	if p.ConnectTimeout > 0 {
+		config.ConnConfig.ConnectTimeout = p.ConnectTimeout
+	}
+}
@@ -8,34 +8,176 @@ import (
 	"github.com/jackc/pgx/v4/log/zapadapter"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"go.uber.org/zap"
+	"mx/internal/logctx"
+	"time"
 )
 
 // Storage defines fields used in db interaction processes
 type Storage struct {
 	logger *zap.Logger
 	db     *pgxpool.Pool
+	// maxCatalogSize limits products count per merchant, zero means no limit
+	maxCatalogSize int64
+	retry          retryPolicy
+	// sandboxMerchants contains ids of merchants which catalogs are stored in sandbox schema
+	sandboxMerchants map[int64]struct{}
+	// slowQueryThreshold enables capturing plans of slow list queries if positive
+	slowQueryThreshold time.Duration
+	explainSampleRate  float64
+	// maxListRows caps number of products read by single List query, zero means no cap
+	maxListRows int64
+	// copyFormat is format of COPY used for bulk inserts, see WithCopyFormat
+	copyFormat string
+	// outbox enables saving changes of tasks to product_events, see WithOutbox
+	outbox bool
+	// skipMigrations disables applying embedded migrations by NewStorage, see WithoutMigrations
+	skipMigrations bool
+	// pool defines connection pool opened by NewStorage, see WithPoolSettings
+	pool PoolSettings
+	// readTimeout and writeTimeout bound read queries and catalog writes, see WithStatementTimeouts
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	// largeDeleteThreshold is number of offer ids Delete applies by VALUES list at most, zero selects it by deleteRTT,
+	// see WithLargeDeleteThreshold
+	largeDeleteThreshold int64
+	// deleteIDCost is server time spent on single offer id of VALUES list, see WithDeleteIDCost
+	deleteIDCost time.Duration
+	// deleteRTT is average round trip time in nanoseconds measured by Delete and deleteCalls is number of Delete calls
+	// selecting threshold automatically, both are accessed atomically
+	deleteRTT   int64
+	deleteCalls int64
 }
 
-// NewStorage constructs Store instance with configured logger
-func NewStorage(ctx context.Context, logger *zap.Logger) (*Storage, error) {
+// StorageOption type represents function to modify Storage struct
+type StorageOption func(s *Storage)
+
+// WithCatalogLimit applies passed limit as maximum number of products per merchant
+func WithCatalogLimit(limit int64) StorageOption {
+	return func(s *Storage) {
+		s.maxCatalogSize = limit
+	}
+}
+
+// WithMaxListRows caps number of products returned by List whatever limit is requested,
+// so query without limit can not read the whole table
+func WithMaxListRows(n int64) StorageOption {
+	return func(s *Storage) {
+		s.maxListRows = n
+	}
+}
+
+// CatalogLimitError is returned when import would make merchant catalog larger than configured limit
+type CatalogLimitError struct {
+	MerchantID   int64
+	Limit        int64
+	CurrentCount int64
+	ResultCount  int64
+}
+
+// Error returns string representation of CatalogLimitError
+func (e *CatalogLimitError) Error() string {
+	return fmt.Sprintf(
+		"catalog of merchant %d is limited to %d products: it has %d products now and would have %d after import",
+		e.MerchantID,
+		e.Limit,
+		e.CurrentCount,
+		e.ResultCount,
+	)
+}
+
+// NewStorage constructs Store instance with configured logger and applies embedded migrations unless WithoutMigrations is passed
+func NewStorage(ctx context.Context, logger *zap.Logger, options ...StorageOption) (*Storage, error) {
 	if logger == nil {
 		return nil, errors.New("no logger provided")
 	}
 
+	storage := &Storage{
+		logger:               logger,
+		retry:                defaultRetryPolicy(),
+		copyFormat:           CopyFormatBinary,
+		largeDeleteThreshold: DefaultLargeDeleteThreshold,
+		deleteIDCost:         DefaultDeleteIDCost,
+	}
+
+	for _, opt := range options {
+		opt(storage)
+	}
+
+	err := storage.pool.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if storage.readTimeout < 0 || storage.writeTimeout < 0 {
+		return nil, errors.New("statement timeouts can not be negative")
+	}
+
+	if storage.largeDeleteThreshold < 0 {
+		return nil, errors.New("large delete threshold can not be negative")
+	}
+
+	if storage.deleteIDCost <= 0 {
+		return nil, errors.New("delete id cost must be positive")
+	}
+
 	config, _ := pgxpool.ParseConfig("")
 
 	config.ConnConfig.Logger = zapadapter.NewLogger(logger)
 	config.ConnConfig.LogLevel = pgx.LogLevelError
+	storage.pool.apply(config)
 
 	pool, err := pgxpool.ConnectConfig(ctx, config)
 	if err != nil {
 		return nil, fmt.Errorf("cannot connect using config %+v: %w", config, err)
 	}
+	storage.db = pool
 
-	return &Storage{
-		logger: logger,
-		db:     pool,
-	}, nil
+	if !storage.skipMigrations {
+		err = storage.Migrate(ctx)
+		if err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("applying migrations: %w", err)
+		}
+	}
+
+	return storage, nil
+}
+
+// CatalogLimit returns maximum number of products per merchant, zero means no limit
+func (s *Storage) CatalogLimit() int64 {
+	return s.maxCatalogSize
+}
+
+// MaxListRows returns cap of products returned by List, zero means no cap
+func (s *Storage) MaxListRows() int64 {
+	return s.maxListRows
+}
+
+// CountProducts returns number of available products of the merchant
+func (s *Storage) CountProducts(ctx context.Context, merchantID int64) (int64, error) {
+	ctx, cancel := s.readContext(ctx)
+	defer cancel()
+
+	var count int64
+	sql := "SELECT count(*) FROM " + s.productsTable(merchantID) + " WHERE merchant_id = $1 AND is_available"
+	err := s.db.QueryRow(ctx, sql, merchantID).Scan(&count)
+	if err != nil {
+		s.log(ctx).Error("Counting merchant products", zap.Int64("merchant_id", merchantID), zap.Error(err))
+		return 0, classify(err)
+	}
+
+	return count, nil
+}
+
+// Ping checks database is reachable
+func (s *Storage) Ping(ctx context.Context) error {
+	_, err := s.db.Exec(ctx, "SELECT 1")
+	return classify(err)
+}
+
+// log returns logger of request or task carried by ctx, so SQL errors can be correlated with them
+func (s *Storage) log(ctx context.Context) *zap.Logger {
+	return logctx.FromContext(ctx, s.logger)
 }
 
 // Close closes all database connections in pool
@@ -44,29 +186,249 @@ func (s *Storage) Close() {
 	s.db.Close()
 }
 
-func (s *Storage) UpsertAndDelete(ctx context.Context, toUpsert []Product, merchantID int64, toDelete []int64) (int64, int64, int64, error) {
+// phases of UpsertAndDelete reported through WithPhaseCallback
+const (
+	PhaseUpserting = "upserting"
+	PhaseDeleting  = "deleting"
+)
+
+// import modes of task, ImportModeInsertOnly leaves existing offers unchanged and deletes nothing
+const (
+	ImportModeUpsert     = "upsert"
+	ImportModeInsertOnly = "insert-only"
+)
+
+// ImportStats defines rows count of UpsertAndDelete result,
+// Skipped is number of provided rows which did not change the catalog
+type ImportStats struct {
+	Added   int64
+	Updated int64
+	Removed int64
+	Skipped int64
+}
+
+// importParameters defines fields that affect UpsertAndDelete behaviour
+type importParameters struct {
+	onPhase    func(phase string)
+	insertOnly bool
+	// updateColumns limits columns of existing products set by import, empty means UpdatableColumns
+	updateColumns []string
+	// checkpointTaskID is id of task which checkpoint is saved within the same transaction if not empty
+	checkpointTaskID string
+	checkpoint       int64
+	ignored          int64
+	duplicates       int64
+	// rejected contains rows of the chunk ignored as invalid, reasonCounts counts rows of the chunk per reason code
+	rejected     []RejectedRow
+	reasonCounts map[string]int64
+	// preview rolls the transaction back keeping changes of the task only
+	preview bool
+}
+
+// ImportOption type represents function to modify importParameters struct
+type ImportOption func(parameters *importParameters)
+
+// WithPhaseCallback makes UpsertAndDelete call f every time it starts new phase
+func WithPhaseCallback(f func(phase string)) ImportOption {
+	return func(p *importParameters) {
+		p.onPhase = f
+	}
+}
+
+// WithCheckpoint makes UpsertAndDelete save checkpoint of the task and add applied rows stats
+// to the task record within the same transaction, ignored and duplicates are numbers of rows skipped
+// as invalid or as duplicates of other rows before checkpoint. Changed offers are saved as changes of the task,
+// see TaskChanges.
+func WithCheckpoint(taskID string, checkpoint int64, ignored, duplicates int64) ImportOption {
+	return func(p *importParameters) {
+		p.checkpointTaskID = taskID
+		p.checkpoint = checkpoint
+		p.ignored = ignored
+		p.duplicates = duplicates
+	}
+}
+
+// WithInsertOnly makes UpsertAndDelete insert new offers only, existing offers and offers to delete are skipped
+func WithInsertOnly() ImportOption {
+	return func(p *importParameters) {
+		p.insertOnly = true
+	}
+}
+
+// WithUpdateColumns makes UpsertAndDelete set only provided columns of existing offers,
+// columns are expected to pass ValidateUpdateColumns
+func WithUpdateColumns(columns ...string) ImportOption {
+	return func(p *importParameters) {
+		p.updateColumns = columns
+	}
+}
+
+// WithPreview makes UpsertAndDelete roll back its transaction saving only offers it would have changed as changes
+// of the task passed to WithCheckpoint, so they can be reviewed before the task is applied. Checkpoint, chunk,
+// rejected rows and stats of the task record are left unchanged.
+func WithPreview() ImportOption {
+	return func(p *importParameters) {
+		p.preview = true
+	}
+}
+
+// UpsertAndDelete upserts and deletes provided products within single transaction.
+// Transaction is performed once again if it fails due to transient error according to retry policy.
+//
+// Returns stats of applied rows and error.
+func (s *Storage) UpsertAndDelete(ctx context.Context, toUpsert []Product, merchantID int64, toDelete []int64, options ...ImportOption) (ImportStats, error) {
+	ctx, cancel := s.writeContext(ctx)
+	defer cancel()
+
+	parameters := &importParameters{
+		onPhase: func(string) {},
+	}
+
+	for _, opt := range options {
+		opt(parameters)
+	}
+
+	var stats ImportStats
+	err := s.withRetry(ctx, "upsert and delete", func() error {
+		var err error
+		stats, err = s.upsertAndDelete(ctx, toUpsert, merchantID, toDelete, parameters)
+		return classify(err)
+	})
+	if err != nil {
+		return ImportStats{}, classify(err)
+	}
+
+	return stats, nil
+}
+
+func (s *Storage) upsertAndDelete(ctx context.Context, toUpsert []Product, merchantID int64, toDelete []int64, parameters *importParameters) (ImportStats, error) {
 	var inserted, updated, deleted int64
 	var err error
 
-	s.logger.Debug("Starting parent transaction")
+	startedAt := time.Now()
+	s.log(ctx).Debug("Starting parent transaction")
 
 	tx, err := s.db.Begin(ctx)
 	if err != nil {
-		return 0, 0, 0, err
+		return ImportStats{}, err
 	}
 	defer tx.Rollback(context.Background())
 
+	err = checkNotFrozen(ctx, tx, merchantID)
+	if err != nil {
+		return ImportStats{}, err
+	}
+
+	if s.maxCatalogSize > 0 {
+		// concurrent imports for the same merchant have to be serialized to check catalog size correctly
+		_, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", merchantID)
+		if err != nil {
+			s.log(ctx).Error("Acquiring merchant lock", zap.Error(err))
+			return ImportStats{}, err
+		}
+	}
+
 	if len(toUpsert) != 0 {
-		inserted, updated, err = s.Upsert(ctx, toUpsert, asNestedTo(tx))
+		parameters.onPhase(PhaseUpserting)
+		txOpts := []TxOption{asNestedTo(tx), onTable(s.productsTable(merchantID))}
+		if parameters.insertOnly {
+			txOpts = append(txOpts, insertOnly())
+		}
+		if len(parameters.updateColumns) != 0 {
+			txOpts = append(txOpts, updatingColumns(parameters.updateColumns))
+		}
+		if parameters.checkpointTaskID != "" {
+			txOpts = append(txOpts, recordingChanges(parameters.checkpointTaskID))
+		}
+
+		inserted, updated, err = s.Upsert(ctx, toUpsert, txOpts...)
+		if err != nil {
+			return ImportStats{}, err
+		}
+	}
+
+	if len(toDelete) != 0 && !parameters.insertOnly {
+		parameters.onPhase(PhaseDeleting)
+		txOpts := []TxOption{asNestedTo(tx)}
+		if parameters.checkpointTaskID != "" {
+			txOpts = append(txOpts, recordingChanges(parameters.checkpointTaskID))
+		}
+
+		deleted, err = s.Delete(ctx, merchantID, toDelete, txOpts...)
 		if err != nil {
-			return 0, 0, 0, err
+			return ImportStats{}, err
 		}
 	}
 
-	if len(toDelete) != 0 {
-		deleted, err = s.Delete(ctx, merchantID, toDelete, asNestedTo(tx))
+	if s.maxCatalogSize > 0 {
+		var count int64
+		sql := "SELECT count(*) FROM " + s.productsTable(merchantID) + " WHERE merchant_id = $1 AND is_available"
+		err = tx.QueryRow(ctx, sql, merchantID).Scan(&count)
+		if err != nil {
+			s.log(ctx).Error("Counting merchant products", zap.Error(err))
+			return ImportStats{}, err
+		}
+
+		// imports shrinking catalog are allowed even if it is still over the limit
+		if count > s.maxCatalogSize && inserted > deleted {
+			s.log(ctx).Info("Catalog limit exceeded", zap.Int64("merchant_id", merchantID), zap.Int64("count", count))
+			return ImportStats{}, &CatalogLimitError{
+				MerchantID:   merchantID,
+				Limit:        s.maxCatalogSize,
+				CurrentCount: count - inserted + deleted,
+				ResultCount:  count,
+			}
+		}
+	}
+
+	stats := ImportStats{
+		Added:   inserted,
+		Updated: updated,
+		Removed: deleted,
+		Skipped: int64(len(toUpsert)) - inserted - updated,
+	}
+	if parameters.insertOnly {
+		stats.Skipped += int64(len(toDelete))
+	}
+
+	if parameters.checkpointTaskID != "" && !parameters.preview {
+		// chunk starts at previous checkpoint, so it is saved before checkpoint is moved
+		err = s.saveChunk(ctx, tx, parameters, stats, startedAt)
+		if err != nil {
+			return ImportStats{}, err
+		}
+
+		err = s.saveRejectedRows(ctx, tx, parameters)
+		if err != nil {
+			return ImportStats{}, err
+		}
+
+		sql := `UPDATE tasks
+                   SET checkpoint = $2,
+                       added = added + $3,
+                       updated = updated + $4,
+                       removed = removed + $5,
+                       ignored = ignored + $6,
+                       skipped = skipped + $7,
+                       duplicates = duplicates + $8,
+                       reason_counts = (SELECT coalesce(jsonb_object_agg(code, n), '{}'::jsonb)
+                                          FROM (SELECT code, sum(n::bigint) AS n
+                                                  FROM (SELECT * FROM jsonb_each_text(reason_counts)
+                                                         UNION ALL
+                                                        SELECT * FROM jsonb_each_text($9::jsonb)) AS counts (code, n)
+                                                 GROUP BY code) AS sums),
+                       updated_at = now()
+                 WHERE id = $1`
+
+		reasonCounts := parameters.reasonCounts
+		if reasonCounts == nil {
+			reasonCounts = map[string]int64{}
+		}
+		_, err = tx.Exec(ctx, sql, parameters.checkpointTaskID, parameters.checkpoint, stats.Added, stats.Updated, stats.Removed, parameters.ignored, stats.Skipped, parameters.duplicates,
+			reasonCounts)
 		if err != nil {
-			return 0, 0, 0, err
+			s.log(ctx).Error("Saving task checkpoint", zap.Error(err))
+			return ImportStats{}, err
 		}
 	}
 
@@ -74,20 +436,32 @@ func (s *Storage) UpsertAndDelete(ctx context.Context, toUpsert []Product, merch
 	if ctxErr != nil {
 		switch {
 		case errors.Is(ctxErr, context.DeadlineExceeded):
-			s.logger.Info("Task deadline exceeded")
-			return 0, 0, 0, ctxErr
+			s.log(ctx).Info("Task deadline exceeded")
+			return ImportStats{}, ctxErr
 
 		case errors.Is(ctxErr, context.Canceled):
-			s.logger.Info("Task is canceled")
-			return 0, 0, 0, ctxErr
+			s.log(ctx).Info("Task is canceled")
+			return ImportStats{}, ctxErr
 		}
 	}
 
+	if parameters.preview {
+		if parameters.checkpointTaskID != "" {
+			err = s.keepPreviewChanges(ctx, tx, parameters.checkpointTaskID, toUpsert, toDelete)
+			if err != nil {
+				return ImportStats{}, err
+			}
+		}
+
+		// deferred rollback reverts catalog changes
+		return stats, nil
+	}
+
 	err = tx.Commit(ctx)
 	if err != nil {
-		s.logger.Error("Commit transaction", zap.Error(err))
-		return 0, 0, 0, err
+		s.log(ctx).Error("Commit transaction", zap.Error(err))
+		return ImportStats{}, err
 	}
 
-	return inserted, updated, deleted, nil
+	return stats, nil
 }
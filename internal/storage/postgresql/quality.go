@@ -0,0 +1,79 @@
+package postgresql
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"time"
+)
+
+// DailyQuality defines data quality of files the merchant imported during the UTC day starting at Day
+type DailyQuality struct {
+	Day time.Time `json:"day"`
+	// Tasks is number of done tasks, Rows is number of rows they read including ignored and duplicate ones
+	Tasks      int64 `json:"tasks"`
+	Rows       int64 `json:"rows"`
+	Ignored    int64 `json:"ignored"`
+	Duplicates int64 `json:"duplicates"`
+	// IgnoredRate is share of read rows ignored as invalid
+	IgnoredRate float64 `json:"ignored_rate"`
+	// ReasonCounts is number of rejected rows and products skipped by hooks per reason code
+	ReasonCounts map[string]int64 `json:"reason_counts"`
+}
+
+// MerchantQuality returns data quality of tasks of the merchant, archived included, done within [from, to)
+// aggregated per UTC day in day order, so regressions of merchant feed show up as growth of ignored rate or codes
+func (s *Storage) MerchantQuality(ctx context.Context, merchantID int64, from, to time.Time) ([]DailyQuality, error) {
+	ctx, cancel := s.readContext(ctx)
+	defer cancel()
+
+	sql := `WITH done AS (
+                SELECT (finished_at AT TIME ZONE 'UTC')::date AS day,
+                       added + updated + removed + skipped + ignored + duplicates AS rows,
+                       ignored, duplicates, reason_counts
+                  FROM tasks
+                 WHERE merchant_id = $1 AND state = 'Done' AND finished_at >= $2 AND finished_at < $3
+                 UNION ALL
+                SELECT (finished_at AT TIME ZONE 'UTC')::date,
+                       added + updated + removed + skipped + ignored + duplicates,
+                       ignored, duplicates, reason_counts
+                  FROM tasks_archive
+                 WHERE merchant_id = $1 AND state = 'Done' AND finished_at >= $2 AND finished_at < $3
+            )
+            SELECT d.day, count(*), sum(d.rows)::bigint, sum(d.ignored)::bigint, sum(d.duplicates)::bigint,
+                   (SELECT coalesce(jsonb_object_agg(c.code, c.n), '{}'::jsonb)
+                      FROM (SELECT r.key AS code, sum(r.value::bigint) AS n
+                              FROM done t, jsonb_each_text(t.reason_counts) AS r
+                             WHERE t.day = d.day
+                             GROUP BY r.key) AS c)
+              FROM done d
+             GROUP BY d.day
+             ORDER BY d.day`
+
+	rows, err := s.db.Query(ctx, sql, merchantID, from, to)
+	if err != nil {
+		s.log(ctx).Error("Selecting merchant data quality", zap.Int64("merchant_id", merchantID), zap.Error(err))
+		return nil, classify(err)
+	}
+	defer rows.Close()
+
+	quality := []DailyQuality{}
+	for rows.Next() {
+		var q DailyQuality
+		err = rows.Scan(&q.Day, &q.Tasks, &q.Rows, &q.Ignored, &q.Duplicates, &q.ReasonCounts)
+		if err != nil {
+			s.log(ctx).Error("Scanning row", zap.Error(err))
+			return nil, classify(err)
+		}
+
+		if q.Rows > 0 {
+			q.IgnoredRate = float64(q.Ignored) / float64(q.Rows)
+		}
+		quality = append(quality, q)
+	}
+
+	if rows.Err() != nil {
+		return nil, classify(rows.Err())
+	}
+
+	return quality, nil
+}
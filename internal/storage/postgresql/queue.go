@@ -0,0 +1,92 @@
+package postgresql
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+	"time"
+)
+
+// ErrQueueEmpty is returned when there is no task to be claimed
+var ErrQueueEmpty = errors.New("no pending tasks")
+
+// ClaimTask takes the oldest task in Processing state which is either not claimed yet or which claim
+// was made before leaseExpiredBefore, i.e. instance processing it has probably died.
+// Rows locked by concurrent claims are skipped, so every task is taken by single instance.
+func (s *Storage) ClaimTask(ctx context.Context, instanceID string, leaseExpiredBefore time.Time) (Task, error) {
+	sql := `UPDATE tasks
+               SET claimed_by = $1,
+                   claimed_at = now(),
+                   updated_at = now()
+             WHERE id = (SELECT id
+                           FROM tasks
+                          WHERE state = 'Processing'
+                            AND (claimed_at IS NULL OR claimed_at < $2)
+                          ORDER BY created_at
+                          LIMIT 1
+                            FOR UPDATE SKIP LOCKED)
+         RETURNING ` + taskColumns
+
+	t, err := scanTask(s.db.QueryRow(ctx, sql, instanceID, leaseExpiredBefore))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Task{}, ErrQueueEmpty
+		}
+
+		s.log(ctx).Error("Claiming task", zap.String("instance_id", instanceID), zap.Error(err))
+		return Task{}, classify(err)
+	}
+
+	return t, nil
+}
+
+// CountPendingTasks returns number of tasks in Processing state which are not claimed by any instance
+func (s *Storage) CountPendingTasks(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.db.QueryRow(ctx, "SELECT count(*) FROM tasks WHERE state = 'Processing' AND claimed_at IS NULL").Scan(&count)
+	if err != nil {
+		s.log(ctx).Error("Counting pending tasks", zap.Error(err))
+		return 0, classify(err)
+	}
+
+	return count, nil
+}
+
+// CancelQueuedTasks cancels tasks in Processing state not claimed by any instance yet which either have one of
+// provided ids or belong to the merchant, all of them within single statement. Returns ids of canceled tasks.
+func (s *Storage) CancelQueuedTasks(ctx context.Context, ids []string, merchantID int64) ([]string, error) {
+	sql := `UPDATE tasks
+               SET state = 'Canceled',
+                   finished_at = now(),
+                   updated_at = now()
+             WHERE state = 'Processing'
+               AND claimed_at IS NULL
+               AND (id = ANY($1) OR merchant_id = $2)
+         RETURNING id`
+
+	rows, err := s.db.Query(ctx, sql, ids, merchantID)
+	if err != nil {
+		s.log(ctx).Error("Canceling queued tasks", zap.Error(err))
+		return nil, classify(err)
+	}
+	defer rows.Close()
+
+	var canceled []string
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			s.log(ctx).Error("Scanning row", zap.Error(err))
+			return nil, classify(err)
+		}
+
+		canceled = append(canceled, id)
+	}
+
+	if rows.Err() != nil {
+		return nil, classify(rows.Err())
+	}
+
+	return canceled, nil
+}
@@ -0,0 +1,97 @@
+package postgresql
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+)
+
+// RejectedRow defines row of uploaded file ignored as invalid or as duplicate of other row
+type RejectedRow struct {
+	// Row is one-based number of the row among rows read from uploaded file
+	Row int64 `json:"row" csv:"row"`
+	// Code is stable machine-readable identifier of Reason, e.g. BAD_PRICE or DUPLICATE_IN_FILE
+	Code   string `json:"code" csv:"code"`
+	Reason string `json:"reason" csv:"reason"`
+}
+
+// WithRejectedRows makes UpsertAndDelete save rows of the chunk ignored as invalid to the task report,
+// it has effect together with WithCheckpoint only
+func WithRejectedRows(rows []RejectedRow) ImportOption {
+	return func(p *importParameters) {
+		p.rejected = rows
+	}
+}
+
+// WithReasonCounts makes UpsertAndDelete add numbers of rows of the chunk per reason code to reason counts
+// of the task record, it has effect together with WithCheckpoint only
+func WithReasonCounts(counts map[string]int64) ImportOption {
+	return func(p *importParameters) {
+		p.reasonCounts = counts
+	}
+}
+
+// saveRejectedRows inserts rejected rows of the chunk being committed by UpsertAndDelete within its transaction
+func (s *Storage) saveRejectedRows(ctx context.Context, tx pgx.Tx, parameters *importParameters) error {
+	if len(parameters.rejected) == 0 {
+		return nil
+	}
+
+	rows := make([][]interface{}, 0, len(parameters.rejected))
+	for _, r := range parameters.rejected {
+		rows = append(rows, []interface{}{parameters.checkpointTaskID, r.Row, r.Code, r.Reason})
+	}
+
+	columnNames := []string{"task_id", "row_number", "code", "reason"}
+	_, err := s.copyFrom(ctx, tx, "task_rejected_rows", columnNames, pgx.CopyFromRows(rows))
+	if err != nil {
+		s.log(ctx).Error("Saving rejected rows", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// TaskReport returns rows of the task ignored as invalid in file order or ErrTaskNotFound
+func (s *Storage) TaskReport(ctx context.Context, taskID string) ([]RejectedRow, error) {
+	var exists bool
+	err := s.db.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM tasks WHERE id = $1)", taskID).Scan(&exists)
+	if err != nil {
+		s.log(ctx).Error("Reading task", zap.String("task_id", taskID), zap.Error(err))
+		return nil, classify(err)
+	}
+
+	if !exists {
+		return nil, ErrTaskNotFound
+	}
+
+	sql := `SELECT row_number, code, reason
+              FROM task_rejected_rows
+             WHERE task_id = $1
+             ORDER BY row_number`
+
+	rows, err := s.db.Query(ctx, sql, taskID)
+	if err != nil {
+		s.log(ctx).Error("Selecting rejected rows", zap.String("task_id", taskID), zap.Error(err))
+		return nil, classify(err)
+	}
+	defer rows.Close()
+
+	report := []RejectedRow{}
+	for rows.Next() {
+		var r RejectedRow
+		err = rows.Scan(&r.Row, &r.Code, &r.Reason)
+		if err != nil {
+			s.log(ctx).Error("Scanning row", zap.Error(err))
+			return nil, classify(err)
+		}
+
+		report = append(report, r)
+	}
+
+	if rows.Err() != nil {
+		return nil, classify(rows.Err())
+	}
+
+	return report, nil
+}
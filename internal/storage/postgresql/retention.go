@@ -0,0 +1,94 @@
+package postgresql
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"time"
+)
+
+// StaleMerchant defines merchant which had no successful import since some moment
+type StaleMerchant struct {
+	MerchantID   int64
+	ProductCount int64
+}
+
+// StaleMerchants returns merchants having products but no task finished with Done state after since
+func (s *Storage) StaleMerchants(ctx context.Context, since time.Time) ([]StaleMerchant, error) {
+	sql := `SELECT p.merchant_id::bigint, count(*)
+              FROM products p
+             WHERE NOT EXISTS (SELECT 1
+                                 FROM tasks t
+                                WHERE t.merchant_id = p.merchant_id
+                                  AND t.state = 'Done'
+                                  AND t.finished_at >= $1)
+             GROUP BY p.merchant_id
+             ORDER BY p.merchant_id`
+
+	rows, err := s.db.Query(ctx, sql, since)
+	if err != nil {
+		s.log(ctx).Error("Selecting stale merchants", zap.Error(err))
+		return nil, classify(err)
+	}
+	defer rows.Close()
+
+	var merchants []StaleMerchant
+	for rows.Next() {
+		var m StaleMerchant
+		err = rows.Scan(&m.MerchantID, &m.ProductCount)
+		if err != nil {
+			s.log(ctx).Error("Scanning row", zap.Error(err))
+			return nil, classify(err)
+		}
+
+		merchants = append(merchants, m)
+	}
+
+	if rows.Err() != nil {
+		return nil, classify(rows.Err())
+	}
+
+	return merchants, nil
+}
+
+// DeleteMerchantProducts deletes all products of the merchant and returns deleted rows count
+func (s *Storage) DeleteMerchantProducts(ctx context.Context, merchantID int64) (int64, error) {
+	tag, err := s.db.Exec(ctx, "DELETE FROM "+s.productsTable(merchantID)+" WHERE merchant_id = $1", merchantID)
+	if err != nil {
+		s.log(ctx).Error("Deleting merchant products", zap.Int64("merchant_id", merchantID), zap.Error(err))
+		return 0, classify(err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// CountTasksToArchive returns number of finished tasks created before provided moment
+func (s *Storage) CountTasksToArchive(ctx context.Context, before time.Time) (int64, error) {
+	var count int64
+	err := s.db.QueryRow(ctx, "SELECT count(*) FROM tasks WHERE created_at < $1 AND finished_at IS NOT NULL", before).Scan(&count)
+	if err != nil {
+		s.log(ctx).Error("Counting tasks to archive", zap.Error(err))
+		return 0, classify(err)
+	}
+
+	return count, nil
+}
+
+// ArchiveTasks moves finished tasks created before provided moment from tasks table to tasks_archive
+// and returns archived rows count
+func (s *Storage) ArchiveTasks(ctx context.Context, before time.Time) (int64, error) {
+	sql := `WITH archived AS
+                    (DELETE FROM tasks
+                      WHERE created_at < $1
+                        AND finished_at IS NOT NULL
+                  RETURNING *)
+            INSERT INTO tasks_archive
+            SELECT *, now() FROM archived`
+
+	tag, err := s.db.Exec(ctx, sql, before)
+	if err != nil {
+		s.log(ctx).Error("Archiving tasks", zap.Error(err))
+		return 0, classify(err)
+	}
+
+	return tag.RowsAffected(), nil
+}
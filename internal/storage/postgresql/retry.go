@@ -0,0 +1,86 @@
+package postgresql
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgconn"
+	"go.uber.org/zap"
+	"time"
+)
+
+// retryPolicy defines how many times and how often transient failures are retried
+type retryPolicy struct {
+	attempts       int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+func defaultRetryPolicy() retryPolicy {
+	return retryPolicy{
+		attempts:       3,
+		initialBackoff: 100 * time.Millisecond,
+		maxBackoff:     2 * time.Second,
+	}
+}
+
+// WithRetryPolicy applies passed values as retry policy of import transactions.
+// Retried operation is performed at most attempts times, delay between attempts starts
+// with initialBackoff and doubles every time up to maxBackoff.
+func WithRetryPolicy(attempts int, initialBackoff, maxBackoff time.Duration) StorageOption {
+	return func(s *Storage) {
+		s.retry = retryPolicy{
+			attempts:       attempts,
+			initialBackoff: initialBackoff,
+			maxBackoff:     maxBackoff,
+		}
+	}
+}
+
+// isRetryable reports whether err is transient, so operation may succeed being performed once again
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	switch errorKind(err) {
+	case ErrConflict, ErrUnavailable:
+		var pgErr *pgconn.PgError
+		// unique violation is a conflict with existing row rather than with concurrent transaction
+		return !errors.As(err, &pgErr) || pgErr.Code != "23505"
+	}
+
+	return pgconn.SafeToRetry(err)
+}
+
+// withRetry calls f until it succeeds, returns not retryable error, ctx is done or attempts are exhausted
+func (s *Storage) withRetry(ctx context.Context, operation string, f func() error) error {
+	backoff := s.retry.initialBackoff
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = f()
+		if err == nil || attempt >= s.retry.attempts || !isRetryable(err) {
+			return err
+		}
+
+		s.log(ctx).Warn("Retrying transient failure",
+			zap.String("operation", operation),
+			zap.Int("attempt", attempt),
+			zap.Duration("backoff", backoff),
+			zap.Error(err),
+		)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		backoff *= 2
+		if backoff > s.retry.maxBackoff {
+			backoff = s.retry.maxBackoff
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package postgresql
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+	"math"
+)
+
+// sampleOversampling defines how many times more rows than requested TABLESAMPLE is expected to return,
+// so the random shortage of sampled rows rarely leads to smaller result
+const sampleOversampling = 2
+
+// Sample returns up to n random available products of the merchant.
+// Rows are picked via TABLESAMPLE BERNOULLI with percentage derived from merchant catalog size
+// and then shuffled and truncated to n.
+func (s *Storage) Sample(ctx context.Context, merchantID int64, n int) ([]Product, error) {
+	ctx, cancel := s.readContext(ctx)
+	defer cancel()
+
+	count, err := s.CountProducts(ctx, merchantID)
+	if err != nil {
+		return nil, classify(err)
+	}
+
+	if count == 0 {
+		return []Product{}, nil
+	}
+
+	percent := math.Min(100, float64(n)*sampleOversampling/float64(count)*100)
+
+	sql := `SELECT ` + productSelectColumns + `
+              FROM ` + s.productsTable(merchantID) + ` TABLESAMPLE BERNOULLI ($2)
+             WHERE merchant_id = $1
+               AND is_available
+             ORDER BY random()
+             LIMIT $3`
+
+	rows, err := s.db.Query(ctx, sql, merchantID, percent, n)
+	if err != nil {
+		s.log(ctx).Error("Sampling rows", zap.Error(err))
+		return nil, classify(err)
+	}
+
+	return s.collectProducts(ctx, rows)
+}
+
+// collectProducts scans all products from rows and closes them
+func (s *Storage) collectProducts(ctx context.Context, rows pgx.Rows) ([]Product, error) {
+	defer rows.Close()
+
+	products := []Product{}
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			s.log(ctx).Error("Scanning row", zap.Error(err))
+			return nil, err
+		}
+
+		products = append(products, p)
+	}
+
+	if rows.Err() != nil {
+		return nil, classify(rows.Err())
+	}
+
+	return products, nil
+}
@@ -0,0 +1,61 @@
+package postgresql
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	// productsTable contains production catalogs
+	productsTable = "products"
+	// sandboxProductsTable contains catalogs of sandbox merchants isolated from production ones
+	sandboxProductsTable = "sandbox.products"
+)
+
+// WithSandboxMerchants marks passed merchant ids as sandbox ones,
+// their imports and reads use isolated sandbox schema
+func WithSandboxMerchants(ids ...int64) StorageOption {
+	return func(s *Storage) {
+		if s.sandboxMerchants == nil {
+			s.sandboxMerchants = make(map[int64]struct{}, len(ids))
+		}
+
+		for _, id := range ids {
+			s.sandboxMerchants[id] = struct{}{}
+		}
+	}
+}
+
+// IsSandbox reports whether merchant is sandbox one
+func (s *Storage) IsSandbox(merchantID int64) bool {
+	_, ok := s.sandboxMerchants[merchantID]
+	return ok
+}
+
+// productsTable returns name of the table containing merchant catalog
+func (s *Storage) productsTable(merchantID int64) string {
+	if s.IsSandbox(merchantID) {
+		return sandboxProductsTable
+	}
+
+	return productsTable
+}
+
+// PurgeSandbox deletes catalogs of sandbox merchants with no task created after provided moment
+// and returns deleted rows count
+func (s *Storage) PurgeSandbox(ctx context.Context, before time.Time) (int64, error) {
+	sql := `DELETE FROM sandbox.products sp
+             WHERE NOT EXISTS (SELECT 1
+                                 FROM tasks t
+                                WHERE t.merchant_id = sp.merchant_id
+                                  AND t.created_at >= $1)`
+
+	tag, err := s.db.Exec(ctx, sql, before)
+	if err != nil {
+		s.log(ctx).Error("Purging sandbox catalogs", zap.Error(err))
+		return 0, classify(err)
+	}
+
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,286 @@
+package postgresql
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"strings"
+)
+
+// column defines expected column, domain is empty for columns of built-in types
+type column struct {
+	name   string
+	domain string
+}
+
+// productColumns defines columns of production and sandbox products tables
+var productColumns = []column{
+	{"merchant_id", "merchant_id"},
+	{"offer_id", "offer_id"},
+	{"name", "product_name"},
+	{"price", "product_price"},
+	{"quantity", "product_quantity"},
+	{"original_price", ""},
+	{"original_currency", ""},
+	{"last_seen_at", ""},
+	{"is_available", ""},
+}
+
+// archivedProductColumns defines columns of production and sandbox products_archive tables
+var archivedProductColumns = append(append([]column(nil), productColumns...), column{"archived_at", ""})
+
+// expectedColumns defines columns required by the code per table
+var expectedColumns = []struct {
+	table   string
+	columns []column
+}{
+	{"public.products", productColumns},
+	{"sandbox.products", productColumns},
+	{"public.products_archive", archivedProductColumns},
+	{"sandbox.products_archive", archivedProductColumns},
+	{"public.tasks", []column{
+		{"id", ""}, {"merchant_id", "merchant_id"}, {"state", ""},
+		{"added", ""}, {"updated", ""}, {"removed", ""}, {"ignored", ""}, {"skipped", ""}, {"duplicates", ""},
+		{"created_at", ""}, {"updated_at", ""}, {"finished_at", ""},
+		{"error_code", ""}, {"error_reason", ""}, {"file_path", ""}, {"checkpoint", ""},
+		{"claimed_by", ""}, {"claimed_at", ""}, {"idempotency_key", ""},
+		{"file_format", ""}, {"file_options", ""}, {"import_mode", ""}, {"update_columns", ""}, {"currency", ""},
+		{"file_removed_at", ""}, {"deadline", ""}, {"labels", ""},
+		{"reason_counts", ""},
+	}},
+	{"public.tasks_archive", []column{{"id", ""}, {"archived_at", ""}}},
+	{"public.task_chunks", []column{
+		{"task_id", ""}, {"start_row", ""}, {"end_row", ""},
+		{"added", ""}, {"updated", ""}, {"removed", ""}, {"ignored", ""}, {"skipped", ""},
+		{"started_at", ""}, {"finished_at", ""},
+	}},
+	{"public.task_rejected_rows", []column{{"task_id", ""}, {"row_number", ""}, {"code", ""}, {"reason", ""}}},
+	{"public.task_changes", []column{
+		{"task_id", ""}, {"offer_id", "offer_id"}, {"change", ""}, {"name", ""}, {"price", ""}, {"quantity", ""},
+	}},
+	{"public.task_approvals", []column{{"task_id", ""}, {"approved", ""}, {"reason", ""}, {"decided_at", ""}}},
+	{"public.product_history", []column{
+		{"id", ""}, {"merchant_id", "merchant_id"}, {"offer_id", "offer_id"}, {"task_id", ""}, {"change", ""},
+		{"old_price", ""}, {"new_price", ""}, {"old_quantity", ""}, {"new_quantity", ""}, {"changed_at", ""},
+	}},
+	{"public.product_events", []column{
+		{"id", ""}, {"merchant_id", "merchant_id"}, {"offer_id", "offer_id"}, {"task_id", ""}, {"change", ""},
+		{"name", ""}, {"price", ""}, {"quantity", ""}, {"created_at", ""},
+	}},
+	{"public.expiry_rules", []column{{"merchant_id", "merchant_id"}, {"stale_after_days", ""}, {"action", ""}, {"updated_at", ""}}},
+	{"public.expired_offers", []column{
+		{"merchant_id", "merchant_id"}, {"offer_id", "offer_id"}, {"name", "product_name"},
+		{"action", ""}, {"last_seen_at", ""}, {"expired_at", ""},
+	}},
+	{"public.api_usage", []column{
+		{"hour", ""}, {"api_key", ""}, {"merchant_id", ""}, {"requests", ""},
+		{"client_errors", ""}, {"server_errors", ""}, {"bytes_in", ""}, {"bytes_out", ""},
+	}},
+	{"public.import_sources", []column{
+		{"merchant_id", "merchant_id"}, {"dsn", ""}, {"query", ""}, {"interval_seconds", ""}, {"enabled", ""},
+		{"last_run_at", ""}, {"last_pulled_at", ""}, {"last_task_id", ""}, {"last_error", ""}, {"updated_at", ""},
+	}},
+	{"public.offer_id_sequences", []column{{"merchant_id", "merchant_id"}, {"last_offer_id", "offer_id"}}},
+	{"public.merchant_freezes", []column{{"merchant_id", "merchant_id"}, {"reason", ""}, {"frozen_at", ""}}},
+	{"public.offboardings", []column{
+		{"id", ""}, {"merchant_id", "merchant_id"}, {"state", ""}, {"phase", ""},
+		{"products", ""}, {"tasks", ""}, {"files", ""}, {"archive_key", ""}, {"archive_size", ""}, {"error", ""},
+		{"created_at", ""}, {"updated_at", ""}, {"exported_at", ""}, {"delete_after", ""}, {"deleted_at", ""},
+	}},
+}
+
+// expectedDomains defines domains required by the code with their base types
+var expectedDomains = []struct {
+	name     string
+	dataType string
+}{
+	{"merchant_id", "integer"},
+	{"offer_id", "integer"},
+	{"product_name", "character varying"},
+	{"product_price", "numeric"},
+	{"product_quantity", "integer"},
+}
+
+// expectedDomainConstraints defines CHECK constraints of domains which the code relies on, names of uploaded
+// products are validated by the same rules before they are written
+var expectedDomainConstraints = []string{
+	"product_name.no_control_characters",
+}
+
+// expectedIndexes defines indexes required by the code, unique_ids_pair backs ON CONFLICT clause of upsert
+var expectedIndexes = []string{
+	"public.unique_ids_pair",
+	"public.products_merchant_id_name_idx",
+	"public.products_name_trgm_idx",
+	"public.tasks_pkey",
+	"public.tasks_processing_created_at_idx",
+	"public.tasks_merchant_id_idempotency_key_idx",
+	"public.tasks_file_cleanup_idx",
+	"public.tasks_labels_idx",
+	"public.tasks_merchant_id_finished_at_idx",
+	"public.tasks_archive_pkey",
+	"public.task_chunks_pkey",
+	"public.task_rejected_rows_pkey",
+	"public.task_changes_pkey",
+	"public.task_approvals_pkey",
+	"public.product_history_pkey",
+	"public.product_history_merchant_id_offer_id_idx",
+	"public.product_events_pkey",
+	"public.products_archive_pkey",
+	"public.expiry_rules_pkey",
+	"public.expired_offers_pkey",
+	"public.import_sources_pkey",
+	"public.offer_id_sequences_pkey",
+	"public.merchant_freezes_pkey",
+	"public.expired_offers_merchant_id_expired_at_idx",
+	"public.api_usage_pkey",
+	"public.api_usage_merchant_id_hour_idx",
+	"public.offboardings_pkey",
+	"public.offboardings_active_merchant_id_idx",
+	"public.catalog_stats_merchant_id_idx",
+}
+
+// expectedViews defines materialized views required by the code
+var expectedViews = []string{
+	"public.catalog_stats",
+}
+
+// SchemaError is returned when database schema does not match the one expected by the code
+type SchemaError struct {
+	Problems []string
+}
+
+// Error returns string representation of SchemaError
+func (e *SchemaError) Error() string {
+	return "database schema does not match migrations of internal/storage/postgresql/migrations: " + strings.Join(e.Problems, "; ")
+}
+
+// CheckSchema verifies tables, domains with their constraints, indexes and views required by the code exist and have expected shape.
+// It is meant to be called on startup, so incompatible schema is reported before the first query fails.
+func (s *Storage) CheckSchema(ctx context.Context) error {
+	var problems []string
+
+	columnProblems, err := s.checkColumns(ctx)
+	if err != nil {
+		return err
+	}
+	problems = append(problems, columnProblems...)
+
+	sql := `SELECT domain_name::text, data_type::text
+              FROM information_schema.domains
+             WHERE domain_schema = 'public'`
+
+	domains, err := s.readPairs(ctx, sql)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range expectedDomains {
+		actual, ok := domains[d.name]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("domain %s is missing", d.name))
+		case actual != d.dataType:
+			problems = append(problems, fmt.Sprintf("domain %s is %s, expected %s", d.name, actual, d.dataType))
+		}
+	}
+
+	sql = `SELECT domain_name || '.' || constraint_name, ''
+             FROM information_schema.domain_constraints
+            WHERE domain_schema = 'public'`
+
+	constraints, err := s.readPairs(ctx, sql)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range expectedDomainConstraints {
+		if _, ok := constraints[name]; !ok {
+			problems = append(problems, fmt.Sprintf("domain constraint %s is missing", name))
+		}
+	}
+
+	indexes, err := s.readPairs(ctx, "SELECT schemaname || '.' || indexname, '' FROM pg_indexes")
+	if err != nil {
+		return err
+	}
+
+	for _, name := range expectedIndexes {
+		if _, ok := indexes[name]; !ok {
+			problems = append(problems, fmt.Sprintf("index %s is missing", name))
+		}
+	}
+
+	views, err := s.readPairs(ctx, "SELECT schemaname || '.' || matviewname, '' FROM pg_matviews")
+	if err != nil {
+		return err
+	}
+
+	for _, name := range expectedViews {
+		if _, ok := views[name]; !ok {
+			problems = append(problems, fmt.Sprintf("materialized view %s is missing", name))
+		}
+	}
+
+	if len(problems) != 0 {
+		return &SchemaError{Problems: problems}
+	}
+
+	s.log(ctx).Info("Database schema is compatible")
+	return nil
+}
+
+// checkColumns returns description of every missing column or column of wrong domain
+func (s *Storage) checkColumns(ctx context.Context) ([]string, error) {
+	sql := `SELECT table_schema || '.' || table_name || '.' || column_name, coalesce(domain_name, '')
+              FROM information_schema.columns
+             WHERE table_schema IN ('public', 'sandbox')`
+
+	actual, err := s.readPairs(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	for _, t := range expectedColumns {
+		for _, c := range t.columns {
+			domain, ok := actual[t.table+"."+c.name]
+			switch {
+			case !ok:
+				problems = append(problems, fmt.Sprintf("column %s.%s is missing", t.table, c.name))
+			case c.domain != "" && domain != c.domain:
+				problems = append(problems, fmt.Sprintf("column %s.%s has domain %q, expected %q", t.table, c.name, domain, c.domain))
+			}
+		}
+	}
+
+	return problems, nil
+}
+
+// readPairs returns result of two text columns query as map
+func (s *Storage) readPairs(ctx context.Context, sql string) (map[string]string, error) {
+	rows, err := s.db.Query(ctx, sql)
+	if err != nil {
+		s.log(ctx).Error("Reading schema", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	pairs := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		err = rows.Scan(&key, &value)
+		if err != nil {
+			s.log(ctx).Error("Scanning row", zap.Error(err))
+			return nil, err
+		}
+
+		pairs[key] = value
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return pairs, nil
+}
@@ -0,0 +1,161 @@
+package postgresql
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+	"time"
+)
+
+// ErrNoImportSource is returned when merchant has no import source
+var ErrNoImportSource = errors.New("no import source")
+
+// ImportSource defines database products of the merchant are pulled from every IntervalSeconds
+type ImportSource struct {
+	MerchantID int64 `json:"merchant_id"`
+	// DSN is connection string of merchant database including credentials, it is never returned by API.
+	// It is saved as sealed by dbsource.Cipher if import source key is configured.
+	DSN string `json:"dsn,omitempty"`
+	// Query selects offer_id, name, price, quantity and available columns, see dbsource package for placeholders
+	Query           string `json:"query"`
+	IntervalSeconds int64  `json:"interval_seconds"`
+	Enabled         bool   `json:"enabled"`
+	// LastRunAt is start of the latest pull, LastPulledAt is start of the latest pull which scheduled a task
+	LastRunAt    *time.Time `json:"last_run_at,omitempty"`
+	LastPulledAt *time.Time `json:"last_pulled_at,omitempty"`
+	// LastTaskID is task scheduled by the latest successful pull, LastError describes why the latest pull failed
+	LastTaskID string    `json:"last_task_id,omitempty"`
+	LastError  string    `json:"last_error,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// importSourceColumns defines columns scanned by scanImportSource in the same order
+const importSourceColumns = `merchant_id::bigint, dsn, query, interval_seconds, enabled, last_run_at, last_pulled_at,
+                             last_task_id, last_error, updated_at`
+
+func scanImportSource(row pgx.Row) (ImportSource, error) {
+	var src ImportSource
+	err := row.Scan(
+		&src.MerchantID,
+		&src.DSN,
+		&src.Query,
+		&src.IntervalSeconds,
+		&src.Enabled,
+		&src.LastRunAt,
+		&src.LastPulledAt,
+		&src.LastTaskID,
+		&src.LastError,
+		&src.UpdatedAt,
+	)
+	return src, err
+}
+
+// SetImportSource creates or replaces import source of the merchant and returns saved one.
+// Replaced source is pulled by the next poll whatever its interval is.
+func (s *Storage) SetImportSource(ctx context.Context, src ImportSource) (ImportSource, error) {
+	sql := `INSERT INTO import_sources (merchant_id, dsn, query, interval_seconds, enabled, updated_at)
+            VALUES ($1, $2, $3, $4, $5, now())
+                ON CONFLICT (merchant_id) DO UPDATE
+               SET dsn = excluded.dsn,
+                   query = excluded.query,
+                   interval_seconds = excluded.interval_seconds,
+                   enabled = excluded.enabled,
+                   last_run_at = NULL,
+                   updated_at = excluded.updated_at
+         RETURNING ` + importSourceColumns
+
+	saved, err := scanImportSource(s.db.QueryRow(ctx, sql, src.MerchantID, src.DSN, src.Query, src.IntervalSeconds, src.Enabled))
+	if err != nil {
+		s.log(ctx).Error("Saving import source", zap.Int64("merchant_id", src.MerchantID), zap.Error(err))
+		return ImportSource{}, classify(err)
+	}
+
+	return saved, nil
+}
+
+// ReadImportSource returns import source of the merchant or ErrNoImportSource
+func (s *Storage) ReadImportSource(ctx context.Context, merchantID int64) (ImportSource, error) {
+	sql := `SELECT ` + importSourceColumns + `
+              FROM import_sources
+             WHERE merchant_id = $1`
+
+	src, err := scanImportSource(s.db.QueryRow(ctx, sql, merchantID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ImportSource{}, ErrNoImportSource
+		}
+
+		s.log(ctx).Error("Reading import source", zap.Int64("merchant_id", merchantID), zap.Error(err))
+		return ImportSource{}, classify(err)
+	}
+
+	return src, nil
+}
+
+// DeleteImportSource removes import source of the merchant or returns ErrNoImportSource
+func (s *Storage) DeleteImportSource(ctx context.Context, merchantID int64) error {
+	tag, err := s.db.Exec(ctx, "DELETE FROM import_sources WHERE merchant_id = $1", merchantID)
+	if err != nil {
+		s.log(ctx).Error("Deleting import source", zap.Int64("merchant_id", merchantID), zap.Error(err))
+		return classify(err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrNoImportSource
+	}
+
+	return nil
+}
+
+// ClaimDueImportSources sets last_run_at of enabled sources whose interval has passed since previous pull to now
+// and returns them. Claimed rows are locked by update, so concurrent instances never claim the same pull.
+func (s *Storage) ClaimDueImportSources(ctx context.Context, now time.Time) ([]ImportSource, error) {
+	sql := `UPDATE import_sources
+               SET last_run_at = $1
+             WHERE enabled
+               AND (last_run_at IS NULL OR last_run_at + interval_seconds * interval '1 second' <= $1)
+         RETURNING ` + importSourceColumns
+
+	rows, err := s.db.Query(ctx, sql, now)
+	if err != nil {
+		s.log(ctx).Error("Claiming import sources", zap.Error(err))
+		return nil, classify(err)
+	}
+	defer rows.Close()
+
+	var sources []ImportSource
+	for rows.Next() {
+		src, err := scanImportSource(rows)
+		if err != nil {
+			s.log(ctx).Error("Scanning row", zap.Error(err))
+			return nil, classify(err)
+		}
+
+		sources = append(sources, src)
+	}
+
+	if rows.Err() != nil {
+		return nil, classify(rows.Err())
+	}
+
+	return sources, nil
+}
+
+// FinishImportSourceRun saves outcome of pull started at runAt, non-empty taskID means the pull scheduled a task
+// and moves last_pulled_at to runAt, otherwise errorText is saved as last error
+func (s *Storage) FinishImportSourceRun(ctx context.Context, merchantID int64, runAt time.Time, taskID, errorText string) error {
+	sql := `UPDATE import_sources
+               SET last_task_id = CASE WHEN $3 <> '' THEN $3 ELSE last_task_id END,
+                   last_pulled_at = CASE WHEN $3 <> '' THEN $2 ELSE last_pulled_at END,
+                   last_error = $4
+             WHERE merchant_id = $1`
+
+	_, err := s.db.Exec(ctx, sql, merchantID, runAt, taskID, errorText)
+	if err != nil {
+		s.log(ctx).Error("Saving import source run", zap.Int64("merchant_id", merchantID), zap.Error(err))
+		return classify(err)
+	}
+
+	return nil
+}
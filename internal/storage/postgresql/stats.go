@@ -0,0 +1,132 @@
+package postgresql
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v4"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"time"
+)
+
+// ErrNoCatalogStats is returned by MerchantStats when merchant has no products
+var ErrNoCatalogStats = errors.New("no catalog stats")
+
+// CatalogStats defines summary of merchant catalog
+type CatalogStats struct {
+	MerchantID   int64           `json:"merchant_id"`
+	ProductCount int64           `json:"product_count"`
+	MinPrice     decimal.Decimal `json:"min_price"`
+	MaxPrice     decimal.Decimal `json:"max_price"`
+	AvgPrice     decimal.Decimal `json:"avg_price"`
+	// LastUpdate is time of the latest task which changed the catalog, nil if it is unknown
+	LastUpdate *time.Time `json:"last_update"`
+}
+
+// MerchantStats defines live summary of merchant catalog returned by /stats
+type MerchantStats struct {
+	MerchantID    int64           `json:"merchant_id"`
+	ProductCount  int64           `json:"product_count"`
+	TotalQuantity int64           `json:"total_quantity"`
+	MinPrice      decimal.Decimal `json:"min_price"`
+	AvgPrice      decimal.Decimal `json:"avg_price"`
+	MaxPrice      decimal.Decimal `json:"max_price"`
+	// LastImport is time the latest successful task of the merchant finished, nil if there is none
+	LastImport *time.Time `json:"last_import"`
+}
+
+// RefreshCatalogStats recomputes catalog_stats materialized view without blocking its readers
+func (s *Storage) RefreshCatalogStats(ctx context.Context) error {
+	_, err := s.db.Exec(ctx, "REFRESH MATERIALIZED VIEW CONCURRENTLY catalog_stats")
+	if err != nil {
+		s.log(ctx).Error("Refreshing catalog stats", zap.Error(err))
+		return classify(err)
+	}
+
+	return nil
+}
+
+// MerchantStats returns summary of merchant catalog aggregated by dedicated query on every call, so unlike
+// catalog_stats it is never stale. LastImport is finish time of the latest done task of the merchant, archived included.
+func (s *Storage) MerchantStats(ctx context.Context, merchantID int64) (MerchantStats, error) {
+	ctx, cancel := s.readContext(ctx)
+	defer cancel()
+
+	sql := `SELECT p.merchant_id::bigint,
+                   count(*),
+                   sum(p.quantity)::bigint,
+                   min(p.price)::numeric,
+                   round(avg(p.price), 2),
+                   max(p.price)::numeric,
+                   (SELECT max(t.finished_at)
+                      FROM (SELECT finished_at FROM tasks WHERE merchant_id = $1 AND state = 'Done'
+                             UNION ALL
+                            SELECT finished_at FROM tasks_archive WHERE merchant_id = $1 AND state = 'Done') AS t)
+              FROM ` + s.productsTable(merchantID) + ` p
+             WHERE p.merchant_id = $1
+               AND p.is_available
+             GROUP BY p.merchant_id`
+
+	var stats MerchantStats
+	err := s.db.QueryRow(ctx, sql, merchantID).Scan(
+		&stats.MerchantID,
+		&stats.ProductCount,
+		&stats.TotalQuantity,
+		&stats.MinPrice,
+		&stats.AvgPrice,
+		&stats.MaxPrice,
+		&stats.LastImport,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return MerchantStats{}, ErrNoCatalogStats
+		}
+
+		s.log(ctx).Error("Aggregating merchant stats", zap.Int64("merchant_id", merchantID), zap.Error(err))
+		return MerchantStats{}, classify(err)
+	}
+
+	return stats, nil
+}
+
+// ListMerchants returns catalog summaries of all production merchants ordered by merchant id
+func (s *Storage) ListMerchants(ctx context.Context) ([]CatalogStats, error) {
+	ctx, cancel := s.readContext(ctx)
+	defer cancel()
+
+	sql := `SELECT merchant_id, product_count, min_price, max_price, avg_price, last_update
+              FROM catalog_stats
+             ORDER BY merchant_id`
+
+	rows, err := s.db.Query(ctx, sql)
+	if err != nil {
+		s.log(ctx).Error("Selecting catalog stats", zap.Error(err))
+		return nil, classify(err)
+	}
+	defer rows.Close()
+
+	merchants := []CatalogStats{}
+	for rows.Next() {
+		var stats CatalogStats
+		err = rows.Scan(
+			&stats.MerchantID,
+			&stats.ProductCount,
+			&stats.MinPrice,
+			&stats.MaxPrice,
+			&stats.AvgPrice,
+			&stats.LastUpdate,
+		)
+		if err != nil {
+			s.log(ctx).Error("Scanning row", zap.Error(err))
+			return nil, classify(err)
+		}
+
+		merchants = append(merchants, stats)
+	}
+
+	if rows.Err() != nil {
+		return nil, classify(rows.Err())
+	}
+
+	return merchants, nil
+}
@@ -0,0 +1,55 @@
+package postgresql
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+)
+
+// likeEscaper escapes LIKE pattern wildcards using default escape character
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Suggest returns up to limit distinct product names of the merchant starting with prefix in alphabetical order.
+// Query is expected to be served by index-only scan on products_merchant_id_name_idx.
+func (s *Storage) Suggest(ctx context.Context, merchantID int64, prefix string, limit int) ([]string, error) {
+	ctx, cancel := s.readContext(ctx)
+	defer cancel()
+
+	sql := `SELECT DISTINCT name
+              FROM ` + s.productsTable(merchantID) + `
+             WHERE merchant_id = $1
+               AND is_available
+               AND name LIKE $2
+             ORDER BY name
+             LIMIT $3`
+
+	started := time.Now()
+	pattern := likeEscaper.Replace(prefix) + "%"
+	rows, err := s.db.Query(ctx, sql, merchantID, pattern, limit)
+	if err != nil {
+		s.log(ctx).Error("Selecting name suggestions", zap.Error(err))
+		return nil, classify(err)
+	}
+	defer rows.Close()
+
+	names := make([]string, 0, limit)
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			s.log(ctx).Error("Scanning row", zap.Error(err))
+			return nil, classify(err)
+		}
+
+		names = append(names, name)
+	}
+
+	if rows.Err() != nil {
+		return nil, classify(rows.Err())
+	}
+
+	s.observeQuery(ctx, "suggest", started, sql, merchantID, pattern, limit)
+
+	return names, nil
+}
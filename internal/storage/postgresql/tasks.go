@@ -0,0 +1,352 @@
+package postgresql
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+	"time"
+)
+
+// ErrTaskNotFound is returned when there is no task with requested id in database
+var ErrTaskNotFound = errors.New("task not found")
+
+// Task defines persisted task state and result stats
+type Task struct {
+	ID         string `json:"id"`
+	MerchantID int64  `json:"merchant_id"`
+	State      string `json:"state"`
+	Added      int64  `json:"added"`
+	Updated    int64  `json:"updated"`
+	Removed    int64  `json:"removed"`
+	Ignored    int64  `json:"ignored"`
+	// Skipped is number of rows matching existing offers left unchanged
+	Skipped int64 `json:"skipped"`
+	// Duplicates is number of rows dropped or overridden by other rows with the same offer_id
+	Duplicates int64 `json:"duplicates"`
+	// ReasonCounts is number of rejected rows and products skipped by hooks per reason code
+	ReasonCounts map[string]int64 `json:"reason_counts"`
+	CreatedAt    time.Time        `json:"created_at"`
+	UpdatedAt    time.Time        `json:"updated_at"`
+	FinishedAt   *time.Time       `json:"finished_at"`
+	// ErrorCode and ErrorReason describe why task was aborted, both are empty for other tasks
+	ErrorCode   string `json:"error_code"`
+	ErrorReason string `json:"error_reason"`
+	// FilePath points to uploaded file, Checkpoint is number of its rows already applied to the database
+	FilePath   string `json:"file_path"`
+	Checkpoint int64  `json:"checkpoint"`
+	// FileFormat and FileOptions define the way uploaded file is read, FileOptions is JSON object
+	FileFormat  string `json:"file_format"`
+	FileOptions string `json:"file_options"`
+	// ClaimedBy is id of instance processing the task taken from shared queue, ClaimedAt is time it was taken
+	ClaimedBy string     `json:"claimed_by"`
+	ClaimedAt *time.Time `json:"claimed_at"`
+	// IdempotencyKey is client provided key of upload request which created the task, may be empty
+	IdempotencyKey string `json:"idempotency_key"`
+	// ImportMode is either ImportModeUpsert or ImportModeInsertOnly, empty value means ImportModeUpsert
+	ImportMode string `json:"import_mode"`
+	// UpdateColumns limits columns of existing products set by the task, empty means UpdatableColumns
+	UpdateColumns []string `json:"update_columns"`
+	// Currency is currency of uploaded prices converted into base one, empty means prices are not converted
+	Currency string `json:"currency"`
+	// Deadline is time by which client requires the task to be finished, nil means there is no deadline
+	Deadline *time.Time `json:"deadline"`
+	// Labels are free-form client tags of the task, e.g. name of automation run which uploaded the file
+	Labels []string `json:"labels"`
+}
+
+// CreateTask inserts new task record using ID, MerchantID, State, CreatedAt, file fields, IdempotencyKey,
+// ImportMode, UpdateColumns, Currency, Deadline and Labels of t, empty FileOptions are saved as empty JSON object
+func (s *Storage) CreateTask(ctx context.Context, t Task) error {
+	sql := `INSERT INTO tasks (id, merchant_id, state, created_at, updated_at, file_path, file_format, file_options, idempotency_key, import_mode, update_columns, currency, deadline, labels)
+                 VALUES ($1, $2, $3, $4, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`
+
+	options := t.FileOptions
+	if options == "" {
+		options = "{}"
+	}
+
+	mode := t.ImportMode
+	if mode == "" {
+		mode = ImportModeUpsert
+	}
+
+	columns := t.UpdateColumns
+	if columns == nil {
+		columns = []string{}
+	}
+
+	labels := t.Labels
+	if labels == nil {
+		labels = []string{}
+	}
+
+	_, err := s.db.Exec(ctx, sql, t.ID, t.MerchantID, t.State, t.CreatedAt, t.FilePath, t.FileFormat, options, t.IdempotencyKey, mode, columns, t.Currency, t.Deadline, labels)
+	if err != nil {
+		s.log(ctx).Error("Inserting task", zap.String("task_id", t.ID), zap.Error(err))
+		return classify(err)
+	}
+
+	return nil
+}
+
+// FinishTask sets final state of existing task record, errorCode and errorReason may be empty
+func (s *Storage) FinishTask(ctx context.Context, id string, state string, finishedAt time.Time, errorCode, errorReason string) error {
+	sql := `UPDATE tasks
+               SET state = $2,
+                   updated_at = now(),
+                   finished_at = $3,
+                   error_code = $4,
+                   error_reason = $5
+             WHERE id = $1`
+
+	tag, err := s.db.Exec(ctx, sql, id, state, finishedAt, errorCode, errorReason)
+	if err != nil {
+		s.log(ctx).Error("Updating task state", zap.String("task_id", id), zap.Error(err))
+		return classify(err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrTaskNotFound
+	}
+
+	return nil
+}
+
+// ForceAbortTask sets Aborted state of task record which is still Processing regardless of instance processing it.
+// Returns state the task had before, so not Processing task is left unchanged, or ErrTaskNotFound.
+func (s *Storage) ForceAbortTask(ctx context.Context, id string, finishedAt time.Time, errorCode, errorReason string) (string, error) {
+	sql := `WITH target AS
+                    (SELECT id, state
+                       FROM tasks
+                      WHERE id = $1
+                        FOR UPDATE),
+                 aborted AS
+                    (UPDATE tasks t
+                        SET state = 'Aborted',
+                            updated_at = now(),
+                            finished_at = $2,
+                            error_code = $3,
+                            error_reason = $4
+                       FROM target
+                      WHERE t.id = target.id
+                        AND target.state = 'Processing')
+            SELECT state FROM target`
+
+	var state string
+	err := s.db.QueryRow(ctx, sql, id, finishedAt, errorCode, errorReason).Scan(&state)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", ErrTaskNotFound
+		}
+
+		s.log(ctx).Error("Force aborting task", zap.String("task_id", id), zap.Error(err))
+		return "", classify(err)
+	}
+
+	return state, nil
+}
+
+// SaveTaskResult sets final state and result stats of existing task record
+func (s *Storage) SaveTaskResult(ctx context.Context, id string, state string, added, updated, removed, ignored, skipped, duplicates int64, finishedAt time.Time) error {
+	sql := `UPDATE tasks
+               SET state = $2,
+                   added = $3,
+                   updated = $4,
+                   removed = $5,
+                   ignored = $6,
+                   skipped = $7,
+                   duplicates = $8,
+                   updated_at = now(),
+                   finished_at = $9
+             WHERE id = $1`
+
+	tag, err := s.db.Exec(ctx, sql, id, state, added, updated, removed, ignored, skipped, duplicates, finishedAt)
+	if err != nil {
+		s.log(ctx).Error("Saving task result", zap.String("task_id", id), zap.Error(err))
+		return classify(err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrTaskNotFound
+	}
+
+	return nil
+}
+
+// taskColumns defines columns scanned by scanTask in the same order
+const taskColumns = `id, merchant_id, state, added, updated, removed, ignored, skipped, duplicates, created_at, updated_at, finished_at,
+                     error_code, error_reason, file_path, checkpoint, claimed_by, claimed_at, idempotency_key,
+                     file_format, file_options::text, import_mode, update_columns, currency, deadline, labels, reason_counts`
+
+func scanTask(row pgx.Row) (Task, error) {
+	var t Task
+	err := row.Scan(
+		&t.ID,
+		&t.MerchantID,
+		&t.State,
+		&t.Added,
+		&t.Updated,
+		&t.Removed,
+		&t.Ignored,
+		&t.Skipped,
+		&t.Duplicates,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+		&t.FinishedAt,
+		&t.ErrorCode,
+		&t.ErrorReason,
+		&t.FilePath,
+		&t.Checkpoint,
+		&t.ClaimedBy,
+		&t.ClaimedAt,
+		&t.IdempotencyKey,
+		&t.FileFormat,
+		&t.FileOptions,
+		&t.ImportMode,
+		&t.UpdateColumns,
+		&t.Currency,
+		&t.Deadline,
+		&t.Labels,
+		&t.ReasonCounts,
+	)
+	return t, err
+}
+
+// ReadTask returns task record with provided id or ErrTaskNotFound
+func (s *Storage) ReadTask(ctx context.Context, id string) (Task, error) {
+	sql := `SELECT ` + taskColumns + `
+              FROM tasks
+             WHERE id = $1`
+
+	t, err := scanTask(s.db.QueryRow(ctx, sql, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Task{}, ErrTaskNotFound
+		}
+
+		s.log(ctx).Error("Reading task", zap.String("task_id", id), zap.Error(err))
+		return Task{}, classify(err)
+	}
+
+	return t, nil
+}
+
+// TaskArchived reports whether task with provided id was moved to tasks_archive
+func (s *Storage) TaskArchived(ctx context.Context, id string) (bool, error) {
+	var archived bool
+	err := s.db.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM tasks_archive WHERE id = $1)", id).Scan(&archived)
+	if err != nil {
+		s.log(ctx).Error("Checking archived task", zap.String("task_id", id), zap.Error(err))
+		return false, classify(err)
+	}
+
+	return archived, nil
+}
+
+// FindTaskByIdempotencyKey returns the latest task of the merchant created after provided moment
+// by upload with the same idempotency key or ErrTaskNotFound
+func (s *Storage) FindTaskByIdempotencyKey(ctx context.Context, merchantID int64, key string, since time.Time) (Task, error) {
+	sql := `SELECT ` + taskColumns + `
+              FROM tasks
+             WHERE merchant_id = $1
+               AND idempotency_key = $2
+               AND created_at >= $3
+             ORDER BY created_at DESC
+             LIMIT 1`
+
+	t, err := scanTask(s.db.QueryRow(ctx, sql, merchantID, key, since))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Task{}, ErrTaskNotFound
+		}
+
+		s.log(ctx).Error("Reading task by idempotency key", zap.Int64("merchant_id", merchantID), zap.Error(err))
+		return Task{}, classify(err)
+	}
+
+	return t, nil
+}
+
+// CountTasksSince returns number of tasks created for the merchant after provided moment
+func (s *Storage) CountTasksSince(ctx context.Context, merchantID int64, since time.Time) (int64, error) {
+	var count int64
+	err := s.db.QueryRow(ctx, "SELECT count(*) FROM tasks WHERE merchant_id = $1 AND created_at >= $2", merchantID, since).Scan(&count)
+	if err != nil {
+		s.log(ctx).Error("Counting tasks", zap.Int64("merchant_id", merchantID), zap.Error(err))
+		return 0, classify(err)
+	}
+
+	return count, nil
+}
+
+// UnfinishedTasks returns tasks which are still in Processing state in creation order
+func (s *Storage) UnfinishedTasks(ctx context.Context) ([]Task, error) {
+	sql := `SELECT ` + taskColumns + `
+              FROM tasks
+             WHERE state = 'Processing'
+             ORDER BY created_at`
+
+	rows, err := s.db.Query(ctx, sql)
+	if err != nil {
+		s.log(ctx).Error("Selecting unfinished tasks", zap.Error(err))
+		return nil, classify(err)
+	}
+	defer rows.Close()
+
+	var tasks []Task
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			s.log(ctx).Error("Scanning row", zap.Error(err))
+			return nil, classify(err)
+		}
+
+		tasks = append(tasks, t)
+	}
+
+	if rows.Err() != nil {
+		return nil, classify(rows.Err())
+	}
+
+	return tasks, nil
+}
+
+// ListTasks returns at most limit latest tasks having every one of provided labels,
+// merchantID limits tasks to the merchant unless it is zero
+func (s *Storage) ListTasks(ctx context.Context, merchantID int64, labels []string, limit int) ([]Task, error) {
+	sql := `SELECT ` + taskColumns + `
+              FROM tasks
+             WHERE ($1::bigint = 0 OR merchant_id = $1)
+               AND labels @> $2
+             ORDER BY created_at DESC, id
+             LIMIT $3`
+
+	if labels == nil {
+		labels = []string{}
+	}
+
+	rows, err := s.db.Query(ctx, sql, merchantID, labels, limit)
+	if err != nil {
+		s.log(ctx).Error("Selecting tasks", zap.Int64("merchant_id", merchantID), zap.Strings("labels", labels), zap.Error(err))
+		return nil, classify(err)
+	}
+	defer rows.Close()
+
+	var tasks []Task
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			s.log(ctx).Error("Scanning row", zap.Error(err))
+			return nil, classify(err)
+		}
+
+		tasks = append(tasks, t)
+	}
+
+	if rows.Err() != nil {
+		return nil, classify(rows.Err())
+	}
+
+	return tasks, nil
+}
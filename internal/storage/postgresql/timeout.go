@@ -0,0 +1,37 @@
+package postgresql
+
+import (
+	"context"
+	"time"
+)
+
+// WithStatementTimeouts bounds time of read queries like List, Count or Get by read and time of catalog writes
+// by UpsertAndDelete and CreateProduct by write, so query of caller which forgot its own deadline can not hold
+// connection indefinitely. Query running out of time is canceled on the server. Earlier deadline of caller context
+// takes precedence, zero disables respective timeout. ListEach is bounded by read timeout as a whole, since rows
+// it streams at pace of its caller, e.g. CSV export of /list, are capped by ListRowCap.
+func WithStatementTimeouts(read, write time.Duration) StorageOption {
+	return func(s *Storage) {
+		s.readTimeout = read
+		s.writeTimeout = write
+	}
+}
+
+// readContext returns ctx bounded by read timeout
+func (s *Storage) readContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return boundedContext(ctx, s.readTimeout)
+}
+
+// writeContext returns ctx bounded by write timeout
+func (s *Storage) writeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return boundedContext(ctx, s.writeTimeout)
+}
+
+// boundedContext returns ctx with timeout d unless d is zero
+func boundedContext(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, d)
+}
@@ -5,16 +5,25 @@ import "github.com/jackc/pgx/v4"
 type txOptions struct {
 	runAsChild bool
 	parentTx   pgx.Tx
+	// table defines products table to be modified, empty value means it is derived from modified rows
+	table string
+	// insertOnly makes Upsert leave existing rows unchanged
+	insertOnly bool
+	// updateColumns limits columns of existing rows set by Upsert, empty means UpdatableColumns
+	updateColumns []string
+	// changesTaskID is id of task which changes are saved by Upsert and Delete, see recordingChanges
+	changesTaskID string
 }
 
 func defaultTxOptions() *txOptions {
 	return &txOptions{
 		runAsChild: false,
 		parentTx:   nil,
+		table:      "",
 	}
 }
 
-func buildOptions(options ...txOption) *txOptions {
+func buildOptions(options ...TxOption) *txOptions {
 	resultOptions := defaultTxOptions()
 	for _, o := range options {
 		o.apply(resultOptions)
@@ -22,7 +31,8 @@ func buildOptions(options ...txOption) *txOptions {
 	return resultOptions
 }
 
-type txOption interface {
+// TxOption modifies transaction of Upsert and Delete, options are constructed within the package only
+type TxOption interface {
 	apply(options *txOptions)
 }
 
@@ -30,9 +40,28 @@ type txOptionFunc func(options *txOptions)
 
 func (f txOptionFunc) apply(opts *txOptions) { f(opts) }
 
-func asNestedTo(parentTx pgx.Tx) txOption {
+func asNestedTo(parentTx pgx.Tx) TxOption {
 	return txOptionFunc(func(opts *txOptions) {
 		opts.runAsChild = true
 		opts.parentTx = parentTx
 	})
 }
+
+// insertOnly makes Upsert skip rows of existing offers instead of updating them
+func insertOnly() TxOption {
+	return txOptionFunc(func(opts *txOptions) {
+		opts.insertOnly = true
+	})
+}
+
+func updatingColumns(columns []string) TxOption {
+	return txOptionFunc(func(opts *txOptions) {
+		opts.updateColumns = columns
+	})
+}
+
+func onTable(table string) TxOption {
+	return txOptionFunc(func(opts *txOptions) {
+		opts.table = table
+	})
+}
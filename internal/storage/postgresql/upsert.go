@@ -3,19 +3,79 @@ package postgresql
 import (
 	"context"
 	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+	"strings"
 )
 
-// Upsert performs three-step transaction:
+// UpdatableColumns defines columns of existing products which can be set by upsert
+var UpdatableColumns = []string{"name", "price", "quantity"}
+
+// ValidateUpdateColumns checks that columns are UpdatableColumns listed at most once
+func ValidateUpdateColumns(columns []string) error {
+	seen := make(map[string]bool, len(columns))
+	for _, c := range columns {
+		if seen[c] {
+			return fmt.Errorf("column %q is listed more than once", c)
+		}
+		seen[c] = true
+
+		known := false
+		for _, u := range UpdatableColumns {
+			if c == u {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("column %q can not be updated, use %s", c, strings.Join(UpdatableColumns, ", "))
+		}
+	}
+
+	return nil
+}
+
+// conflictUpdate returns SET and WHERE clauses of ON CONFLICT DO UPDATE assigning provided columns
+// only if any of them changes, original price and currency are assigned together with price
+func conflictUpdate(columns []string) (string, string) {
+	if len(columns) == 0 {
+		columns = UpdatableColumns
+	}
+
+	set := make([]string, 0, len(columns))
+	where := make([]string, 0, len(columns))
+	for _, c := range columns {
+		set = append(set, c+" = excluded."+c)
+		where = append(where, "products."+c+" <> excluded."+c)
+
+		if c == "price" {
+			for _, o := range []string{"original_price", "original_currency"} {
+				set = append(set, o+" = excluded."+o)
+				where = append(where, "products."+o+" IS DISTINCT FROM excluded."+o)
+			}
+		}
+	}
+
+	return strings.Join(set, ",\n                            "), strings.Join(where, "\n                         OR ")
+}
+
+// Upsert performs five-step transaction:
 // 1. creates temporary table
 // 2. fills it via bulkProducts insert with incoming data
-// 3. insert rows from temporary table into "products"
+// 3. makes unavailable offers of incoming data available again setting all their columns, they are counted as added
+// 4. insert rows from temporary table into "products"
+// 5. marks existing offers of incoming data as seen today, see ExpireOffers
 // if provided ctx is not canceled or timed out transaction will be committed.
 //
 // Upsert will run as nested transaction providing asNestedTo option. By default it runs as stand-alone one.
+// With insertOnly option rows of existing offers are left unchanged,
+// updatingColumns option limits columns set for existing offers,
+// recordingChanges option saves added and updated offers as changes of the task and to product history.
 //
 // Returns added and updated rows count and error
-func (s *Storage) Upsert(ctx context.Context, products []Product, options ...txOption) (int64, int64, error) {
+func (s *Storage) Upsert(ctx context.Context, products []Product, options ...TxOption) (int64, int64, error) {
 	bulkData := bulkProducts{
 		rows: products,
 		idx:  -1,
@@ -26,58 +86,144 @@ func (s *Storage) Upsert(ctx context.Context, products []Product, options ...txO
 	var tx pgx.Tx
 	var err error
 	if txOptions.runAsChild {
-		s.logger.Debug("Running upsert as nested transaction")
+		s.log(ctx).Debug("Running upsert as nested transaction")
 		tx, err = txOptions.parentTx.Begin(ctx)
 	} else {
-		s.logger.Debug("Running upsert as stand-alone transaction")
+		s.log(ctx).Debug("Running upsert as stand-alone transaction")
 		tx, err = s.db.Begin(ctx)
 	}
 
 	if err != nil {
-		s.logger.Error("Begin upsert transaction")
-		return 0, 0, err
+		s.log(ctx).Error("Begin upsert transaction")
+		return 0, 0, classify(err)
 	}
 	// error handling can be omitted for rollback according to docs
 	// see https://pkg.go.dev/github.com/jackc/pgx/v4?tab=doc#hdr-Transactions or any source comment on Rollback
 	// TODO: define timeout for transaction rollback
 	defer tx.Rollback(context.Background())
 
-	s.logger.Debug("Creating temporary table")
+	s.log(ctx).Debug("Creating temporary table")
 
 	sql := `CREATE TEMPORARY TABLE products_temporary
              (LIKE products
+         INCLUDING DEFAULTS
          INCLUDING CONSTRAINTS
          INCLUDING INDEXES)
                 ON COMMIT DROP`
 
 	_, err = tx.Exec(ctx, sql)
 	if err != nil {
-		s.logger.Error("Create temporary table")
-		return 0, 0, err
+		s.log(ctx).Error("Create temporary table")
+		return 0, 0, classify(err)
 	}
 
-	s.logger.Debug("Performing bulkProducts insert on temporary table")
+	s.log(ctx).Debug("Performing bulkProducts insert on temporary table")
 
-	columnNames := []string{"merchant_id", "offer_id", "name", "price", "quantity"}
-	_, err = tx.CopyFrom(ctx, pgx.Identifier{"products_temporary"}, columnNames, &bulkData)
+	columnNames := []string{"merchant_id", "offer_id", "name", "price", "quantity", "original_price", "original_currency"}
+	_, err = s.copyFrom(ctx, tx, "products_temporary", columnNames, &bulkData)
 	if err != nil {
-		s.logger.Error("Bulk insert")
-		return 0, 0, err
+		s.log(ctx).Error("Bulk insert")
+		return 0, 0, classify(err)
+	}
+
+	table := txOptions.table
+	if table == "" && len(products) != 0 {
+		table = s.productsTable(products[0].MerchantID)
 	}
 
-	s.logger.Debug("Performing insert from temporary to products")
+	s.log(ctx).Debug("Performing insert from temporary to products", zap.String("table", table), zap.Bool("insert_only", txOptions.insertOnly))
 	var inserted, updated int64
-	sql = `WITH xmax_values AS
-                    (INSERT INTO products
+	var args []interface{}
+	if txOptions.changesTaskID != "" {
+		args = append(args, txOptions.changesTaskID)
+	}
+
+	// unavailable offers used to be deleted, so they are revived the way deleted ones would be inserted
+	// whatever import mode and update columns are, and are left unchanged by the insert afterwards
+	s.log(ctx).Debug("Reviving unavailable offers")
+	sql = `WITH revived AS
+                    (UPDATE ` + table + ` AS products
+                        SET name = t.name,
+                            price = t.price,
+                            quantity = t.quantity,
+                            original_price = t.original_price,
+                            original_currency = t.original_currency,
+                            is_available = true
+                       FROM products_temporary t
+                      WHERE products.merchant_id = t.merchant_id
+                        AND products.offer_id = t.offer_id
+                        AND NOT products.is_available
+                  RETURNING products.merchant_id, products.offer_id, products.name, products.price, products.quantity)`
+	if txOptions.changesTaskID != "" {
+		sql += `,
+                 ` + upsertedChanges("revived", "$1") + `,
+                 ` + upsertedHistory("revived", "", "$1")
+		if s.outbox {
+			sql += `,
+                 ` + upsertedEvents("revived", "$1")
+		}
+	}
+	sql += `
+            SELECT count(*) FROM revived`
+
+	var revived int64
+	err = tx.QueryRow(ctx, sql, args...).Scan(&revived)
+	if err != nil {
+		s.log(ctx).Error("Reviving unavailable offers")
+		return 0, 0, classify(err)
+	}
+
+	if txOptions.insertOnly {
+		sql = `INSERT INTO ` + table + `
+               SELECT * FROM products_temporary
+                   ON CONFLICT (merchant_id, offer_id) DO NOTHING`
+		if txOptions.changesTaskID != "" {
+			sql = `WITH inserted AS
+                    (` + sql + `
+                  RETURNING merchant_id, offer_id, name, price, quantity),
+                 ` + upsertedChanges("inserted", "$1") + `,
+                 ` + upsertedHistory("inserted", "", "$1")
+			if s.outbox {
+				sql += `,
+                 ` + upsertedEvents("inserted", "$1")
+			}
+			sql += `
+            SELECT count(*) FROM inserted`
+
+			err = tx.QueryRow(ctx, sql, args...).Scan(&inserted)
+		} else {
+			var tag pgconn.CommandTag
+			tag, err = tx.Exec(ctx, sql)
+			inserted = tag.RowsAffected()
+		}
+		if err != nil {
+			s.log(ctx).Error("Insert from temporary to products")
+			return 0, 0, classify(err)
+		}
+	} else {
+		set, where := conflictUpdate(txOptions.updateColumns)
+		sql = `WITH `
+		if txOptions.changesTaskID != "" {
+			sql += previousValues(table) + `,
+                 `
+		}
+		sql += `xmax_values AS
+                    (INSERT INTO ` + table + ` AS products
                      SELECT * FROM products_temporary
                          ON CONFLICT (merchant_id, offer_id) DO UPDATE
-			            SET name = excluded.name,
-                            price = excluded.price,
-                            quantity = excluded.quantity
-                      WHERE products.name <> excluded.name
-                         OR products.price <> excluded.price
-                         OR products.quantity <> excluded.quantity
-                  RETURNING xmax),
+                        SET ` + set + `
+                      WHERE ` + where + `
+                  RETURNING merchant_id, offer_id, name, price, quantity, xmax),`
+		if txOptions.changesTaskID != "" {
+			sql += `
+                 ` + upsertedChanges("xmax_values", "$1") + `,
+                 ` + upsertedHistory("xmax_values", "previous", "$1") + `,`
+			if s.outbox {
+				sql += `
+                 ` + upsertedEvents("xmax_values", "$1") + `,`
+			}
+		}
+		sql += `
                  temp_stats AS
                     (SELECT SUM(CASE WHEN xmax = 0 THEN 1 ELSE 0 END) AS inserted,
                             SUM(CASE WHEN xmax::text::int > 0 THEN 1 ELSE 0 END) AS updated
@@ -86,36 +232,53 @@ func (s *Storage) Upsert(ctx context.Context, products []Product, options ...txO
 		                    COALESCE(updated, 0) AS updated
 		               FROM temp_stats`
 
-	err = tx.QueryRow(ctx, sql).Scan(&inserted, &updated)
+		err = tx.QueryRow(ctx, sql, args...).Scan(&inserted, &updated)
+		if err != nil {
+			s.log(ctx).Error("Insert from temporary to products")
+			return 0, 0, classify(err)
+		}
+	}
+
+	// unchanged offers are not touched by the insert, while last seen date matters for expiry rules.
+	// Offers are marked once a day at most to avoid rewriting every row of frequent imports.
+	s.log(ctx).Debug("Marking offers as seen")
+	sql = `UPDATE ` + table + ` AS products
+              SET last_seen_at = now()
+             FROM products_temporary t
+            WHERE products.merchant_id = t.merchant_id
+              AND products.offer_id = t.offer_id
+              AND products.last_seen_at < date_trunc('day', now())`
+
+	_, err = tx.Exec(ctx, sql)
 	if err != nil {
-		s.logger.Error("Insert from temporary to products")
-		return 0, 0, err
+		s.log(ctx).Error("Marking offers as seen")
+		return 0, 0, classify(err)
 	}
 
 	ctxErr := ctx.Err()
 	if ctxErr != nil {
 		switch {
 		case errors.Is(ctxErr, context.DeadlineExceeded):
-			s.logger.Info("Task deadline exceeded")
+			s.log(ctx).Info("Task deadline exceeded")
 			return 0, 0, ctxErr
 
 		case errors.Is(ctxErr, context.Canceled):
-			s.logger.Info("Task is canceled")
+			s.log(ctx).Info("Task is canceled")
 			return 0, 0, ctxErr
 		}
 	}
 
 	if txOptions.runAsChild {
-		s.logger.Debug("Committing nested upsert transaction")
+		s.log(ctx).Debug("Committing nested upsert transaction")
 	} else {
-		s.logger.Debug("Committing stand-alone upsert transaction")
+		s.log(ctx).Debug("Committing stand-alone upsert transaction")
 	}
 
 	err = tx.Commit(ctx)
 	if err != nil {
-		s.logger.Error("Commit nested upsert transaction")
-		return 0, 0, err
+		s.log(ctx).Error("Commit nested upsert transaction")
+		return 0, 0, classify(err)
 	}
 
-	return inserted, updated, nil
+	return inserted + revived, updated, nil
 }
@@ -0,0 +1,160 @@
+package postgresql
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"time"
+)
+
+// UsageCounters defines API usage aggregated over some period
+type UsageCounters struct {
+	Requests int64 `json:"requests"`
+	// ClientErrors and ServerErrors count responses with 4xx and 5xx status
+	ClientErrors int64 `json:"client_errors"`
+	ServerErrors int64 `json:"server_errors"`
+	// ErrorRate is share of requests responded with 4xx or 5xx status
+	ErrorRate float64 `json:"error_rate"`
+	BytesIn   int64   `json:"bytes_in"`
+	BytesOut  int64   `json:"bytes_out"`
+}
+
+// computeErrorRate fills ErrorRate from counted requests and errors
+func (c *UsageCounters) computeErrorRate() {
+	if c.Requests == 0 {
+		c.ErrorRate = 0
+		return
+	}
+
+	c.ErrorRate = float64(c.ClientErrors+c.ServerErrors) / float64(c.Requests)
+}
+
+// HourlyUsage defines usage of the API key on behalf of the merchant during the hour starting at Hour.
+// MerchantID is zero for requests without merchant_id parameter.
+type HourlyUsage struct {
+	Hour       time.Time `json:"hour"`
+	APIKey     string    `json:"api_key"`
+	MerchantID int64     `json:"merchant_id"`
+	UsageCounters
+}
+
+// UsageTotal defines usage of the API key on behalf of the merchant during requested period
+type UsageTotal struct {
+	APIKey     string `json:"api_key"`
+	MerchantID int64  `json:"merchant_id"`
+	UsageCounters
+}
+
+// SaveUsage adds counters of provided records to the ones already saved for the same hour, key and merchant
+func (s *Storage) SaveUsage(ctx context.Context, records []HourlyUsage) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	hours := make([]time.Time, 0, len(records))
+	keys := make([]string, 0, len(records))
+	merchants := make([]int64, 0, len(records))
+	requests := make([]int64, 0, len(records))
+	clientErrors := make([]int64, 0, len(records))
+	serverErrors := make([]int64, 0, len(records))
+	bytesIn := make([]int64, 0, len(records))
+	bytesOut := make([]int64, 0, len(records))
+	for _, r := range records {
+		hours = append(hours, r.Hour)
+		keys = append(keys, r.APIKey)
+		merchants = append(merchants, r.MerchantID)
+		requests = append(requests, r.Requests)
+		clientErrors = append(clientErrors, r.ClientErrors)
+		serverErrors = append(serverErrors, r.ServerErrors)
+		bytesIn = append(bytesIn, r.BytesIn)
+		bytesOut = append(bytesOut, r.BytesOut)
+	}
+
+	sql := `INSERT INTO api_usage (hour, api_key, merchant_id, requests, client_errors, server_errors, bytes_in, bytes_out)
+            SELECT * FROM unnest($1::timestamptz[], $2::text[], $3::bigint[], $4::bigint[],
+                                 $5::bigint[], $6::bigint[], $7::bigint[], $8::bigint[])
+                ON CONFLICT (hour, api_key, merchant_id) DO UPDATE
+               SET requests = api_usage.requests + excluded.requests,
+                   client_errors = api_usage.client_errors + excluded.client_errors,
+                   server_errors = api_usage.server_errors + excluded.server_errors,
+                   bytes_in = api_usage.bytes_in + excluded.bytes_in,
+                   bytes_out = api_usage.bytes_out + excluded.bytes_out`
+
+	_, err := s.db.Exec(ctx, sql, hours, keys, merchants, requests, clientErrors, serverErrors, bytesIn, bytesOut)
+	if err != nil {
+		s.log(ctx).Error("Saving API usage", zap.Int("records", len(records)), zap.Error(err))
+		return classify(err)
+	}
+
+	return nil
+}
+
+// MerchantUsage returns hourly usage of the merchant since provided moment, most recent hours first
+func (s *Storage) MerchantUsage(ctx context.Context, merchantID int64, since time.Time) ([]HourlyUsage, error) {
+	sql := `SELECT hour, api_key, merchant_id, requests, client_errors, server_errors, bytes_in, bytes_out
+              FROM api_usage
+             WHERE merchant_id = $1
+               AND hour >= date_trunc('hour', $2::timestamptz)
+             ORDER BY hour DESC, api_key`
+
+	rows, err := s.db.Query(ctx, sql, merchantID, since)
+	if err != nil {
+		s.log(ctx).Error("Selecting merchant API usage", zap.Int64("merchant_id", merchantID), zap.Error(err))
+		return nil, classify(err)
+	}
+	defer rows.Close()
+
+	usage := []HourlyUsage{}
+	for rows.Next() {
+		var u HourlyUsage
+		err = rows.Scan(&u.Hour, &u.APIKey, &u.MerchantID, &u.Requests, &u.ClientErrors, &u.ServerErrors, &u.BytesIn, &u.BytesOut)
+		if err != nil {
+			s.log(ctx).Error("Scanning row", zap.Error(err))
+			return nil, classify(err)
+		}
+
+		u.computeErrorRate()
+		usage = append(usage, u)
+	}
+
+	if rows.Err() != nil {
+		return nil, classify(rows.Err())
+	}
+
+	return usage, nil
+}
+
+// UsageSummary returns usage totals of every API key and merchant pair since provided moment, busiest first
+func (s *Storage) UsageSummary(ctx context.Context, since time.Time) ([]UsageTotal, error) {
+	sql := `SELECT api_key, merchant_id, sum(requests)::bigint, sum(client_errors)::bigint, sum(server_errors)::bigint,
+                   sum(bytes_in)::bigint, sum(bytes_out)::bigint
+              FROM api_usage
+             WHERE hour >= date_trunc('hour', $1::timestamptz)
+             GROUP BY api_key, merchant_id
+             ORDER BY sum(requests) DESC, api_key, merchant_id`
+
+	rows, err := s.db.Query(ctx, sql, since)
+	if err != nil {
+		s.log(ctx).Error("Selecting API usage summary", zap.Error(err))
+		return nil, classify(err)
+	}
+	defer rows.Close()
+
+	totals := []UsageTotal{}
+	for rows.Next() {
+		var t UsageTotal
+		err = rows.Scan(&t.APIKey, &t.MerchantID, &t.Requests, &t.ClientErrors, &t.ServerErrors, &t.BytesIn, &t.BytesOut)
+		if err != nil {
+			s.log(ctx).Error("Scanning row", zap.Error(err))
+			return nil, classify(err)
+		}
+
+		t.computeErrorRate()
+		totals = append(totals, t)
+	}
+
+	if rows.Err() != nil {
+		return nil, classify(rows.Err())
+	}
+
+	return totals, nil
+}
@@ -0,0 +1,21 @@
+package storage
+
+import (
+	"context"
+	"mx/internal/storage/postgresql"
+)
+
+// ProductStore is implemented by catalog storage, it is satisfied by *postgresql.Storage
+// and by *postgresql.MemoryStore used by tests and demo mode
+type ProductStore interface {
+	// Upsert inserts or updates products and returns numbers of added and updated ones
+	Upsert(ctx context.Context, products []postgresql.Product, options ...postgresql.TxOption) (int64, int64, error)
+	// Delete makes offers of the merchant unavailable and returns their number
+	Delete(ctx context.Context, merchantID int64, offerIDs []int64, options ...postgresql.TxOption) (int64, error)
+	// UpsertAndDelete applies chunk of uploaded file at once
+	UpsertAndDelete(ctx context.Context, toUpsert []postgresql.Product, merchantID int64, toDelete []int64, options ...postgresql.ImportOption) (postgresql.ImportStats, error)
+	// List returns products matching options
+	List(ctx context.Context, options ...postgresql.ListOption) ([]postgresql.Product, error)
+	// Get returns available product of the merchant or postgresql.ErrProductNotFound
+	Get(ctx context.Context, merchantID, offerID int64) (postgresql.Product, error)
+}
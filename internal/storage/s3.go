@@ -0,0 +1,319 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// emptyPayloadHash is hex encoded SHA-256 of empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Config defines location and credentials of S3 compatible bucket, e.g. AWS S3 or MinIO one
+type S3Config struct {
+	// Endpoint is base URL of the service like https://s3.eu-central-1.amazonaws.com or http://minio:9000
+	Endpoint string
+	Region   string
+	Bucket   string
+	// AccessKeyID and SecretAccessKey are credentials requests are signed with
+	AccessKeyID     string
+	SecretAccessKey string
+	// PathStyle addresses bucket as first path segment rather than as subdomain, MinIO requires it by default
+	PathStyle bool
+}
+
+// S3Blob stores blobs as objects of S3 compatible bucket signing requests with AWS Signature Version 4
+type S3Blob struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+// NewS3Blob constructs S3Blob storing objects in configured bucket with provided request timeout
+func NewS3Blob(cfg S3Config, timeout time.Duration) (*S3Blob, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("s3 endpoint %q must be absolute http or https url", cfg.Endpoint)
+	}
+
+	switch {
+	case cfg.Bucket == "":
+		return nil, errors.New("s3 bucket must be set")
+	case cfg.Region == "":
+		return nil, errors.New("s3 region must be set")
+	case cfg.AccessKeyID == "" || cfg.SecretAccessKey == "":
+		return nil, errors.New("s3 credentials must be set")
+	}
+
+	return &S3Blob{
+		cfg:      cfg,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: timeout},
+		now:      time.Now,
+	}, nil
+}
+
+// Put uploads data as object with provided key
+func (s *S3Blob) Put(ctx context.Context, key string, data []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, s.objectURL(key), data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s.responseError(resp)
+	}
+
+	return nil
+}
+
+// PutFrom spools r to temporary file hashing it, since Signature Version 4 signs payload hash and
+// S3 requires Content-Length, then uploads the file as object with provided key
+func (s *S3Blob) PutFrom(ctx context.Context, key string, r io.Reader) error {
+	f, err := ioutil.TempFile("", "s3-put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, hash), r)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.send(ctx, http.MethodPut, s.objectURL(key), f, size, hex.EncodeToString(hash.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s.responseError(resp)
+	}
+
+	return nil
+}
+
+// Get downloads object with provided key
+func (s *S3Blob) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.objectURL(key), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrBlobNotFound
+	default:
+		defer resp.Body.Close()
+		return nil, s.responseError(resp)
+	}
+}
+
+// Delete removes object with provided key, S3 reports success for missing objects too
+func (s *S3Blob) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, s.objectURL(key), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s.responseError(resp)
+	}
+
+	return nil
+}
+
+// listBucketResult defines page of ListObjectsV2 response
+type listBucketResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key          string
+		LastModified time.Time
+	}
+}
+
+// List returns objects with keys starting with prefix requesting ListObjectsV2 pages until the last one
+func (s *S3Blob) List(ctx context.Context, prefix string) ([]BlobInfo, error) {
+	var blobs []BlobInfo
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+
+		u := s.objectURL("")
+		// Signature Version 4 requires spaces to be encoded as %20
+		u.RawQuery = strings.ReplaceAll(q.Encode(), "+", "%20")
+
+		page, err := s.listPage(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range page.Contents {
+			blobs = append(blobs, BlobInfo{Key: c.Key, ModifiedAt: c.LastModified})
+		}
+
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return blobs, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// listPage requests and decodes single page of ListObjectsV2
+func (s *S3Blob) listPage(ctx context.Context, u *url.URL) (listBucketResult, error) {
+	resp, err := s.do(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return listBucketResult{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return listBucketResult{}, s.responseError(resp)
+	}
+
+	var page listBucketResult
+	err = xml.NewDecoder(resp.Body).Decode(&page)
+	if err != nil {
+		return listBucketResult{}, fmt.Errorf("decoding s3 object list: %w", err)
+	}
+
+	return page, nil
+}
+
+// responseError describes failed response including beginning of error document
+func (s *S3Blob) responseError(resp *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("s3 responded with %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
+
+// objectURL returns URL of object with provided key
+func (s *S3Blob) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	path := strings.TrimSuffix(u.Path, "/")
+	if s.cfg.PathStyle {
+		path += "/" + s.cfg.Bucket
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+	}
+
+	u.Path = path + "/" + key
+	u.RawPath = escapePath(u.Path)
+	return &u
+}
+
+// do sends signed request to provided bucket or object URL
+func (s *S3Blob) do(ctx context.Context, method string, u *url.URL, payload []byte) (*http.Response, error) {
+	if payload == nil {
+		return s.send(ctx, method, u, nil, 0, emptyPayloadHash)
+	}
+
+	sum := sha256.Sum256(payload)
+	return s.send(ctx, method, u, bytes.NewReader(payload), int64(len(payload)), hex.EncodeToString(sum[:]))
+}
+
+// send sends request with body of provided size and hex encoded SHA-256 signed with Signature Version 4
+func (s *S3Blob) send(ctx context.Context, method string, u *url.URL, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+
+	s.sign(req, payloadHash)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("requesting s3: %w", err)
+	}
+
+	return resp, nil
+}
+
+// sign adds AWS Signature Version 4 headers to request,
+// see https://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html
+func (s *S3Blob) sign(req *http.Request, payloadHash string) {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + s.cfg.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretAccessKey), day)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.cfg.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// escapePath encodes every byte of path except unreserved characters and slashes as required by Signature Version 4
+func escapePath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+
+	return b.String()
+}
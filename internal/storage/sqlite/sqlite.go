@@ -0,0 +1,524 @@
+// Package sqlite stores catalog in SQLite database file for local development, so products outlive restarts
+// without being written to PostgreSQL. Only the catalog is kept there, tasks and the rest of service state still
+// require PostgreSQL. Driver registered as "sqlite" has to be linked into the binary, e.g. by building cmd/server
+// with sqlite tag, default build has no such driver.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"mx/internal/logctx"
+	"mx/internal/storage/postgresql"
+	"strconv"
+	"strings"
+)
+
+// DriverName is name of database/sql driver Store is opened with
+const DriverName = "sqlite"
+
+// ErrNoDriver is returned by NewStore when binary has no driver registered as DriverName
+var ErrNoDriver = errors.New("sqlite driver is not linked into binary, it has to be built with sqlite tag")
+
+// DriverLinked reports whether driver registered as DriverName is linked into binary
+func DriverLinked() bool {
+	for _, name := range sql.Drivers() {
+		if name == DriverName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// schema creates products table unless it exists, prices are kept as decimal strings, so they are exact
+const schema = `CREATE TABLE IF NOT EXISTS products (
+    merchant_id       INTEGER NOT NULL CHECK (merchant_id > 0),
+    offer_id          INTEGER NOT NULL CHECK (offer_id > 0),
+    name              TEXT    NOT NULL,
+    price             TEXT    NOT NULL,
+    quantity          INTEGER NOT NULL,
+    is_available      INTEGER NOT NULL DEFAULT 1,
+    original_price    TEXT,
+    original_currency TEXT,
+    PRIMARY KEY (merchant_id, offer_id)
+)`
+
+// selectColumns defines columns scanned by scanProduct in the same order
+const selectColumns = `merchant_id, offer_id, name, price, quantity, is_available, original_price, COALESCE(original_currency, '')`
+
+// Store implements storage.ProductStore on top of SQLite database
+type Store struct {
+	logger *zap.Logger
+	db     *sql.DB
+}
+
+// NewStore opens SQLite database at dsn, e.g. file path, and creates products table unless it exists
+func NewStore(ctx context.Context, logger *zap.Logger, dsn string) (*Store, error) {
+	if logger == nil {
+		return nil, errors.New("no logger provided")
+	}
+
+	if !DriverLinked() {
+		return nil, ErrNoDriver
+	}
+
+	db, err := sql.Open(DriverName, dsn)
+	if err != nil {
+		logger.Error("Opening SQLite database", zap.Error(err))
+		return nil, err
+	}
+	// SQLite allows single writer, so concurrent transactions would fail with busy error instead of waiting
+	db.SetMaxOpenConns(1)
+
+	_, err = db.ExecContext(ctx, schema)
+	if err != nil {
+		logger.Error("Creating products table", zap.Error(err))
+		db.Close()
+		return nil, err
+	}
+
+	return &Store{logger: logger, db: db}, nil
+}
+
+// Close closes the database
+func (s *Store) Close() {
+	s.logger.Info("Closing SQLite database")
+	s.db.Close()
+}
+
+// log returns logger of request or task carried by ctx
+func (s *Store) log(ctx context.Context) *zap.Logger {
+	return logctx.FromContext(ctx, s.logger)
+}
+
+// Upsert inserts new products and updates changed columns of existing ones within single transaction,
+// unavailable products are revived and counted as added. Options are applied by postgresql.Storage only.
+//
+// Returns added and updated products count and error
+func (s *Store) Upsert(ctx context.Context, products []postgresql.Product, options ...postgresql.TxOption) (int64, int64, error) {
+	var inserted, updated int64
+	err := s.inTx(ctx, false, func(tx *sql.Tx) error {
+		var err error
+		inserted, updated, err = s.upsert(ctx, tx, products, false, nil)
+		return err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return inserted, updated, nil
+}
+
+// Delete makes provided offers of the merchant unavailable, already unavailable ones are not counted.
+// Options are applied by postgresql.Storage only.
+//
+// Returns number of products made unavailable and an error.
+func (s *Store) Delete(ctx context.Context, merchantID int64, offerIDs []int64, options ...postgresql.TxOption) (int64, error) {
+	var deleted int64
+	err := s.inTx(ctx, false, func(tx *sql.Tx) error {
+		var err error
+		deleted, err = s.delete(ctx, tx, merchantID, offerIDs)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
+// UpsertAndDelete upserts and deletes provided products within single transaction, phase callback, insert only,
+// update columns and preview options are applied, options of task records are ignored as tasks are not stored here.
+//
+// Returns stats of applied rows and error.
+func (s *Store) UpsertAndDelete(ctx context.Context, toUpsert []postgresql.Product, merchantID int64, toDelete []int64, options ...postgresql.ImportOption) (postgresql.ImportStats, error) {
+	query := postgresql.NewImportQuery(options...)
+
+	var inserted, updated, deleted int64
+	err := s.inTx(ctx, query.Preview, func(tx *sql.Tx) error {
+		var err error
+		if len(toUpsert) != 0 {
+			query.OnPhase(postgresql.PhaseUpserting)
+			inserted, updated, err = s.upsert(ctx, tx, toUpsert, query.InsertOnly, query.UpdateColumns)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(toDelete) != 0 && !query.InsertOnly {
+			query.OnPhase(postgresql.PhaseDeleting)
+			deleted, err = s.delete(ctx, tx, merchantID, toDelete)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return postgresql.ImportStats{}, err
+	}
+
+	stats := postgresql.ImportStats{
+		Added:   inserted,
+		Updated: updated,
+		Removed: deleted,
+		Skipped: int64(len(toUpsert)) - inserted - updated,
+	}
+	if query.InsertOnly {
+		stats.Skipped += int64(len(toDelete))
+	}
+
+	return stats, nil
+}
+
+// inTx runs fn within transaction which is committed unless fn fails or rollback is true
+func (s *Store) inTx(ctx context.Context, rollback bool, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		s.log(ctx).Error("Starting transaction", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(tx)
+	if err != nil || rollback {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		s.log(ctx).Error("Committing transaction", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// upsert saves products within tx the way postgresql.Storage.Upsert does and returns added and updated count
+func (s *Store) upsert(ctx context.Context, tx *sql.Tx, products []postgresql.Product, insertOnly bool, columns []string) (int64, int64, error) {
+	revive, err := tx.PrepareContext(ctx, `UPDATE products
+                                              SET name = ?3, price = ?4, quantity = ?5, original_price = ?6,
+                                                  original_currency = ?7, is_available = 1
+                                            WHERE merchant_id = ?1 AND offer_id = ?2 AND NOT is_available`)
+	if err != nil {
+		s.log(ctx).Error("Preparing revive statement", zap.Error(err))
+		return 0, 0, err
+	}
+	defer revive.Close()
+
+	insert, err := tx.PrepareContext(ctx, `INSERT INTO products (merchant_id, offer_id, name, price, quantity,
+                                                                 original_price, original_currency)
+                                           VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7)
+                                               ON CONFLICT (merchant_id, offer_id) DO NOTHING`)
+	if err != nil {
+		s.log(ctx).Error("Preparing insert statement", zap.Error(err))
+		return 0, 0, err
+	}
+	defer insert.Close()
+
+	var update *sql.Stmt
+	var updateArgs int
+	if !insertOnly {
+		var statement string
+		statement, updateArgs = updateStatement(columns)
+		update, err = tx.PrepareContext(ctx, statement)
+		if err != nil {
+			s.log(ctx).Error("Preparing update statement", zap.Error(err))
+			return 0, 0, err
+		}
+		defer update.Close()
+	}
+
+	var inserted, updated int64
+	for _, p := range products {
+		args := productArgs(p)
+
+		n, err := execCount(ctx, revive, args)
+		if err == nil && n == 0 {
+			n, err = execCount(ctx, insert, args)
+		}
+		if err != nil {
+			s.log(ctx).Error("Inserting product", zap.Int64("offer_id", p.OfferID), zap.Error(err))
+			return 0, 0, err
+		}
+		if n > 0 {
+			inserted++
+			continue
+		}
+
+		if update == nil {
+			continue
+		}
+
+		n, err = execCount(ctx, update, args[:updateArgs])
+		if err != nil {
+			s.log(ctx).Error("Updating product", zap.Int64("offer_id", p.OfferID), zap.Error(err))
+			return 0, 0, err
+		}
+		updated += n
+	}
+
+	return inserted, updated, nil
+}
+
+// updateStatement returns UPDATE statement assigning provided columns of available product only if any of them changes
+// and number of leading productArgs it takes, original price and currency are assigned together with price
+func updateStatement(columns []string) (string, int) {
+	if len(columns) == 0 {
+		columns = postgresql.UpdatableColumns
+	}
+
+	// positions of columns in productArgs, SQLite expects as many args as the largest position used
+	positions := map[string]int{"name": 3, "price": 4, "quantity": 5, "original_price": 6, "original_currency": 7}
+
+	n := 2
+	set := make([]string, 0, len(columns))
+	where := make([]string, 0, len(columns))
+	assign := func(c string) {
+		placeholder := "?" + strconv.Itoa(positions[c])
+		set = append(set, c+" = "+placeholder)
+		where = append(where, c+" IS NOT "+placeholder)
+		if positions[c] > n {
+			n = positions[c]
+		}
+	}
+
+	for _, c := range columns {
+		assign(c)
+		if c == "price" {
+			assign("original_price")
+			assign("original_currency")
+		}
+	}
+
+	return `UPDATE products SET ` + strings.Join(set, ", ") + `
+             WHERE merchant_id = ?1 AND offer_id = ?2 AND is_available
+               AND (` + strings.Join(where, " OR ") + `)`, n
+}
+
+// productArgs returns arguments of upsert statements, prices are saved as decimal strings
+func productArgs(p postgresql.Product) []interface{} {
+	var originalPrice, originalCurrency interface{}
+	if p.OriginalPrice != nil {
+		originalPrice = p.OriginalPrice.String()
+		originalCurrency = p.OriginalCurrency
+	}
+
+	return []interface{}{p.MerchantID, p.OfferID, p.Name, p.Price.String(), p.Quantity, originalPrice, originalCurrency}
+}
+
+func execCount(ctx context.Context, stmt *sql.Stmt, args []interface{}) (int64, error) {
+	res, err := stmt.ExecContext(ctx, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// delete makes available offers of the merchant unavailable within tx and returns their number
+func (s *Store) delete(ctx context.Context, tx *sql.Tx, merchantID int64, offerIDs []int64) (int64, error) {
+	stmt, err := tx.PrepareContext(ctx, "UPDATE products SET is_available = 0 WHERE merchant_id = ?1 AND offer_id = ?2 AND is_available")
+	if err != nil {
+		s.log(ctx).Error("Preparing delete statement", zap.Error(err))
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var deleted int64
+	for _, id := range offerIDs {
+		n, err := execCount(ctx, stmt, []interface{}{merchantID, id})
+		if err != nil {
+			s.log(ctx).Error("Deleting product", zap.Int64("offer_id", id), zap.Error(err))
+			return 0, err
+		}
+		deleted += n
+	}
+
+	return deleted, nil
+}
+
+// List returns products matching ListOptions, fuzzy name match falls back to contains one
+func (s *Store) List(ctx context.Context, options ...postgresql.ListOption) ([]postgresql.Product, error) {
+	products := []postgresql.Product{}
+	err := s.ListEach(ctx, func(p postgresql.Product) error {
+		products = append(products, p)
+		return nil
+	}, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return products, nil
+}
+
+// ListEach calls fn for every product List would return in the same order while rows are being read.
+// Error returned by fn stops reading and is returned as is.
+func (s *Store) ListEach(ctx context.Context, fn func(p postgresql.Product) error, options ...postgresql.ListOption) error {
+	query := postgresql.NewListQuery(options...)
+
+	b := strings.Builder{}
+	b.WriteString("SELECT " + selectColumns + " FROM products")
+	args := writeFilters(&b, query, nil)
+
+	if query.AfterSet {
+		b.WriteString(" AND (merchant_id, offer_id) > (?, ?)")
+		args = append(args, query.AfterMerchantID, query.AfterOfferID)
+	}
+
+	b.WriteString(" ORDER BY merchant_id, offer_id")
+
+	// SQLite requires LIMIT clause for OFFSET, negative limit means no limit
+	if query.Limit > 0 || query.Offset > 0 {
+		limit := query.Limit
+		if limit <= 0 {
+			limit = -1
+		}
+		b.WriteString(" LIMIT ? OFFSET ?")
+		args = append(args, limit, query.Offset)
+	}
+
+	rows, err := s.db.QueryContext(ctx, b.String(), args...)
+	if err != nil {
+		s.log(ctx).Error("Selecting rows", zap.Error(err))
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			s.log(ctx).Error("Scanning row", zap.Error(err))
+			return err
+		}
+
+		err = fn(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// Count returns number of products matching filters of ListOptions, pagination options are ignored
+func (s *Store) Count(ctx context.Context, options ...postgresql.ListOption) (int64, error) {
+	query := postgresql.NewListQuery(options...)
+
+	b := strings.Builder{}
+	b.WriteString("SELECT count(*) FROM products")
+	args := writeFilters(&b, query, nil)
+
+	var count int64
+	err := s.db.QueryRowContext(ctx, b.String(), args...).Scan(&count)
+	if err != nil {
+		s.log(ctx).Error("Counting rows", zap.Error(err))
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// Get returns available product of the merchant with provided offer id or postgresql.ErrProductNotFound
+func (s *Store) Get(ctx context.Context, merchantID, offerID int64) (postgresql.Product, error) {
+	stmt := "SELECT " + selectColumns + " FROM products WHERE merchant_id = ? AND offer_id = ? AND is_available"
+
+	p, err := scanProduct(s.db.QueryRowContext(ctx, stmt, merchantID, offerID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return postgresql.Product{}, postgresql.ErrProductNotFound
+		}
+
+		s.log(ctx).Error("Reading product", zap.Int64("merchant_id", merchantID), zap.Int64("offer_id", offerID), zap.Error(err))
+		return postgresql.Product{}, err
+	}
+
+	return p, nil
+}
+
+// writeFilters writes WHERE clause of filters shared by List and Count to b and returns args with filter values appended.
+// Prices are compared as floating point numbers, which is exact enough for bounds of price filter.
+func writeFilters(b *strings.Builder, query postgresql.ListQuery, args []interface{}) []interface{} {
+	b.WriteString(" WHERE 1 = 1")
+
+	switch query.Availability {
+	case postgresql.AvailabilityAny:
+	case postgresql.AvailabilityUnavailable:
+		b.WriteString(" AND NOT is_available")
+	default:
+		b.WriteString(" AND is_available")
+	}
+
+	if query.MerchantID != 0 {
+		b.WriteString(" AND merchant_id = ?")
+		args = append(args, query.MerchantID)
+	}
+
+	if query.OfferID != 0 {
+		b.WriteString(" AND offer_id = ?")
+		args = append(args, query.OfferID)
+	}
+
+	if query.NameQuery != "" {
+		// substr and instr are used instead of LIKE, so query needs no escaping
+		switch query.NameMatch {
+		case postgresql.NameMatchContains, postgresql.NameMatchFuzzy:
+			b.WriteString(" AND instr(lower(name), lower(?)) > 0")
+		default:
+			b.WriteString(" AND substr(name, 1, " + strconv.Itoa(len([]rune(query.NameQuery))) + ") = ?")
+		}
+		args = append(args, query.NameQuery)
+	}
+
+	if query.PriceMin != nil {
+		b.WriteString(" AND CAST(price AS REAL) >= ?")
+		min, _ := query.PriceMin.Float64()
+		args = append(args, min)
+	}
+
+	if query.PriceMax != nil {
+		b.WriteString(" AND CAST(price AS REAL) <= ?")
+		max, _ := query.PriceMax.Float64()
+		args = append(args, max)
+	}
+
+	if query.MinQuantity > 0 {
+		b.WriteString(" AND quantity >= ?")
+		args = append(args, query.MinQuantity)
+	}
+
+	return args
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanProduct(row rowScanner) (postgresql.Product, error) {
+	var p postgresql.Product
+	var price string
+	var originalPrice sql.NullString
+	err := row.Scan(&p.MerchantID, &p.OfferID, &p.Name, &price, &p.Quantity, &p.Available, &originalPrice, &p.OriginalCurrency)
+	if err != nil {
+		return postgresql.Product{}, err
+	}
+
+	p.Price, err = decimal.NewFromString(price)
+	if err != nil {
+		return postgresql.Product{}, err
+	}
+
+	if originalPrice.Valid {
+		d, err := decimal.NewFromString(originalPrice.String)
+		if err != nil {
+			return postgresql.Product{}, err
+		}
+		p.OriginalPrice = &d
+	}
+
+	return p, nil
+}
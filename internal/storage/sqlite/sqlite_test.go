@@ -0,0 +1,261 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	_ "modernc.org/sqlite"
+	"mx/internal/storage/postgresql"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+
+	s, err := NewStore(context.Background(), zap.NewNop(), filepath.Join(t.TempDir(), "catalog.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func product(offerID int64, name, price string) postgresql.Product {
+	return postgresql.Product{MerchantID: 1, OfferID: offerID, Name: name, Price: decimal.RequireFromString(price), Quantity: 1, Available: true}
+}
+
+// offerIDs returns offer ids of products in the same order
+func offerIDs(products []postgresql.Product) []int64 {
+	ids := make([]int64, len(products))
+	for i, p := range products {
+		ids[i] = p.OfferID
+	}
+
+	return ids
+}
+
+func TestUpsertAndDelete(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	inserted, updated, err := s.Upsert(ctx, []postgresql.Product{product(1, "Tea", "1.50"), product(2, "Coffee", "2")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inserted != 2 || updated != 0 {
+		t.Fatalf("expected 2 added and 0 updated, got %d and %d", inserted, updated)
+	}
+
+	// unchanged product is neither added nor updated
+	inserted, updated, err = s.Upsert(ctx, []postgresql.Product{product(1, "Tea", "1.50"), product(2, "Coffee", "3")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inserted != 0 || updated != 1 {
+		t.Fatalf("expected 0 added and 1 updated, got %d and %d", inserted, updated)
+	}
+
+	deleted, err := s.Delete(ctx, 1, []int64{1, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Fatalf("expected 1 deleted, got %d", deleted)
+	}
+
+	deleted, err = s.Delete(ctx, 1, []int64{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 0 {
+		t.Fatalf("expected unavailable product not to be deleted again, got %d", deleted)
+	}
+
+	// unavailable product is revived and counted as added
+	inserted, updated, err = s.Upsert(ctx, []postgresql.Product{product(1, "Green tea", "1.50")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inserted != 1 || updated != 0 {
+		t.Fatalf("expected revived product to be added, got %d added and %d updated", inserted, updated)
+	}
+
+	p, err := s.Get(ctx, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "Green tea" || !p.Available || !p.Price.Equal(decimal.RequireFromString("1.50")) {
+		t.Fatalf("unexpected revived product %+v", p)
+	}
+
+	_, err = s.Get(ctx, 1, 3)
+	if !errors.Is(err, postgresql.ErrProductNotFound) {
+		t.Fatalf("expected ErrProductNotFound, got %v", err)
+	}
+}
+
+func TestImport(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []postgresql.ImportOption
+		stats   postgresql.ImportStats
+		// available are offer ids of available products after import
+		available []int64
+	}{
+		{
+			name:      "default",
+			stats:     postgresql.ImportStats{Added: 1, Updated: 1, Removed: 1},
+			available: []int64{2, 3},
+		},
+		{
+			name:      "insert only",
+			options:   []postgresql.ImportOption{postgresql.WithInsertOnly()},
+			stats:     postgresql.ImportStats{Added: 1, Skipped: 2},
+			available: []int64{1, 2, 3},
+		},
+		{
+			name:      "preview",
+			options:   []postgresql.ImportOption{postgresql.WithPreview()},
+			stats:     postgresql.ImportStats{Added: 1, Updated: 1, Removed: 1},
+			available: []int64{1, 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			ctx := context.Background()
+
+			_, _, err := s.Upsert(ctx, []postgresql.Product{product(1, "Tea", "1"), product(2, "Coffee", "2")})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stats, err := s.UpsertAndDelete(ctx, []postgresql.Product{product(2, "Coffee", "3"), product(3, "Cocoa", "4")}, 1, []int64{1}, tt.options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats != tt.stats {
+				t.Fatalf("expected stats %+v, got %+v", tt.stats, stats)
+			}
+
+			products, err := s.List(ctx, postgresql.WithMerchantID(1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := offerIDs(products); !equalIDs(got, tt.available) {
+				t.Fatalf("expected available offers %v, got %v", tt.available, got)
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	_, _, err := s.Upsert(ctx, []postgresql.Product{
+		product(1, "Black tea", "1"), product(2, "Green tea", "2.50"), product(3, "Coffee", "10"), product(4, "Tea set", "20"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Delete(ctx, 1, []int64{4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	min := decimal.RequireFromString("2.50")
+	tests := []struct {
+		name    string
+		options []postgresql.ListOption
+		want    []int64
+		// count is expected Count of paged listings, which ignores pagination
+		count int64
+	}{
+		{name: "available", want: []int64{1, 2, 3}},
+		{name: "unavailable", options: []postgresql.ListOption{postgresql.WithAvailability(postgresql.AvailabilityUnavailable)}, want: []int64{4}},
+		{name: "any availability", options: []postgresql.ListOption{postgresql.WithAvailability(postgresql.AvailabilityAny)}, want: []int64{1, 2, 3, 4}},
+		{name: "other merchant", options: []postgresql.ListOption{postgresql.WithMerchantID(2)}},
+		{name: "offer id", options: []postgresql.ListOption{postgresql.WithOfferID(2)}, want: []int64{2}},
+		{name: "prefix", options: []postgresql.ListOption{postgresql.WithNameQuery("Green")}, want: []int64{2}},
+		{name: "contains", options: []postgresql.ListOption{postgresql.WithNameQuery("TEA"), postgresql.WithNameMatch(postgresql.NameMatchContains)}, want: []int64{1, 2}},
+		{name: "min price", options: []postgresql.ListOption{postgresql.WithPriceRange(&min, nil)}, want: []int64{2, 3}},
+		{name: "limit and offset", options: []postgresql.ListOption{postgresql.WithLimit(1), postgresql.WithOffset(1)}, want: []int64{2}, count: 3},
+		{name: "offset", options: []postgresql.ListOption{postgresql.WithOffset(2)}, want: []int64{3}, count: 3},
+		{name: "keyset", options: []postgresql.ListOption{postgresql.WithAfter(1, 1), postgresql.WithLimit(1)}, want: []int64{2}, count: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			products, err := s.List(ctx, tt.options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := offerIDs(products); !equalIDs(got, tt.want) {
+				t.Fatalf("expected offers %v, got %v", tt.want, got)
+			}
+
+			count, err := s.Count(ctx, tt.options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.count
+			if want == 0 {
+				want = int64(len(tt.want))
+			}
+			if count != want {
+				t.Fatalf("expected count %d, got %d", want, count)
+			}
+		})
+	}
+}
+
+func TestUpdateStatement(t *testing.T) {
+	tests := []struct {
+		name    string
+		columns []string
+		set     string
+		args    int
+	}{
+		{name: "name", columns: []string{"name"}, set: "SET name = ?3 WHERE", args: 3},
+		{name: "quantity", columns: []string{"quantity"}, set: "SET quantity = ?5 WHERE", args: 5},
+		{
+			name:    "price with original price",
+			columns: []string{"price"},
+			set:     "SET price = ?4, original_price = ?6, original_currency = ?7 WHERE",
+			args:    7,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statement, args := updateStatement(tt.columns)
+
+			if got := strings.Join(strings.Fields(statement), " "); !strings.Contains(got, tt.set) {
+				t.Fatalf("expected statement to contain %q, got %q", tt.set, got)
+			}
+			if args != tt.args {
+				t.Fatalf("expected %d args, got %d", tt.args, args)
+			}
+		})
+	}
+}
+
+func equalIDs(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,79 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"mx/internal/storage/postgresql"
+	"time"
+)
+
+// ErrCanNotAbort is returned when force aborted task is not in Processing state
+var ErrCanNotAbort = errors.New("task can not be aborted due to its current state")
+
+// AbortResult defines outcome of force abort
+type AbortResult struct {
+	ID string `json:"id"`
+	// Local is true if task was queued or processed by this instance, so its processing is stopped,
+	// otherwise only its database record is aborted
+	Local bool `json:"local"`
+}
+
+// ForceAbortTask moves Processing task to Aborted state without waiting for its processing to react,
+// for cases where task does not respond to cancellation. Context of task processed by this instance is canceled,
+// which rolls back its open transaction, and the database record is aborted whichever instance processes the task.
+func (s *Scheduler) ForceAbortTask(ctx context.Context, stringID string) (AbortResult, error) {
+	id, err := ParseTaskID(stringID)
+	if err != nil {
+		return AbortResult{}, ErrBadTaskID
+	}
+
+	finishedAt := time.Now()
+	taskErr := &taskError{code: codeForceAborted, reason: "task is aborted by administrator"}
+
+	s.taskStore.rw.Lock()
+	t, local := s.taskStore.tasks[id]
+	if local {
+		if t.state != Processing {
+			s.taskStore.rw.Unlock()
+			return AbortResult{}, ErrCanNotAbort
+		}
+
+		// finished task is not changed by processing goroutines anymore
+		t.state = Aborted
+		t.result.error = taskErr
+		t.finishedAt = finishedAt
+		s.taskStore.tasks[id] = t
+	}
+	s.taskStore.rw.Unlock()
+
+	if local {
+		s.cancelChannels.rw.Lock()
+		abort, ok := s.cancelChannels.abortFuncs[id]
+		if ok {
+			abort()
+		}
+
+		// queued task leaves the queue without taking processing slot
+		cancelCh, ok := s.cancelChannels.cancelChannels[id]
+		if ok {
+			delete(s.cancelChannels.cancelChannels, id)
+			close(cancelCh)
+		}
+		s.cancelChannels.rw.Unlock()
+
+		s.logger.Warn("Task is force aborted", zap.String("ID", id.String()))
+	}
+
+	state, err := s.db.ForceAbortTask(ctx, id.String(), finishedAt, taskErr.code, taskErr.reason)
+	switch {
+	case errors.Is(err, postgresql.ErrTaskNotFound) && !local:
+		return AbortResult{}, ErrBadTaskID
+	case err != nil && !errors.Is(err, postgresql.ErrTaskNotFound):
+		return AbortResult{}, err
+	case !local && state != Processing.String():
+		return AbortResult{}, ErrCanNotAbort
+	}
+
+	return AbortResult{ID: id.String(), Local: local}, nil
+}
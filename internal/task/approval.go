@@ -0,0 +1,155 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"mx/internal/storage/postgresql"
+	"time"
+)
+
+// ErrNotPendingApproval is returned when approved or rejected task is not in PendingApproval state
+var ErrNotPendingApproval = errors.New("task is not pending approval")
+
+// thresholdReason is saved as reason of approval made by Scheduler itself
+const thresholdReason = "changes are below approval threshold"
+
+// requiresPreview reports whether task has to preview its changes, i.e. it has not been approved yet
+func (s *Scheduler) requiresPreview(id TaskID) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.dbTimeout)
+	defer cancel()
+
+	approved, err := s.db.TaskApproved(ctx, id.String())
+	if err != nil {
+		return false, err
+	}
+
+	return !approved, nil
+}
+
+// finishPreview moves previewed task to PendingApproval state saving stats of changes it is going to make.
+// Task changing fewer offers than approval threshold is approved and applied right away within the same slot.
+func (s *Scheduler) finishPreview(logger *zap.Logger, j job, result taskResult, cancelCh, stopCh chan struct{}) {
+	id := j.id
+	data := result.data
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.dbTimeout)
+	defer cancel()
+
+	changed := data.added + data.updated + data.removed
+	if changed < s.approvalThreshold {
+		logger.Info("Task changes are below approval threshold, task is applied", zap.Int64("changed", changed))
+
+		_, err := s.db.ApproveTask(ctx, id.String(), Processing.String(), Processing.String(), thresholdReason)
+		if err != nil {
+			logger.Error("Approving task", zap.Error(err))
+			s.abortTask(id, storageError(err))
+			return
+		}
+
+		s.taskStore.rw.Lock()
+		t := s.taskStore.tasks[id]
+		t.result = taskResult{}
+		t.progress = progress{}
+		s.taskStore.tasks[id] = t
+		s.taskStore.rw.Unlock()
+
+		j.preview = false
+		s.process(context.Background(), logger, j, cancelCh, stopCh)
+		return
+	}
+
+	s.taskStore.rw.Lock()
+	t := s.taskStore.tasks[id]
+	// force aborted task is already finished
+	if !t.finishedAt.IsZero() {
+		s.taskStore.rw.Unlock()
+		return
+	}
+	t.state = PendingApproval
+	t.result = result
+	t.progress = progress{}
+	s.taskStore.tasks[id] = t
+	s.taskStore.rw.Unlock()
+
+	err := s.db.SaveTaskPreview(ctx, id.String(), PendingApproval.String(), data.added, data.updated, data.removed, data.ignored, data.skipped, data.duplicates, data.reasons)
+	if err != nil {
+		logger.Error("Saving task preview to database", zap.Error(err))
+		s.abortTask(id, storageError(err))
+		return
+	}
+
+	// task may wait for approval indefinitely, so it is read from database meanwhile
+	s.taskStore.rw.Lock()
+	delete(s.taskStore.tasks, id)
+	s.taskStore.rw.Unlock()
+
+	logger.Info("Task is waiting for approval", zap.Int64("changed", changed))
+}
+
+// ApproveTask makes PendingApproval task to be applied, the task is processed from scratch like newly uploaded one.
+// Reason is saved with the approval.
+func (s *Scheduler) ApproveTask(ctx context.Context, stringID string, reason string) error {
+	id, err := ParseTaskID(stringID)
+	if err != nil {
+		return ErrBadTaskID
+	}
+
+	record, err := s.db.ApproveTask(ctx, id.String(), PendingApproval.String(), Processing.String(), reason)
+	if err != nil {
+		return approvalError(err)
+	}
+
+	logger := s.logger.With(zap.String("ID", record.ID))
+	logger.Info("Task is approved")
+
+	file, err := fileFromRecord(record)
+	if err != nil {
+		logger.Error("Restoring uploaded file settings", zap.Error(err))
+		return err
+	}
+
+	t := task{
+		merchantID: record.MerchantID,
+		state:      Processing,
+		startedAt:  record.CreatedAt,
+	}
+
+	j := job{
+		id:         id,
+		merchantID: record.MerchantID,
+		file:       file,
+		settings:   importSettings(record),
+	}
+
+	s.enqueue(logger, j, t)
+	return nil
+}
+
+// RejectTask finishes PendingApproval task in Rejected state, so it is never applied. Reason is saved with the rejection.
+func (s *Scheduler) RejectTask(ctx context.Context, stringID string, reason string) error {
+	id, err := ParseTaskID(stringID)
+	if err != nil {
+		return ErrBadTaskID
+	}
+
+	err = s.db.RejectTask(ctx, id.String(), PendingApproval.String(), Rejected.String(), time.Now(), reason)
+	if err != nil {
+		return approvalError(err)
+	}
+
+	s.logger.Info("Task is rejected", zap.String("ID", id.String()))
+	return nil
+}
+
+// approvalError converts storage error of approval or rejection into scheduler one
+func approvalError(err error) error {
+	switch {
+	case errors.Is(err, postgresql.ErrTaskNotFound):
+		return ErrBadTaskID
+	case errors.Is(err, postgresql.ErrConflict):
+		return ErrNotPendingApproval
+	default:
+		return err
+	}
+}
@@ -0,0 +1,83 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mx/internal/storage"
+	"os"
+	"path"
+)
+
+// WithBlobStore makes Scheduler read uploaded files from provided blob storage,
+// by default they are read from working directory
+func WithBlobStore(blobs storage.Blob) SchedulerOption {
+	return func(s *Scheduler) {
+		s.blobs = blobs
+	}
+}
+
+// localPather is implemented by blob storage keeping blobs as local files, which are parsed in place
+type localPather interface {
+	Path(key string) string
+}
+
+// fileError is returned when uploaded file can not be fetched from blob storage
+type fileError struct {
+	err error
+}
+
+func (e *fileError) Error() string {
+	return "fetching uploaded file: " + e.err.Error()
+}
+
+func (e *fileError) Unwrap() error {
+	return e.err
+}
+
+// fetchingParse returns parseFunc passing local copy of uploaded blob to parse,
+// since .xlsx workbooks can be read only from local files
+func fetchingParse(blobs storage.Blob, parse parseFunc) parseFunc {
+	return func(ctx context.Context, file File, merchantID int64, skip, batchSize int64, report progressFunc, apply applyFunc) error {
+		if local, ok := blobs.(localPather); ok {
+			file.Path = local.Path(file.Path)
+			return parse(ctx, file, merchantID, skip, batchSize, report, apply)
+		}
+
+		localPath, err := fetchBlob(ctx, blobs, file.Path)
+		if err != nil {
+			return &fileError{err}
+		}
+		defer os.Remove(localPath)
+
+		file.Path = localPath
+		return parse(ctx, file, merchantID, skip, batchSize, report, apply)
+	}
+}
+
+// fetchBlob copies blob into temporary file and returns its path, the file has to be removed by caller
+func fetchBlob(ctx context.Context, blobs storage.Blob, key string) (string, error) {
+	r, err := blobs.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	f, err := ioutil.TempFile("", "upload-*"+path.Ext(key))
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(f, r)
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("copying blob %s: %w", key, err)
+	}
+
+	return f.Name(), nil
+}
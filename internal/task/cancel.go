@@ -0,0 +1,148 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"mx/internal/storage/postgresql"
+)
+
+// outcomes of CancelTasks per task
+const (
+	CancelOutcomeCanceled      = "canceled"
+	CancelOutcomeNotCancelable = "not_cancelable"
+	CancelOutcomeNotFound      = "not_found"
+)
+
+// CancelResult defines outcome of canceling single task
+type CancelResult struct {
+	ID      string `json:"id"`
+	Outcome string `json:"outcome"`
+	// State is state of task which can not be canceled, e.g. Done
+	State string `json:"state,omitempty"`
+}
+
+// signalCancel closes cancel channels of provided tasks within single critical section and reports which tasks
+// were still queued or processed. Channel is removed once closed, so repeated cancellation is refused.
+func (s *Scheduler) signalCancel(ids []TaskID) map[TaskID]bool {
+	s.cancelChannels.rw.Lock()
+	defer s.cancelChannels.rw.Unlock()
+
+	signaled := make(map[TaskID]bool, len(ids))
+	for _, id := range ids {
+		ch, ok := s.cancelChannels.cancelChannels[id]
+		if !ok {
+			continue
+		}
+
+		delete(s.cancelChannels.cancelChannels, id)
+		close(ch)
+		signaled[id] = true
+	}
+
+	return signaled
+}
+
+// CancelTasks cancels Processing tasks with provided ids or, if ids are empty, all Processing tasks of the merchant.
+// Tasks queued or processed by this instance are signaled within single critical section and tasks waiting
+// in shared queue are canceled by single database statement, tasks processed by other instances can not be canceled.
+// Returns outcome of every requested task or of every matching task of the merchant.
+func (s *Scheduler) CancelTasks(ctx context.Context, stringIDs []string, merchantID int64) ([]CancelResult, error) {
+	results := make([]CancelResult, 0, len(stringIDs))
+	requested := make(map[TaskID]bool, len(stringIDs))
+	var ids []TaskID
+	for _, stringID := range stringIDs {
+		id, err := ParseTaskID(stringID)
+		if err != nil {
+			results = append(results, CancelResult{ID: stringID, Outcome: CancelOutcomeNotFound})
+			continue
+		}
+
+		if !requested[id] {
+			requested[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	states := make(map[TaskID]taskState)
+	var candidates []TaskID
+	s.taskStore.rw.RLock()
+	if len(stringIDs) == 0 {
+		for id, t := range s.taskStore.tasks {
+			if t.merchantID == merchantID && t.state == Processing {
+				ids = append(ids, id)
+			}
+		}
+	}
+	for _, id := range ids {
+		t, ok := s.taskStore.tasks[id]
+		if !ok {
+			continue
+		}
+
+		states[id] = t.state
+		if t.state == Processing {
+			candidates = append(candidates, id)
+		}
+	}
+	s.taskStore.rw.RUnlock()
+
+	signaled := s.signalCancel(candidates)
+
+	var remote []string
+	outcomes := make(map[TaskID]CancelResult, len(ids))
+	for _, id := range ids {
+		state, ok := states[id]
+		switch {
+		case !ok:
+			remote = append(remote, id.String())
+		case signaled[id]:
+			outcomes[id] = CancelResult{ID: id.String(), Outcome: CancelOutcomeCanceled}
+		default:
+			outcomes[id] = CancelResult{ID: id.String(), Outcome: CancelOutcomeNotCancelable, State: state.String()}
+		}
+	}
+
+	if s.instanceID != "" && (len(remote) != 0 || len(stringIDs) == 0) {
+		filter := merchantID
+		if len(stringIDs) != 0 {
+			filter = 0
+		}
+
+		canceled, err := s.db.CancelQueuedTasks(ctx, remote, filter)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, stringID := range canceled {
+			id, err := ParseTaskID(stringID)
+			if err != nil {
+				continue
+			}
+
+			if len(stringIDs) == 0 {
+				ids = append(ids, id)
+			}
+			outcomes[id] = CancelResult{ID: stringID, Outcome: CancelOutcomeCanceled}
+		}
+	}
+
+	for _, id := range ids {
+		result, ok := outcomes[id]
+		if !ok {
+			// task is unknown to this instance or processed by another one
+			record, err := s.db.ReadTask(ctx, id.String())
+			switch {
+			case errors.Is(err, postgresql.ErrTaskNotFound):
+				result = CancelResult{ID: id.String(), Outcome: CancelOutcomeNotFound}
+			case err != nil:
+				return nil, err
+			default:
+				result = CancelResult{ID: id.String(), Outcome: CancelOutcomeNotCancelable, State: record.State}
+			}
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
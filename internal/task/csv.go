@@ -0,0 +1,110 @@
+package task
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mx/internal/xlsxstream"
+	"os"
+	"strings"
+)
+
+// csvReader reads rows of CSV file reordering columns according to header if file has one
+type csvReader struct {
+	file *os.File
+	r    *csv.Reader
+	// columns maps position expected by parseRow to position in the file, nil means the same order
+	columns []int
+}
+
+func openCSV(f File) (*csvReader, error) {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+	if f.Delimiter != 0 {
+		r.Comma = f.Delimiter
+	}
+
+	reader := &csvReader{file: file, r: r}
+	switch {
+	// explicit mapping refers to columns by their position in the file, so header is only skipped
+	case f.Header && len(f.Mapping) != 0:
+		_, err = r.Read()
+		if err != nil && !errors.Is(err, io.EOF) {
+			file.Close()
+			return nil, err
+		}
+	case f.Header:
+		err = reader.readHeader()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return reader, nil
+}
+
+// readHeader matches header names to expected columns ignoring case and unknown columns
+func (c *csvReader) readHeader() error {
+	header, err := c.r.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return errors.New("csv file has no header")
+		}
+		return err
+	}
+
+	row := make(xlsxstream.Row, len(header))
+	for i, name := range header {
+		row[i] = xlsxstream.Cell{Value: name, Type: xlsxstream.CellTypeString}
+	}
+
+	c.columns, err = headerColumns(row)
+	if err != nil {
+		return fmt.Errorf("csv %w", err)
+	}
+
+	return nil
+}
+
+// Next returns next CSV record as row of string cells
+func (c *csvReader) Next() (xlsxstream.Row, error) {
+	record, err := c.r.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.columns == nil {
+		row := make(xlsxstream.Row, len(record))
+		for i, value := range record {
+			row[i] = xlsxstream.Cell{Value: strings.TrimSpace(value), Type: xlsxstream.CellTypeString}
+		}
+		return row, nil
+	}
+
+	row := make(xlsxstream.Row, len(c.columns))
+	for i, position := range c.columns {
+		if position < len(record) {
+			row[i] = xlsxstream.Cell{Value: strings.TrimSpace(record[position]), Type: xlsxstream.CellTypeString}
+		}
+	}
+
+	return row, nil
+}
+
+// RowsTotal returns zero since CSV file can not be measured without reading it
+func (c *csvReader) RowsTotal() int64 {
+	return 0
+}
+
+// Close closes underlying file
+func (c *csvReader) Close() error {
+	return c.file.Close()
+}
@@ -0,0 +1,142 @@
+package task
+
+import (
+	"fmt"
+	"mx/internal/storage/postgresql"
+)
+
+// policies applied to rows with the same offer_id within one file
+const (
+	// DuplicatesLastWins applies the last row of the offer, it is the default policy
+	DuplicatesLastWins = "last-wins"
+	// DuplicatesFirstWins applies the first row of the offer and ignores the following ones
+	DuplicatesFirstWins = "first-wins"
+	// DuplicatesRejectFile aborts the task once the same offer_id is read twice
+	DuplicatesRejectFile = "reject-file"
+)
+
+// duplicateOfferError is returned while parsing file containing the same offer_id twice with DuplicatesRejectFile policy
+type duplicateOfferError struct {
+	OfferID  int64 `json:"offer_id"`
+	Row      int64 `json:"row"`
+	FirstRow int64 `json:"first_row"`
+}
+
+func (e *duplicateOfferError) Error() string {
+	return fmt.Sprintf("offer_id %d of row %d is already listed in row %d", e.OfferID, e.Row, e.FirstRow)
+}
+
+// batchPosition defines place of the offer row within batch
+type batchPosition struct {
+	deleted bool
+	idx     int
+}
+
+// batchBuilder collects parsed rows into batch applying duplicate policy.
+// Duplicates of rows applied in earlier batches are overridden by applying batches in file order.
+type batchBuilder struct {
+	policy string
+	// seen maps offer ids read so far to rows they were first read from
+	seen map[int64]int64
+	b    batch
+	// positions maps offer ids of current batch to their rows in ToUpsert or ToDelete
+	positions map[int64]batchPosition
+}
+
+func newBatchBuilder(policy string) *batchBuilder {
+	if policy == "" {
+		policy = DuplicatesLastWins
+	}
+
+	return &batchBuilder{
+		policy:    policy,
+		seen:      make(map[int64]int64),
+		positions: make(map[int64]batchPosition),
+	}
+}
+
+// remember marks offer as read from the row without adding it to batch, it is used for rows applied by previous runs
+func (bb *batchBuilder) remember(row int64, offerID int64) {
+	if _, ok := bb.seen[offerID]; !ok {
+		bb.seen[offerID] = row
+	}
+}
+
+// ignore counts row which can not be parsed
+func (bb *batchBuilder) ignore(row int64, err *rowError) {
+	bb.b.Ignored++
+	bb.reject(row, err)
+}
+
+// reject adds row to report of the batch counting it under reason code
+func (bb *batchBuilder) reject(row int64, err *rowError) {
+	bb.b.Rejected = append(bb.b.Rejected, postgresql.RejectedRow{Row: row, Code: err.code, Reason: err.message})
+	if bb.b.Reasons == nil {
+		bb.b.Reasons = make(map[string]int64)
+	}
+	bb.b.Reasons[err.code]++
+}
+
+// add appends parsed offer row to batch according to duplicate policy
+func (bb *batchBuilder) add(row int64, product postgresql.Product, available bool) error {
+	firstRow, duplicate := bb.seen[product.OfferID]
+	if duplicate {
+		switch bb.policy {
+		case DuplicatesRejectFile:
+			return &duplicateOfferError{OfferID: product.OfferID, Row: row, FirstRow: firstRow}
+		case DuplicatesFirstWins:
+			bb.b.Duplicates++
+			bb.reject(row, &rowError{reasonDuplicate, fmt.Sprintf("offer_id %d is already listed in row %d, the row is ignored", product.OfferID, firstRow)})
+			return nil
+		}
+
+		bb.b.Duplicates++
+		bb.reject(row, &rowError{reasonDuplicate, fmt.Sprintf("offer_id %d is already listed in row %d, the row overrides it", product.OfferID, firstRow)})
+		if pos, ok := bb.positions[product.OfferID]; ok {
+			bb.remove(pos)
+		}
+	} else {
+		bb.seen[product.OfferID] = row
+	}
+
+	if available {
+		bb.positions[product.OfferID] = batchPosition{idx: len(bb.b.ToUpsert)}
+		bb.b.ToUpsert = append(bb.b.ToUpsert, product)
+	} else {
+		bb.positions[product.OfferID] = batchPosition{deleted: true, idx: len(bb.b.ToDelete)}
+		bb.b.ToDelete = append(bb.b.ToDelete, product.OfferID)
+	}
+
+	return nil
+}
+
+// remove drops row at pos from batch moving the last row of the same list in its place
+func (bb *batchBuilder) remove(pos batchPosition) {
+	if pos.deleted {
+		last := len(bb.b.ToDelete) - 1
+		moved := bb.b.ToDelete[last]
+		bb.b.ToDelete[pos.idx] = moved
+		bb.b.ToDelete = bb.b.ToDelete[:last]
+		if pos.idx != last {
+			bb.positions[moved] = pos
+		}
+		return
+	}
+
+	last := len(bb.b.ToUpsert) - 1
+	moved := bb.b.ToUpsert[last]
+	bb.b.ToUpsert[pos.idx] = moved
+	bb.b.ToUpsert = bb.b.ToUpsert[:last]
+	if pos.idx != last {
+		bb.positions[moved.OfferID] = pos
+	}
+}
+
+// flush returns collected batch ending at row end and starts new one
+func (bb *batchBuilder) flush(end int64) batch {
+	b := bb.b
+	b.End = end
+	bb.b = batch{}
+	bb.positions = make(map[int64]batchPosition)
+	return b
+}
@@ -0,0 +1,70 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"mx/internal/storage/postgresql"
+)
+
+// ErrTaskNotFinished is returned when changes of task still being processed are requested
+var ErrTaskNotFinished = errors.New("task is not finished yet")
+
+// Diff defines page of offers changed by finished task
+type Diff struct {
+	TaskID     string                  `json:"task_id"`
+	MerchantID int64                   `json:"merchant_id"`
+	State      string                  `json:"state"`
+	Changes    []postgresql.TaskChange `json:"changes"`
+	// NextAfter is offer_id to read next page after, it is nil on the last page
+	NextAfter *int64 `json:"next_after"`
+}
+
+// ReadTaskDiff returns at most limit offers changed by finished task ordered by offer_id starting after provided one.
+// Aborted, canceled or timed out tasks report changes of chunks committed before they stopped.
+// PendingApproval and Rejected tasks report changes found by their preview.
+func (s *Scheduler) ReadTaskDiff(ctx context.Context, stringID string, afterOfferID int64, limit int) (Diff, error) {
+	id, err := ParseTaskID(stringID)
+	if err != nil {
+		return Diff{}, ErrBadTaskID
+	}
+
+	s.taskStore.rw.RLock()
+	t, ok := s.taskStore.tasks[id]
+	s.taskStore.rw.RUnlock()
+
+	if !ok {
+		t, err = s.readTask(ctx, id)
+		if err != nil {
+			return Diff{}, err
+		}
+	}
+
+	// previewed task reports changes it is going to make once approved
+	if t.finishedAt.IsZero() && t.state != PendingApproval {
+		return Diff{}, ErrTaskNotFinished
+	}
+
+	// one more change is read to find out whether there is next page
+	changes, err := s.db.TaskChanges(ctx, id.String(), afterOfferID, limit+1)
+	if err != nil {
+		if errors.Is(err, postgresql.ErrTaskNotFound) {
+			return Diff{}, ErrBadTaskID
+		}
+
+		return Diff{}, err
+	}
+
+	diff := Diff{
+		TaskID:     id.String(),
+		MerchantID: t.merchantID,
+		State:      t.state.String(),
+		Changes:    changes,
+	}
+	if len(changes) > limit {
+		diff.Changes = changes[:limit]
+		next := diff.Changes[limit-1].OfferID
+		diff.NextAfter = &next
+	}
+
+	return diff, nil
+}
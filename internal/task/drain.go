@@ -0,0 +1,26 @@
+package task
+
+import "sync/atomic"
+
+// Drain makes Scheduler stop claiming tasks from shared queue, while tasks already accepted by the instance
+// are processed as usual. Draining can not be stopped, instance is expected to be shut down once ActiveTasks is zero.
+func (s *Scheduler) Drain() {
+	if atomic.CompareAndSwapInt32(&s.draining, 0, 1) {
+		s.logger.Info("Scheduler is draining")
+	}
+}
+
+// Draining reports whether Drain has been called
+func (s *Scheduler) Draining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+// ActiveTasks returns number of tasks accepted by the instance which are not finished yet,
+// i.e. processed, queued for a free slot or pending until database is available
+func (s *Scheduler) ActiveTasks() int64 {
+	s.pendingRW.Lock()
+	pending := len(s.pendingJobs)
+	s.pendingRW.Unlock()
+
+	return int64(len(s.slots)) + atomic.LoadInt64(&s.queueLength) + int64(pending)
+}
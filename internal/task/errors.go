@@ -0,0 +1,98 @@
+package task
+
+import (
+	"errors"
+	"mx/internal/storage/postgresql"
+)
+
+// error codes describing why task was aborted
+const (
+	codeBadFile       = "BAD_FILE"
+	codeParserFailed  = "PARSER_FAILED"
+	codeCatalogLimit  = "CATALOG_LIMIT_EXCEEDED"
+	codeDatabaseError = "DATABASE_ERROR"
+	codeUnavailable   = "DATABASE_UNAVAILABLE"
+	codeConflict      = "CONFLICT"
+	codeTooLarge      = "VALUE_TOO_LARGE"
+	codeConstraint    = "CONSTRAINT_VIOLATION"
+	codeHookFailed    = "HOOK_FAILED"
+	codeDuplicate     = "DUPLICATE_OFFER"
+	codeConversion    = "CURRENCY_CONVERSION_FAILED"
+	codeFileMissing   = "FILE_UNAVAILABLE"
+	codeForceAborted  = "FORCE_ABORTED"
+	codeFrozen        = "MERCHANT_FROZEN"
+	codeUnknown       = "UNKNOWN"
+)
+
+// taskError defines reason of aborting task.
+// code and reason are safe to be shown to client while err may contain internal details.
+type taskError struct {
+	code   string
+	reason string
+	err    error
+}
+
+func (e *taskError) Error() string {
+	if e.err == nil {
+		return e.code + ": " + e.reason
+	}
+
+	return e.code + ": " + e.reason + ": " + e.err.Error()
+}
+
+func (e *taskError) Unwrap() error {
+	return e.err
+}
+
+// parseError wraps error returned by parseFunc
+func parseError(err error) *taskError {
+	var duplicateErr *duplicateOfferError
+	if errors.As(err, &duplicateErr) {
+		return &taskError{code: codeDuplicate, reason: duplicateErr.Error(), err: err}
+	}
+
+	var fileErr *fileError
+	if errors.As(err, &fileErr) {
+		return &taskError{code: codeFileMissing, reason: "uploaded file can not be fetched from storage", err: err}
+	}
+
+	var workerErr *workerError
+	if errors.As(err, &workerErr) {
+		return &taskError{code: codeParserFailed, reason: "file parser failed", err: err}
+	}
+
+	return &taskError{code: codeBadFile, reason: "file can not be read as .xlsx workbook", err: err}
+}
+
+// storageError wraps error returned while applying parsed rows to the database
+func storageError(err error) *taskError {
+	var limitErr *postgresql.CatalogLimitError
+	if errors.As(err, &limitErr) {
+		return &taskError{code: codeCatalogLimit, reason: limitErr.Error(), err: err}
+	}
+
+	switch {
+	case errors.Is(err, postgresql.ErrMerchantFrozen):
+		return &taskError{code: codeFrozen, reason: "merchant catalog is frozen", err: err}
+	case errors.Is(err, postgresql.ErrUnavailable):
+		return &taskError{code: codeUnavailable, reason: "database is unavailable, file can be uploaded again later", err: err}
+	case errors.Is(err, postgresql.ErrConflict):
+		return &taskError{code: codeConflict, reason: "changes conflicted with concurrent import", err: err}
+	case errors.Is(err, postgresql.ErrTooLarge):
+		return &taskError{code: codeTooLarge, reason: "file contains values exceeding database limits", err: err}
+	case errors.Is(err, postgresql.ErrConstraintViolation):
+		return &taskError{code: codeConstraint, reason: "file contains values violating catalog constraints", err: err}
+	}
+
+	return &taskError{code: codeDatabaseError, reason: "database failed to apply changes", err: err}
+}
+
+// errorDetails returns client-safe code and reason of err
+func errorDetails(err error) (string, string) {
+	var taskErr *taskError
+	if errors.As(err, &taskErr) {
+		return taskErr.code, taskErr.reason
+	}
+
+	return codeUnknown, "task processing is aborted"
+}
@@ -0,0 +1,143 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"mx/internal/storage/postgresql"
+	"mx/internal/xlsxstream"
+)
+
+// formats of uploaded files
+const (
+	FormatXLSX   = "xlsx"
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// columnNames defines names of CSV header columns and NDJSON object fields in the order parseRow expects them
+var columnNames = []string{"offer_id", "name", "price", "quantity", "available"}
+
+// File defines uploaded file and the way it should be read
+type File struct {
+	// Path is blob key of uploaded file, parse functions get path of its local copy instead
+	Path   string `json:"path"`
+	Format string `json:"format"`
+	// Delimiter separates CSV fields, Header reports whether first CSV row contains column names
+	Delimiter rune `json:"delimiter,omitempty"`
+	Header    bool `json:"header,omitempty"`
+	// Sheet selects .xlsx worksheet by name or 1-based number, AllSheets selects all of them,
+	// by default only the first worksheet is read
+	Sheet     string `json:"sheet,omitempty"`
+	AllSheets bool   `json:"all_sheets,omitempty"`
+	// Mapping maps columnNames fields to .xlsx or CSV column letters, unmapped fields keep their default columns.
+	// Without mapping columns are matched by names of header row if file has one.
+	Mapping map[string]string `json:"mapping,omitempty"`
+	// Duplicates is policy applied to rows with the same offer_id, empty value means DuplicatesLastWins
+	Duplicates string `json:"duplicates,omitempty"`
+	// Names is policy of product names configured by WithNamePolicy, it is not persisted with the task
+	Names NamePolicy `json:"names,omitempty"`
+	// Prices is policy of product prices configured by WithPricePolicy, it is not persisted with the task
+	Prices PricePolicy `json:"prices"`
+}
+
+// fileOptions defines format specific settings of File persisted with the task
+type fileOptions struct {
+	Delimiter  string            `json:"delimiter,omitempty"`
+	Header     bool              `json:"header,omitempty"`
+	Sheet      string            `json:"sheet,omitempty"`
+	AllSheets  bool              `json:"all_sheets,omitempty"`
+	Mapping    map[string]string `json:"mapping,omitempty"`
+	Duplicates string            `json:"duplicates,omitempty"`
+}
+
+// record fills file fields of task record
+func (f File) record(t *postgresql.Task) error {
+	opts := fileOptions{Header: f.Header, Sheet: f.Sheet, AllSheets: f.AllSheets, Mapping: f.Mapping, Duplicates: f.Duplicates}
+	if f.Delimiter != 0 {
+		opts.Delimiter = string(f.Delimiter)
+	}
+
+	options, err := json.Marshal(opts)
+	if err != nil {
+		return err
+	}
+
+	t.FilePath = f.Path
+	t.FileFormat = f.Format
+	t.FileOptions = string(options)
+	return nil
+}
+
+// fileFromRecord restores File from task record
+func fileFromRecord(t postgresql.Task) (File, error) {
+	f := File{
+		Path:   t.FilePath,
+		Format: t.FileFormat,
+	}
+
+	var opts fileOptions
+	if t.FileOptions != "" {
+		err := json.Unmarshal([]byte(t.FileOptions), &opts)
+		if err != nil {
+			return File{}, fmt.Errorf("decoding file options: %w", err)
+		}
+	}
+
+	f.Header = opts.Header
+	f.Sheet = opts.Sheet
+	f.AllSheets = opts.AllSheets
+	f.Mapping = opts.Mapping
+	f.Duplicates = opts.Duplicates
+	for _, r := range opts.Delimiter {
+		f.Delimiter = r
+		break
+	}
+
+	return f, nil
+}
+
+// rowReader represents source of uploaded file rows
+type rowReader interface {
+	// Next returns next row or io.EOF if there are no more rows
+	Next() (xlsxstream.Row, error)
+	// RowsTotal returns number of rows in file or zero if it is unknown
+	RowsTotal() int64
+	Close() error
+}
+
+// openFile returns rowReader reading file according to its format
+func openFile(f File) (rowReader, error) {
+	var r rowReader
+	var err error
+
+	switch f.Format {
+	case FormatXLSX, "":
+		var options []xlsxstream.Option
+		switch {
+		case f.AllSheets:
+			options = append(options, xlsxstream.WithAllSheets())
+		case f.Sheet != "":
+			options = append(options, xlsxstream.WithSheet(f.Sheet))
+		}
+		r, err = xlsxstream.Open(f.Path, options...)
+	case FormatCSV:
+		r, err = openCSV(f)
+	case FormatNDJSON:
+		// NDJSON fields are matched by names
+		return openNDJSON(f)
+	default:
+		return nil, fmt.Errorf("unsupported file format %q", f.Format)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	mapped, err := newMappedReader(r, f.Mapping)
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+
+	return mapped, nil
+}
@@ -0,0 +1,90 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"mx/internal/storage/postgresql"
+	"strings"
+)
+
+// ErrSkipProduct is returned by ProductHook to make product ignored instead of written to the database
+var ErrSkipProduct = errors.New("product is skipped by hook")
+
+// ProductHook enriches product parsed from uploaded file before it is written to the database,
+// e.g. infers category, converts currency or cleans up name. MerchantID and OfferID must be left unchanged.
+// Any error other than ErrSkipProduct aborts the task.
+type ProductHook interface {
+	Enrich(ctx context.Context, product *postgresql.Product) error
+}
+
+// ProductHookFunc type is an adapter to use ordinary function as ProductHook
+type ProductHookFunc func(ctx context.Context, product *postgresql.Product) error
+
+// Enrich calls f(ctx, product)
+func (f ProductHookFunc) Enrich(ctx context.Context, product *postgresql.Product) error {
+	return f(ctx, product)
+}
+
+// WithProductHooks registers hooks invoked in provided order for every product to be upserted,
+// rows to be deleted are not passed to hooks
+func WithProductHooks(hooks ...ProductHook) SchedulerOption {
+	return func(s *Scheduler) {
+		s.hooks = append(s.hooks, hooks...)
+	}
+}
+
+// NameCleanupHook collapses whitespace sequences in product name into single spaces
+var NameCleanupHook = ProductHookFunc(func(_ context.Context, product *postgresql.Product) error {
+	product.Name = strings.Join(strings.Fields(product.Name), " ")
+	return nil
+})
+
+// hookError wraps error returned by ProductHook
+func hookError(err error) *taskError {
+	return &taskError{code: codeHookFailed, reason: "product enrichment failed", err: err}
+}
+
+// runHooks passes products through hooks and returns products to be upserted and number of skipped ones
+func runHooks(ctx context.Context, hooks []ProductHook, products []postgresql.Product) ([]postgresql.Product, int64, error) {
+	if len(hooks) == 0 {
+		return products, 0, nil
+	}
+
+	enriched := products[:0]
+	var skipped int64
+	for _, p := range products {
+		keep, err := enrich(ctx, hooks, &p)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if !keep {
+			skipped++
+			continue
+		}
+
+		enriched = append(enriched, p)
+	}
+
+	return enriched, skipped, nil
+}
+
+// enrich passes single product through hooks, keep is false if any of hooks skipped it
+func enrich(ctx context.Context, hooks []ProductHook, p *postgresql.Product) (bool, error) {
+	merchantID, offerID := p.MerchantID, p.OfferID
+	for _, h := range hooks {
+		err := h.Enrich(ctx, p)
+		if errors.Is(err, ErrSkipProduct) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if p.MerchantID != merchantID || p.OfferID != offerID {
+		return false, errors.New("hook changed merchant or offer id of product")
+	}
+
+	return true, nil
+}
@@ -0,0 +1,158 @@
+package task
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/rs/xid"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// names of task id formats
+const (
+	IDFormatXID    = "xid"
+	IDFormatUUIDv7 = "uuidv7"
+)
+
+// uuidLength defines length of canonical UUID representation like 01890a5d-ac96-774b-bcce-b302099a8057
+const uuidLength = 36
+
+// TaskID identifies task. It is stored in database and returned to clients in its string form,
+// which is either 20 characters long xid or canonical UUID.
+type TaskID struct {
+	s string
+}
+
+// ParseTaskID parses string form of task id in any of supported formats
+func ParseTaskID(s string) (TaskID, error) {
+	switch len(s) {
+	case 20:
+		id, err := xid.FromString(s)
+		if err != nil {
+			return TaskID{}, err
+		}
+		return TaskID{s: id.String()}, nil
+	case uuidLength:
+		s = strings.ToLower(s)
+		for i, c := range s {
+			switch i {
+			case 8, 13, 18, 23:
+				if c != '-' {
+					return TaskID{}, fmt.Errorf("invalid uuid %q", s)
+				}
+			default:
+				if !strings.ContainsRune("0123456789abcdef", c) {
+					return TaskID{}, fmt.Errorf("invalid uuid %q", s)
+				}
+			}
+		}
+		return TaskID{s: s}, nil
+	default:
+		return TaskID{}, fmt.Errorf("invalid task id %q", s)
+	}
+}
+
+// String returns stable string form of task id
+func (id TaskID) String() string {
+	return id.s
+}
+
+// Time returns creation time encoded in id, both xid and UUIDv7 start with timestamp
+func (id TaskID) Time() time.Time {
+	if len(id.s) == uuidLength {
+		ms, err := strconv.ParseInt(id.s[0:8]+id.s[9:13], 16, 64)
+		if err != nil {
+			return time.Time{}
+		}
+		return time.Unix(0, ms*int64(time.Millisecond))
+	}
+
+	x, err := xid.FromString(id.s)
+	if err != nil {
+		return time.Time{}
+	}
+	return x.Time()
+}
+
+// IsZero reports whether id is zero value which does not identify any task
+func (id TaskID) IsZero() bool {
+	return id.s == ""
+}
+
+// MarshalText encodes task id as its string form
+func (id TaskID) MarshalText() ([]byte, error) {
+	return []byte(id.s), nil
+}
+
+// UnmarshalText decodes task id from its string form
+func (id *TaskID) UnmarshalText(text []byte) error {
+	parsed, err := ParseTaskID(string(text))
+	if err != nil {
+		return err
+	}
+
+	*id = parsed
+	return nil
+}
+
+// IDGenerator generates ids of new tasks
+type IDGenerator interface {
+	NewTaskID() TaskID
+}
+
+// NewIDGenerator returns generator of task ids in format with passed name
+func NewIDGenerator(format string) (IDGenerator, error) {
+	switch format {
+	case IDFormatXID, "":
+		return XIDGenerator{}, nil
+	case IDFormatUUIDv7:
+		return UUIDv7Generator{}, nil
+	default:
+		return nil, fmt.Errorf("unknown task id format %q", format)
+	}
+}
+
+// XIDGenerator generates task ids using github.com/rs/xid
+type XIDGenerator struct{}
+
+// NewTaskID returns new xid based task id
+func (XIDGenerator) NewTaskID() TaskID {
+	return TaskID{s: xid.New().String()}
+}
+
+// UUIDv7Generator generates time-ordered UUID version 7 task ids
+// see https://datatracker.ietf.org/doc/html/draft-peabody-dispatch-new-uuid-format
+type UUIDv7Generator struct{}
+
+// NewTaskID returns new UUIDv7 based task id
+func (UUIDv7Generator) NewTaskID() TaskID {
+	var u [16]byte
+	_, err := rand.Read(u[6:])
+	if err != nil {
+		panic(fmt.Errorf("reading random bytes for uuid: %w", err))
+	}
+
+	// the first 48 bits are unix timestamp in milliseconds
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(time.Now().UnixNano()/int64(time.Millisecond)))
+	copy(u[:6], ts[2:])
+
+	u[6] = u[6]&0x0f | 0x70
+	u[8] = u[8]&0x3f | 0x80
+
+	var b [uuidLength]byte
+	hex.Encode(b[0:8], u[0:4])
+	b[8] = '-'
+	hex.Encode(b[9:13], u[4:6])
+	b[13] = '-'
+	hex.Encode(b[14:18], u[6:8])
+	b[18] = '-'
+	hex.Encode(b[19:23], u[8:10])
+	b[23] = '-'
+	hex.Encode(b[24:], u[10:])
+
+	return TaskID{s: string(b[:])}
+}
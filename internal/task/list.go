@@ -0,0 +1,55 @@
+package task
+
+import (
+	"context"
+)
+
+// ListedTask defines task of task list with its status
+type ListedTask struct {
+	ID         string   `json:"id"`
+	MerchantID int64    `json:"merchant_id"`
+	Labels     []string `json:"labels"`
+	Status
+}
+
+// ListTasks returns at most limit latest tasks having every one of provided labels,
+// merchantID limits tasks to the merchant unless it is zero.
+// Tasks are read from database, but status of tasks kept in memory is taken from memory as it is more recent.
+func (s *Scheduler) ListTasks(ctx context.Context, merchantID int64, labels []string, limit int) ([]ListedTask, error) {
+	records, err := s.db.ListTasks(ctx, merchantID, labels, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := make([]ListedTask, 0, len(records))
+	for _, record := range records {
+		t, err := s.taskFromRecord(record)
+		if err != nil {
+			return nil, err
+		}
+
+		id, err := ParseTaskID(record.ID)
+		if err == nil {
+			s.taskStore.rw.RLock()
+			current, ok := s.taskStore.tasks[id]
+			s.taskStore.rw.RUnlock()
+			if ok {
+				t = current
+			}
+		}
+
+		labels := record.Labels
+		if labels == nil {
+			labels = []string{}
+		}
+
+		tasks = append(tasks, ListedTask{
+			ID:         record.ID,
+			MerchantID: record.MerchantID,
+			Labels:     labels,
+			Status:     t.status(),
+		})
+	}
+
+	return tasks, nil
+}
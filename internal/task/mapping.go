@@ -0,0 +1,169 @@
+package task
+
+import (
+	"fmt"
+	"mx/internal/xlsxstream"
+	"strings"
+)
+
+// headerMatchThreshold defines how many cells of a row have to be column names for the row to be treated as header
+const headerMatchThreshold = 2
+
+// ValidateMapping checks that mapping of columnNames to column letters like "A" or "AB" refers to known fields
+func ValidateMapping(mapping map[string]string) error {
+	for field, letters := range mapping {
+		if columnPosition(field) < 0 {
+			return fmt.Errorf("unknown field %q, expected one of %s", field, strings.Join(columnNames, ", "))
+		}
+
+		_, err := letterIndex(letters)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// mappedReader reorders cells of rows into the order parseRow expects according to explicit mapping
+// or to header row detected in the file
+type mappedReader struct {
+	rowReader
+	// columns maps position expected by parseRow to position in the file, nil means the same order
+	columns  []int
+	explicit bool
+}
+
+// newMappedReader wraps r applying mapping, header rows are detected only if mapping is empty
+func newMappedReader(r rowReader, mapping map[string]string) (*mappedReader, error) {
+	m := &mappedReader{rowReader: r}
+	if len(mapping) == 0 {
+		return m, nil
+	}
+
+	m.explicit = true
+	m.columns = make([]int, len(columnNames))
+	for i := range m.columns {
+		m.columns[i] = i
+	}
+
+	for field, letters := range mapping {
+		position := columnPosition(field)
+		if position < 0 {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+
+		index, err := letterIndex(letters)
+		if err != nil {
+			return nil, err
+		}
+		m.columns[position] = index
+	}
+
+	return m, nil
+}
+
+// Next returns next row with cells reordered. Detected header row is returned as is, so it is counted as ignored one.
+func (m *mappedReader) Next() (xlsxstream.Row, error) {
+	row, err := m.rowReader.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	if !m.explicit && isHeader(row) {
+		m.columns, err = headerColumns(row)
+		if err != nil {
+			return nil, err
+		}
+		return row, nil
+	}
+
+	if m.columns == nil {
+		return row, nil
+	}
+
+	mapped := make(xlsxstream.Row, len(m.columns))
+	for i, position := range m.columns {
+		mapped[i] = row.Cell(position)
+	}
+
+	return mapped, nil
+}
+
+// isHeader reports whether row consists of strings at least headerMatchThreshold of which are column names
+func isHeader(row xlsxstream.Row) bool {
+	var matched int
+	for _, cell := range row {
+		if cell.Value == "" {
+			continue
+		}
+
+		if cell.Type != xlsxstream.CellTypeString {
+			return false
+		}
+
+		if columnPosition(normalizeName(cell.Value)) >= 0 {
+			matched++
+		}
+	}
+
+	return matched >= headerMatchThreshold
+}
+
+// headerColumns maps header names to expected columns ignoring case and unknown columns
+func headerColumns(row xlsxstream.Row) ([]int, error) {
+	positions := make(map[string]int, len(row))
+	for i, cell := range row {
+		positions[normalizeName(cell.Value)] = i
+	}
+
+	columns := make([]int, len(columnNames))
+	var missing []string
+	for i, name := range columnNames {
+		position, ok := positions[name]
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+		columns[i] = position
+	}
+
+	if len(missing) != 0 {
+		return nil, fmt.Errorf("header has no %s columns", strings.Join(missing, ", "))
+	}
+
+	return columns, nil
+}
+
+// normalizeName trims header name and lowers its case, name may start with UTF-8 byte order mark
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\uFEFF")))
+}
+
+// columnPosition returns position of field in columnNames or -1 if there is no such field
+func columnPosition(field string) int {
+	for i, name := range columnNames {
+		if name == field {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// letterIndex returns zero-based index of column letters like "A" or "AB"
+func letterIndex(letters string) (int, error) {
+	if letters == "" || len(letters) > 3 {
+		return 0, fmt.Errorf("invalid column %q", letters)
+	}
+
+	var index int
+	for _, c := range strings.ToUpper(letters) {
+		if c < 'A' || c > 'Z' {
+			return 0, fmt.Errorf("invalid column %q", letters)
+		}
+		index = index*26 + int(c-'A'+1)
+	}
+
+	return index - 1, nil
+}
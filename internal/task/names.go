@@ -0,0 +1,96 @@
+package task
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxNameLength is length of product_name domain, longer names can not be stored whatever NamePolicy is
+const MaxNameLength = 200
+
+// NamePolicy limits length and characters of uploaded product names, rows violating it are ignored
+// and reported with the reason. Control characters are always rejected, the same way CHECK constraint
+// of product_name domain does.
+type NamePolicy struct {
+	// MaxLength is maximum number of characters in name, zero means MaxNameLength
+	MaxLength int `json:"max_length,omitempty"`
+	// RejectEmoji rejects names containing emoji and pictographic symbols
+	RejectEmoji bool `json:"reject_emoji,omitempty"`
+}
+
+// Validate checks MaxLength fits product_name domain
+func (p NamePolicy) Validate() error {
+	if p.MaxLength < 0 || p.MaxLength > MaxNameLength {
+		return fmt.Errorf("maximum name length must be between 0 and %d, got %d", MaxNameLength, p.MaxLength)
+	}
+
+	return nil
+}
+
+// WithNamePolicy applies passed policy to names of uploaded products
+func WithNamePolicy(p NamePolicy) SchedulerOption {
+	return func(s *Scheduler) {
+		s.names = p
+	}
+}
+
+// ValidateName checks name of product created outside uploads against names policy of the scheduler,
+// returned error is safe to be shown to client
+func (s *Scheduler) ValidateName(name string) error {
+	nameErr := s.names.check(name)
+	if nameErr != nil {
+		return nameErr
+	}
+
+	return nil
+}
+
+// check returns rowError describing the first violation of policy by name,
+// position of rejected character is 1-based number of character in name
+func (p NamePolicy) check(name string) *rowError {
+	maxLength := p.MaxLength
+	if maxLength == 0 {
+		maxLength = MaxNameLength
+	}
+
+	length := utf8.RuneCountInString(name)
+	if length > maxLength {
+		return &rowError{reasonNameTooLong, fmt.Sprintf("name is %d characters long, at most %d are allowed", length, maxLength)}
+	}
+
+	position := 0
+	for _, r := range name {
+		position++
+		switch {
+		case r == utf8.RuneError:
+			return &rowError{reasonBadNameChar, fmt.Sprintf("name contains invalid UTF-8 at position %d", position)}
+		case unicode.IsControl(r):
+			return &rowError{reasonBadNameChar, fmt.Sprintf("name contains control character %U at position %d", r, position)}
+		case p.RejectEmoji && isEmoji(r):
+			return &rowError{reasonEmojiInName, fmt.Sprintf("name contains emoji %U at position %d", r, position)}
+		}
+	}
+
+	return nil
+}
+
+// isEmoji reports whether r belongs to blocks of emoji and pictographic symbols or is emoji presentation selector
+func isEmoji(r rune) bool {
+	switch {
+	case r >= 0x1F000 && r <= 0x1FAFF:
+		// mahjong and playing cards, enclosed symbols, pictographs, emoticons, transport and supplemental symbols
+		return true
+	case r >= 0x2600 && r <= 0x27BF:
+		// miscellaneous symbols and dingbats
+		return true
+	case r >= 0x2B00 && r <= 0x2BFF:
+		// arrows and geometric shapes like star and large circles used as emoji
+		return true
+	case r == 0xFE0F || r == 0x200D || r == 0x20E3:
+		// emoji presentation selector, zero width joiner of emoji sequences and keycap
+		return true
+	default:
+		return false
+	}
+}
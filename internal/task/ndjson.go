@@ -0,0 +1,99 @@
+package task
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mx/internal/xlsxstream"
+	"os"
+)
+
+// ndjsonReader reads rows of NDJSON file where every non-blank line is JSON object with product fields
+type ndjsonReader struct {
+	file *os.File
+	r    *bufio.Reader
+}
+
+func openNDJSON(f File) (*ndjsonReader, error) {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ndjsonReader{file: file, r: bufio.NewReader(file)}, nil
+}
+
+// Next returns fields of next object as row of cells ordered like columnNames.
+// Line which is not valid object is returned as row of empty cells, so it is ignored like any other invalid row.
+func (n *ndjsonReader) Next() (xlsxstream.Row, error) {
+	for {
+		line, err := n.r.ReadBytes('\n')
+		if err != nil && !(errors.Is(err, io.EOF) && len(line) != 0) {
+			return nil, err
+		}
+
+		// file may start with UTF-8 byte order mark
+		line = bytes.TrimSpace(bytes.TrimPrefix(line, []byte("\uFEFF")))
+		if len(line) == 0 {
+			continue
+		}
+
+		return ndjsonRow(line), nil
+	}
+}
+
+// ndjsonRow converts object fields into cells keeping raw representation of numbers
+func ndjsonRow(line []byte) xlsxstream.Row {
+	row := make(xlsxstream.Row, len(columnNames))
+
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(line, &fields)
+	if err != nil {
+		return row
+	}
+
+	for i, name := range columnNames {
+		raw, ok := fields[name]
+		if !ok {
+			continue
+		}
+
+		var value interface{}
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		err = dec.Decode(&value)
+		if err != nil {
+			continue
+		}
+
+		switch v := value.(type) {
+		case json.Number:
+			row[i] = xlsxstream.Cell{Value: v.String(), Type: xlsxstream.CellTypeNumeric}
+		case string:
+			row[i] = xlsxstream.Cell{Value: v, Type: xlsxstream.CellTypeString}
+		case bool:
+			cell := xlsxstream.Cell{Value: "0", Type: xlsxstream.CellTypeBool}
+			if v {
+				cell.Value = "1"
+			}
+			row[i] = cell
+		default:
+			// null, arrays and nested objects are not valid values of any field
+			row[i] = xlsxstream.Cell{Type: xlsxstream.CellTypeError}
+		}
+	}
+
+	return row
+}
+
+// RowsTotal returns zero since NDJSON file can not be measured without reading it
+func (n *ndjsonReader) RowsTotal() int64 {
+	return 0
+}
+
+// Close closes underlying file
+func (n *ndjsonReader) Close() error {
+	return n.file.Close()
+}
@@ -0,0 +1,52 @@
+package task
+
+import (
+	"go.uber.org/zap"
+	"time"
+)
+
+// retryPending periodically saves Pending tasks to database and starts their processing until Close is called
+func (s *Scheduler) retryPending() {
+	defer close(s.pendingDone)
+
+	ticker := time.NewTicker(pendingRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopPending:
+			return
+		case <-ticker.C:
+			s.savePendingTasks()
+		}
+	}
+}
+
+// savePendingTasks saves Pending tasks in upload order stopping at first failure,
+// since database is most likely still unavailable
+func (s *Scheduler) savePendingTasks() {
+	s.pendingRW.Lock()
+	defer s.pendingRW.Unlock()
+
+	for len(s.pendingJobs) != 0 {
+		j := s.pendingJobs[0]
+		logger := s.logger.With(zap.String("ID", j.id.String()))
+
+		s.taskStore.rw.RLock()
+		t := s.taskStore.tasks[j.id]
+		s.taskStore.rw.RUnlock()
+
+		t.state = Processing
+
+		err := s.createTaskRecord(j, t)
+		if err != nil {
+			logger.Warn("Saving pending task to database", zap.Int("pending", len(s.pendingJobs)), zap.Error(err))
+			return
+		}
+
+		s.pendingJobs = s.pendingJobs[1:]
+
+		logger.Info("Pending task is saved to database")
+		s.enqueue(logger, j, t)
+	}
+}
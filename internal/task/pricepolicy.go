@@ -0,0 +1,111 @@
+package task
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	"strings"
+)
+
+// MaxPriceScale is scale of product_price domain, prices with more decimal places can not be stored as is
+const MaxPriceScale = 2
+
+// maxPriceIntegerDigits is number of integer digits product_price domain allows
+const maxPriceIntegerDigits = 12
+
+// modes of rounding prices having more decimal places than scale of PricePolicy
+const (
+	// PriceRoundingReject ignores rows with such prices reporting them, it is the default mode
+	PriceRoundingReject = "reject"
+	// PriceRoundingHalfUp rounds half away from zero the way PostgreSQL numeric does
+	PriceRoundingHalfUp = "half-up"
+	// PriceRoundingHalfEven rounds half to the nearest even digit
+	PriceRoundingHalfEven = "half-even"
+	// PriceRoundingDown drops extra decimal places
+	PriceRoundingDown = "down"
+)
+
+var priceRoundings = []string{PriceRoundingReject, PriceRoundingHalfUp, PriceRoundingHalfEven, PriceRoundingDown}
+
+// priceLimit is the least price product_price domain can not store
+var priceLimit = decimal.New(1, maxPriceIntegerDigits)
+
+// PricePolicy defines decimal places uploaded prices are kept with, so prices are never rounded by database silently
+type PricePolicy struct {
+	// Scale is number of decimal places of prices, at most MaxPriceScale
+	Scale int32 `json:"scale"`
+	// Rounding is one of PriceRounding constants, empty means PriceRoundingReject
+	Rounding string `json:"rounding,omitempty"`
+}
+
+// DefaultPricePolicy keeps whole scale of product_price domain and rejects prices not fitting it
+var DefaultPricePolicy = PricePolicy{Scale: MaxPriceScale, Rounding: PriceRoundingReject}
+
+// Validate checks Scale fits product_price domain and Rounding is known
+func (p PricePolicy) Validate() error {
+	if p.Scale < 0 || p.Scale > MaxPriceScale {
+		return fmt.Errorf("price scale must be between 0 and %d, got %d", MaxPriceScale, p.Scale)
+	}
+
+	if p.Rounding == "" {
+		return nil
+	}
+
+	for _, r := range priceRoundings {
+		if p.Rounding == r {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("price rounding must be one of %s, got %q", strings.Join(priceRoundings, ", "), p.Rounding)
+}
+
+// WithPricePolicy applies passed policy to prices of uploaded products
+func WithPricePolicy(p PricePolicy) SchedulerOption {
+	return func(s *Scheduler) {
+		s.pricePolicy = p
+	}
+}
+
+// NormalizePrice applies price policy of the scheduler to price of product created outside uploads,
+// returned error is safe to be shown to client
+func (s *Scheduler) NormalizePrice(price decimal.Decimal) (decimal.Decimal, error) {
+	price, priceErr := s.pricePolicy.apply(price)
+	if priceErr != nil {
+		return decimal.Decimal{}, priceErr
+	}
+
+	return price, nil
+}
+
+// apply returns positive price rounded to policy scale or rowError describing why it can not be stored
+func (p PricePolicy) apply(price decimal.Decimal) (decimal.Decimal, *rowError) {
+	if !price.Equal(price.Truncate(p.Scale)) {
+		if p.Rounding == "" || p.Rounding == PriceRoundingReject {
+			return decimal.Decimal{}, &rowError{reasonPriceScale, fmt.Sprintf("price %s has more than %d decimal places", price, p.Scale)}
+		}
+		price = p.round(price)
+	}
+
+	if !price.IsPositive() {
+		return decimal.Decimal{}, errInvalidPrice
+	}
+
+	if price.Cmp(priceLimit) >= 0 {
+		return decimal.Decimal{}, &rowError{reasonPriceTooLarge, fmt.Sprintf("price must be less than %s", priceLimit)}
+	}
+
+	return price, nil
+}
+
+// round rounds price to policy scale, PriceRoundingReject rounds half up as prices converted into base currency
+// have to be rounded anyway
+func (p PricePolicy) round(price decimal.Decimal) decimal.Decimal {
+	switch p.Rounding {
+	case PriceRoundingHalfEven:
+		return price.RoundBank(p.Scale)
+	case PriceRoundingDown:
+		return price.Truncate(p.Scale)
+	default:
+		return price.Round(p.Scale)
+	}
+}
@@ -0,0 +1,69 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"github.com/shopspring/decimal"
+	"mx/internal/storage/postgresql"
+)
+
+// PriceConverter converts uploaded prices into base currency, so prices of different merchants are comparable
+type PriceConverter interface {
+	BaseCurrency() string
+	Convert(ctx context.Context, amount decimal.Decimal, from string) (decimal.Decimal, error)
+}
+
+// WithPriceConverter makes tasks with currency set convert prices into base currency of c
+// keeping uploaded ones as original price and currency
+func WithPriceConverter(c PriceConverter) SchedulerOption {
+	return func(s *Scheduler) {
+		s.prices = c
+	}
+}
+
+// importSettings restores ImportSettings from task record
+func importSettings(record postgresql.Task) ImportSettings {
+	settings := ImportSettings{
+		Mode:          record.ImportMode,
+		UpdateColumns: record.UpdateColumns,
+		Currency:      record.Currency,
+		Labels:        record.Labels,
+	}
+
+	if record.Deadline != nil {
+		settings.Deadline = *record.Deadline
+	}
+
+	return settings
+}
+
+// conversionError wraps error returned by PriceConverter
+func conversionError(err error) *taskError {
+	return &taskError{code: codeConversion, reason: "prices can not be converted into base currency", err: err}
+}
+
+// normalizePrices converts prices of products from provided currency into base one rounding them to policy scale
+func normalizePrices(ctx context.Context, prices PriceConverter, policy PricePolicy, currency string, products []postgresql.Product) error {
+	if prices == nil {
+		return errors.New("currency conversion is not configured")
+	}
+
+	for i := range products {
+		original := products[i].Price
+		converted, err := prices.Convert(ctx, original, currency)
+		if err != nil {
+			return err
+		}
+
+		converted = policy.round(converted)
+		if !converted.IsPositive() {
+			return errors.New("price " + original.String() + " " + currency + " is too small to be converted")
+		}
+
+		products[i].Price = converted
+		products[i].OriginalPrice = &original
+		products[i].OriginalCurrency = currency
+	}
+
+	return nil
+}
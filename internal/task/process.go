@@ -0,0 +1,392 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"io"
+	"mx/internal/storage"
+	"mx/internal/storage/postgresql"
+	"mx/internal/xlsxstream"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// columns order expected in uploaded workbook
+const (
+	offerIDColumn = iota
+	nameColumn
+	priceColumn
+	quantityColumn
+	availableColumn
+)
+
+// batch defines consecutive rows of uploaded workbook split by the way they should be applied to the database
+type batch struct {
+	ToUpsert []postgresql.Product `json:"to_upsert"`
+	ToDelete []int64              `json:"to_delete"`
+	Ignored  int64                `json:"ignored"`
+	// Duplicates is number of rows dropped or overridden by other rows with the same offer_id
+	Duplicates int64 `json:"duplicates"`
+	// Rejected contains numbers of ignored and duplicate rows and reasons they were ignored
+	Rejected []postgresql.RejectedRow `json:"rejected"`
+	// Reasons counts rejected rows and products skipped by hooks per reason code
+	Reasons map[string]int64 `json:"reasons,omitempty"`
+	// End is number of workbook rows read including the batch ones
+	End int64 `json:"end"`
+}
+
+// rows returns number of workbook rows in the batch
+func (b batch) rows() int64 {
+	return int64(len(b.ToUpsert)+len(b.ToDelete)) + b.Ignored + b.Duplicates
+}
+
+// job defines parameters of single task processing run
+type job struct {
+	id         TaskID
+	merchantID int64
+	file       File
+	settings   ImportSettings
+	// idempotencyKey is key of upload request which created the task, may be empty
+	idempotencyKey string
+	// checkpoint is number of parsed rows already applied to the database by previous runs
+	checkpoint int64
+	// applied contains stats of rows applied by previous runs
+	applied dataPayload
+	// preview makes the run only record changes of the task without applying them, see WithApproval
+	preview bool
+}
+
+// ImportSettings defines the way rows of the task are applied to existing catalog
+type ImportSettings struct {
+	// Mode is either postgresql.ImportModeUpsert or postgresql.ImportModeInsertOnly, empty means upsert
+	Mode string
+	// UpdateColumns limits columns of existing products set by upsert, empty means all of postgresql.UpdatableColumns
+	UpdateColumns []string
+	// Currency is currency of uploaded prices, they are converted into base currency if set
+	Currency string
+	// Deadline is time by which task has to be finished, task is not started or is timed out once it passes.
+	// Zero value means there is no deadline besides task timeout.
+	Deadline time.Time
+	// Labels are free-form client tags of the task, they do not affect import
+	Labels []string
+}
+
+// applyFunc represents function applying batch of parsed rows to the database
+type applyFunc func(b batch) error
+
+// parseFunc represents function reading uploaded file row by row skipping first skip rows.
+// Parsed rows are passed to apply in batches of batchSize rows, non-positive batchSize means single batch.
+// Parsing progress is expected to be sent to report periodically.
+type parseFunc func(ctx context.Context, file File, merchantID int64, skip, batchSize int64, report progressFunc, apply applyFunc) error
+
+// trueProcessTask parses uploaded file via provided parse function and applies its rows to the database.
+// Rows marked as available are upserted, rows marked as unavailable are deleted
+// and rows that can not be parsed are ignored.
+//
+// Rows are applied while file is being read in transactions of chunkSize rows each saving task checkpoint,
+// so interrupted task can be resumed skipping rows applied before. Non-positive chunkSize means single transaction,
+// which requires the whole file to be parsed before it is applied.
+//
+// Progress is sent to report, result is sent through resultCh, any error is sent through abortCh.
+func trueProcessTask(ctx context.Context, logger *zap.Logger, resultCh chan<- taskResult, abortCh chan<- error, db storage.ProductStore, parse parseFunc, prices PriceConverter, hooks []ProductHook, report progressFunc, j job, chunkSize int64) {
+	abort := func(err error) {
+		select {
+		case abortCh <- err:
+		case <-ctx.Done():
+		}
+	}
+
+	if j.checkpoint > 0 {
+		logger.Info("Resuming task from checkpoint", zap.Int64("checkpoint", j.checkpoint))
+	}
+
+	// last reported progress is kept, so database phases are reported with number of rows read so far
+	var current progress
+	trackingReport := func(p progress) {
+		current = p
+		report(p)
+	}
+
+	stats := j.applied
+	var applyErr, conversionErr, hookErr error
+	apply := func(b batch) error {
+		if j.settings.Currency != "" {
+			err := normalizePrices(ctx, prices, j.file.Prices, j.settings.Currency, b.ToUpsert)
+			if err != nil {
+				conversionErr = err
+				return err
+			}
+		}
+
+		toUpsert, hookSkipped, err := runHooks(ctx, hooks, b.ToUpsert)
+		if err != nil {
+			hookErr = err
+			return err
+		}
+		b.ToUpsert = toUpsert
+		b.Ignored += hookSkipped
+		if hookSkipped > 0 {
+			if b.Reasons == nil {
+				b.Reasons = make(map[string]int64)
+			}
+			b.Reasons[reasonSkippedByHook] += hookSkipped
+		}
+
+		onPhase := func(phase string) {
+			report(progress{Phase: phase, RowsParsed: b.End, RowsTotal: current.RowsTotal})
+		}
+
+		options := []postgresql.ImportOption{
+			postgresql.WithPhaseCallback(onPhase),
+			postgresql.WithCheckpoint(j.id.String(), b.End, b.Ignored, b.Duplicates),
+			postgresql.WithRejectedRows(b.Rejected),
+			postgresql.WithReasonCounts(b.Reasons),
+		}
+		if j.settings.Mode == postgresql.ImportModeInsertOnly {
+			options = append(options, postgresql.WithInsertOnly())
+		}
+		if len(j.settings.UpdateColumns) != 0 {
+			options = append(options, postgresql.WithUpdateColumns(j.settings.UpdateColumns...))
+		}
+		if j.preview {
+			options = append(options, postgresql.WithPreview())
+		}
+
+		res, err := db.UpsertAndDelete(ctx, b.ToUpsert, j.merchantID, b.ToDelete, options...)
+		if err != nil {
+			applyErr = err
+			return err
+		}
+
+		stats.added += res.Added
+		stats.updated += res.Updated
+		stats.removed += res.Removed
+		stats.ignored += b.Ignored
+		stats.skipped += res.Skipped
+		stats.duplicates += b.Duplicates
+		stats.addReasons(b.Reasons)
+
+		report(progress{Phase: phaseParsing, RowsParsed: b.End, RowsTotal: current.RowsTotal})
+		return nil
+	}
+
+	logger.Info("Processing file", zap.String("path", j.file.Path), zap.String("format", j.file.Format), zap.String("mode", j.settings.Mode), zap.Strings("update_columns", j.settings.UpdateColumns), zap.Bool("preview", j.preview))
+	trackingReport(progress{Phase: phaseParsing})
+	err := parse(ctx, j.file, j.merchantID, j.checkpoint, chunkSize, trackingReport, apply)
+	if err != nil {
+		if conversionErr != nil {
+			logger.Error("Converting prices", zap.Error(conversionErr))
+			abort(conversionError(conversionErr))
+			return
+		}
+
+		if hookErr != nil {
+			logger.Error("Enriching products", zap.Error(hookErr))
+			abort(hookError(hookErr))
+			return
+		}
+
+		if applyErr != nil {
+			logger.Error("Applying workbook to database", zap.Error(applyErr))
+			abort(storageError(applyErr))
+			return
+		}
+
+		logger.Error("Parsing workbook", zap.Error(err))
+		abort(parseError(err))
+		return
+	}
+
+	logger.Info("Workbook is processed",
+		zap.Int64("added", stats.added),
+		zap.Int64("updated", stats.updated),
+		zap.Int64("removed", stats.removed),
+		zap.Int64("ignored", stats.ignored),
+		zap.Int64("skipped", stats.skipped),
+		zap.Int64("duplicates", stats.duplicates),
+	)
+
+	result := taskResult{
+		data:  stats,
+		error: nil,
+	}
+
+	select {
+	case resultCh <- result:
+	case <-ctx.Done():
+	}
+}
+
+// parseFile streams rows of uploaded file in current process
+func parseFile(ctx context.Context, file File, merchantID int64, skip, batchSize int64, report progressFunc, apply applyFunc) error {
+	reader, err := openFile(file)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	current := progress{
+		Phase:     phaseParsing,
+		RowsTotal: reader.RowsTotal(),
+	}
+
+	bb := newBatchBuilder(file.Duplicates)
+	for {
+		row, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		current.RowsParsed++
+		if current.RowsParsed%progressReportInterval == 0 {
+			report(current)
+		}
+
+		product, available, rowErr := parseRow(row, file.Names, file.Prices)
+
+		// rows applied by previous runs are only remembered to detect their duplicates
+		if current.RowsParsed <= skip {
+			if rowErr == nil {
+				bb.remember(current.RowsParsed, product.OfferID)
+			}
+			continue
+		}
+
+		if rowErr != nil {
+			bb.ignore(current.RowsParsed, rowErr)
+		} else {
+			product.MerchantID = merchantID
+			err = bb.add(current.RowsParsed, product, available)
+			if err != nil {
+				return err
+			}
+		}
+
+		if batchSize > 0 && bb.b.rows() == batchSize {
+			err = apply(bb.flush(current.RowsParsed))
+			if err != nil {
+				return err
+			}
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	report(current)
+
+	if bb.b.rows() != 0 {
+		return apply(bb.flush(current.RowsParsed))
+	}
+
+	return nil
+}
+
+// codes of reasons rows are ignored or dropped for, they are stable identifiers reported with rejected rows
+// and counted per task
+const (
+	reasonBadOfferID      = "BAD_OFFER_ID"
+	reasonBadAvailability = "BAD_AVAILABILITY"
+	reasonEmptyName       = "EMPTY_NAME"
+	reasonNameTooLong     = "NAME_TOO_LONG"
+	reasonBadNameChar     = "BAD_NAME_CHARACTER"
+	reasonEmojiInName     = "EMOJI_IN_NAME"
+	reasonBadPrice        = "BAD_PRICE"
+	reasonPriceScale      = "PRICE_OUT_OF_SCALE"
+	reasonPriceTooLarge   = "PRICE_TOO_LARGE"
+	reasonBadQuantity     = "BAD_QUANTITY"
+	reasonDuplicate       = "DUPLICATE_IN_FILE"
+	reasonSkippedByHook   = "SKIPPED_BY_HOOK"
+)
+
+// rowError describes why row is ignored, code is one of reason codes and message is shown to client as is
+type rowError struct {
+	code    string
+	message string
+}
+
+func (e *rowError) Error() string {
+	return e.message
+}
+
+// reasons of rows being ignored reported by parseRow
+var (
+	errInvalidOfferID      = &rowError{reasonBadOfferID, "offer_id must be positive integer"}
+	errInvalidAvailability = &rowError{reasonBadAvailability, "available must be either true or false"}
+	errBlankName           = &rowError{reasonEmptyName, "name must not be blank"}
+	errInvalidPrice        = &rowError{reasonBadPrice, "price must be positive number"}
+	errInvalidQuantity     = &rowError{reasonBadQuantity, "quantity must be positive integer"}
+)
+
+// parseRow converts workbook row into Product and its availability checking name against names policy
+// and applying prices policy to price, rowError describes the first cell containing invalid value
+func parseRow(row xlsxstream.Row, names NamePolicy, prices PricePolicy) (postgresql.Product, bool, *rowError) {
+	offerID, err := row.Cell(offerIDColumn).Int64()
+	if err != nil || offerID <= 0 {
+		return postgresql.Product{}, false, errInvalidOfferID
+	}
+
+	available, ok := parseAvailability(row.Cell(availableColumn))
+	if !ok {
+		return postgresql.Product{}, false, errInvalidAvailability
+	}
+
+	// name, price and quantity are irrelevant for rows to be deleted
+	if !available {
+		return postgresql.Product{OfferID: offerID}, false, nil
+	}
+
+	name := strings.TrimSpace(row.Cell(nameColumn).String())
+	if name == "" {
+		return postgresql.Product{}, false, errBlankName
+	}
+
+	nameErr := names.check(name)
+	if nameErr != nil {
+		return postgresql.Product{}, false, nameErr
+	}
+
+	price, err := row.Cell(priceColumn).Decimal()
+	if err != nil || !price.IsPositive() {
+		return postgresql.Product{}, false, errInvalidPrice
+	}
+
+	price, priceErr := prices.apply(price)
+	if priceErr != nil {
+		return postgresql.Product{}, false, priceErr
+	}
+
+	quantity, err := row.Cell(quantityColumn).Int64()
+	if err != nil || quantity <= 0 {
+		return postgresql.Product{}, false, errInvalidQuantity
+	}
+
+	return postgresql.Product{
+		OfferID:   offerID,
+		Name:      name,
+		Price:     price,
+		Quantity:  quantity,
+		Available: true,
+	}, true, nil
+}
+
+// parseAvailability reads boolean cell value accepting both boolean typed cells
+// and string representations like "true" or "false"
+func parseAvailability(cell xlsxstream.Cell) (bool, bool) {
+	if cell.Type == xlsxstream.CellTypeBool {
+		return cell.Bool(), true
+	}
+
+	available, err := strconv.ParseBool(strings.TrimSpace(cell.String()))
+	if err != nil {
+		return false, false
+	}
+
+	return available, true
+}
@@ -0,0 +1,32 @@
+package task
+
+import "mx/internal/storage/postgresql"
+
+// phases of task processing reported through progress
+const (
+	phaseParsing   = "parsing"
+	phaseUpserting = postgresql.PhaseUpserting
+	phaseDeleting  = postgresql.PhaseDeleting
+)
+
+// progressReportInterval defines number of parsed rows between progress reports
+const progressReportInterval = 1000
+
+// progress defines current phase of task processing and number of parsed rows
+type progress struct {
+	Phase      string `json:"phase"`
+	RowsParsed int64  `json:"rows_parsed"`
+	RowsTotal  int64  `json:"rows_total"`
+}
+
+// progressFunc represents function receiving progress reports while task is processed
+type progressFunc func(p progress)
+
+// percent returns share of parsed rows in percents
+func (p progress) percent() float64 {
+	if p.RowsTotal == 0 {
+		return 0
+	}
+
+	return float64(p.RowsParsed) / float64(p.RowsTotal) * 100
+}
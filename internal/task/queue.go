@@ -0,0 +1,120 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"mx/internal/storage/postgresql"
+	"time"
+)
+
+// pollQueue claims tasks from shared queue while there are free processing slots until Close is called
+func (s *Scheduler) pollQueue() {
+	defer close(s.pollDone)
+
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		// draining instance finishes its tasks only, queued ones are left to other instances
+		if s.Draining() {
+			select {
+			case <-ticker.C:
+				continue
+			case <-s.stopPoll:
+				return
+			}
+		}
+
+		select {
+		case s.slots <- struct{}{}:
+		case <-s.stopPoll:
+			return
+		}
+
+		j, ok := s.claimTask()
+		if ok {
+			go s.processClaimed(j)
+			continue
+		}
+
+		<-s.slots
+
+		select {
+		case <-ticker.C:
+		case <-s.stopPoll:
+			return
+		}
+	}
+}
+
+// claimTask takes next task from shared queue and saves it to memory,
+// false is returned if queue is empty or claim has failed
+func (s *Scheduler) claimTask() (job, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.dbTimeout)
+	defer cancel()
+
+	// task can not run longer than taskTimeout, so claim which is much older belongs to instance that died
+	record, err := s.db.ClaimTask(ctx, s.instanceID, time.Now().Add(-2*s.taskTimeout))
+	if err != nil {
+		if !errors.Is(err, postgresql.ErrQueueEmpty) {
+			s.logger.Error("Claiming task from shared queue", zap.Error(err))
+		}
+		return job{}, false
+	}
+
+	id, err := ParseTaskID(record.ID)
+	if err != nil {
+		s.logger.Error("Parsing claimed task id", zap.String("ID", record.ID), zap.Error(err))
+		return job{}, false
+	}
+
+	file, err := fileFromRecord(record)
+	if err != nil {
+		s.logger.Error("Restoring uploaded file settings", zap.String("ID", record.ID), zap.Error(err))
+		return job{}, false
+	}
+
+	applied := dataPayload{
+		added:      record.Added,
+		updated:    record.Updated,
+		removed:    record.Removed,
+		ignored:    record.Ignored,
+		skipped:    record.Skipped,
+		duplicates: record.Duplicates,
+		reasons:    record.ReasonCounts,
+	}
+
+	s.taskStore.rw.Lock()
+	s.taskStore.tasks[id] = task{
+		merchantID: record.MerchantID,
+		state:      Processing,
+		result: taskResult{
+			data:  applied,
+			error: nil,
+		},
+		startedAt: record.CreatedAt,
+	}
+	s.taskStore.rw.Unlock()
+
+	return job{
+		id:         id,
+		merchantID: record.MerchantID,
+		file:       file,
+		settings:   importSettings(record),
+		checkpoint: record.Checkpoint,
+		applied:    applied,
+	}, true
+}
+
+// processClaimed processes task taken by pollQueue which has already occupied processing slot for it
+func (s *Scheduler) processClaimed(j job) {
+	logger := s.logger.With(zap.String("ID", j.id.String()))
+	logger.Info("Task is claimed from shared queue", zap.Int64("checkpoint", j.checkpoint))
+
+	cancelCh, stopCh := s.registerChannels(j.id)
+	defer s.unregisterChannels(j.id)
+	defer func() { <-s.slots }()
+
+	s.process(context.Background(), logger, j, cancelCh, stopCh)
+}
@@ -3,94 +3,506 @@ package task
 import (
 	"context"
 	"errors"
-	"github.com/rs/xid"
 	"go.uber.org/zap"
+	"mx/internal/logctx"
+	"mx/internal/metrics"
+	"mx/internal/storage"
 	"mx/internal/storage/postgresql"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	// defaultMaxConcurrentTasks defines number of tasks processed simultaneously if WithMaxConcurrentTasks is not provided
+	defaultMaxConcurrentTasks = 4
+	// defaultTaskTTL defines how long finished task is kept in memory if WithTaskTTL is not provided
+	defaultTaskTTL = time.Hour
+	// pendingRetryInterval defines how often saving of Pending tasks to database is retried
+	pendingRetryInterval = 5 * time.Second
+	// defaultIdempotencyWindow defines how long idempotency key of upload is remembered if WithIdempotencyWindow is not provided
+	defaultIdempotencyWindow = 24 * time.Hour
+)
+
 var (
 	ErrCanNotCancel = errors.New("task can not be canceled due to its current state")
 	ErrBadTaskID    = errors.New("no such task")
+	// ErrTaskNotFound and ErrTaskExpired are returned by ReadTaskStatus for well-formed id of missing task,
+	// they tell task which never existed from task which record was archived or removed after retention period
+	ErrTaskNotFound = errors.New("task never existed")
+	ErrTaskExpired  = errors.New("task has expired")
 )
 
 type store struct {
 	rw    sync.RWMutex
-	tasks map[xid.ID]task
+	tasks map[TaskID]task
 }
 
 type cancelChannels struct {
 	rw             sync.Mutex
-	cancelChannels map[xid.ID]chan struct{}
-	stopChannels   map[xid.ID]chan struct{}
+	cancelChannels map[TaskID]chan struct{}
+	stopChannels   map[TaskID]chan struct{}
+	// abortFuncs cancel contexts of tasks being processed, see ForceAbortTask
+	abortFuncs map[TaskID]context.CancelFunc
 }
 
 type Scheduler struct {
 	logger         *zap.Logger
 	taskTimeout    time.Duration
+	dbTimeout      time.Duration
 	taskStore      *store
 	cancelChannels *cancelChannels
 	db             *postgresql.Storage
+	// products stores catalog rows of uploaded files are applied to, see WithProductStore
+	products storage.ProductStore
+	parse    parseFunc
+	// blobs stores uploaded files, see WithBlobStore
+	blobs storage.Blob
+	// prices converts uploaded prices into base currency, see WithPriceConverter
+	prices PriceConverter
+	// hooks enrich parsed products before they are written to the database, see WithProductHooks
+	hooks []ProductHook
+	// names limits length and characters of uploaded product names, see WithNamePolicy
+	names NamePolicy
+	// pricePolicy defines decimal places of uploaded prices, see WithPricePolicy
+	pricePolicy PricePolicy
+	// slots bounds number of tasks processed simultaneously, tasks waiting for a free slot are queued
+	slots              chan struct{}
+	maxConcurrentTasks int
+	queueLength        int64
+	// taskTTL defines how long finished tasks are kept in memory before being evicted by sweeper
+	taskTTL   time.Duration
+	stopSweep chan struct{}
+	sweepDone chan struct{}
+	// chunkSize defines number of rows applied to the database within single transaction,
+	// zero means whole file is applied at once
+	chunkSize int64
+	// instanceID is set if tasks are taken from queue shared by several instances, see WithSharedQueue
+	instanceID   string
+	pollInterval time.Duration
+	stopPoll     chan struct{}
+	pollDone     chan struct{}
+	// idempotencyWindow defines how long repeated upload with the same idempotency key refers to the original task
+	idempotencyWindow time.Duration
+	// taskRetention defines how long task records are kept in database, zero means it is unknown, see WithTaskRetention
+	taskRetention time.Duration
+	// pendingJobs contains tasks in Pending state in upload order
+	pendingRW   sync.Mutex
+	pendingJobs []job
+	stopPending chan struct{}
+	pendingDone chan struct{}
+	// approval makes tasks changing at least approvalThreshold offers wait for approval, see WithApproval
+	approval          bool
+	approvalThreshold int64
+	// draining is set by Drain and makes instance stop claiming tasks from shared queue
+	draining int32
+}
+
+// SchedulerOption type represents function to modify Scheduler struct
+type SchedulerOption func(s *Scheduler)
+
+// WithParseWorker makes Scheduler parse uploaded files in separate worker process
+// started by provided command. Worker process is expected to call ServeParseWorker.
+func WithParseWorker(command string, args ...string) SchedulerOption {
+	return func(s *Scheduler) {
+		s.parse = workerParse(command, args...)
+	}
+}
+
+// WithMaxConcurrentTasks limits number of tasks processed simultaneously,
+// other tasks wait in queue for a free slot
+func WithMaxConcurrentTasks(n int) SchedulerOption {
+	return func(s *Scheduler) {
+		s.maxConcurrentTasks = n
+	}
+}
+
+// WithTaskTTL applies passed ttl as time finished task is kept in memory,
+// evicted tasks are still available from database
+func WithTaskTTL(ttl time.Duration) SchedulerOption {
+	return func(s *Scheduler) {
+		s.taskTTL = ttl
+	}
+}
+
+// WithChunkSize makes Scheduler apply uploaded files in transactions of n rows each
+// saving checkpoint after every transaction, so interrupted task can be resumed
+func WithChunkSize(n int64) SchedulerOption {
+	return func(s *Scheduler) {
+		s.chunkSize = n
+	}
+}
+
+// WithSharedQueue makes Scheduler take tasks from database queue shared by all instances
+// instead of processing tasks uploaded to this instance only. Queue is polled every interval
+// and instanceID is saved in claimed task records. Uploaded files have to be available to every instance.
+func WithSharedQueue(instanceID string, interval time.Duration) SchedulerOption {
+	return func(s *Scheduler) {
+		s.instanceID = instanceID
+		s.pollInterval = interval
+	}
 }
 
-func NewScheduler(logger *zap.Logger, db *postgresql.Storage) (*Scheduler, error) {
+// WithIdempotencyWindow applies passed window as time idempotency key of upload is remembered for
+func WithIdempotencyWindow(window time.Duration) SchedulerOption {
+	return func(s *Scheduler) {
+		s.idempotencyWindow = window
+	}
+}
+
+// WithTaskRetention applies passed period as time task records are kept in database, so status of missing task
+// created earlier is reported expired rather than never existed
+func WithTaskRetention(d time.Duration) SchedulerOption {
+	return func(s *Scheduler) {
+		s.taskRetention = d
+	}
+}
+
+// WithApproval makes tasks preview their changes and wait in PendingApproval state until they are approved
+// or rejected, tasks changing fewer than threshold offers are applied without approval
+func WithApproval(threshold int64) SchedulerOption {
+	return func(s *Scheduler) {
+		s.approval = true
+		s.approvalThreshold = threshold
+	}
+}
+
+// WithProductStore makes Scheduler apply uploaded files to provided catalog storage instead of the database,
+// task records are still kept in the database
+func WithProductStore(products storage.ProductStore) SchedulerOption {
+	return func(s *Scheduler) {
+		s.products = products
+	}
+}
+
+func NewScheduler(logger *zap.Logger, db *postgresql.Storage, options ...SchedulerOption) (*Scheduler, error) {
 	if logger == nil {
 		return nil, errors.New("no logger provided")
 	}
 
 	taskStore := &store{
 		rw:    sync.RWMutex{},
-		tasks: make(map[xid.ID]task),
+		tasks: make(map[TaskID]task),
 	}
 
 	cancelChannels := &cancelChannels{
 		rw:             sync.Mutex{},
-		cancelChannels: make(map[xid.ID]chan struct{}),
-		stopChannels:   make(map[xid.ID]chan struct{}),
+		cancelChannels: make(map[TaskID]chan struct{}),
+		stopChannels:   make(map[TaskID]chan struct{}),
+		abortFuncs:     make(map[TaskID]context.CancelFunc),
 	}
 
 	scheduler := &Scheduler{
-		logger:         logger,
-		taskTimeout:    20 * time.Second,
-		taskStore:      taskStore,
-		cancelChannels: cancelChannels,
-		db:             db,
+		logger:             logger,
+		taskTimeout:        20 * time.Second,
+		dbTimeout:          5 * time.Second,
+		taskStore:          taskStore,
+		cancelChannels:     cancelChannels,
+		db:                 db,
+		products:           db,
+		parse:              parseFile,
+		blobs:              storage.NewLocalBlob(""),
+		maxConcurrentTasks: defaultMaxConcurrentTasks,
+		taskTTL:            defaultTaskTTL,
+		idempotencyWindow:  defaultIdempotencyWindow,
+		pricePolicy:        DefaultPricePolicy,
+		stopSweep:          make(chan struct{}),
+		sweepDone:          make(chan struct{}),
+		stopPending:        make(chan struct{}),
+		pendingDone:        make(chan struct{}),
+	}
+
+	for _, opt := range options {
+		opt(scheduler)
+	}
+	scheduler.parse = fetchingParse(scheduler.blobs, scheduler.parse)
+
+	if scheduler.maxConcurrentTasks <= 0 {
+		return nil, errors.New("max concurrent tasks must be positive")
+	}
+	scheduler.slots = make(chan struct{}, scheduler.maxConcurrentTasks)
+
+	if scheduler.taskTTL <= 0 {
+		return nil, errors.New("task ttl must be positive")
+	}
+
+	if scheduler.idempotencyWindow <= 0 {
+		return nil, errors.New("idempotency window must be positive")
+	}
+
+	if scheduler.approvalThreshold < 0 {
+		return nil, errors.New("approval threshold can not be negative")
+	}
+
+	err := scheduler.pricePolicy.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	go scheduler.sweep()
+	go scheduler.retryPending()
+
+	if scheduler.instanceID != "" {
+		if scheduler.pollInterval <= 0 {
+			return nil, errors.New("queue poll interval must be positive")
+		}
+
+		scheduler.stopPoll = make(chan struct{})
+		scheduler.pollDone = make(chan struct{})
+		go scheduler.pollQueue()
 	}
 
 	return scheduler, nil
 }
 
-func (s *Scheduler) NewTask(taskID xid.ID, merchantID int64, filePath string) {
+// Close stops background sweeper of finished tasks, retries of pending tasks and shared queue polling
+func (s *Scheduler) Close() {
+	if s.instanceID != "" {
+		s.logger.Info("Stopping queue polling")
+		close(s.stopPoll)
+		<-s.pollDone
+	}
+
+	s.logger.Info("Stopping pending tasks retries")
+	close(s.stopPending)
+	<-s.pendingDone
+
+	s.logger.Info("Stopping task sweeper")
+	close(s.stopSweep)
+	<-s.sweepDone
+}
+
+// NewTask creates task processing uploaded file with provided import settings, idempotencyKey may be empty.
+// Task which can not be saved to unavailable database is kept pending, so error is returned only if task
+// record can not be built at all.
+func (s *Scheduler) NewTask(taskID TaskID, merchantID int64, file File, settings ImportSettings, idempotencyKey string) error {
 	logger := s.logger.With(zap.String("ID", taskID.String()))
 	logger.Info("Creating new task")
 
 	t := task{
-		state: Processing,
+		merchantID: merchantID,
+		state:      Processing,
 		result: taskResult{
 			data: dataPayload{
-				added:   0,
-				updated: 0,
-				removed: 0,
-				ignored: 0,
+				added:      0,
+				updated:    0,
+				removed:    0,
+				ignored:    0,
+				skipped:    0,
+				duplicates: 0,
 			},
 			error: nil,
 		},
+		startedAt: time.Now(),
+	}
+
+	logger.Info("Saving task state to database")
+
+	j := job{
+		id:             taskID,
+		merchantID:     merchantID,
+		file:           file,
+		settings:       settings,
+		idempotencyKey: idempotencyKey,
+	}
+
+	record, err := taskRecord(j, t)
+	if err != nil {
+		logger.Error("Building task record", zap.Error(err))
+		return err
+	}
+
+	err = s.saveTaskRecord(record)
+	if err != nil {
+		// uploaded file is already saved, so task is kept until database is available again
+		logger.Error("Saving task state to database, task is pending", zap.Error(err))
+		t.state = Pending
+
+		s.taskStore.rw.Lock()
+		s.taskStore.tasks[taskID] = t
+		s.taskStore.rw.Unlock()
+
+		s.pendingRW.Lock()
+		s.pendingJobs = append(s.pendingJobs, j)
+		s.pendingRW.Unlock()
+		return nil
+	}
+
+	s.enqueue(logger, j, t)
+	return nil
+}
+
+// enqueue starts processing of task saved to database. Task added to shared queue
+// is processed by whichever instance claims it, so it is not kept in memory.
+func (s *Scheduler) enqueue(logger *zap.Logger, j job, t task) {
+	if s.instanceID != "" {
+		s.taskStore.rw.Lock()
+		delete(s.taskStore.tasks, j.id)
+		s.taskStore.rw.Unlock()
+
+		logger.Info("Task is added to shared queue")
+		return
 	}
 
 	logger.Info("Saving task state to memory")
 
 	s.taskStore.rw.Lock()
-	s.taskStore.tasks[taskID] = t
+	s.taskStore.tasks[j.id] = t
 	s.taskStore.rw.Unlock()
 
-	go s.schedule(context.Background(), logger, taskID, merchantID, filePath)
+	go s.schedule(context.Background(), logger, j)
+}
+
+// createTaskRecord saves new task to database
+func (s *Scheduler) createTaskRecord(j job, t task) error {
+	record, err := taskRecord(j, t)
+	if err != nil {
+		return err
+	}
+
+	return s.saveTaskRecord(record)
+}
+
+// taskRecord builds database record of new task
+func taskRecord(j job, t task) (postgresql.Task, error) {
+	record := postgresql.Task{
+		ID:             j.id.String(),
+		MerchantID:     j.merchantID,
+		State:          t.state.String(),
+		CreatedAt:      t.startedAt,
+		IdempotencyKey: j.idempotencyKey,
+		ImportMode:     j.settings.Mode,
+		UpdateColumns:  j.settings.UpdateColumns,
+		Currency:       j.settings.Currency,
+		Labels:         j.settings.Labels,
+	}
+
+	if !j.settings.Deadline.IsZero() {
+		deadline := j.settings.Deadline
+		record.Deadline = &deadline
+	}
+
+	err := j.file.record(&record)
+	if err != nil {
+		return postgresql.Task{}, err
+	}
+
+	return record, nil
+}
+
+// saveTaskRecord inserts task record to database
+func (s *Scheduler) saveTaskRecord(record postgresql.Task) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.dbTimeout)
+	defer cancel()
+
+	return s.db.CreateTask(ctx, record)
+}
+
+// FindIdempotentTask returns id of task created by earlier upload of the merchant with the same idempotency key
+// within idempotency window. False is returned if there is no such task.
+func (s *Scheduler) FindIdempotentTask(ctx context.Context, merchantID int64, key string) (TaskID, bool, error) {
+	// pending tasks are not in database yet
+	s.pendingRW.Lock()
+	for _, j := range s.pendingJobs {
+		if j.merchantID == merchantID && j.idempotencyKey == key {
+			s.pendingRW.Unlock()
+			return j.id, true, nil
+		}
+	}
+	s.pendingRW.Unlock()
+
+	record, err := s.db.FindTaskByIdempotencyKey(ctx, merchantID, key, time.Now().Add(-s.idempotencyWindow))
+	if err != nil {
+		if errors.Is(err, postgresql.ErrTaskNotFound) {
+			return TaskID{}, false, nil
+		}
+
+		return TaskID{}, false, err
+	}
+
+	id, err := ParseTaskID(record.ID)
+	if err != nil {
+		return TaskID{}, false, err
+	}
+
+	return id, true, nil
 }
 
-func (s *Scheduler) ReadTaskStatus(stringID string) (string, error) {
-	id, err := xid.FromString(stringID)
+// Resume schedules tasks which were being processed when previous process stopped.
+// Tasks continue from their last checkpoint.
+//
+// With shared queue it does nothing, since unfinished tasks are claimed again by any instance
+// once their lease expires.
+func (s *Scheduler) Resume(ctx context.Context) error {
+	if s.instanceID != "" {
+		return nil
+	}
+
+	records, err := s.db.UnfinishedTasks(ctx)
 	if err != nil {
-		return "", ErrBadTaskID
+		return err
+	}
+
+	for _, record := range records {
+		id, err := ParseTaskID(record.ID)
+		if err != nil {
+			s.logger.Error("Parsing unfinished task id", zap.String("ID", record.ID), zap.Error(err))
+			continue
+		}
+
+		logger := s.logger.With(zap.String("ID", record.ID))
+		logger.Info("Resuming task", zap.Int64("checkpoint", record.Checkpoint))
+
+		applied := dataPayload{
+			added:      record.Added,
+			updated:    record.Updated,
+			removed:    record.Removed,
+			ignored:    record.Ignored,
+			skipped:    record.Skipped,
+			duplicates: record.Duplicates,
+			reasons:    record.ReasonCounts,
+		}
+
+		s.taskStore.rw.Lock()
+		s.taskStore.tasks[id] = task{
+			merchantID: record.MerchantID,
+			state:      Processing,
+			result: taskResult{
+				data:  applied,
+				error: nil,
+			},
+			startedAt: record.CreatedAt,
+		}
+		s.taskStore.rw.Unlock()
+
+		file, err := fileFromRecord(record)
+		if err != nil {
+			logger.Error("Restoring uploaded file settings", zap.Error(err))
+			continue
+		}
+
+		j := job{
+			id:         id,
+			merchantID: record.MerchantID,
+			file:       file,
+			settings:   importSettings(record),
+			checkpoint: record.Checkpoint,
+			applied:    applied,
+		}
+
+		go s.schedule(context.Background(), logger, j)
+	}
+
+	return nil
+}
+
+// ReadTaskStatus returns task state and its result stats.
+// Task is looked up in memory first and in database if memory has no such task,
+// e.g. after restart.
+func (s *Scheduler) ReadTaskStatus(ctx context.Context, stringID string) (Status, error) {
+	id, err := ParseTaskID(stringID)
+	if err != nil {
+		return Status{}, ErrBadTaskID
 	}
 
 	s.taskStore.rw.RLock()
@@ -98,18 +510,103 @@ func (s *Scheduler) ReadTaskStatus(stringID string) (string, error) {
 	s.taskStore.rw.RUnlock()
 
 	if !ok {
-		return "", ErrBadTaskID
+		task, err = s.readTask(ctx, id)
+		if errors.Is(err, ErrBadTaskID) {
+			return Status{}, s.missingTaskError(ctx, id)
+		}
+		if err != nil {
+			return Status{}, err
+		}
+	}
+
+	status := task.status()
+	status.QueueLength = atomic.LoadInt64(&s.queueLength)
+	if s.instanceID != "" {
+		status.QueueLength, err = s.db.CountPendingTasks(ctx)
+		if err != nil {
+			return Status{}, err
+		}
+	}
+
+	return status, nil
+}
+
+// missingTaskError tells whether task which is neither in memory nor in database has expired or never existed.
+// Task is expired if its record is archived or if its id is older than retention period.
+func (s *Scheduler) missingTaskError(ctx context.Context, id TaskID) error {
+	archived, err := s.db.TaskArchived(ctx, id.String())
+	if err != nil {
+		return err
+	}
+
+	createdAt := id.Time()
+	if archived || (s.taskRetention > 0 && createdAt.Before(time.Now().Add(-s.taskRetention))) {
+		return ErrTaskExpired
+	}
+
+	return ErrTaskNotFound
+}
+
+// ReadTaskChunks returns stats of committed chunks of the task
+func (s *Scheduler) ReadTaskChunks(ctx context.Context, stringID string) ([]postgresql.TaskChunk, error) {
+	id, err := ParseTaskID(stringID)
+	if err != nil {
+		return nil, ErrBadTaskID
+	}
+
+	chunks, err := s.db.TaskChunks(ctx, id.String())
+	if err != nil {
+		if errors.Is(err, postgresql.ErrTaskNotFound) {
+			return nil, ErrBadTaskID
+		}
+
+		return nil, err
+	}
+
+	return chunks, nil
+}
+
+// ReadTaskReport returns rows of the task ignored as invalid with reasons
+func (s *Scheduler) ReadTaskReport(ctx context.Context, stringID string) ([]postgresql.RejectedRow, error) {
+	id, err := ParseTaskID(stringID)
+	if err != nil {
+		return nil, ErrBadTaskID
+	}
+
+	report, err := s.db.TaskReport(ctx, id.String())
+	if err != nil {
+		if errors.Is(err, postgresql.ErrTaskNotFound) {
+			return nil, ErrBadTaskID
+		}
+
+		return nil, err
 	}
 
-	if task.state == Done {
-		return task.state.String() + " " + task.result.data.String(), nil
+	return report, nil
+}
+
+// ReadTaskFile returns uploaded file of the task saved to the database
+func (s *Scheduler) ReadTaskFile(ctx context.Context, stringID string) (File, error) {
+	id, err := ParseTaskID(stringID)
+	if err != nil {
+		return File{}, ErrBadTaskID
 	}
 
-	return task.state.String(), nil
+	record, err := s.db.ReadTask(ctx, id.String())
+	if err != nil {
+		if errors.Is(err, postgresql.ErrTaskNotFound) {
+			return File{}, ErrBadTaskID
+		}
+
+		return File{}, err
+	}
+
+	return fileFromRecord(record)
 }
 
+// CancelTask cancels task processed or queued by this instance
 func (s *Scheduler) CancelTask(stringID string) error {
-	id, err := xid.FromString(stringID)
+	id, err := ParseTaskID(stringID)
 	if err != nil {
 		return ErrBadTaskID
 	}
@@ -126,31 +623,59 @@ func (s *Scheduler) CancelTask(stringID string) error {
 		return ErrCanNotCancel
 	}
 
-	s.cancelChannels.rw.Lock()
-	//select {
-	//case <-s.cancelChannels.stopChannels[id]:
-	//	err = ErrCanNotCancel
-	//default:
-	//	s.cancelChannels.cancelChannels[id] <- struct{}{}
-	//}
-	// TODO: test if next two lines can lead to concurrent writing to close channel
-	s.cancelChannels.cancelChannels[id] <- struct{}{}
-	close(s.cancelChannels.cancelChannels[id])
-	s.cancelChannels.rw.Unlock()
+	if !s.signalCancel([]TaskID{id})[id] {
+		return ErrCanNotCancel
+	}
 
-	return err
+	return nil
 }
 
 // schedule prepares and starts goroutines that process task
 // only this function is responsible for changing task state
 // signals for such updates come through cancelChannels
-func (s *Scheduler) schedule(ctx context.Context, logger *zap.Logger, id xid.ID, merchantID int64, filePath string) {
-	logger.Info("Scheduling task")
-	ctx, cancel := context.WithTimeout(ctx, s.taskTimeout)
-	defer cancel()
+func (s *Scheduler) schedule(ctx context.Context, logger *zap.Logger, j job) {
+	id := j.id
+	cancelCh, stopCh := s.registerChannels(id)
+	defer s.unregisterChannels(id)
+
+	logger.Info("Queueing task")
+	atomic.AddInt64(&s.queueLength, 1)
+
+	// nil channel never fires, so task without deadline waits for a slot or cancellation only
+	var deadlineCh <-chan time.Time
+	if !j.settings.Deadline.IsZero() {
+		timer := time.NewTimer(time.Until(j.settings.Deadline))
+		defer timer.Stop()
+		deadlineCh = timer.C
+	}
 
-	resultCh := make(chan taskResult)
-	abortCh := make(chan struct{})
+	select {
+	case s.slots <- struct{}{}:
+		atomic.AddInt64(&s.queueLength, -1)
+
+	// queued task can be canceled before its processing started
+	case <-cancelCh:
+		atomic.AddInt64(&s.queueLength, -1)
+		logger.Info("Task is canceled while queued")
+		close(stopCh)
+		s.updateTaskState(id, Canceled)
+		return
+
+	// task which can not be finished by its deadline is not started
+	case <-deadlineCh:
+		atomic.AddInt64(&s.queueLength, -1)
+		logger.Info("Task deadline has passed while queued")
+		close(stopCh)
+		s.updateTaskState(id, TimedOut)
+		return
+	}
+	defer func() { <-s.slots }()
+
+	s.process(ctx, logger, j, cancelCh, stopCh)
+}
+
+// registerChannels creates channels used to cancel task and to signal its processing is stopped
+func (s *Scheduler) registerChannels(id TaskID) (chan struct{}, chan struct{}) {
 	cancelCh := make(chan struct{})
 	stopCh := make(chan struct{})
 
@@ -159,7 +684,69 @@ func (s *Scheduler) schedule(ctx context.Context, logger *zap.Logger, id xid.ID,
 	s.cancelChannels.stopChannels[id] = stopCh
 	s.cancelChannels.rw.Unlock()
 
-	go trueProcessTask(ctx, logger, resultCh, abortCh, s.db, merchantID, filePath)
+	return cancelCh, stopCh
+}
+
+func (s *Scheduler) unregisterChannels(id TaskID) {
+	s.cancelChannels.rw.Lock()
+	delete(s.cancelChannels.cancelChannels, id)
+	delete(s.cancelChannels.stopChannels, id)
+	delete(s.cancelChannels.abortFuncs, id)
+	s.cancelChannels.rw.Unlock()
+}
+
+// process runs task which already holds processing slot and waits for its outcome
+func (s *Scheduler) process(ctx context.Context, logger *zap.Logger, j job, cancelCh, stopCh chan struct{}) {
+	id := j.id
+	resultCh := make(chan taskResult)
+	abortCh := make(chan error)
+
+	// deadline may pass while slot is taken or before task is claimed from shared queue
+	deadline := j.settings.Deadline
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		logger.Info("Task deadline has passed, task is not started", zap.Time("deadline", deadline))
+		s.updateTaskState(id, TimedOut)
+		return
+	}
+
+	s.markTaskDequeued(id)
+
+	// task resumed from checkpoint has already been applied partially, so it is not previewed
+	if s.approval && j.checkpoint == 0 {
+		preview, err := s.requiresPreview(id)
+		if err != nil {
+			logger.Error("Checking task approval", zap.Error(err))
+			s.abortTask(id, storageError(err))
+			return
+		}
+		j.preview = preview
+	}
+
+	logger.Info("Scheduling task")
+	ctx, cancel := context.WithTimeout(ctx, s.taskTimeout)
+	defer cancel()
+
+	// task is timed out at its deadline if it comes before task timeout
+	if !deadline.IsZero() {
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	s.cancelChannels.rw.Lock()
+	s.cancelChannels.abortFuncs[id] = cancel
+	s.cancelChannels.rw.Unlock()
+
+	report := func(p progress) {
+		s.updateTaskProgress(id, p)
+	}
+
+	// storage logs of the task are correlated by its id
+	ctx = logctx.NewContext(ctx, logger)
+
+	// policy is passed with the file, so worker processes apply it as well
+	j.file.Names = s.names
+	j.file.Prices = s.pricePolicy
+	go trueProcessTask(ctx, logger, resultCh, abortCh, s.products, s.parse, s.prices, s.hooks, report, j, s.chunkSize)
 
 	select {
 	// processing timing out
@@ -170,38 +757,195 @@ func (s *Scheduler) schedule(ctx context.Context, logger *zap.Logger, id xid.ID,
 	// processing cancellation
 	case <-cancelCh:
 		logger.Info("Task is canceled")
-		// any schedule goroutine is the only sender for this channel
-		// while any http-request calling CancelTask is a receiver
+		// cancelCh is closed by signalCancel, stopCh tells processing goroutine to stop
 		close(stopCh)
 		s.updateTaskState(id, Canceled)
 
 	// processing "in-task" error
-	case <-abortCh:
-		logger.Info("Task is aborted")
-		s.updateTaskState(id, Aborted)
+	case err := <-abortCh:
+		logger.Info("Task is aborted", zap.Error(err))
+		s.abortTask(id, err)
 
 	// processing successful finishing
 	case result := <-resultCh:
+		if j.preview {
+			logger.Info("Task is previewed")
+			s.finishPreview(logger, j, result, cancelCh, stopCh)
+			return
+		}
+
 		logger.Info("Task is done")
-		s.taskStore.rw.Lock()
-		t := s.taskStore.tasks[id]
-		t.state = Done
-		t.result = result
-		s.taskStore.tasks[id] = t
-		s.taskStore.rw.Unlock()
-		s.updateTaskState(id, Done)
+		s.saveTaskResult(id, result)
 	}
 
-	s.cancelChannels.rw.Lock()
-	delete(s.cancelChannels.cancelChannels, id)
-	delete(s.cancelChannels.stopChannels, id)
-	s.cancelChannels.rw.Unlock()
+	// chunks committed before task was finished change the catalog whatever the final state is,
+	// preview changes nothing
+	if !j.preview {
+		s.refreshCatalogStats()
+	}
+}
+
+// refreshCatalogStats recomputes catalog stats after import, failure only leaves stats outdated until next import
+func (s *Scheduler) refreshCatalogStats() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.dbTimeout)
+	defer cancel()
+
+	err := s.db.RefreshCatalogStats(ctx)
+	if err != nil {
+		s.logger.Error("Refreshing catalog stats", zap.Error(err))
+	}
+}
+
+// updateTaskProgress saves latest progress report of task
+func (s *Scheduler) updateTaskProgress(id TaskID, p progress) {
+	s.taskStore.rw.Lock()
+	t := s.taskStore.tasks[id]
+	t.progress = p
+	s.taskStore.tasks[id] = t
+	s.taskStore.rw.Unlock()
+}
+
+// markTaskDequeued saves time when task left the queue and its processing started
+func (s *Scheduler) markTaskDequeued(id TaskID) {
+	s.taskStore.rw.Lock()
+	t := s.taskStore.tasks[id]
+	t.dequeuedAt = time.Now()
+	s.taskStore.tasks[id] = t
+	s.taskStore.rw.Unlock()
 }
 
-func (s *Scheduler) updateTaskState(id xid.ID, state taskState) {
+func (s *Scheduler) updateTaskState(id TaskID, state taskState) {
 	s.taskStore.rw.Lock()
 	t := s.taskStore.tasks[id]
+	// force aborted task is already finished
+	if !t.finishedAt.IsZero() {
+		s.taskStore.rw.Unlock()
+		return
+	}
 	t.state = state
+	t.finishedAt = time.Now()
+	s.taskStore.tasks[id] = t
+	s.taskStore.rw.Unlock()
+
+	if state == TimedOut {
+		metrics.AddMerchant(metrics.FailedTasksByMerchant, t.merchantID, 1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.dbTimeout)
+	defer cancel()
+
+	err := s.db.FinishTask(ctx, id.String(), state.String(), t.finishedAt, "", "")
+	if err != nil {
+		s.logger.Error("Saving task state to database", zap.String("ID", id.String()), zap.Error(err))
+	}
+}
+
+// abortTask sets Aborted state and saves error which caused it
+func (s *Scheduler) abortTask(id TaskID, taskErr error) {
+	s.taskStore.rw.Lock()
+	t := s.taskStore.tasks[id]
+	if !t.finishedAt.IsZero() {
+		s.taskStore.rw.Unlock()
+		return
+	}
+	t.state = Aborted
+	t.result.error = taskErr
+	t.finishedAt = time.Now()
 	s.taskStore.tasks[id] = t
 	s.taskStore.rw.Unlock()
+
+	metrics.AddMerchant(metrics.FailedTasksByMerchant, t.merchantID, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.dbTimeout)
+	defer cancel()
+
+	code, reason := errorDetails(taskErr)
+	err := s.db.FinishTask(ctx, id.String(), Aborted.String(), t.finishedAt, code, reason)
+	if err != nil {
+		s.logger.Error("Saving task state to database", zap.String("ID", id.String()), zap.Error(err))
+	}
+}
+
+func (s *Scheduler) saveTaskResult(id TaskID, result taskResult) {
+	s.taskStore.rw.Lock()
+	t := s.taskStore.tasks[id]
+	if !t.finishedAt.IsZero() {
+		s.taskStore.rw.Unlock()
+		return
+	}
+	t.state = Done
+	t.result = result
+	t.finishedAt = time.Now()
+	s.taskStore.tasks[id] = t
+	s.taskStore.rw.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.dbTimeout)
+	defer cancel()
+
+	data := result.data
+	err := s.db.SaveTaskResult(ctx, id.String(), Done.String(), data.added, data.updated, data.removed, data.ignored, data.skipped, data.duplicates, t.finishedAt)
+	if err != nil {
+		s.logger.Error("Saving task result to database", zap.String("ID", id.String()), zap.Error(err))
+	}
+}
+
+// readTask restores task from its database record
+func (s *Scheduler) readTask(ctx context.Context, id TaskID) (task, error) {
+	record, err := s.db.ReadTask(ctx, id.String())
+	if err != nil {
+		if errors.Is(err, postgresql.ErrTaskNotFound) {
+			return task{}, ErrBadTaskID
+		}
+
+		return task{}, err
+	}
+
+	return s.taskFromRecord(record)
+}
+
+// taskFromRecord converts database record into task
+func (s *Scheduler) taskFromRecord(record postgresql.Task) (task, error) {
+	state, err := parseTaskState(record.State)
+	if err != nil {
+		return task{}, err
+	}
+
+	t := task{
+		merchantID: record.MerchantID,
+		state:      state,
+		result: taskResult{
+			data: dataPayload{
+				added:      record.Added,
+				updated:    record.Updated,
+				removed:    record.Removed,
+				ignored:    record.Ignored,
+				skipped:    record.Skipped,
+				duplicates: record.Duplicates,
+				reasons:    record.ReasonCounts,
+			},
+			error: nil,
+		},
+		startedAt: record.CreatedAt,
+		// local queue is not persisted, so restored task is never considered queued
+		dequeuedAt: record.CreatedAt,
+	}
+
+	if s.instanceID != "" {
+		// task waits in shared queue until it is claimed
+		t.dequeuedAt = time.Time{}
+		if record.ClaimedAt != nil {
+			t.dequeuedAt = *record.ClaimedAt
+		}
+	}
+
+	if record.FinishedAt != nil {
+		t.finishedAt = *record.FinishedAt
+	}
+
+	// only client-safe details are persisted, so the original error can not be restored
+	if record.ErrorCode != "" {
+		t.result.error = &taskError{code: record.ErrorCode, reason: record.ErrorReason}
+	}
+
+	return t, nil
 }
@@ -0,0 +1,96 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"mx/internal/storage/postgresql"
+	"time"
+)
+
+// sweep periodically evicts finished tasks older than taskTTL from memory until Close is called
+func (s *Scheduler) sweep() {
+	defer close(s.sweepDone)
+
+	// tasks are checked several times per ttl, so none of them outlives it significantly
+	ticker := time.NewTicker(s.taskTTL / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopSweep:
+			return
+		case <-ticker.C:
+			s.evictFinishedTasks(time.Now().Add(-s.taskTTL))
+		}
+	}
+}
+
+// evictFinishedTasks removes tasks finished before provided moment from memory.
+// Every task is saved to database right before eviction, so the task is not lost
+// even if saving its state during processing has failed.
+func (s *Scheduler) evictFinishedTasks(before time.Time) {
+	s.taskStore.rw.RLock()
+	expired := make(map[TaskID]task)
+	for id, t := range s.taskStore.tasks {
+		if !t.finishedAt.IsZero() && t.finishedAt.Before(before) {
+			expired[id] = t
+		}
+	}
+	s.taskStore.rw.RUnlock()
+
+	var evicted int
+	for id, t := range expired {
+		err := s.archiveTask(id, t)
+		if err != nil {
+			s.logger.Error("Archiving finished task", zap.String("ID", id.String()), zap.Error(err))
+			continue
+		}
+
+		s.taskStore.rw.Lock()
+		delete(s.taskStore.tasks, id)
+		s.taskStore.rw.Unlock()
+		evicted++
+	}
+
+	if evicted != 0 {
+		s.logger.Info("Finished tasks are evicted from memory", zap.Int("count", evicted))
+	}
+}
+
+// archiveTask writes final task state to database creating task record if it is missing
+func (s *Scheduler) archiveTask(id TaskID, t task) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.dbTimeout)
+	defer cancel()
+
+	err := s.finishTaskRecord(ctx, id, t)
+	if !errors.Is(err, postgresql.ErrTaskNotFound) {
+		return err
+	}
+
+	err = s.db.CreateTask(ctx, postgresql.Task{
+		ID:         id.String(),
+		MerchantID: t.merchantID,
+		State:      t.state.String(),
+		CreatedAt:  t.startedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.finishTaskRecord(ctx, id, t)
+}
+
+func (s *Scheduler) finishTaskRecord(ctx context.Context, id TaskID, t task) error {
+	if t.state == Done {
+		data := t.result.data
+		return s.db.SaveTaskResult(ctx, id.String(), t.state.String(), data.added, data.updated, data.removed, data.ignored, data.skipped, data.duplicates, t.finishedAt)
+	}
+
+	var code, reason string
+	if t.state == Aborted {
+		code, reason = errorDetails(t.result.error)
+	}
+
+	return s.db.FinishTask(ctx, id.String(), t.state.String(), t.finishedAt, code, reason)
+}
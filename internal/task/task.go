@@ -2,11 +2,13 @@ package task
 
 import (
 	"fmt"
+	"time"
 )
 
 // taskState defines helper type to describe different task states
 // one should probably think of state guarantees taking into account situations
 // when updating task state in some database may result in an error
+//
 //go:generate stringer -type=taskState
 type taskState int
 
@@ -21,21 +23,61 @@ const (
 	Canceled
 	// Aborted defines task state when it was implicitly canceled by error while processing e.g. some IO operation
 	Aborted
+	// Pending defines task state when uploaded file is saved but task could not be saved to database,
+	// task is saved and processed as soon as database is available again
+	Pending
+	// PendingApproval defines task state when its changes are previewed and it waits for approval to be applied,
+	// see WithApproval
+	PendingApproval
+	// Rejected defines task state when its preview was rejected, so it was never applied
+	Rejected
 )
 
-// dataPayload defines lines that were added, updated, removed and ignored respectively during .xlsx file processing
+// parseTaskState returns taskState corresponding to its string representation
+func parseTaskState(s string) (taskState, error) {
+	for state := Processing; state <= Rejected; state++ {
+		if state.String() == s {
+			return state, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown task state %q", s)
+}
+
+// dataPayload defines lines that were added, updated, removed and ignored respectively during .xlsx file processing,
+// skipped lines match existing offers and left them unchanged, duplicates lines have the same offer_id as other ones
 type dataPayload struct {
-	added, updated, removed, ignored int64
+	added, updated, removed, ignored, skipped, duplicates int64
+	// reasons counts rejected rows and products skipped by hooks per reason code
+	reasons map[string]int64
+}
+
+// addReasons adds counts of reason codes to the payload, the map is copied, so payloads never share it
+func (d *dataPayload) addReasons(counts map[string]int64) {
+	if len(counts) == 0 {
+		return
+	}
+
+	reasons := make(map[string]int64, len(d.reasons)+len(counts))
+	for code, n := range d.reasons {
+		reasons[code] = n
+	}
+	for code, n := range counts {
+		reasons[code] += n
+	}
+	d.reasons = reasons
 }
 
 // String returns string representation of dataPayload struct
 func (d dataPayload) String() string {
 	result := fmt.Sprintf(
-		"Added: %d, Updated: %d, Removed: %d, Ignored: %d",
+		"Added: %d, Updated: %d, Removed: %d, Ignored: %d, Skipped: %d, Duplicates: %d",
 		d.added,
 		d.updated,
 		d.removed,
 		d.ignored,
+		d.skipped,
+		d.duplicates,
 	)
 
 	return result
@@ -49,7 +91,84 @@ type taskResult struct {
 }
 
 // task defines fields used for general task processing including its state and result
+// dequeuedAt is zero while task waits in queue for a free processing slot
+// finishedAt is zero until task reaches any state other than Processing, Pending or PendingApproval
 type task struct {
-	state  taskState
-	result taskResult
+	merchantID int64
+	state      taskState
+	result     taskResult
+	startedAt  time.Time
+	dequeuedAt time.Time
+	finishedAt time.Time
+	progress   progress
+}
+
+// Status defines machine-readable representation of task state and result stats
+type Status struct {
+	State      string `json:"state"`
+	Added      int64  `json:"added"`
+	Updated    int64  `json:"updated"`
+	Removed    int64  `json:"removed"`
+	Ignored    int64  `json:"ignored"`
+	Skipped    int64  `json:"skipped"`
+	Duplicates int64  `json:"duplicates"`
+	// ReasonCounts maps reason code, e.g. BAD_PRICE, to number of rows rejected and products skipped by hooks for it
+	ReasonCounts map[string]int64 `json:"reason_counts,omitempty"`
+	StartedAt    time.Time        `json:"started_at"`
+	FinishedAt   *time.Time       `json:"finished_at"`
+	// Error and ErrorCode describe why task was aborted
+	Error     string `json:"error,omitempty"`
+	ErrorCode string `json:"error_code,omitempty"`
+	// Queued is true while task waits for a free processing slot
+	Queued bool `json:"queued"`
+	// WaitTimeMS is time in milliseconds task spent (or is spending) in queue
+	WaitTimeMS int64 `json:"wait_time_ms"`
+	// QueueLength is number of tasks currently waiting in queue
+	QueueLength int64 `json:"queue_length"`
+	// Phase, RowsParsed, RowsTotal and Percent describe progress of task being processed
+	Phase      string  `json:"phase,omitempty"`
+	RowsParsed int64   `json:"rows_parsed"`
+	RowsTotal  int64   `json:"rows_total"`
+	Percent    float64 `json:"percent"`
+}
+
+// status builds Status from task
+func (t task) status() Status {
+	status := Status{
+		State:        t.state.String(),
+		Added:        t.result.data.added,
+		Updated:      t.result.data.updated,
+		Removed:      t.result.data.removed,
+		Ignored:      t.result.data.ignored,
+		Skipped:      t.result.data.skipped,
+		Duplicates:   t.result.data.duplicates,
+		ReasonCounts: t.result.data.reasons,
+		StartedAt:    t.startedAt,
+	}
+
+	if t.state == Processing {
+		status.Phase = t.progress.Phase
+		status.RowsParsed = t.progress.RowsParsed
+		status.RowsTotal = t.progress.RowsTotal
+		status.Percent = t.progress.percent()
+	}
+
+	switch {
+	case !t.dequeuedAt.IsZero():
+		status.WaitTimeMS = t.dequeuedAt.Sub(t.startedAt).Milliseconds()
+	case t.state == Processing:
+		status.Queued = true
+		status.WaitTimeMS = time.Since(t.startedAt).Milliseconds()
+	}
+
+	if !t.finishedAt.IsZero() {
+		finishedAt := t.finishedAt
+		status.FinishedAt = &finishedAt
+	}
+
+	if t.state == Aborted {
+		status.ErrorCode, status.Error = errorDetails(t.result.error)
+	}
+
+	return status
 }
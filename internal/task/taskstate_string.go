@@ -13,11 +13,14 @@ func _() {
 	_ = x[TimedOut-2]
 	_ = x[Canceled-3]
 	_ = x[Aborted-4]
+	_ = x[Pending-5]
+	_ = x[PendingApproval-6]
+	_ = x[Rejected-7]
 }
 
-const _taskState_name = "ProcessingDoneTimedOutCanceledAborted"
+const _taskState_name = "ProcessingDoneTimedOutCanceledAbortedPendingPendingApprovalRejected"
 
-var _taskState_index = [...]uint8{0, 10, 14, 22, 30, 37}
+var _taskState_index = [...]uint8{0, 10, 14, 22, 30, 37, 44, 59, 67}
 
 func (i taskState) String() string {
 	if i < 0 || i >= taskState(len(_taskState_index)-1) {
@@ -0,0 +1,185 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+)
+
+// workerError is returned when worker process itself failed rather than reported file parsing error
+type workerError struct {
+	err error
+}
+
+func (e *workerError) Error() string {
+	return e.err.Error()
+}
+
+func (e *workerError) Unwrap() error {
+	return e.err
+}
+
+// workerRequest defines payload written to parse worker stdin
+type workerRequest struct {
+	File       File  `json:"file"`
+	MerchantID int64 `json:"merchant_id"`
+	Skip       int64 `json:"skip"`
+	BatchSize  int64 `json:"batch_size"`
+}
+
+// workerResponse defines payload read from parse worker stdout.
+// Worker writes any number of responses with either Progress or Batch field set
+// followed by single final response with Done or Error field set.
+// Worker blocks on writing next batch until previous one is applied, so batches do not pile up in memory.
+type workerResponse struct {
+	Progress *progress `json:"progress,omitempty"`
+	Batch    *batch    `json:"batch,omitempty"`
+	Done     bool      `json:"done,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	// Duplicate is set together with Error if file is rejected due to duplicate offer
+	Duplicate *duplicateOfferError `json:"duplicate,omitempty"`
+}
+
+// ServeParseWorker reads single workerRequest from r, parses requested file
+// and writes workerResponse messages to w. It is meant to be called by worker executable
+// spawned with WithParseWorker option.
+func ServeParseWorker(r io.Reader, w io.Writer) error {
+	var req workerRequest
+	err := json.NewDecoder(r).Decode(&req)
+	if err != nil {
+		return fmt.Errorf("decoding worker request: %w", err)
+	}
+
+	enc := json.NewEncoder(w)
+	var encErr error
+	report := func(p progress) {
+		if encErr == nil {
+			encErr = enc.Encode(workerResponse{Progress: &p})
+		}
+	}
+
+	apply := func(b batch) error {
+		err := enc.Encode(workerResponse{Batch: &b})
+		if err != nil {
+			return fmt.Errorf("encoding worker batch: %w", err)
+		}
+
+		return nil
+	}
+
+	resp := workerResponse{Done: true}
+	err = parseFile(context.Background(), req.File, req.MerchantID, req.Skip, req.BatchSize, report, apply)
+	if err != nil {
+		resp = workerResponse{Error: err.Error()}
+		errors.As(err, &resp.Duplicate)
+	}
+
+	if encErr != nil {
+		return fmt.Errorf("encoding worker progress: %w", encErr)
+	}
+
+	err = enc.Encode(resp)
+	if err != nil {
+		return fmt.Errorf("encoding worker response: %w", err)
+	}
+
+	return nil
+}
+
+// workerParse returns parseFunc which spawns new worker process per call,
+// so the parser crash or pathological file can not take down the whole server
+func workerParse(command string, args ...string) parseFunc {
+	return func(ctx context.Context, file File, merchantID int64, skip, batchSize int64, report progressFunc, apply applyFunc) error {
+		req, err := json.Marshal(workerRequest{
+			File:       file,
+			MerchantID: merchantID,
+			Skip:       skip,
+			BatchSize:  batchSize,
+		})
+		if err != nil {
+			return err
+		}
+
+		// worker is killed if batch can not be applied
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, command, args...)
+		cmd.Stdin = bytes.NewReader(req)
+		cmd.Stderr = &stderr
+
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return &workerError{err}
+		}
+
+		err = cmd.Start()
+		if err != nil {
+			return &workerError{fmt.Errorf("starting parse worker: %w", err)}
+		}
+
+		var resp workerResponse
+		var applyErr error
+		dec := json.NewDecoder(stdout)
+		for {
+			resp = workerResponse{}
+			err = dec.Decode(&resp)
+			if err != nil {
+				break
+			}
+
+			if resp.Progress != nil {
+				report(*resp.Progress)
+				continue
+			}
+
+			if resp.Batch != nil {
+				applyErr = apply(*resp.Batch)
+				if applyErr != nil {
+					cancel()
+					break
+				}
+				continue
+			}
+
+			break
+		}
+
+		// worker output has to be read completely before Wait is called
+		_, _ = io.Copy(ioutil.Discard, stdout)
+
+		waitErr := cmd.Wait()
+		if applyErr != nil {
+			return applyErr
+		}
+
+		if waitErr != nil {
+			return &workerError{fmt.Errorf("running parse worker: %w: %s", waitErr, strings.TrimSpace(stderr.String()))}
+		}
+
+		if err != nil {
+			return &workerError{fmt.Errorf("decoding worker response: %w", err)}
+		}
+
+		if resp.Duplicate != nil {
+			return resp.Duplicate
+		}
+
+		if resp.Error != "" {
+			return errors.New(resp.Error)
+		}
+
+		if !resp.Done {
+			return &workerError{errors.New("parse worker response has no result")}
+		}
+
+		return nil
+	}
+}
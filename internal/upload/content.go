@@ -0,0 +1,125 @@
+package upload
+
+import (
+	"bytes"
+	"mime"
+	"mx/internal/task"
+)
+
+// contentTypeFormats defines accepted content types of uploaded files, empty format means it is detected
+// from file name or content since such content type is used by clients and web servers for files of any kind
+var contentTypeFormats = map[string]string{
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": task.FormatXLSX,
+	"text/csv":             task.FormatCSV,
+	"application/csv":      task.FormatCSV,
+	"application/x-ndjson": task.FormatNDJSON,
+	"application/jsonl":    task.FormatNDJSON,
+	// browsers on Windows declare .csv files as Excel ones
+	"application/vnd.ms-excel":     "",
+	"text/plain":                   "",
+	"application/json":             "",
+	"application/octet-stream":     "",
+	"application/zip":              "",
+	"application/x-zip-compressed": "",
+	"binary/octet-stream":          "",
+}
+
+// workbookPart is present in every .xlsx archive, see ECMA-376 Part 1
+const workbookPart = "xl/workbook.xml"
+
+// binarySniffLength defines length of text file prefix checked for NUL bytes
+const binarySniffLength = 512
+
+// ContentError is returned when uploaded file content does not match its format or declared content type,
+// its message is safe to be shown to client
+type ContentError struct {
+	msg string
+}
+
+// Error returns string representation of ContentError
+func (e *ContentError) Error() string {
+	return e.msg
+}
+
+// declaredFormat returns format of declared content type, empty content type and generic ones yield empty format
+func declaredFormat(contentType string) (string, error) {
+	if contentType == "" {
+		return "", nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", &ContentError{"content type " + contentType + " can not be parsed"}
+	}
+
+	format, ok := contentTypeFormats[mediaType]
+	if !ok {
+		return "", &ContentError{"content type " + mediaType + " is not supported"}
+	}
+
+	return format, nil
+}
+
+// checkContent verifies head of file looks like file of provided format: .xlsx files must be zip archives,
+// while CSV and NDJSON files must not be binary. Workbook part of .xlsx archive is looked for by partScanner.
+func checkContent(format string, head []byte) error {
+	if format == task.FormatXLSX {
+		if !bytes.HasPrefix(head, zipSignature) {
+			return &ContentError{"file is not .xlsx workbook since it is not zip archive"}
+		}
+		return nil
+	}
+
+	if bytes.HasPrefix(head, zipSignature) {
+		return &ContentError{"zip archive can not be read as " + format + " file, use xlsx format for workbooks"}
+	}
+
+	prefix := head
+	if len(prefix) > binarySniffLength {
+		prefix = prefix[:binarySniffLength]
+	}
+	if bytes.IndexByte(prefix, 0) >= 0 {
+		return &ContentError{"binary file can not be read as " + format + " file"}
+	}
+
+	return nil
+}
+
+// partScanner looks for workbookPart in .xlsx archive written through it while the archive is streamed.
+// Names of archive members are stored uncompressed in their local headers, so the archive is not kept to be read;
+// damaged archives are reported by the task parsing them.
+type partScanner struct {
+	// tail is end of previous write, so name split between writes is found
+	tail  []byte
+	found bool
+}
+
+// Write looks for workbookPart in p and never fails
+func (s *partScanner) Write(p []byte) (int, error) {
+	if s.found {
+		return len(p), nil
+	}
+
+	part := []byte(workbookPart)
+	keep := len(part) - 1
+	head := p
+	if len(head) > keep {
+		head = head[:keep]
+	}
+
+	if bytes.Contains(p, part) || bytes.Contains(append(s.tail, head...), part) {
+		s.found = true
+		return len(p), nil
+	}
+
+	if len(p) >= keep {
+		s.tail = append(s.tail[:0], p[len(p)-keep:]...)
+	} else {
+		s.tail = append(s.tail, p...)
+		if len(s.tail) > keep {
+			s.tail = s.tail[len(s.tail)-keep:]
+		}
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,34 @@
+package upload
+
+import (
+	"context"
+	"io"
+	"mx/internal/storage"
+	"strconv"
+)
+
+// BlobFileStore saves uploaded files as blobs with keys prefixed by merchant id
+type BlobFileStore struct {
+	blobs storage.Blob
+}
+
+// NewBlobFileStore constructs BlobFileStore saving files to provided blob storage
+func NewBlobFileStore(blobs storage.Blob) *BlobFileStore {
+	return &BlobFileStore{blobs: blobs}
+}
+
+// Save writes content read from r as blob with key <merchant id>/name and returns the key
+func (b *BlobFileStore) Save(ctx context.Context, merchantID int64, name string, r io.Reader) (string, error) {
+	key := strconv.FormatInt(merchantID, 10) + "/" + name
+	err := b.blobs.PutFrom(ctx, key, r)
+	if err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
+
+// Remove deletes blob with provided key
+func (b *BlobFileStore) Remove(ctx context.Context, key string) error {
+	return b.blobs.Delete(ctx, key)
+}
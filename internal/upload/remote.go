@@ -0,0 +1,167 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateAddress is returned when remote URL resolves to loopback, private or link-local address
+var ErrPrivateAddress = errors.New("remote address is not public")
+
+// RemoteError is returned when remote file can not be downloaded due to remote server or network failure
+type RemoteError struct {
+	msg string
+}
+
+// Error returns string representation of RemoteError
+func (e *RemoteError) Error() string {
+	return e.msg
+}
+
+// RemoteFile defines file downloaded from remote URL
+type RemoteFile struct {
+	Name string
+	Data []byte
+	// Format is determined by content type of response, empty value means it has to be detected
+	Format string
+}
+
+// Downloader downloads files to be uploaded from remote URLs
+type Downloader struct {
+	client  *http.Client
+	maxSize int64
+}
+
+// NewDownloader constructs Downloader limiting time of every download and size of downloaded files.
+// Connections to non-public addresses are refused, so uploads can not be used to reach internal services.
+func NewDownloader(timeout time.Duration, maxSize int64) *Downloader {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			ip := net.ParseIP(host)
+			if ip == nil || !isPublic(ip) {
+				return ErrPrivateAddress
+			}
+
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &Downloader{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   timeout,
+		},
+		maxSize: maxSize,
+	}
+}
+
+// Download fetches file located at rawURL. Errors are either *ValidationError, *RemoteError or internal ones.
+func (d *Downloader) Download(ctx context.Context, rawURL string) (RemoteFile, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return RemoteFile{}, &ValidationError{"url must be absolute http or https URL"}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return RemoteFile{}, err
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		if errors.Is(err, ErrPrivateAddress) {
+			return RemoteFile{}, &ValidationError{"url must point to public address"}
+		}
+		return RemoteFile{}, &RemoteError{fmt.Sprintf("requesting remote file: %v", err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return RemoteFile{}, &RemoteError{"remote server responded with status " + strconv.Itoa(resp.StatusCode)}
+	}
+
+	var file RemoteFile
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err == nil {
+		var ok bool
+		file.Format, ok = contentTypeFormats[mediaType]
+		if !ok {
+			return RemoteFile{}, &ValidationError{"remote file content type " + mediaType + " is not supported"}
+		}
+	}
+
+	if resp.ContentLength > d.maxSize {
+		return RemoteFile{}, &ValidationError{"remote file must not be larger than " + strconv.FormatInt(d.maxSize, 10) + " bytes"}
+	}
+
+	// one more byte is read to tell file of exactly maximum size from larger one without Content-Length
+	file.Data, err = ioutil.ReadAll(io.LimitReader(resp.Body, d.maxSize+1))
+	if err != nil {
+		return RemoteFile{}, &RemoteError{fmt.Sprintf("reading remote file: %v", err)}
+	}
+
+	if int64(len(file.Data)) > d.maxSize {
+		return RemoteFile{}, &ValidationError{"remote file must not be larger than " + strconv.FormatInt(d.maxSize, 10) + " bytes"}
+	}
+
+	file.Name = path.Base(resp.Request.URL.Path)
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+	if err == nil && params["filename"] != "" {
+		file.Name = params["filename"]
+	}
+
+	return file, nil
+}
+
+// nonPublicNetworks defines private and shared address ranges which are not covered by net.IP methods
+var nonPublicNetworks = parseNetworks("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7")
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+
+	return networks
+}
+
+// isPublic reports whether ip is globally routable unicast address
+func isPublic(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
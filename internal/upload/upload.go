@@ -0,0 +1,488 @@
+// Package upload implements orchestration of file uploads independent of transport:
+// validation, idempotency and quota checks, saving the file and creating the task
+package upload
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"io"
+	"mx/internal/currency"
+	"mx/internal/logctx"
+	"mx/internal/metrics"
+	"mx/internal/storage/postgresql"
+	"mx/internal/task"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxIdempotencyKeyLength defines maximum length of idempotency key
+const MaxIdempotencyKeyLength = 255
+
+const (
+	// MaxLabels defines maximum number of labels of single upload
+	MaxLabels = 10
+	// MaxLabelLength defines maximum length of label
+	MaxLabelLength = 100
+)
+
+// zipSignature starts every .xlsx file since it is zip archive
+var zipSignature = []byte("PK\x03\x04")
+
+// utf8BOM may precede content of text files written by editors
+var utf8BOM = []byte("\uFEFF")
+
+// ErrQuotaExhausted is returned when merchant has exhausted uploads per day quota
+var ErrQuotaExhausted = errors.New("daily upload quota is exhausted")
+
+// ErrDraining is returned when instance is draining and accepts no uploads, see Service.Drain
+var ErrDraining = errors.New("instance is draining")
+
+// ValidationError is returned when upload request is invalid, its message is safe to be shown to client
+type ValidationError struct {
+	msg string
+}
+
+// Error returns string representation of ValidationError
+func (e *ValidationError) Error() string {
+	return e.msg
+}
+
+// Scheduler is implemented by task scheduler processing uploaded files
+type Scheduler interface {
+	FindIdempotentTask(ctx context.Context, merchantID int64, key string) (task.TaskID, bool, error)
+	NewTask(taskID task.TaskID, merchantID int64, file task.File, settings task.ImportSettings, idempotencyKey string) error
+}
+
+// FileStore is implemented by storage of uploaded files
+type FileStore interface {
+	// Save writes content read from r to the end as file of the merchant with provided name
+	// and returns blob key passed to task
+	Save(ctx context.Context, merchantID int64, name string, r io.Reader) (string, error)
+	// Remove deletes file saved with provided key, it is called when upload is refused after saving the file
+	Remove(ctx context.Context, key string) error
+}
+
+// Quota is implemented by checker of merchant upload limits
+type Quota interface {
+	UploadAllowed(ctx context.Context, merchantID int64) (bool, error)
+}
+
+// FreezeChecker is implemented by storage of merchant freezes
+type FreezeChecker interface {
+	MerchantFrozen(ctx context.Context, merchantID int64) (bool, error)
+}
+
+// Request defines single upload
+type Request struct {
+	MerchantID int64
+	// IdempotencyKey may be empty, repeated upload with the same key refers to the original task
+	IdempotencyKey string
+	FileName       string
+	// Body is read to the end while the file is saved, so it is never kept in memory as a whole
+	Body io.Reader
+	// ContentType is declared content type of the file, empty value means it is unknown
+	ContentType string
+	// Format is either task.FormatXLSX, task.FormatCSV or task.FormatNDJSON, empty value means format is detected
+	Format string
+	// Delimiter and Header apply to CSV files, zero Delimiter means comma and nil Header means true
+	Delimiter rune
+	Header    *bool
+	// Sheet and AllSheets apply to .xlsx files and select worksheets to be read, by default the first one
+	Sheet     string
+	AllSheets bool
+	// Mapping maps product fields to column letters of .xlsx or CSV file, see task.File
+	Mapping map[string]string
+	// Mode is either postgresql.ImportModeUpsert or postgresql.ImportModeInsertOnly, empty value means upsert
+	Mode string
+	// Duplicates is policy applied to rows with the same offer_id, see task.File
+	Duplicates string
+	// UpdateColumns limits columns of existing products set by upsert, see postgresql.UpdatableColumns
+	UpdateColumns []string
+	// Currency is ISO 4217 code of uploaded prices, empty value means base currency
+	Currency string
+	// Deadline is time by which task has to be finished, zero value means there is no deadline
+	Deadline time.Time
+	// Labels are free-form tags saved with the task, so tasks can be filtered by them
+	Labels []string
+}
+
+// Result defines outcome of upload
+type Result struct {
+	TaskID task.TaskID
+	// Repeated is true if upload has the same idempotency key as earlier one, which task is returned
+	Repeated bool
+	Location string
+}
+
+// Service defines fields used in upload processing
+type Service struct {
+	logger    *zap.Logger
+	files     FileStore
+	scheduler Scheduler
+	quota     Quota
+	ids       task.IDGenerator
+	location  func(taskID task.TaskID) string
+	// baseCurrency is currency uploaded prices are converted into, empty means conversion is disabled
+	baseCurrency string
+	// freezes refuses uploads of frozen merchants, nil disables the check
+	freezes FreezeChecker
+	// draining is set by Drain and makes every upload refused
+	draining int32
+}
+
+// Option type represents function to modify Service struct
+type Option func(s *Service)
+
+// WithLocation applies passed function building URL of task status returned in Result
+func WithLocation(f func(taskID task.TaskID) string) Option {
+	return func(s *Service) {
+		s.location = f
+	}
+}
+
+// WithIDGenerator applies passed generator of task ids
+func WithIDGenerator(ids task.IDGenerator) Option {
+	return func(s *Service) {
+		s.ids = ids
+	}
+}
+
+// WithBaseCurrency enables uploads with currency of prices, which are converted into base currency.
+// Uploads without currency are treated as having prices in base currency.
+func WithBaseCurrency(base string) Option {
+	return func(s *Service) {
+		s.baseCurrency = base
+	}
+}
+
+// WithFreezeCheck makes uploads of merchants frozen according to f refused with postgresql.ErrMerchantFrozen,
+// so they are not accepted as tasks which would fail anyway
+func WithFreezeCheck(f FreezeChecker) Option {
+	return func(s *Service) {
+		s.freezes = f
+	}
+}
+
+// NewService constructs Service, by default task ids are xids and Location is relative URL of task status
+func NewService(logger *zap.Logger, files FileStore, scheduler Scheduler, quota Quota, options ...Option) (*Service, error) {
+	if logger == nil {
+		return nil, errors.New("no logger provided")
+	}
+
+	service := &Service{
+		logger:    logger,
+		files:     files,
+		scheduler: scheduler,
+		quota:     quota,
+		ids:       task.XIDGenerator{},
+		location: func(taskID task.TaskID) string {
+			return "/tasks?id=" + taskID.String()
+		},
+	}
+
+	for _, opt := range options {
+		opt(service)
+	}
+
+	return service, nil
+}
+
+// Drain makes Service refuse every following upload with ErrDraining
+func (s *Service) Drain() {
+	atomic.StoreInt32(&s.draining, 1)
+}
+
+// Draining reports whether Drain has been called
+func (s *Service) Draining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+// Upload validates request, saves uploaded file and creates task processing it. Errors are either *ValidationError,
+// *ContentError, ErrQuotaExhausted, ErrDraining, postgresql.ErrMerchantFrozen or internal ones.
+func (s *Service) Upload(ctx context.Context, req Request) (Result, error) {
+	if s.Draining() {
+		return Result{}, ErrDraining
+	}
+
+	taskID := s.ids.NewTaskID()
+	logger := logctx.FromContext(ctx, s.logger).With(zap.String("task_id", taskID.String()))
+	ctx = logctx.NewContext(ctx, logger)
+
+	if req.Body == nil {
+		req.Body = bytes.NewReader(nil)
+	}
+
+	// format is detected and text content is checked by file prefix, the rest is only streamed to file store
+	body := bufio.NewReaderSize(req.Body, binarySniffLength)
+	head, err := body.Peek(binarySniffLength)
+	if err != nil && err != io.EOF {
+		return Result{}, err
+	}
+
+	file, err := validate(req, head)
+	if err != nil {
+		return Result{}, err
+	}
+
+	settings, err := s.importSettings(req)
+	if err != nil {
+		return Result{}, err
+	}
+
+	if req.IdempotencyKey != "" {
+		originalID, found, err := s.scheduler.FindIdempotentTask(ctx, req.MerchantID, req.IdempotencyKey)
+		switch {
+		case postgresql.IsUnavailable(err):
+			logger.Warn("Skipping idempotency key check", zap.Error(err))
+		case err != nil:
+			logger.Error("Checking idempotency key", zap.Error(err))
+			return Result{}, err
+		case found:
+			logger.Info("Repeated upload", zap.String("original_task_id", originalID.String()))
+			return Result{TaskID: originalID, Repeated: true, Location: s.location(originalID)}, nil
+		}
+	}
+
+	if s.freezes != nil {
+		frozen, err := s.freezes.MerchantFrozen(ctx, req.MerchantID)
+		switch {
+		// task of frozen merchant fails once it is processed, so nothing is lost by accepting it
+		case postgresql.IsUnavailable(err):
+			logger.Warn("Skipping merchant freeze check", zap.Error(err))
+		case err != nil:
+			logger.Error("Checking merchant freeze", zap.Error(err))
+			return Result{}, err
+		case frozen:
+			return Result{}, postgresql.ErrMerchantFrozen
+		}
+	}
+
+	allowed, err := s.quota.UploadAllowed(ctx, req.MerchantID)
+	switch {
+	// upload is accepted as pending task rather than lost while database is unavailable
+	case postgresql.IsUnavailable(err):
+		logger.Warn("Skipping upload quota check", zap.Error(err))
+		allowed = true
+	case err != nil:
+		logger.Error("Checking upload quota", zap.Error(err))
+		return Result{}, err
+	}
+
+	if !allowed {
+		return Result{}, ErrQuotaExhausted
+	}
+
+	content := io.Reader(body)
+	var parts *partScanner
+	if file.Format == task.FormatXLSX {
+		parts = &partScanner{}
+		content = io.TeeReader(body, parts)
+	}
+
+	file.Path, err = s.files.Save(ctx, req.MerchantID, taskID.String()+"."+file.Format, content)
+	if err != nil {
+		logger.Error("Saving uploaded file", zap.Error(err))
+		return Result{}, err
+	}
+
+	if parts != nil && !parts.found {
+		s.removeFile(ctx, file.Path)
+		return Result{}, &ContentError{"file is not .xlsx workbook since zip archive has no " + workbookPart}
+	}
+
+	err = s.scheduler.NewTask(taskID, req.MerchantID, file, settings, req.IdempotencyKey)
+	if err != nil {
+		logger.Error("Creating task", zap.Error(err))
+		s.removeFile(ctx, file.Path)
+		return Result{}, err
+	}
+
+	metrics.AddMerchant(metrics.UploadsByMerchant, req.MerchantID, 1)
+
+	return Result{TaskID: taskID, Location: s.location(taskID)}, nil
+}
+
+// removeFile deletes saved file of refused upload, failure is only logged since orphaned files are swept by cleanup job
+func (s *Service) removeFile(ctx context.Context, key string) {
+	err := s.files.Remove(ctx, key)
+	if err != nil {
+		logctx.FromContext(ctx, s.logger).Warn("Removing saved file of refused upload", zap.String("key", key), zap.Error(err))
+	}
+}
+
+// importSettings checks requested import mode, update columns, currency, deadline and labels, empty mode means upsert
+func (s *Service) importSettings(req Request) (task.ImportSettings, error) {
+	if !req.Deadline.IsZero() && !req.Deadline.After(time.Now()) {
+		return task.ImportSettings{}, &ValidationError{"process deadline must be in the future"}
+	}
+
+	labels, err := validateLabels(req.Labels)
+	if err != nil {
+		return task.ImportSettings{}, err
+	}
+
+	settings := task.ImportSettings{Mode: req.Mode, UpdateColumns: req.UpdateColumns, Deadline: req.Deadline, Labels: labels}
+	switch settings.Mode {
+	case "":
+		settings.Mode = postgresql.ImportModeUpsert
+	case postgresql.ImportModeUpsert, postgresql.ImportModeInsertOnly:
+	default:
+		return task.ImportSettings{}, &ValidationError{"mode must be either upsert or insert-only"}
+	}
+
+	if len(settings.UpdateColumns) != 0 {
+		if settings.Mode == postgresql.ImportModeInsertOnly {
+			return task.ImportSettings{}, &ValidationError{"update columns do not apply to insert-only mode"}
+		}
+
+		err = postgresql.ValidateUpdateColumns(settings.UpdateColumns)
+		if err != nil {
+			return task.ImportSettings{}, &ValidationError{"update columns are invalid: " + err.Error()}
+		}
+	}
+
+	switch {
+	case req.Currency != "" && s.baseCurrency == "":
+		return task.ImportSettings{}, &ValidationError{"currency conversion is not configured"}
+	case req.Currency != "" && !currency.ValidCode(req.Currency):
+		return task.ImportSettings{}, &ValidationError{"currency must be ISO 4217 code like USD"}
+	case req.Currency != "":
+		settings.Currency = req.Currency
+	default:
+		settings.Currency = s.baseCurrency
+	}
+
+	return settings, nil
+}
+
+// validateLabels checks number and length of labels and drops repeated ones
+func validateLabels(labels []string) ([]string, error) {
+	if len(labels) > MaxLabels {
+		return nil, &ValidationError{"upload can not have more than " + strconv.Itoa(MaxLabels) + " labels"}
+	}
+
+	var unique []string
+	seen := make(map[string]bool, len(labels))
+	for _, label := range labels {
+		switch {
+		case label == "":
+			return nil, &ValidationError{"label can not be blank"}
+		case len(label) > MaxLabelLength:
+			return nil, &ValidationError{"label must not be longer than " + strconv.Itoa(MaxLabelLength) + " characters"}
+		case !utf8.ValidString(label):
+			return nil, &ValidationError{"label must be valid UTF-8 string"}
+		case seen[label]:
+			continue
+		}
+
+		seen[label] = true
+		unique = append(unique, label)
+	}
+
+	return unique, nil
+}
+
+// validate checks request fields and determines format of uploaded file from Format field,
+// declared content type, file name or head of its content, then checks the head matches the format
+func validate(req Request, head []byte) (task.File, error) {
+	if req.MerchantID <= 0 {
+		return task.File{}, &ValidationError{"merchant id must be positive integer greater than zero"}
+	}
+
+	if len(req.IdempotencyKey) > MaxIdempotencyKeyLength {
+		return task.File{}, &ValidationError{"idempotency key must not be longer than " + strconv.Itoa(MaxIdempotencyKeyLength) + " characters"}
+	}
+
+	declared, err := declaredFormat(req.ContentType)
+	if err != nil {
+		return task.File{}, err
+	}
+
+	file := task.File{Format: req.Format}
+	switch {
+	case file.Format == task.FormatXLSX || file.Format == task.FormatCSV || file.Format == task.FormatNDJSON:
+	case file.Format != "":
+		return task.File{}, &ValidationError{"format must be either xlsx, csv or ndjson"}
+	case declared != "":
+		file.Format = declared
+	case strings.EqualFold(filepath.Ext(req.FileName), ".csv"):
+		file.Format = task.FormatCSV
+	case strings.EqualFold(filepath.Ext(req.FileName), ".ndjson"), strings.EqualFold(filepath.Ext(req.FileName), ".jsonl"):
+		file.Format = task.FormatNDJSON
+	case strings.EqualFold(filepath.Ext(req.FileName), ".xlsx"), bytes.HasPrefix(head, zipSignature):
+		file.Format = task.FormatXLSX
+	// text starting with JSON object is NDJSON, any other text is read as CSV
+	case bytes.HasPrefix(bytes.TrimLeft(bytes.TrimPrefix(head, utf8BOM), " \t\r\n"), []byte("{")):
+		file.Format = task.FormatNDJSON
+	default:
+		file.Format = task.FormatCSV
+	}
+
+	if declared != "" && declared != file.Format {
+		return task.File{}, &ContentError{"declared content type " + req.ContentType + " does not match " + file.Format + " format"}
+	}
+
+	err = checkContent(file.Format, head)
+	if err != nil {
+		return task.File{}, err
+	}
+
+	switch req.Duplicates {
+	case "", task.DuplicatesLastWins, task.DuplicatesFirstWins, task.DuplicatesRejectFile:
+		file.Duplicates = req.Duplicates
+	default:
+		return task.File{}, &ValidationError{"duplicates policy must be either last-wins, first-wins or reject-file"}
+	}
+
+	if len(req.Mapping) != 0 {
+		if file.Format == task.FormatNDJSON {
+			return task.File{}, &ValidationError{"mapping applies only to xlsx and csv files"}
+		}
+
+		err := task.ValidateMapping(req.Mapping)
+		if err != nil {
+			return task.File{}, &ValidationError{"mapping is invalid: " + err.Error()}
+		}
+		file.Mapping = req.Mapping
+	}
+
+	if file.Format == task.FormatXLSX {
+		if req.Sheet != "" && req.AllSheets {
+			return task.File{}, &ValidationError{"either sheet or all sheets can be selected"}
+		}
+
+		file.Sheet = req.Sheet
+		file.AllSheets = req.AllSheets
+		return file, nil
+	}
+
+	if req.Sheet != "" || req.AllSheets {
+		return task.File{}, &ValidationError{"sheets can be selected only in xlsx files"}
+	}
+
+	if file.Format != task.FormatCSV {
+		return file, nil
+	}
+
+	file.Delimiter = ','
+	if req.Delimiter != 0 {
+		if req.Delimiter == '"' || req.Delimiter == '\r' || req.Delimiter == '\n' {
+			return task.File{}, &ValidationError{"delimiter must be single character other than quote or line break"}
+		}
+		file.Delimiter = req.Delimiter
+	}
+
+	file.Header = true
+	if req.Header != nil {
+		file.Header = *req.Header
+	}
+
+	return file, nil
+}
@@ -0,0 +1,293 @@
+package upload
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"io"
+	"io/ioutil"
+	"mx/internal/task"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeFileStore keeps saved files in memory and records removed keys
+type fakeFileStore struct {
+	saveErr error
+	saved   map[string][]byte
+	removed []string
+}
+
+func (f *fakeFileStore) Save(_ context.Context, merchantID int64, name string, r io.Reader) (string, error) {
+	if f.saveErr != nil {
+		return "", f.saveErr
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+
+	if f.saved == nil {
+		f.saved = make(map[string][]byte)
+	}
+	key := "files/" + name
+	f.saved[key] = data
+	return key, nil
+}
+
+func (f *fakeFileStore) Remove(_ context.Context, key string) error {
+	f.removed = append(f.removed, key)
+	delete(f.saved, key)
+	return nil
+}
+
+// fakeScheduler records created tasks and finds idempotent ones in idempotent map
+type fakeScheduler struct {
+	newTaskErr error
+	idempotent map[string]task.TaskID
+	created    []task.File
+}
+
+func (f *fakeScheduler) FindIdempotentTask(_ context.Context, _ int64, key string) (task.TaskID, bool, error) {
+	id, ok := f.idempotent[key]
+	return id, ok, nil
+}
+
+func (f *fakeScheduler) NewTask(_ task.TaskID, _ int64, file task.File, _ task.ImportSettings, _ string) error {
+	if f.newTaskErr != nil {
+		return f.newTaskErr
+	}
+
+	f.created = append(f.created, file)
+	return nil
+}
+
+// allowAll allows every upload
+type allowAll struct{}
+
+func (allowAll) UploadAllowed(context.Context, int64) (bool, error) {
+	return true, nil
+}
+
+// fixedIDs generates the same task id every time
+type fixedIDs struct {
+	id task.TaskID
+}
+
+func (f fixedIDs) NewTaskID() task.TaskID {
+	return f.id
+}
+
+func newTestService(t *testing.T, files *fakeFileStore, scheduler *fakeScheduler, options ...Option) (*Service, task.TaskID) {
+	t.Helper()
+
+	id := task.XIDGenerator{}.NewTaskID()
+	options = append([]Option{WithIDGenerator(fixedIDs{id})}, options...)
+	service, err := NewService(zap.NewNop(), files, scheduler, allowAll{}, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return service, id
+}
+
+// workbook returns zip archive containing members with provided names
+func workbook(t *testing.T, names ...string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range names {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = w.Write([]byte("<workbook/>"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := zw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestUploadValidation(t *testing.T) {
+	csv := []byte("offer_id,name,price,quantity,available\n1,a,1,1,true\n")
+	tests := []struct {
+		name string
+		req  Request
+		// content is true if *ContentError rather than *ValidationError is expected
+		content bool
+	}{
+		{name: "merchant id", req: Request{MerchantID: 0, Body: bytes.NewReader(csv)}},
+		{name: "idempotency key", req: Request{MerchantID: 1, IdempotencyKey: strings.Repeat("k", MaxIdempotencyKeyLength+1), Body: bytes.NewReader(csv)}},
+		{name: "format", req: Request{MerchantID: 1, Format: "xls", Body: bytes.NewReader(csv)}},
+		{name: "duplicates", req: Request{MerchantID: 1, Duplicates: "any", Body: bytes.NewReader(csv)}},
+		{name: "delimiter", req: Request{MerchantID: 1, Delimiter: '"', Body: bytes.NewReader(csv)}},
+		{name: "sheet of csv", req: Request{MerchantID: 1, Sheet: "Sheet1", Body: bytes.NewReader(csv)}},
+		{name: "mapping of ndjson", req: Request{MerchantID: 1, Format: task.FormatNDJSON, Mapping: map[string]string{"price": "B"}, Body: strings.NewReader(`{"offer_id":1}`)}},
+		{name: "mode", req: Request{MerchantID: 1, Mode: "replace", Body: bytes.NewReader(csv)}},
+		{name: "past deadline", req: Request{MerchantID: 1, Deadline: time.Now().Add(-time.Minute), Body: bytes.NewReader(csv)}},
+		{name: "too many labels", req: Request{MerchantID: 1, Labels: make([]string, MaxLabels+1), Body: bytes.NewReader(csv)}},
+		{name: "blank label", req: Request{MerchantID: 1, Labels: []string{""}, Body: bytes.NewReader(csv)}},
+		{name: "currency without conversion", req: Request{MerchantID: 1, Currency: "USD", Body: bytes.NewReader(csv)}},
+		{name: "unsupported content type", req: Request{MerchantID: 1, ContentType: "image/png", Body: bytes.NewReader(csv)}, content: true},
+		{name: "content type contradicts format", req: Request{MerchantID: 1, ContentType: "text/csv", Format: task.FormatXLSX, Body: bytes.NewReader(csv)}, content: true},
+		{name: "csv is not zip archive", req: Request{MerchantID: 1, Format: task.FormatXLSX, Body: bytes.NewReader(csv)}, content: true},
+		{name: "zip archive as csv", req: Request{MerchantID: 1, Format: task.FormatCSV, Body: bytes.NewReader(workbook(t, "xl/workbook.xml"))}, content: true},
+		{name: "binary csv", req: Request{MerchantID: 1, Format: task.FormatCSV, Body: bytes.NewReader([]byte("a,b\x00c"))}, content: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := &fakeFileStore{}
+			scheduler := &fakeScheduler{}
+			service, _ := newTestService(t, files, scheduler)
+
+			_, err := service.Upload(context.Background(), tt.req)
+
+			var validationErr *ValidationError
+			var contentErr *ContentError
+			switch {
+			case tt.content && !errors.As(err, &contentErr):
+				t.Fatalf("expected *ContentError, got %T: %v", err, err)
+			case !tt.content && !errors.As(err, &validationErr):
+				t.Fatalf("expected *ValidationError, got %T: %v", err, err)
+			}
+
+			if len(files.saved) != 0 || len(scheduler.created) != 0 {
+				t.Fatalf("invalid upload is saved or scheduled: %d files, %d tasks", len(files.saved), len(scheduler.created))
+			}
+		})
+	}
+}
+
+func TestUploadWorkbookWithoutWorkbookPart(t *testing.T) {
+	files := &fakeFileStore{}
+	scheduler := &fakeScheduler{}
+	service, id := newTestService(t, files, scheduler)
+
+	_, err := service.Upload(context.Background(), Request{MerchantID: 1, Body: bytes.NewReader(workbook(t, "docProps/app.xml"))})
+
+	var contentErr *ContentError
+	if !errors.As(err, &contentErr) {
+		t.Fatalf("expected *ContentError, got %T: %v", err, err)
+	}
+
+	key := "files/" + id.String() + "." + task.FormatXLSX
+	if len(files.removed) != 1 || files.removed[0] != key {
+		t.Fatalf("saved file %s is not removed, removed files are %v", key, files.removed)
+	}
+	if len(scheduler.created) != 0 {
+		t.Fatal("task is created for invalid workbook")
+	}
+}
+
+func TestUploadSaveError(t *testing.T) {
+	saveErr := errors.New("disk is full")
+	files := &fakeFileStore{saveErr: saveErr}
+	scheduler := &fakeScheduler{}
+	service, _ := newTestService(t, files, scheduler)
+
+	_, err := service.Upload(context.Background(), Request{MerchantID: 1, Body: strings.NewReader("a,b\n")})
+	if !errors.Is(err, saveErr) {
+		t.Fatalf("expected save error, got %v", err)
+	}
+
+	if len(scheduler.created) != 0 {
+		t.Fatal("task is created for file which is not saved")
+	}
+}
+
+func TestUploadNewTaskError(t *testing.T) {
+	newTaskErr := errors.New("task record can not be built")
+	files := &fakeFileStore{}
+	scheduler := &fakeScheduler{newTaskErr: newTaskErr}
+	service, id := newTestService(t, files, scheduler)
+
+	_, err := service.Upload(context.Background(), Request{MerchantID: 1, FileName: "export.csv", Body: strings.NewReader("a,b\n")})
+	if !errors.Is(err, newTaskErr) {
+		t.Fatalf("expected task creation error, got %v", err)
+	}
+
+	key := "files/" + id.String() + "." + task.FormatCSV
+	if len(files.removed) != 1 || files.removed[0] != key {
+		t.Fatalf("saved file %s is not removed, removed files are %v", key, files.removed)
+	}
+	if len(files.saved) != 0 {
+		t.Fatalf("files are left in store: %v", files.saved)
+	}
+}
+
+func TestUploadLocation(t *testing.T) {
+	content := "offer_id,name,price,quantity,available\n1,a,1,1,true\n"
+	repeatedID := task.XIDGenerator{}.NewTaskID()
+
+	tests := []struct {
+		name     string
+		options  []Option
+		key      string
+		location func(id task.TaskID) string
+		repeated bool
+	}{
+		{
+			name:     "default",
+			location: func(id task.TaskID) string { return "/tasks?id=" + id.String() },
+		},
+		{
+			name:     "custom",
+			options:  []Option{WithLocation(func(id task.TaskID) string { return "https://mx.example/tasks?id=" + id.String() })},
+			location: func(id task.TaskID) string { return "https://mx.example/tasks?id=" + id.String() },
+		},
+		{
+			name:     "repeated upload",
+			key:      "repeated",
+			location: func(task.TaskID) string { return "/tasks?id=" + repeatedID.String() },
+			repeated: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := &fakeFileStore{}
+			scheduler := &fakeScheduler{idempotent: map[string]task.TaskID{"repeated": repeatedID}}
+			service, id := newTestService(t, files, scheduler, tt.options...)
+
+			result, err := service.Upload(context.Background(), Request{MerchantID: 1, IdempotencyKey: tt.key, FileName: "export.csv", Body: strings.NewReader(content)})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want := tt.location(id); result.Location != want {
+				t.Fatalf("expected location %s, got %s", want, result.Location)
+			}
+			if result.Repeated != tt.repeated {
+				t.Fatalf("expected repeated %v, got %v", tt.repeated, result.Repeated)
+			}
+
+			if tt.repeated {
+				if len(files.saved) != 0 || len(scheduler.created) != 0 {
+					t.Fatal("repeated upload is saved or scheduled again")
+				}
+				return
+			}
+
+			key := "files/" + id.String() + "." + task.FormatCSV
+			if string(files.saved[key]) != content {
+				t.Fatalf("expected saved %s to be %q, got %q", key, content, files.saved[key])
+			}
+			if len(scheduler.created) != 1 || scheduler.created[0].Path != key {
+				t.Fatalf("expected task of %s, got %v", key, scheduler.created)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package xlsxstream
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// parts of single worksheet workbook written before the worksheet itself
+const (
+	contentTypesPart = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`
+	rootRelsPart = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`
+	workbookRelsPart = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`
+	sheetStart = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`
+	sheetEnd = `</sheetData></worksheet>`
+)
+
+// Writer writes rows of single worksheet .xlsx workbook one by one, so memory usage does not depend on number of rows.
+// Strings are written inline, so no shared strings table is kept.
+type Writer struct {
+	archive *zip.Writer
+	sheet   io.Writer
+	rows    int64
+	// dimension is number of rows and columns declared by worksheet dimension, zero rows omits the dimension
+	dimensionRows    int64
+	dimensionColumns int
+	buf              []byte
+}
+
+// WriterOption type represents function to modify Writer struct
+type WriterOption func(w *Writer)
+
+// WithDimension declares number of rows and columns the worksheet is going to have,
+// so readers like Reader know rows count before reading them
+func WithDimension(rows int64, columns int) WriterOption {
+	return func(w *Writer) {
+		w.dimensionRows = rows
+		w.dimensionColumns = columns
+	}
+}
+
+// NewWriter writes workbook parts preceding rows of worksheet named sheetName to w, rows are added by WriteRow
+// and the workbook is completed by Close
+func NewWriter(w io.Writer, sheetName string, options ...WriterOption) (*Writer, error) {
+	if sheetName == "" {
+		return nil, errors.New("sheet name can not be blank")
+	}
+
+	xw := &Writer{archive: zip.NewWriter(w)}
+	for _, opt := range options {
+		opt(xw)
+	}
+
+	name, err := escape(nil, sheetName)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := []struct {
+		path    string
+		content string
+	}{
+		{"[Content_Types].xml", contentTypesPart},
+		{"_rels/.rels", rootRelsPart},
+		{workbookPath, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"` +
+			` xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="` + string(name) + `" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+		{workbookRelsPath, workbookRelsPart},
+	}
+	for _, part := range parts {
+		err = xw.writePart(part.path, part.content)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	xw.sheet, err = xw.archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+
+	header := sheetStart
+	if xw.dimensionRows > 0 && xw.dimensionColumns > 0 {
+		header += `<dimension ref="A1:` + columnLetters(xw.dimensionColumns-1) + strconv.FormatInt(xw.dimensionRows, 10) + `"/>`
+	}
+	_, err = io.WriteString(xw.sheet, header+`<sheetData>`)
+	if err != nil {
+		return nil, err
+	}
+
+	return xw, nil
+}
+
+func (w *Writer) writePart(partPath, content string) error {
+	part, err := w.archive.Create(partPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(part, content)
+	return err
+}
+
+// WriteRow appends row of cells to the worksheet, numeric cell values are written as is
+// and have to be valid numbers, boolean ones are either "1" or "0"
+func (w *Writer) WriteRow(cells ...Cell) error {
+	w.rows++
+	number := strconv.FormatInt(w.rows, 10)
+
+	var err error
+	buf := append(w.buf[:0], `<row r="`+number+`">`...)
+	for i, cell := range cells {
+		if cell.Value == "" {
+			continue
+		}
+
+		ref := columnLetters(i) + number
+		switch cell.Type {
+		case CellTypeNumeric:
+			buf = append(buf, `<c r="`+ref+`"><v>`...)
+			buf, err = escape(buf, cell.Value)
+			buf = append(buf, `</v></c>`...)
+		case CellTypeBool:
+			buf = append(buf, `<c r="`+ref+`" t="b"><v>`...)
+			buf, err = escape(buf, cell.Value)
+			buf = append(buf, `</v></c>`...)
+		case CellTypeString:
+			buf = append(buf, `<c r="`+ref+`" t="inlineStr"><is><t xml:space="preserve">`...)
+			buf, err = escape(buf, cell.Value)
+			buf = append(buf, `</t></is></c>`...)
+		default:
+			return errors.New("only numeric, boolean and string cells can be written")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	buf = append(buf, `</row>`...)
+	w.buf = buf
+
+	_, err = w.sheet.Write(buf)
+	return err
+}
+
+// Close completes the worksheet and the archive, it does not close underlying writer
+func (w *Writer) Close() error {
+	_, err := io.WriteString(w.sheet, sheetEnd)
+	if err != nil {
+		return err
+	}
+
+	return w.archive.Close()
+}
+
+// escape appends s to buf escaping XML special characters, characters not allowed in XML are replaced by U+FFFD
+func escape(buf []byte, s string) ([]byte, error) {
+	var b xmlBuffer
+	b.buf = buf
+	err := xml.EscapeText(&b, []byte(s))
+	return b.buf, err
+}
+
+// xmlBuffer appends written bytes to buf
+type xmlBuffer struct {
+	buf []byte
+}
+
+func (b *xmlBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	return len(p), nil
+}
+
+// columnLetters returns column letters like "A" or "AB" of zero-based column index
+func columnLetters(index int) string {
+	var letters []byte
+	for index >= 0 {
+		letters = append([]byte{byte('A' + index%26)}, letters...)
+		index = index/26 - 1
+	}
+
+	return string(letters)
+}
@@ -0,0 +1,582 @@
+// Package xlsxstream reads rows of .xlsx worksheets one by one decoding sheet XML as a stream,
+// so memory usage does not depend on number of rows. Only shared strings table is kept in memory.
+// Writer produces single worksheet workbooks the same way.
+package xlsxstream
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	workbookPath      = "xl/workbook.xml"
+	workbookRelsPath  = "xl/_rels/workbook.xml.rels"
+	sharedStringsType = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/sharedStrings"
+)
+
+// CellType defines type of cell value
+type CellType int
+
+const (
+	// CellTypeNumeric is type of cells without explicit type, i.e. numbers and dates
+	CellTypeNumeric CellType = iota
+	// CellTypeString is type of shared, inline and formula string cells
+	CellTypeString
+	// CellTypeBool is type of boolean cells which value is either "1" or "0"
+	CellTypeBool
+	// CellTypeError is type of cells containing formula errors like #DIV/0!
+	CellTypeError
+)
+
+// Cell defines raw value of worksheet cell
+type Cell struct {
+	Value string
+	Type  CellType
+}
+
+// String returns raw cell value
+func (c Cell) String() string {
+	return c.Value
+}
+
+// Int64 parses cell value as integer
+func (c Cell) Int64() (int64, error) {
+	return strconv.ParseInt(c.Value, 10, 64)
+}
+
+// Float parses cell value as floating point number
+func (c Cell) Float() (float64, error) {
+	return strconv.ParseFloat(c.Value, 64)
+}
+
+// Decimal parses cell value as exact decimal number, so prices are not rounded through float64
+func (c Cell) Decimal() (decimal.Decimal, error) {
+	return decimal.NewFromString(strings.TrimSpace(c.Value))
+}
+
+// Bool returns value of boolean cell
+func (c Cell) Bool() bool {
+	return c.Value == "1"
+}
+
+// Row defines cells of worksheet row indexed by column, missing cells are empty
+type Row []Cell
+
+// Cell returns cell of the row by zero-based column index or empty cell if row is shorter
+func (r Row) Cell(i int) Cell {
+	if i < 0 || i >= len(r) {
+		return Cell{}
+	}
+
+	return r[i]
+}
+
+// ErrSheetNotFound is returned when workbook has no worksheet selected via WithSheet
+var ErrSheetNotFound = errors.New("worksheet is not found")
+
+// Reader reads rows of selected worksheets of .xlsx file
+type Reader struct {
+	archive       *zip.ReadCloser
+	sheet         io.ReadCloser
+	dec           *xml.Decoder
+	sharedStrings []string
+	rowsTotal     int64
+	// sheetRef and allSheets select worksheets as described by WithSheet and WithAllSheets
+	sheetRef  string
+	allSheets bool
+	// sheets contains paths of selected worksheets, current is index of the one being read
+	sheets  []string
+	current int
+}
+
+// Option type represents function to modify Reader struct
+type Option func(r *Reader)
+
+// WithSheet selects worksheet by name or by its 1-based number if workbook has no worksheet with such name
+func WithSheet(ref string) Option {
+	return func(r *Reader) {
+		r.sheetRef = ref
+	}
+}
+
+// WithAllSheets selects all worksheets, rows of which are read one sheet after another in workbook order
+func WithAllSheets() Option {
+	return func(r *Reader) {
+		r.allSheets = true
+	}
+}
+
+// Open opens .xlsx file located at filePath and prepares reading its worksheets, by default only the first one
+func Open(filePath string, options ...Option) (*Reader, error) {
+	archive, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reader{archive: archive}
+	for _, opt := range options {
+		opt(r)
+	}
+
+	err = r.open()
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *Reader) open() error {
+	sheets, sharedStringsPath, err := r.locateParts()
+	if err != nil {
+		return err
+	}
+
+	r.sheets, err = r.selectSheets(sheets)
+	if err != nil {
+		return err
+	}
+
+	if sharedStringsPath != "" {
+		r.sharedStrings, err = r.readSharedStrings(sharedStringsPath)
+		if err != nil {
+			return fmt.Errorf("reading shared strings: %w", err)
+		}
+	}
+
+	// rows of the sheets after the first one are counted in advance, so total is known before reading
+	for _, sheetPath := range r.sheets[1:] {
+		rows, err := r.countRows(sheetPath)
+		if err != nil {
+			return err
+		}
+		r.rowsTotal += rows
+	}
+
+	rows, err := r.startSheet(r.sheets[0])
+	if err != nil {
+		return err
+	}
+	r.rowsTotal += rows
+
+	return nil
+}
+
+// selectSheets returns paths of worksheets selected by options
+func (r *Reader) selectSheets(sheets []worksheet) ([]string, error) {
+	if r.allSheets {
+		paths := make([]string, len(sheets))
+		for i, sheet := range sheets {
+			paths[i] = sheet.path
+		}
+		return paths, nil
+	}
+
+	if r.sheetRef == "" {
+		return []string{sheets[0].path}, nil
+	}
+
+	for _, sheet := range sheets {
+		if sheet.name == r.sheetRef {
+			return []string{sheet.path}, nil
+		}
+	}
+
+	number, err := strconv.Atoi(r.sheetRef)
+	if err != nil || number < 1 || number > len(sheets) {
+		return nil, fmt.Errorf("%w: %q", ErrSheetNotFound, r.sheetRef)
+	}
+
+	return []string{sheets[number-1].path}, nil
+}
+
+// startSheet opens worksheet and skips to its sheetData element returning number of rows declared by dimension
+func (r *Reader) startSheet(sheetPath string) (int64, error) {
+	sheet, err := r.openPart(sheetPath)
+	if err != nil {
+		return 0, err
+	}
+
+	r.sheet = sheet
+	r.dec = xml.NewDecoder(sheet)
+
+	return skipToSheetData(r.dec)
+}
+
+// countRows returns number of rows declared by worksheet dimension
+func (r *Reader) countRows(sheetPath string) (int64, error) {
+	sheet, err := r.openPart(sheetPath)
+	if err != nil {
+		return 0, err
+	}
+	defer sheet.Close()
+
+	return skipToSheetData(xml.NewDecoder(sheet))
+}
+
+// skipToSheetData reads worksheet until sheetData element returning number of rows declared by dimension
+func skipToSheetData(dec *xml.Decoder) (int64, error) {
+	var rows int64
+
+	// dimension element precedes sheetData and is the only way to know rows count without reading them
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return 0, errors.New("worksheet has no sheetData element")
+			}
+			return 0, err
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "dimension":
+			rows = dimensionRows(attr(start, "ref"))
+		case "sheetData":
+			return rows, nil
+		}
+	}
+}
+
+// RowsTotal returns number of rows declared by dimensions of selected worksheets or zero if it is unknown
+func (r *Reader) RowsTotal() int64 {
+	return r.rowsTotal
+}
+
+// Next returns next row of selected worksheets or io.EOF if there are no more rows
+func (r *Reader) Next() (Row, error) {
+	for {
+		tok, err := r.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "row" {
+				return r.readRow()
+			}
+		case xml.EndElement:
+			if t.Name.Local != "sheetData" {
+				continue
+			}
+
+			if r.current == len(r.sheets)-1 {
+				return nil, io.EOF
+			}
+
+			_ = r.sheet.Close()
+			r.sheet = nil
+			r.current++
+			_, err = r.startSheet(r.sheets[r.current])
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// Close closes underlying file
+func (r *Reader) Close() error {
+	if r.sheet != nil {
+		_ = r.sheet.Close()
+	}
+
+	return r.archive.Close()
+}
+
+// readRow reads cells until the end of current row element
+func (r *Reader) readRow() (Row, error) {
+	var row Row
+	for {
+		tok, err := r.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local != "c" {
+				continue
+			}
+
+			column := len(row)
+			if ref := attr(t, "r"); ref != "" {
+				column, err = columnIndex(ref)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			cell, err := r.readCell(t)
+			if err != nil {
+				return nil, err
+			}
+
+			for len(row) < column {
+				row = append(row, Cell{})
+			}
+			row = append(row[:column], cell)
+
+		case xml.EndElement:
+			if t.Name.Local == "row" {
+				return row, nil
+			}
+		}
+	}
+}
+
+// readCell reads value of cell element which start is already consumed
+func (r *Reader) readCell(start xml.StartElement) (Cell, error) {
+	var value, inline strings.Builder
+	var inValue, inInline bool
+
+	for {
+		tok, err := r.dec.Token()
+		if err != nil {
+			return Cell{}, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "v":
+				inValue = true
+			case "t":
+				inInline = true
+			}
+		case xml.CharData:
+			if inValue {
+				value.Write(t)
+			}
+			if inInline {
+				inline.Write(t)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "v":
+				inValue = false
+			case "t":
+				inInline = false
+			case "c":
+				return r.cell(attr(start, "t"), value.String(), inline.String())
+			}
+		}
+	}
+}
+
+// cell resolves raw cell value according to its type attribute
+func (r *Reader) cell(typ, value, inline string) (Cell, error) {
+	switch typ {
+	case "s":
+		i, err := strconv.Atoi(value)
+		if err != nil || i < 0 || i >= len(r.sharedStrings) {
+			return Cell{}, fmt.Errorf("invalid shared string index %q", value)
+		}
+		return Cell{Value: r.sharedStrings[i], Type: CellTypeString}, nil
+	case "inlineStr":
+		return Cell{Value: inline, Type: CellTypeString}, nil
+	case "str":
+		return Cell{Value: value, Type: CellTypeString}, nil
+	case "b":
+		return Cell{Value: value, Type: CellTypeBool}, nil
+	case "e":
+		return Cell{Value: value, Type: CellTypeError}, nil
+	default:
+		return Cell{Value: value, Type: CellTypeNumeric}, nil
+	}
+}
+
+// worksheet defines name and path inside the archive of workbook worksheet
+type worksheet struct {
+	name string
+	path string
+}
+
+// locateParts returns worksheets in workbook order and path of shared strings table inside the archive
+func (r *Reader) locateParts() ([]worksheet, string, error) {
+	var workbook struct {
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			ID   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+
+	err := r.decodePart(workbookPath, &workbook)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(workbook.Sheets) == 0 {
+		return nil, "", errors.New("workbook has no sheets")
+	}
+
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Type   string `xml:"Type,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+
+	err = r.decodePart(workbookRelsPath, &rels)
+	if err != nil {
+		return nil, "", err
+	}
+
+	targets := make(map[string]string, len(rels.Relationships))
+	var sharedStringsPath string
+	for _, rel := range rels.Relationships {
+		targets[rel.ID] = partPath(rel.Target)
+		if rel.Type == sharedStringsType {
+			sharedStringsPath = partPath(rel.Target)
+		}
+	}
+
+	sheets := make([]worksheet, len(workbook.Sheets))
+	for i, sheet := range workbook.Sheets {
+		sheetPath, ok := targets[sheet.ID]
+		if !ok {
+			return nil, "", fmt.Errorf("worksheet %q is not found in workbook relationships", sheet.Name)
+		}
+		sheets[i] = worksheet{name: sheet.Name, path: sheetPath}
+	}
+
+	return sheets, sharedStringsPath, nil
+}
+
+// readSharedStrings reads shared strings table concatenating rich text runs and skipping phonetic hints
+func (r *Reader) readSharedStrings(name string) ([]string, error) {
+	part, err := r.openPart(name)
+	if err != nil {
+		return nil, err
+	}
+	defer part.Close()
+
+	var table []string
+	var current strings.Builder
+	var inText bool
+	var phoneticDepth int
+
+	dec := xml.NewDecoder(part)
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return table, nil
+			}
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "si":
+				current.Reset()
+			case "t":
+				inText = phoneticDepth == 0
+			case "rPh":
+				phoneticDepth++
+			}
+		case xml.CharData:
+			if inText {
+				current.Write(t)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "si":
+				table = append(table, current.String())
+			case "t":
+				inText = false
+			case "rPh":
+				phoneticDepth--
+			}
+		}
+	}
+}
+
+func (r *Reader) decodePart(name string, v interface{}) error {
+	part, err := r.openPart(name)
+	if err != nil {
+		return err
+	}
+	defer part.Close()
+
+	err = xml.NewDecoder(part).Decode(v)
+	if err != nil {
+		return fmt.Errorf("decoding %s: %w", name, err)
+	}
+
+	return nil
+}
+
+func (r *Reader) openPart(name string) (io.ReadCloser, error) {
+	for _, f := range r.archive.File {
+		if f.Name == name {
+			return f.Open()
+		}
+	}
+
+	return nil, fmt.Errorf("%s is not found in workbook", name)
+}
+
+// partPath resolves relationship target which is either absolute or relative to xl directory
+func partPath(target string) string {
+	if strings.HasPrefix(target, "/") {
+		return strings.TrimPrefix(target, "/")
+	}
+
+	return path.Join("xl", target)
+}
+
+// columnIndex returns zero-based column index of cell reference like "C12"
+func columnIndex(ref string) (int, error) {
+	var index int
+	var i int
+	for i < len(ref) && ref[i] >= 'A' && ref[i] <= 'Z' {
+		index = index*26 + int(ref[i]-'A'+1)
+		i++
+	}
+
+	if i == 0 {
+		return 0, fmt.Errorf("invalid cell reference %q", ref)
+	}
+
+	return index - 1, nil
+}
+
+// dimensionRows returns number of rows covered by dimension reference like "A1:E100"
+func dimensionRows(ref string) int64 {
+	parts := strings.Split(ref, ":")
+	last := parts[len(parts)-1]
+
+	digits := strings.TrimLeft(last, "ABCDEFGHIJKLMNOPQRSTUVWXYZ")
+	rows, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return rows
+}
+
+func attr(e xml.StartElement, name string) string {
+	for _, a := range e.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+
+	return ""
+}